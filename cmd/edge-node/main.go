@@ -22,6 +22,7 @@ import (
 	"github.com/TeaOSLab/EdgeNode/internal/utils"
 	executils "github.com/TeaOSLab/EdgeNode/internal/utils/exec"
 	fsutils "github.com/TeaOSLab/EdgeNode/internal/utils/fs"
+	"github.com/TeaOSLab/EdgeNode/internal/waf/replay"
 	"github.com/iwind/TeaGo/Tea"
 	_ "github.com/iwind/TeaGo/bootstrap"
 	"github.com/iwind/TeaGo/logs"
@@ -36,8 +37,15 @@ func main() {
 		Version(teaconst.Version).
 		Product(teaconst.ProductName).
 		Usage(teaconst.ProcessName + " [-v|start|stop|restart|status|quit|test|reload|service|daemon|config|pprof|top|accesslog|uninstall]").
+		Usage(teaconst.ProcessName + " restart --graceful").
 		Usage(teaconst.ProcessName + " [trackers|goman|conns|gc|bandwidth|disk|cache.garbage]").
-		Usage(teaconst.ProcessName + " [ip.drop|ip.reject|ip.remove|ip.close] IP")
+		Usage(teaconst.ProcessName + " [ip.drop|ip.reject|ip.remove|ip.close] IP").
+		Usage(teaconst.ProcessName + " ip.feeds [--update[=NAME]] [--json]").
+		Usage(teaconst.ProcessName + " firewall.state [--reconcile] [--json]").
+		Usage(teaconst.ProcessName + " geo.policies [--json]").
+		Usage(teaconst.ProcessName + " origin.states").
+		Usage(teaconst.ProcessName + " waf test POLICY_FILE REQUEST_FILE... [--expect=EXPECTATION] [--quiet]").
+		Usage(teaconst.ProcessName + " waf stats [--minutes=MINUTES] [--top=COUNT] [--sort=cost|avg|hits|requests|verified] [--json]")
 
 	app.On("start:before", func() {
 		// validate config
@@ -392,6 +400,184 @@ func main() {
 			}
 		}
 	})
+	app.On("ip.feeds", func() {
+		var options = app.ParseOptions(os.Args[2:])
+		var params = map[string]any{}
+		updateOption, ok := options["update"]
+		if ok {
+			params["update"] = true
+			if len(updateOption) > 0 {
+				params["name"] = updateOption[0]
+			}
+		}
+
+		var sock = gosock.NewTmpSock(teaconst.ProcessName)
+		reply, err := sock.Send(&gosock.Command{
+			Code:   "ipFeeds",
+			Params: params,
+		})
+		if err != nil {
+			fmt.Println("[ERROR]" + err.Error())
+			return
+		}
+
+		var replyMap = maps.NewMap(reply.Params)
+		var errString = replyMap.GetString("error")
+		if len(errString) > 0 {
+			fmt.Println("[ERROR]" + errString)
+			return
+		}
+
+		if _, ok = options["json"]; ok {
+			resultJSON, err := json.MarshalIndent(reply.Params, "", "  ")
+			if err != nil {
+				fmt.Println("[ERROR]" + err.Error())
+				return
+			}
+			fmt.Println(string(resultJSON))
+			return
+		}
+
+		if params["update"] == true {
+			fmt.Println("update triggered")
+		}
+
+		var feeds = replyMap.GetSlice("feeds")
+		if len(feeds) == 0 {
+			fmt.Println("no feeds, please configure them in 'configs/ip_feeds.yaml'")
+			return
+		}
+
+		var formatTime = func(timestamp int64) string {
+			if timestamp <= 0 {
+				return "-"
+			}
+			return time.Unix(timestamp, 0).Format("2006-01-02 15:04:05")
+		}
+		fmt.Printf("%-20s %-6s %-8s %10s %8s %8s %8s %-19s %-19s  %s\n", "NAME", "LIST", "SERVER", "ITEMS", "ADDED", "REMOVED", "INVALID", "SUCCEEDED", "NEXT", "ERROR")
+		for _, feed := range feeds {
+			var feedMap = maps.NewMap(feed)
+			var serverString = "global"
+			if feedMap.GetInt64("serverId") > 0 {
+				serverString = types.String(feedMap.GetInt64("serverId"))
+			}
+			fmt.Printf("%-20s %-6s %-8s %10d %8d %8d %8d %-19s %-19s  %s\n",
+				feedMap.GetString("name"),
+				feedMap.GetString("listType"),
+				serverString,
+				feedMap.GetInt("countItems"),
+				feedMap.GetInt("countAdded"),
+				feedMap.GetInt("countRemoved"),
+				feedMap.GetInt("countInvalid"),
+				formatTime(feedMap.GetInt64("succeededAt")),
+				formatTime(feedMap.GetInt64("nextUpdateAt")),
+				feedMap.GetString("error"))
+		}
+	})
+	app.On("origin.states", func() {
+		var sock = gosock.NewTmpSock(teaconst.ProcessName)
+		reply, err := sock.Send(&gosock.Command{Code: "originStates"})
+		if err != nil {
+			fmt.Println("[ERROR]" + err.Error())
+		} else {
+			resultJSON, err := json.MarshalIndent(reply.Params, "", "  ")
+			if err != nil {
+				fmt.Println("[ERROR]" + err.Error())
+			} else {
+				fmt.Println(string(resultJSON))
+			}
+		}
+	})
+	app.On("geo.policies", func() {
+		var sock = gosock.NewTmpSock(teaconst.ProcessName)
+		reply, err := sock.Send(&gosock.Command{Code: "geoPolicies"})
+		if err != nil {
+			fmt.Println("[ERROR]" + err.Error())
+			return
+		}
+
+		var options = app.ParseOptions(os.Args[2:])
+		if _, ok := options["json"]; ok {
+			resultJSON, err := json.MarshalIndent(reply.Params, "", "  ")
+			if err != nil {
+				fmt.Println("[ERROR]" + err.Error())
+				return
+			}
+			fmt.Println(string(resultJSON))
+			return
+		}
+
+		var replyMap = maps.NewMap(reply.Params)
+		if !replyMap.GetBool("isActive") {
+			fmt.Println("no active geo policies")
+			return
+		}
+		for _, policy := range replyMap.GetSlice("policies") {
+			var policyMap = maps.NewMap(policy)
+			fmt.Println(policyMap.GetString("name") + ": denied " + types.String(policyMap.GetInt64("countDenied")))
+		}
+	})
+	app.On("firewall.state", func() {
+		var options = app.ParseOptions(os.Args[2:])
+		var params = map[string]any{}
+		if _, ok := options["reconcile"]; ok {
+			params["reconcile"] = true
+		}
+
+		var sock = gosock.NewTmpSock(teaconst.ProcessName)
+		reply, err := sock.Send(&gosock.Command{
+			Code:   "firewallState",
+			Params: params,
+		})
+		if err != nil {
+			fmt.Println("[ERROR]" + err.Error())
+			return
+		}
+
+		var replyMap = maps.NewMap(reply.Params)
+		var errString = replyMap.GetString("error")
+		if len(errString) > 0 {
+			fmt.Println("[ERROR]" + errString)
+			return
+		}
+
+		if _, ok := options["json"]; ok {
+			resultJSON, err := json.MarshalIndent(reply.Params, "", "  ")
+			if err != nil {
+				fmt.Println("[ERROR]" + err.Error())
+				return
+			}
+			fmt.Println(string(resultJSON))
+			return
+		}
+
+		fmt.Println("firewall: " + replyMap.GetString("firewall"))
+		var report = replyMap.GetMap("report")
+		if len(report) == 0 {
+			fmt.Println("not reconciled yet, use '--reconcile' to reconcile now")
+			return
+		}
+		var joinIPs = func(key string) string {
+			var ips = []string{}
+			for _, ip := range report.GetSlice(key) {
+				ips = append(ips, types.String(ip))
+			}
+			return strings.Join(ips, ", ")
+		}
+		fmt.Println("reconciled at: " + time.Unix(report.GetInt64("reconciledAt"), 0).Format("2006-01-02 15:04:05"))
+		fmt.Println("active: " + types.String(report.GetInt("countActive")))
+		fmt.Println("expired: " + types.String(report.GetInt("countExpired")))
+		if report.GetBool("isReplayed") {
+			fmt.Println("replayed: " + types.String(report.GetInt("countMissing")))
+		} else {
+			fmt.Println("missing: " + types.String(report.GetInt("countMissing")) + " " + joinIPs("missingIPs"))
+		}
+		fmt.Println("stale: " + types.String(report.GetInt("countStale")) + " " + joinIPs("staleIPs"))
+		fmt.Println("unknown: " + types.String(report.GetInt("countUnknown")))
+		if len(report.GetString("error")) > 0 {
+			fmt.Println("error: " + report.GetString("error"))
+		}
+	})
 	app.On("accesslog", func() {
 		// local sock
 		var tmpDir = os.TempDir()
@@ -529,6 +715,171 @@ func main() {
 			fmt.Println("[ERROR]" + params.GetString("error"))
 		}
 	})
+	app.On("waf", func() {
+		var args = os.Args[2:]
+		if len(args) > 0 && args[0] == "stats" {
+			var options = app.ParseOptions(args[1:])
+			var minutes = 60
+			minutesOption, ok := options["minutes"]
+			if ok {
+				minutes = types.Int(minutesOption[0])
+			}
+			var top = 20
+			topOption, ok := options["top"]
+			if ok {
+				top = types.Int(topOption[0])
+			}
+			var sortField = "cost"
+			sortOption, ok := options["sort"]
+			if ok {
+				sortField = sortOption[0]
+			}
+
+			var sock = gosock.NewTmpSock(teaconst.ProcessName)
+			reply, err := sock.Send(&gosock.Command{
+				Code:   "wafStats",
+				Params: map[string]any{"minutes": minutes},
+			})
+			if err != nil {
+				fmt.Println("[ERROR]" + err.Error())
+				return
+			}
+
+			var params = maps.NewMap(reply.Params)
+			if _, ok = options["json"]; ok {
+				resultJSON, err := json.MarshalIndent(reply.Params, "", "  ")
+				if err != nil {
+					fmt.Println("[ERROR]" + err.Error())
+					return
+				}
+				fmt.Println(string(resultJSON))
+				return
+			}
+
+			var items = []maps.Map{}
+			for _, item := range params.GetSlice("items") {
+				items = append(items, maps.NewMap(item))
+			}
+			if len(items) == 0 {
+				fmt.Println("no stats yet")
+				return
+			}
+			sort.Slice(items, func(i, j int) bool {
+				switch sortField {
+				case "hits":
+					return items[i].GetInt64("countHits") > items[j].GetInt64("countHits")
+				case "requests":
+					return items[i].GetInt64("countRequests") > items[j].GetInt64("countRequests")
+				case "verified":
+					return items[i].GetInt64("countVerified") > items[j].GetInt64("countVerified")
+				case "avg":
+					return items[i].GetFloat64("avgCostMicros") > items[j].GetFloat64("avgCostMicros")
+				default:
+					return items[i].GetFloat64("avgCostMicros")*items[i].GetFloat64("countRequests") > items[j].GetFloat64("avgCostMicros")*items[j].GetFloat64("countRequests")
+				}
+			})
+			if top > 0 && len(items) > top {
+				items = items[:top]
+			}
+
+			var costBuckets = params.GetSlice("costBuckets")
+			fmt.Printf("%-8s %-8s %12s %10s %10s %10s %10s %11s %11s  %s\n", "SET", "GROUP", "REQUESTS", "HITS", "VERIFIED", "AVG(us)", "MAX(us)", "VALUE CACHE", "MATCH CACHE", "NAME")
+			for _, item := range items {
+				fmt.Printf("%-8d %-8d %12d %10d %10d %10.2f %10.2f %10.2f%% %10.2f%%  %s\n",
+					item.GetInt64("setId"),
+					item.GetInt64("groupId"),
+					item.GetInt64("countRequests"),
+					item.GetInt64("countHits"),
+					item.GetInt64("countVerified"),
+					item.GetFloat64("avgCostMicros"),
+					item.GetFloat64("maxCostMicros"),
+					item.GetFloat64("valueCacheHitRate")*100,
+					item.GetFloat64("matchCacheHitRate")*100,
+					item.GetString("groupName")+" / "+item.GetString("setName"))
+
+				// 耗时分布，为抽样数据
+				var bucketStrings = []string{}
+				for index, count := range item.GetSlice("costBuckets") {
+					var label string
+					if index < len(costBuckets) {
+						label = "<" + types.String(costBuckets[index]) + "us"
+					} else {
+						label = ">=" + types.String(costBuckets[len(costBuckets)-1]) + "us"
+					}
+					bucketStrings = append(bucketStrings, label+":"+types.String(count))
+				}
+				fmt.Println("         " + strings.Join(bucketStrings, " "))
+			}
+			return
+		}
+
+		if len(args) == 0 || args[0] != "test" {
+			fmt.Println("Usage: edge-node waf test POLICY_FILE REQUEST_FILE... [--expect=EXPECTATION] [--quiet]")
+			fmt.Println("       edge-node waf stats [--minutes=MINUTES] [--top=COUNT] [--sort=cost|avg|hits|requests|verified] [--json]")
+			return
+		}
+
+		var files = []string{}
+		var optionArgs = []string{}
+		for _, arg := range args[1:] {
+			if strings.HasPrefix(arg, "-") {
+				optionArgs = append(optionArgs, arg)
+			} else {
+				files = append(files, arg)
+			}
+		}
+		if len(files) < 2 {
+			fmt.Println("Usage: edge-node waf test POLICY_FILE REQUEST_FILE... [--expect=EXPECTATION] [--quiet]")
+			return
+		}
+
+		runner, err := replay.NewRunnerWithFile(files[0])
+		if err != nil {
+			fmt.Println("[ERROR]load policy '" + files[0] + "' failed: " + err.Error())
+			os.Exit(1)
+		}
+
+		var options = app.ParseOptions(optionArgs)
+		expect, ok := options["expect"]
+		if ok && len(expect[0]) > 0 {
+			runner.DefaultExpectation, err = replay.ParseExpectation(expect[0])
+			if err != nil {
+				fmt.Println("[ERROR]" + err.Error())
+				os.Exit(1)
+			}
+		}
+		_, quiet := options["quiet"]
+
+		var stat = replay.NewStat()
+		var hasErrors = false
+		for _, file := range files[1:] {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				fmt.Println("[ERROR]" + err.Error())
+				hasErrors = true
+				continue
+			}
+			cases, err := replay.LoadFile(file, data)
+			if err != nil {
+				fmt.Println("[ERROR]" + err.Error())
+				hasErrors = true
+				continue
+			}
+			for _, c := range cases {
+				var result = runner.Run(c)
+				stat.Add(result)
+				if !quiet || !result.IsOk() {
+					result.Print(os.Stdout)
+				}
+			}
+		}
+
+		fmt.Println("======")
+		stat.Print(os.Stdout)
+		if hasErrors || stat.CountFailed > 0 {
+			os.Exit(1)
+		}
+	})
 	app.On("config", func() {
 		var configString = os.Args[len(os.Args)-1]
 		if configString == "config" {
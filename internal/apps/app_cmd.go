@@ -14,6 +14,7 @@ import (
 
 	teaconst "github.com/TeaOSLab/EdgeNode/internal/const"
 	executils "github.com/TeaOSLab/EdgeNode/internal/utils/exec"
+	"github.com/iwind/TeaGo/lists"
 	"github.com/iwind/TeaGo/logs"
 	"github.com/iwind/TeaGo/maps"
 	"github.com/iwind/TeaGo/types"
@@ -242,11 +243,38 @@ func (this *AppCmd) runStop() {
 
 // 重启
 func (this *AppCmd) runRestart() {
+	if lists.ContainsString(os.Args[2:], "--graceful") {
+		this.runGracefulRestart()
+		return
+	}
+
 	this.runStop()
 	time.Sleep(1 * time.Second)
 	this.runStart()
 }
 
+// 优雅重启
+func (this *AppCmd) runGracefulRestart() {
+	var pid = this.getPID()
+	if pid == 0 {
+		fmt.Println(this.product + " not started yet")
+		return
+	}
+
+	reply, err := this.sock.Send(&gosock.Command{Code: "gracefulRestart"})
+	if err != nil {
+		fmt.Println(this.product+" graceful restart failed:", err.Error())
+		return
+	}
+	var replyMap = maps.NewMap(reply.Params)
+	if !replyMap.GetBool("isOk") {
+		fmt.Println(this.product+" graceful restart failed:", replyMap.GetString("error"))
+		return
+	}
+
+	fmt.Println(this.product+" restarted ok, old pid:", types.String(pid)+", new pid:", types.String(this.getPID()))
+}
+
 // 状态
 func (this *AppCmd) runStatus() {
 	var pid = this.getPID()
@@ -500,5 +500,5 @@ func (this *KVListFileStore) Close() error {
 }
 
 func (this *KVListFileStore) isReady() bool {
-	return this.rawIsReady && !this.rawStore.IsClosed()
+	return this.rawIsReady && !this.rawStore.IsClosed() && !this.rawStore.IsReleased()
 }
@@ -27,6 +27,7 @@ import (
 	"github.com/TeaOSLab/EdgeNode/internal/utils/fasttime"
 	fsutils "github.com/TeaOSLab/EdgeNode/internal/utils/fs"
 	"github.com/TeaOSLab/EdgeNode/internal/utils/goman"
+	"github.com/TeaOSLab/EdgeNode/internal/utils/kvstore"
 	memutils "github.com/TeaOSLab/EdgeNode/internal/utils/mem"
 	setutils "github.com/TeaOSLab/EdgeNode/internal/utils/sets"
 	"github.com/TeaOSLab/EdgeNode/internal/utils/trackers"
@@ -478,8 +479,8 @@ func (this *FileStorage) OpenFlushWriter(key string, expiresAt int64, status int
 }
 
 func (this *FileStorage) openWriter(key string, expiredAt int64, status int, headerSize int, bodySize int64, maxSize int64, isPartial bool, isFlushing bool) (Writer, error) {
-	// 是否正在退出
-	if teaconst.IsQuiting {
+	// 是否正在退出，或者缓存索引已经交给其他进程
+	if teaconst.IsQuiting || kvstore.IsReleased() {
 		return nil, ErrWritingUnavailable
 	}
 
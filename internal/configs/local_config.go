@@ -0,0 +1,46 @@
+// Copyright 2024 GoEdge CDN goedge.cdn@gmail.com. All rights reserved. Official site: https://goedge.cloud .
+
+package configs
+
+import (
+	"os"
+
+	"github.com/iwind/TeaGo/Tea"
+	"gopkg.in/yaml.v3"
+)
+
+// LocalConfig 节点本地YAML配置
+// 这些配置只对当前节点生效，放在 configs/ 目录下，由各个模块自行定义结构
+type LocalConfig interface {
+	// Init 校验并初始化配置
+	Init() error
+}
+
+// LoadLocalConfig 从 configs/ 目录中加载节点本地配置文件
+// 如果配置文件不存在，则返回传入的默认配置
+func LoadLocalConfig[T LocalConfig](fileName string, defaultConfig T) (T, error) {
+	data, err := os.ReadFile(Tea.ConfigFile(fileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return defaultConfig, nil
+		}
+		var emptyConfig T
+		return emptyConfig, err
+	}
+	return ParseLocalConfig(data, defaultConfig)
+}
+
+// ParseLocalConfig 分析YAML格式的节点本地配置
+// 配置内容会覆盖 config 中已有的默认值，然后调用 Init() 初始化
+func ParseLocalConfig[T LocalConfig](data []byte, config T) (T, error) {
+	var emptyConfig T
+	err := yaml.Unmarshal(data, config)
+	if err != nil {
+		return emptyConfig, err
+	}
+	err = config.Init()
+	if err != nil {
+		return emptyConfig, err
+	}
+	return config, nil
+}
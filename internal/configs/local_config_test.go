@@ -0,0 +1,49 @@
+// Copyright 2024 GoEdge CDN goedge.cdn@gmail.com. All rights reserved. Official site: https://goedge.cloud .
+
+package configs_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/TeaOSLab/EdgeNode/internal/configs"
+	"github.com/iwind/TeaGo/assert"
+)
+
+type testLocalConfig struct {
+	IsOn    bool `yaml:"isOn"`
+	Timeout int  `yaml:"timeout"`
+}
+
+func (this *testLocalConfig) Init() error {
+	if this.Timeout < 0 {
+		return errors.New("'timeout' should not be negative")
+	}
+	return nil
+}
+
+func TestLoadLocalConfig_NotExist(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	var defaultConfig = &testLocalConfig{IsOn: true, Timeout: 10}
+	config, err := configs.LoadLocalConfig("test_local_config_not_exist.yaml", defaultConfig)
+	a.IsNil(err)
+	a.IsTrue(config == defaultConfig)
+}
+
+func TestParseLocalConfig(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	// 没有设置的选项保留默认值
+	config, err := configs.ParseLocalConfig([]byte("timeout: 5"), &testLocalConfig{IsOn: true, Timeout: 10})
+	a.IsNil(err)
+	a.IsTrue(config.IsOn)
+	a.IsTrue(config.Timeout == 5)
+
+	config, err = configs.ParseLocalConfig([]byte("timeout: -1"), &testLocalConfig{})
+	a.IsNotNil(err)
+	a.IsTrue(config == nil)
+
+	_, err = configs.ParseLocalConfig([]byte("timeout: ["), &testLocalConfig{})
+	a.IsNotNil(err)
+}
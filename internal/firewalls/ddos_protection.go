@@ -91,8 +91,14 @@ func (this *DDoSProtectionManager) Apply(config *ddosconfigs.ProtectionConfig) e
 		}
 	}
 
+	// 本地配置
+	localConfig, err := LoadDDoSLocalProtectionConfig()
+	if err != nil {
+		return fmt.Errorf("load '%s' failed: %w", DDoSProtectionConfigFileName, err)
+	}
+
 	// 对比配置
-	configJSON, err := json.Marshal(config)
+	configJSON, err := json.Marshal([]any{config, localConfig})
 	if err != nil {
 		return fmt.Errorf("encode config to json failed: %w", err)
 	}
@@ -106,35 +112,25 @@ func (this *DDoSProtectionManager) Apply(config *ddosconfigs.ProtectionConfig) e
 	}
 
 	if nftablesInstance == nil {
-		if config == nil || !config.IsOn() {
+		if (config == nil || !config.IsOn()) && !localConfig.IsOn() {
 			return nil
 		}
 		return errors.New("nftables instance should not be nil")
 	}
 
-	if config == nil {
-		// TCP
-		err := this.removeTCPRules()
-		if err != nil {
-			return err
-		}
-
-		// TODO other protocols
-
-		return nil
-	}
-
-	// TCP
-	if config.TCP == nil {
-		err := this.removeTCPRules()
-		if err != nil {
-			return err
-		}
-	} else {
-		// allow ip list
+	// allow ip list
+	// 所有协议共用同一个白名单
+	if (config != nil && config.TCP != nil) || localConfig.IsOn() {
 		var allowIPList = []string{}
-		for _, ipConfig := range config.TCP.AllowIPList {
-			allowIPList = append(allowIPList, ipConfig.IP)
+		if config != nil && config.TCP != nil {
+			for _, ipConfig := range config.TCP.AllowIPList {
+				allowIPList = append(allowIPList, ipConfig.IP)
+			}
+		}
+		for _, ip := range localConfig.AllowIPList() {
+			if !lists.ContainsString(allowIPList, ip) {
+				allowIPList = append(allowIPList, ip)
+			}
 		}
 		for _, ip := range this.lastAllowIPList {
 			if !lists.ContainsString(allowIPList, ip) {
@@ -145,19 +141,36 @@ func (this *DDoSProtectionManager) Apply(config *ddosconfigs.ProtectionConfig) e
 		if err != nil {
 			return err
 		}
+	}
 
-		// tcp
-		if config.TCP.IsOn {
-			err := this.addTCPRules(config.TCP)
-			if err != nil {
-				return err
-			}
-		} else {
-			err := this.removeTCPRules()
-			if err != nil {
-				return err
-			}
-		}
+	// TCP
+	if config == nil || config.TCP == nil || !config.TCP.IsOn {
+		err = this.removeTCPRules()
+	} else {
+		err = this.addTCPRules(config.TCP)
+	}
+	if err != nil {
+		return err
+	}
+
+	// UDP
+	if localConfig.UDP == nil || !localConfig.UDP.IsOn {
+		err = this.removeRules("udp")
+	} else {
+		err = this.addUDPRules(localConfig.UDP)
+	}
+	if err != nil {
+		return err
+	}
+
+	// ICMP
+	if localConfig.ICMP == nil || !localConfig.ICMP.IsOn {
+		err = this.removeRules("icmp")
+	} else {
+		err = this.addICMPRules(localConfig.ICMP)
+	}
+	if err != nil {
+		return err
 	}
 
 	this.lastConfig = configJSON
@@ -359,6 +372,195 @@ func (this *DDoSProtectionManager) removeTCPRules() error {
 	return nil
 }
 
+// 防护规则
+type ddosRule struct {
+	args     []string // 规则参数，不包括表和链
+	userData []string // 用来标记规则的数据
+}
+
+// 添加UDP规则
+func (this *DDoSProtectionManager) addUDPRules(udpConfig *UDPProtectionConfig) error {
+	var nftExe = nftables.NftExePath()
+	if len(nftExe) == 0 {
+		return nil
+	}
+
+	// 检查nft版本不能小于0.9
+	if len(nftablesInstance.version) > 0 && stringutil.VersionCompare("0.9", nftablesInstance.version) > 0 {
+		return nil
+	}
+
+	var ports = []int32{}
+	for _, port := range udpConfig.Ports {
+		if !lists.ContainsInt32(ports, port) {
+			ports = append(ports, port)
+		}
+	}
+
+	for _, filter := range nftablesFilters {
+		var protocol = filter.protocol()
+		var rules = []*ddosRule{}
+		for _, port := range ports {
+			var portString = types.String(port)
+
+			// 单IP数据包速率
+			if udpConfig.PacketsSecondlyRate > 0 {
+				var rate = types.String(udpConfig.PacketsSecondlyRate)
+				rules = append(rules, this.limitRule(protocol, []string{"udp", "dport", portString, "meter", "meter-" + protocol + "-" + portString + "-udp-packets-rate", "{ " + protocol + " saddr limit rate over " + rate + "/second burst " + types.String(udpConfig.PacketsSecondlyRate+3) + " packets }"}, udpConfig.BlockTimeout, []string{"udp", portString, "packetsRate", rate}))
+			}
+
+			// 单IP流量速率
+			if udpConfig.BytesSecondlyRate > 0 {
+				var rate = types.String(udpConfig.BytesSecondlyRate)
+				rules = append(rules, this.limitRule(protocol, []string{"udp", "dport", portString, "meter", "meter-" + protocol + "-" + portString + "-udp-bytes-rate", "{ " + protocol + " saddr limit rate over " + rate + " bytes/second }"}, udpConfig.BlockTimeout, []string{"udp", portString, "bytesRate", rate}))
+			}
+		}
+
+		err := this.replaceRules(nftExe, filter, "udp", rules)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// 添加ICMP规则
+func (this *DDoSProtectionManager) addICMPRules(icmpConfig *ICMPProtectionConfig) error {
+	var nftExe = nftables.NftExePath()
+	if len(nftExe) == 0 {
+		return nil
+	}
+
+	// 检查nft版本不能小于0.9
+	if len(nftablesInstance.version) > 0 && stringutil.VersionCompare("0.9", nftablesInstance.version) > 0 {
+		return nil
+	}
+
+	for _, filter := range nftablesFilters {
+		var protocol = filter.protocol()
+		var rules = []*ddosRule{}
+
+		if icmpConfig.EchoSecondlyRate > 0 {
+			var icmpProtocol = "icmp"
+			if filter.IsIPv6 {
+				icmpProtocol = "icmpv6"
+			}
+			var rate = types.String(icmpConfig.EchoSecondlyRate)
+			rules = append(rules, this.limitRule(protocol, []string{icmpProtocol, "type", "echo-request", "meter", "meter-" + protocol + "-icmp-echo-rate", "{ " + protocol + " saddr limit rate over " + rate + "/second burst " + types.String(icmpConfig.EchoSecondlyRate+3) + " packets }"}, icmpConfig.BlockTimeout, []string{"icmp", "0", "echoRate", rate}))
+		}
+
+		err := this.replaceRules(nftExe, filter, "icmp", rules)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// 组合限速规则
+// 超出限制时丢弃数据包，如果设置了封禁时间，则同时加入黑名单
+func (this *DDoSProtectionManager) limitRule(protocol string, matchArgs []string, blockTimeout int, userData []string) *ddosRule {
+	var args = append([]string{}, matchArgs...)
+	if blockTimeout > 0 {
+		args = append(args, "add", "@deny_set", "{"+protocol+" saddr timeout "+types.String(blockTimeout)+"s}")
+	}
+	args = append(args, "counter", "drop")
+
+	userData = append(userData, types.String(blockTimeout))
+	return &ddosRule{
+		args:     args,
+		userData: userData,
+	}
+}
+
+// 替换某个协议的所有规则，规则没有变化时不做任何修改
+func (this *DDoSProtectionManager) replaceRules(nftExe string, filter *nftablesTableDefinition, ruleProtocol string, rules []*ddosRule) error {
+	chain, oldRules, err := this.getRules(filter)
+	if err != nil {
+		return fmt.Errorf("get old rules failed: %w", err)
+	}
+
+	// 检查是否有变化
+	var countOldRules = 0
+	for _, oldRule := range oldRules {
+		var pieces = this.decodeUserData(oldRule.UserData())
+		if len(pieces) > 0 && pieces[0] == ruleProtocol {
+			countOldRules++
+		}
+	}
+	if countOldRules == len(rules) {
+		var hasChanges = false
+		for _, rule := range rules {
+			if !this.existsRule(oldRules, rule.userData) {
+				hasChanges = true
+				break
+			}
+		}
+		if !hasChanges {
+			return nil
+		}
+	}
+
+	// 先清空所有相关规则
+	err = this.removeOldRules(chain, oldRules, ruleProtocol)
+	if err != nil {
+		return fmt.Errorf("delete old rules failed: %w", err)
+	}
+
+	// 添加新规则
+	for _, rule := range rules {
+		var args = []string{"add", "rule", filter.protocol(), filter.Name, nftablesChainName}
+		args = append(args, rule.args...)
+		args = append(args, "comment", this.encodeUserData(rule.userData))
+		var cmd = executils.NewTimeoutCmd(10*time.Second, nftExe, args...)
+		cmd.WithStderr()
+		err = cmd.Run()
+		if err != nil {
+			return fmt.Errorf("add nftables rule '%s' failed: %w (%s)", cmd.String(), err, cmd.Stderr())
+		}
+	}
+
+	return nil
+}
+
+// 删除某个协议的所有规则
+func (this *DDoSProtectionManager) removeRules(ruleProtocol string) error {
+	for _, filter := range nftablesFilters {
+		chain, rules, err := this.getRules(filter)
+		if err != nil {
+			return err
+		}
+
+		err = this.removeOldRules(chain, rules, ruleProtocol)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// 清除某个协议的规则
+func (this *DDoSProtectionManager) removeOldRules(chain *nftables.Chain, rules []*nftables.Rule, ruleProtocol string) error {
+	for _, rule := range rules {
+		var pieces = this.decodeUserData(rule.UserData())
+		if len(pieces) < 4 {
+			continue
+		}
+		if pieces[0] != ruleProtocol {
+			continue
+		}
+		err := chain.DeleteRule(rule)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 // 组合user data
 // 数据中不能包含字母、数字、下划线以外的数据
 func (this *DDoSProtectionManager) encodeUserData(attrs []string) string {
@@ -0,0 +1,103 @@
+// Copyright 2024 GoEdge CDN goedge.cdn@gmail.com. All rights reserved. Official site: https://goedge.cloud .
+
+package firewalls
+
+import (
+	"errors"
+	"net"
+
+	"github.com/TeaOSLab/EdgeNode/internal/configs"
+	"github.com/iwind/TeaGo/types"
+)
+
+// DDoSProtectionConfigFileName 本地DDoS防护配置文件，用于TCP以外的协议
+const DDoSProtectionConfigFileName = "ddos_protection.yaml"
+
+// DDoSLocalProtectionConfig 本地DDoS防护配置
+type DDoSLocalProtectionConfig struct {
+	UDP  *UDPProtectionConfig  `yaml:"udp" json:"udp"`
+	ICMP *ICMPProtectionConfig `yaml:"icmp" json:"icmp"`
+}
+
+// UDPProtectionConfig UDP防护配置
+type UDPProtectionConfig struct {
+	IsOn                bool     `yaml:"isOn" json:"isOn"`
+	Ports               []int32  `yaml:"ports" json:"ports"`                             // 需要防护的端口，为空时不防护
+	PacketsSecondlyRate int      `yaml:"packetsSecondlyRate" json:"packetsSecondlyRate"` // 单IP每秒最大数据包数，0表示不限制
+	BytesSecondlyRate   int64    `yaml:"bytesSecondlyRate" json:"bytesSecondlyRate"`     // 单IP每秒最大字节数，0表示不限制
+	BlockTimeout        int      `yaml:"blockTimeout" json:"blockTimeout"`               // 超出限制后临时封禁的时间，单位：秒，0表示只丢弃超出的数据包
+	AllowIPList         []string `yaml:"allowIPList" json:"allowIPList"`                 // IP白名单
+}
+
+// ICMPProtectionConfig ICMP防护配置
+type ICMPProtectionConfig struct {
+	IsOn             bool     `yaml:"isOn" json:"isOn"`
+	EchoSecondlyRate int      `yaml:"echoSecondlyRate" json:"echoSecondlyRate"` // 单IP每秒最大Echo请求数
+	BlockTimeout     int      `yaml:"blockTimeout" json:"blockTimeout"`         // 超出限制后临时封禁的时间，单位：秒，0表示只丢弃超出的数据包
+	AllowIPList      []string `yaml:"allowIPList" json:"allowIPList"`           // IP白名单
+}
+
+// Init 初始化
+func (this *DDoSLocalProtectionConfig) Init() error {
+	if this.UDP != nil {
+		for _, port := range this.UDP.Ports {
+			if port <= 0 || port > 65535 {
+				return errors.New("udp: invalid port '" + types.String(port) + "'")
+			}
+		}
+		if this.UDP.PacketsSecondlyRate < 0 || this.UDP.BytesSecondlyRate < 0 || this.UDP.BlockTimeout < 0 {
+			return errors.New("udp: rates and block timeout should not be negative")
+		}
+		err := this.checkIPList(this.UDP.AllowIPList)
+		if err != nil {
+			return errors.New("udp: " + err.Error())
+		}
+	}
+	if this.ICMP != nil {
+		if this.ICMP.EchoSecondlyRate < 0 || this.ICMP.BlockTimeout < 0 {
+			return errors.New("icmp: rate and block timeout should not be negative")
+		}
+		err := this.checkIPList(this.ICMP.AllowIPList)
+		if err != nil {
+			return errors.New("icmp: " + err.Error())
+		}
+	}
+	return nil
+}
+
+// IsOn 是否有启用的防护
+func (this *DDoSLocalProtectionConfig) IsOn() bool {
+	return (this.UDP != nil && this.UDP.IsOn) || (this.ICMP != nil && this.ICMP.IsOn)
+}
+
+// AllowIPList 所有协议的IP白名单
+func (this *DDoSLocalProtectionConfig) AllowIPList() []string {
+	var result = []string{}
+	if this.UDP != nil {
+		result = append(result, this.UDP.AllowIPList...)
+	}
+	if this.ICMP != nil {
+		result = append(result, this.ICMP.AllowIPList...)
+	}
+	return result
+}
+
+func (this *DDoSLocalProtectionConfig) checkIPList(ipList []string) error {
+	for _, ip := range ipList {
+		if net.ParseIP(ip) == nil {
+			return errors.New("invalid ip '" + ip + "' in allow ip list")
+		}
+	}
+	return nil
+}
+
+// LoadDDoSLocalProtectionConfig 从配置文件中加载本地DDoS防护配置
+// 如果配置文件不存在，则返回空的配置
+func LoadDDoSLocalProtectionConfig() (*DDoSLocalProtectionConfig, error) {
+	return configs.LoadLocalConfig(DDoSProtectionConfigFileName, &DDoSLocalProtectionConfig{})
+}
+
+// ParseDDoSLocalProtectionConfig 分析本地DDoS防护配置
+func ParseDDoSLocalProtectionConfig(data []byte) (*DDoSLocalProtectionConfig, error) {
+	return configs.ParseLocalConfig(data, &DDoSLocalProtectionConfig{})
+}
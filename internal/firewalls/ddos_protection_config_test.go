@@ -0,0 +1,61 @@
+// Copyright 2024 GoEdge CDN goedge.cdn@gmail.com. All rights reserved. Official site: https://goedge.cloud .
+
+package firewalls_test
+
+import (
+	"testing"
+
+	"github.com/TeaOSLab/EdgeNode/internal/firewalls"
+	"github.com/iwind/TeaGo/assert"
+)
+
+func TestParseDDoSLocalProtectionConfig(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	{
+		config, err := firewalls.ParseDDoSLocalProtectionConfig([]byte(`udp:
+  isOn: true
+  ports: [53, 443]
+  packetsSecondlyRate: 2000
+  bytesSecondlyRate: 10485760
+  blockTimeout: 60
+  allowIPList: ["192.168.1.1"]
+icmp:
+  isOn: true
+  echoSecondlyRate: 10
+  allowIPList: ["192.168.1.1", "::1"]
+`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		a.IsTrue(config.IsOn())
+		a.IsTrue(len(config.UDP.Ports) == 2)
+		a.IsTrue(config.UDP.BytesSecondlyRate == 10485760)
+		a.IsTrue(config.ICMP.EchoSecondlyRate == 10)
+		a.IsTrue(len(config.AllowIPList()) == 3)
+	}
+
+	{
+		config, err := firewalls.ParseDDoSLocalProtectionConfig([]byte(`udp:
+  isOn: false
+`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		a.IsFalse(config.IsOn())
+	}
+
+	{
+		_, err := firewalls.ParseDDoSLocalProtectionConfig([]byte(`udp:
+  ports: [70000]
+`))
+		a.IsNotNil(err)
+	}
+
+	{
+		_, err := firewalls.ParseDDoSLocalProtectionConfig([]byte(`icmp:
+  allowIPList: ["abc"]
+`))
+		a.IsNotNil(err)
+	}
+}
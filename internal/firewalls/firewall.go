@@ -3,6 +3,7 @@
 package firewalls
 
 import (
+	"errors"
 	"os"
 	"runtime"
 	"sync"
@@ -45,12 +46,15 @@ func Firewall() FirewallInterface {
 			var httpFirewall = NewHTTPFirewall(endpoint)
 			for i := 0; i < 10; i++ {
 				if httpFirewall.IsReady() {
-					currentFirewall = httpFirewall
+					currentFirewall = NewStateFirewall(httpFirewall, SharedFirewallState)
 					remotelogs.Println("FIREWALL", "using http firewall '"+endpoint+"'")
 					break
 				}
 				time.Sleep(1 * time.Second)
 			}
+			if currentFirewall != nil {
+				return currentFirewall
+			}
 			return httpFirewall
 		}
 	}
@@ -62,9 +66,9 @@ func Firewall() FirewallInterface {
 			remotelogs.Warn("FIREWALL", "'nftables' should be installed on the system to enhance security (init failed: "+err.Error()+")")
 		} else {
 			if nftables.IsReady() {
-				currentFirewall = nftables
+				currentFirewall = NewStateFirewall(nftables, SharedFirewallState)
 				events.Notify(events.EventNFTablesReady)
-				return nftables
+				return currentFirewall
 			} else {
 				remotelogs.Warn("FIREWALL", "'nftables' should be enabled on the system to enhance security")
 			}
@@ -75,7 +79,20 @@ func Firewall() FirewallInterface {
 	if runtime.GOOS == "linux" {
 		var firewalld = NewFirewalld()
 		if firewalld.IsReady() {
-			currentFirewall = firewalld
+			currentFirewall = NewStateFirewall(firewalld, SharedFirewallState)
+			return currentFirewall
+		}
+	}
+
+	// ipset + iptables，用于不支持nftables的老系统
+	if runtime.GOOS == "linux" {
+		ipset, err := NewIPSetFirewall()
+		if err != nil {
+			if !errors.Is(err, ErrIPSetNotFound) {
+				remotelogs.Warn("FIREWALL", "init 'ipset' failed: "+err.Error())
+			}
+		} else {
+			currentFirewall = NewStateFirewall(ipset, SharedFirewallState)
 			return currentFirewall
 		}
 	}
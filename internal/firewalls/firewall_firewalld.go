@@ -217,6 +217,39 @@ func (this *Firewalld) RemoveSourceIP(ip string) error {
 	return nil
 }
 
+func (this *Firewalld) DropSourceNetwork(network string, timeoutSeconds int) error {
+	if !this.isReady {
+		return nil
+	}
+
+	var family = "ipv4"
+	if strings.Contains(network, ":") {
+		family = "ipv6"
+	}
+	var args = []string{"--add-rich-rule=rule family='" + family + "' source address='" + network + "' drop"}
+	if timeoutSeconds > 0 {
+		args = append(args, "--timeout="+types.String(timeoutSeconds)+"s")
+	}
+	var cmd = executils.NewTimeoutCmd(10*time.Second, this.exe, args...)
+	this.pushCmd(cmd, "")
+	return nil
+}
+
+func (this *Firewalld) RemoveSourceNetwork(network string) error {
+	if !this.isReady {
+		return nil
+	}
+
+	var family = "ipv4"
+	if strings.Contains(network, ":") {
+		family = "ipv6"
+	}
+	var args = []string{"--remove-rich-rule=rule family='" + family + "' source address='" + network + "' drop"}
+	var cmd = executils.NewTimeoutCmd(10*time.Second, this.exe, args...)
+	this.pushCmd(cmd, "")
+	return nil
+}
+
 func (this *Firewalld) pushCmd(cmd *executils.Cmd, denyIP string) {
 	select {
 	case this.cmdQueue <- &firewalldCmd{cmd: cmd, denyIP: denyIP}:
@@ -105,6 +105,23 @@ func (this *HTTPFirewall) RemoveSourceIP(ip string) error {
 	return err
 }
 
+// DropSourceNetwork 丢弃某个源网段数据
+func (this *HTTPFirewall) DropSourceNetwork(network string, timeoutSeconds int) error {
+	_, err := this.get("/dropSourceNetwork", map[string]string{
+		"network":        network,
+		"timeoutSeconds": types.String(timeoutSeconds),
+	})
+	return err
+}
+
+// RemoveSourceNetwork 删除某个源网段
+func (this *HTTPFirewall) RemoveSourceNetwork(network string) error {
+	_, err := this.get("/removeSourceNetwork", map[string]string{
+		"network": network,
+	})
+	return err
+}
+
 func (this *HTTPFirewall) get(path string, args map[string]string) (result maps.Map, err error) {
 	var urlString = this.endpoint + path
 	if len(args) > 0 {
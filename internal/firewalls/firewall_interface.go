@@ -30,4 +30,12 @@ type FirewallInterface interface {
 
 	// RemoveSourceIP 删除某个源IP
 	RemoveSourceIP(ip string) error
+
+	// DropSourceNetwork 丢弃某个源网段数据
+	// network 要封禁的网段，比如 192.168.1.0/24
+	// timeoutSeconds 过期时间
+	DropSourceNetwork(network string, timeoutSeconds int) error
+
+	// RemoveSourceNetwork 删除某个源网段
+	RemoveSourceNetwork(network string) error
 }
@@ -0,0 +1,470 @@
+// Copyright 2024 GoEdge CDN goedge.cdn@gmail.com. All rights reserved. Official site: https://goedge.cloud .
+
+package firewalls
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/TeaOSLab/EdgeNode/internal/conns"
+	"github.com/TeaOSLab/EdgeNode/internal/remotelogs"
+	executils "github.com/TeaOSLab/EdgeNode/internal/utils/exec"
+	"github.com/TeaOSLab/EdgeNode/internal/utils/goman"
+	"github.com/iwind/TeaGo/types"
+)
+
+var ErrIPSetNotFound = errors.New("'ipset' or 'iptables' not found")
+
+const (
+	ipsetChainName = "EDGE_INPUT"
+
+	ipsetDenyIPv4SetName        = "edge_deny_v4"
+	ipsetDenyIPv6SetName        = "edge_deny_v6"
+	ipsetRejectIPv4SetName      = "edge_reject_v4"
+	ipsetRejectIPv6SetName      = "edge_reject_v6"
+	ipsetDenyNetworkIPv4SetName = "edge_deny_net_v4"
+	ipsetDenyNetworkIPv6SetName = "edge_deny_net_v6"
+
+	ipsetMaxTimeout = 2147483 // ipset支持的最大超时时间，单位：秒
+	ipsetBatchSize  = 256     // 每次批量添加的最大IP数量
+)
+
+type ipsetSetDefinition struct {
+	Name   string
+	Type   string // hash:ip, hash:net
+	IsIPv6 bool
+	Target string // DROP, REJECT
+}
+
+var ipsetSets = []*ipsetSetDefinition{
+	{Name: ipsetDenyIPv4SetName, Type: "hash:ip", Target: "DROP"},
+	{Name: ipsetDenyIPv6SetName, Type: "hash:ip", IsIPv6: true, Target: "DROP"},
+	{Name: ipsetRejectIPv4SetName, Type: "hash:ip", Target: "REJECT"},
+	{Name: ipsetRejectIPv6SetName, Type: "hash:ip", IsIPv6: true, Target: "REJECT"},
+	{Name: ipsetDenyNetworkIPv4SetName, Type: "hash:net", Target: "DROP"},
+	{Name: ipsetDenyNetworkIPv6SetName, Type: "hash:net", IsIPv6: true, Target: "DROP"},
+}
+
+type ipsetDropItem struct {
+	action         string // drop, reject
+	ip             string
+	timeoutSeconds int
+}
+
+// IPSetFirewall 基于ipset和iptables的防火墙，用于不支持nftables的系统
+// 相关命令：
+//   - 创建set：ipset create edge_deny_v4 hash:ip timeout 0 maxelem 1000000 -exist
+//   - 创建链：iptables -N EDGE_INPUT
+//   - 引用链：iptables -I INPUT -j EDGE_INPUT
+//   - 添加规则：iptables -A EDGE_INPUT -m set --match-set edge_deny_v4 src -j DROP
+//   - 批量添加IP：echo "add edge_deny_v4 192.168.2.32 timeout 30" | ipset restore -exist
+//   - 删除IP：ipset -exist del edge_deny_v4 192.168.2.32
+type IPSetFirewall struct {
+	BaseFirewall
+
+	isReady      bool
+	ipsetExe     string
+	iptablesExe  string
+	ip6tablesExe string // 为空表示不支持IPv6
+
+	dropIPQueue chan *ipsetDropItem
+}
+
+func NewIPSetFirewall() (*IPSetFirewall, error) {
+	ipsetExe, err := executils.LookPath("ipset")
+	if err != nil || len(ipsetExe) == 0 {
+		return nil, ErrIPSetNotFound
+	}
+	iptablesExe, err := executils.LookPath("iptables")
+	if err != nil || len(iptablesExe) == 0 {
+		return nil, ErrIPSetNotFound
+	}
+
+	var firewall = &IPSetFirewall{
+		ipsetExe:    ipsetExe,
+		iptablesExe: iptablesExe,
+		dropIPQueue: make(chan *ipsetDropItem, 4096),
+	}
+
+	ip6tablesExe, err := executils.LookPath("ip6tables")
+	if err == nil {
+		firewall.ip6tablesExe = ip6tablesExe
+	}
+
+	err = firewall.init()
+	if err != nil {
+		return nil, err
+	}
+
+	return firewall, nil
+}
+
+func (this *IPSetFirewall) init() error {
+	// 创建链
+	for _, exe := range this.iptablesExes() {
+		var cmd = executils.NewTimeoutCmd(10*time.Second, exe, "-N", ipsetChainName)
+		cmd.WithStderr()
+		err := cmd.Run()
+		if err != nil && !strings.Contains(cmd.Stderr(), "already exists") {
+			return fmt.Errorf("create chain '%s': %w, output: %s", ipsetChainName, err, cmd.Stderr())
+		}
+
+		err = this.ensureRule(exe, "INPUT", true, "-j", ipsetChainName)
+		if err != nil {
+			return err
+		}
+	}
+
+	// 创建set和规则
+	for _, set := range ipsetSets {
+		var exe = this.iptablesExe
+		var family = "inet"
+		if set.IsIPv6 {
+			if len(this.ip6tablesExe) == 0 {
+				continue
+			}
+			exe = this.ip6tablesExe
+			family = "inet6"
+		}
+
+		var cmd = executils.NewTimeoutCmd(10*time.Second, this.ipsetExe, "create", set.Name, set.Type, "family", family, "timeout", "0", "maxelem", "1000000", "-exist")
+		cmd.WithStderr()
+		err := cmd.Run()
+		if err != nil {
+			return fmt.Errorf("create ipset '%s': %w, output: %s", set.Name, err, cmd.Stderr())
+		}
+
+		err = this.ensureRule(exe, ipsetChainName, false, "-m", "set", "--match-set", set.Name, "src", "-j", set.Target)
+		if err != nil {
+			return err
+		}
+	}
+
+	this.isReady = true
+
+	goman.New(func() {
+		for item := range this.dropIPQueue {
+			// 合并队列中已有的IP，一次性提交
+			var items = []*ipsetDropItem{item}
+		Loop:
+			for len(items) < ipsetBatchSize {
+				select {
+				case nextItem := <-this.dropIPQueue:
+					items = append(items, nextItem)
+				default:
+					break Loop
+				}
+			}
+
+			err := this.addIPs(items)
+			if err != nil {
+				remotelogs.Warn("IPSET", "drop "+types.String(len(items))+" ips failed: "+err.Error())
+			}
+		}
+	})
+
+	return nil
+}
+
+// Name 名称
+func (this *IPSetFirewall) Name() string {
+	return "ipset"
+}
+
+// IsReady 是否已准备被调用
+func (this *IPSetFirewall) IsReady() bool {
+	return this.isReady
+}
+
+// IsMock 是否为模拟
+func (this *IPSetFirewall) IsMock() bool {
+	return false
+}
+
+// AllowPort 允许端口
+func (this *IPSetFirewall) AllowPort(port int, protocol string) error {
+	if !this.isReady {
+		return nil
+	}
+	for _, exe := range this.iptablesExes() {
+		err := this.ensureRule(exe, ipsetChainName, false, "-p", protocol, "--dport", types.String(port), "-j", "ACCEPT")
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RemovePort 删除端口
+func (this *IPSetFirewall) RemovePort(port int, protocol string) error {
+	if !this.isReady {
+		return nil
+	}
+	for _, exe := range this.iptablesExes() {
+		err := this.deleteRule(exe, ipsetChainName, "-p", protocol, "--dport", types.String(port), "-j", "ACCEPT")
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RejectSourceIP 拒绝某个源IP连接
+func (this *IPSetFirewall) RejectSourceIP(ip string, timeoutSeconds int) error {
+	if !this.isReady {
+		return nil
+	}
+
+	// 避免短时间内重复添加
+	if this.checkLatestIP(ip) {
+		return nil
+	}
+
+	return this.pushItem(&ipsetDropItem{
+		action:         "reject",
+		ip:             ip,
+		timeoutSeconds: timeoutSeconds,
+	})
+}
+
+// DropSourceIP 丢弃某个源IP数据
+func (this *IPSetFirewall) DropSourceIP(ip string, timeoutSeconds int, async bool) error {
+	if !this.isReady {
+		return nil
+	}
+
+	// 尝试关闭连接
+	conns.SharedMap.CloseIPConns(ip)
+
+	// 避免短时间内重复添加
+	if async && this.checkLatestIP(ip) {
+		return nil
+	}
+
+	var item = &ipsetDropItem{
+		action:         "drop",
+		ip:             ip,
+		timeoutSeconds: timeoutSeconds,
+	}
+	if async {
+		return this.pushItem(item)
+	}
+	return this.addIPs([]*ipsetDropItem{item})
+}
+
+// RemoveSourceIP 删除某个源IP
+func (this *IPSetFirewall) RemoveSourceIP(ip string) error {
+	if !this.isReady {
+		return nil
+	}
+
+	var data = net.ParseIP(ip)
+	if data == nil {
+		return errors.New("invalid ip '" + ip + "'")
+	}
+
+	var lines []string
+	if data.To4() == nil {
+		if len(this.ip6tablesExe) == 0 {
+			return nil
+		}
+		lines = []string{"del " + ipsetDenyIPv6SetName + " " + ip, "del " + ipsetRejectIPv6SetName + " " + ip}
+	} else {
+		lines = []string{"del " + ipsetDenyIPv4SetName + " " + ip, "del " + ipsetRejectIPv4SetName + " " + ip}
+	}
+	return this.restore(lines)
+}
+
+// DroppedIPs 列出所有被封禁的IP
+func (this *IPSetFirewall) DroppedIPs() ([]string, error) {
+	if !this.isReady {
+		return nil, nil
+	}
+
+	var setNames = []string{ipsetDenyIPv4SetName, ipsetRejectIPv4SetName}
+	if len(this.ip6tablesExe) > 0 {
+		setNames = append(setNames, ipsetDenyIPv6SetName, ipsetRejectIPv6SetName)
+	}
+
+	// ipset save edge_deny_v4 输出：add edge_deny_v4 192.168.2.32 timeout 30
+	var result = []string{}
+	for _, setName := range setNames {
+		var cmd = executils.NewTimeoutCmd(30*time.Second, this.ipsetExe, "save", setName)
+		cmd.WithStdout()
+		cmd.WithStderr()
+		err := cmd.Run()
+		if err != nil {
+			return nil, fmt.Errorf("run command failed '%s': %w, output: %s", cmd.String(), err, cmd.Stderr())
+		}
+		for _, line := range strings.Split(cmd.Stdout(), "\n") {
+			var pieces = strings.Fields(line)
+			if len(pieces) >= 3 && pieces[0] == "add" && pieces[1] == setName {
+				result = append(result, pieces[2])
+			}
+		}
+	}
+	return result, nil
+}
+
+// DropSourceNetwork 丢弃某个源网段数据
+func (this *IPSetFirewall) DropSourceNetwork(network string, timeoutSeconds int) error {
+	if !this.isReady {
+		return nil
+	}
+
+	setName, cidr, err := this.parseNetwork(network)
+	if err != nil || len(setName) == 0 {
+		return err
+	}
+	return this.restore([]string{"add " + setName + " " + cidr + " timeout " + types.String(this.fixTimeout(timeoutSeconds))})
+}
+
+// RemoveSourceNetwork 删除某个源网段
+func (this *IPSetFirewall) RemoveSourceNetwork(network string) error {
+	if !this.isReady {
+		return nil
+	}
+
+	setName, cidr, err := this.parseNetwork(network)
+	if err != nil || len(setName) == 0 {
+		return err
+	}
+	return this.restore([]string{"del " + setName + " " + cidr})
+}
+
+func (this *IPSetFirewall) pushItem(item *ipsetDropItem) error {
+	select {
+	case this.dropIPQueue <- item:
+	default:
+		return errors.New("drop ip queue is full")
+	}
+	return nil
+}
+
+// 批量添加IP
+func (this *IPSetFirewall) addIPs(items []*ipsetDropItem) error {
+	var lines = []string{}
+	var ips = []string{}
+	for _, item := range items {
+		var data = net.ParseIP(item.ip)
+		if data == nil {
+			continue
+		}
+
+		var setName string
+		if data.To4() == nil {
+			if len(this.ip6tablesExe) == 0 {
+				continue
+			}
+			setName = ipsetDenyIPv6SetName
+			if item.action == "reject" {
+				setName = ipsetRejectIPv6SetName
+			}
+		} else {
+			setName = ipsetDenyIPv4SetName
+			if item.action == "reject" {
+				setName = ipsetRejectIPv4SetName
+			}
+		}
+
+		lines = append(lines, "add "+setName+" "+item.ip+" timeout "+types.String(this.fixTimeout(item.timeoutSeconds)))
+		ips = append(ips, item.ip)
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+
+	err := this.restore(lines)
+	if err != nil {
+		return err
+	}
+
+	// 关闭连接
+	for _, ip := range ips {
+		conns.SharedMap.CloseIPConns(ip)
+	}
+
+	return nil
+}
+
+// 使用ipset restore批量执行命令
+func (this *IPSetFirewall) restore(lines []string) error {
+	var cmd = executils.NewTimeoutCmd(30*time.Second, this.ipsetExe, "restore", "-exist")
+	cmd.WithStdin(strings.NewReader(strings.Join(lines, "\n") + "\n"))
+	cmd.WithStderr()
+	err := cmd.Run()
+	if err != nil {
+		return fmt.Errorf("run command failed '%s': %w, output: %s", cmd.String(), err, cmd.Stderr())
+	}
+	return nil
+}
+
+// 检查规则是否存在，不存在则添加
+func (this *IPSetFirewall) ensureRule(exe string, chain string, insert bool, ruleArgs ...string) error {
+	var cmd = executils.NewTimeoutCmd(10*time.Second, exe, append([]string{"-C", chain}, ruleArgs...)...)
+	if cmd.Run() == nil {
+		return nil
+	}
+
+	var action = "-A"
+	if insert {
+		action = "-I"
+	}
+	cmd = executils.NewTimeoutCmd(10*time.Second, exe, append([]string{action, chain}, ruleArgs...)...)
+	cmd.WithStderr()
+	err := cmd.Run()
+	if err != nil {
+		return fmt.Errorf("run command failed '%s': %w, output: %s", cmd.String(), err, cmd.Stderr())
+	}
+	return nil
+}
+
+// 删除规则，规则不存在时忽略
+func (this *IPSetFirewall) deleteRule(exe string, chain string, ruleArgs ...string) error {
+	var cmd = executils.NewTimeoutCmd(10*time.Second, exe, append([]string{"-C", chain}, ruleArgs...)...)
+	if cmd.Run() != nil {
+		return nil
+	}
+
+	cmd = executils.NewTimeoutCmd(10*time.Second, exe, append([]string{"-D", chain}, ruleArgs...)...)
+	cmd.WithStderr()
+	err := cmd.Run()
+	if err != nil {
+		return fmt.Errorf("run command failed '%s': %w, output: %s", cmd.String(), err, cmd.Stderr())
+	}
+	return nil
+}
+
+func (this *IPSetFirewall) iptablesExes() []string {
+	if len(this.ip6tablesExe) > 0 {
+		return []string{this.iptablesExe, this.ip6tablesExe}
+	}
+	return []string{this.iptablesExe}
+}
+
+// 分析网段，返回对应的set名称和规范化的网段
+// 不支持IPv6时返回空的set名称
+func (this *IPSetFirewall) parseNetwork(network string) (setName string, cidr string, err error) {
+	_, ipNet, err := net.ParseCIDR(network)
+	if err != nil {
+		return "", "", errors.New("invalid network '" + network + "'")
+	}
+	if ipNet.IP.To4() != nil {
+		return ipsetDenyNetworkIPv4SetName, ipNet.String(), nil
+	}
+	if len(this.ip6tablesExe) == 0 {
+		return "", "", nil
+	}
+	return ipsetDenyNetworkIPv6SetName, ipNet.String(), nil
+}
+
+// 超时时间为0表示永久
+func (this *IPSetFirewall) fixTimeout(timeoutSeconds int) int {
+	if timeoutSeconds <= 0 {
+		return 0
+	}
+	return min(timeoutSeconds, ipsetMaxTimeout)
+}
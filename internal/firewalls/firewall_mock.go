@@ -58,3 +58,16 @@ func (this *MockFirewall) RemoveSourceIP(ip string) error {
 	_ = ip
 	return nil
 }
+
+// DropSourceNetwork 丢弃某个源网段数据
+func (this *MockFirewall) DropSourceNetwork(network string, timeoutSeconds int) error {
+	_ = network
+	_ = timeoutSeconds
+	return nil
+}
+
+// RemoveSourceNetwork 删除某个源网段
+func (this *MockFirewall) RemoveSourceNetwork(network string) error {
+	_ = network
+	return nil
+}
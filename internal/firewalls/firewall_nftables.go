@@ -45,7 +45,7 @@ func init() {
 					if err != nil {
 						continue
 					}
-					currentFirewall = nftablesFirewall
+					currentFirewall = NewStateFirewall(nftablesFirewall, SharedFirewallState)
 					remotelogs.Println("FIREWALL", "nftables is ready")
 
 					// fire event
@@ -70,6 +70,11 @@ var nftablesFilters = []*nftablesTableDefinition{
 }
 var nftablesChainName = "input"
 
+const (
+	nftablesDenyNetworkSetName  = "deny_net_set"
+	nftablesDenyNetworkRuleName = "deny_net"
+)
+
 type nftablesTableDefinition struct {
 	Name   string
 	IsIPv4 bool
@@ -119,6 +124,9 @@ type NFTablesFirewall struct {
 	denyIPv4Sets []*nftables.Set
 	denyIPv6Sets []*nftables.Set
 
+	denyNetworkIPv4Set *nftables.Set
+	denyNetworkIPv6Set *nftables.Set
+
 	firewalld *Firewalld
 
 	dropIPQueue chan *blockIPItem
@@ -271,6 +279,59 @@ func (this *NFTablesFirewall) init() error {
 				return errors.New("can not create rule '" + string(ruleName) + "'")
 			}
 		}
+		// network set
+		{
+			var setName = nftablesDenyNetworkSetName
+			set, err := table.GetSet(setName)
+			if err != nil {
+				if nftables.IsNotFound(err) {
+					var keyType nftables.SetDataType
+					if tableDef.IsIPv4 {
+						keyType = nftables.TypeIPAddr
+					} else if tableDef.IsIPv6 {
+						keyType = nftables.TypeIP6Addr
+					}
+					set, err = table.AddSet(setName, &nftables.SetOptions{
+						KeyType:    keyType,
+						HasTimeout: true,
+						Interval:   true,
+					})
+					if err != nil {
+						return fmt.Errorf("create set '%s' failed: %w", setName, err)
+					}
+				} else {
+					return fmt.Errorf("get set '%s' failed: %w", setName, err)
+				}
+			}
+			if set == nil {
+				return errors.New("can not create set '" + setName + "'")
+			}
+			if tableDef.IsIPv4 {
+				this.denyNetworkIPv4Set = set
+			} else if tableDef.IsIPv6 {
+				this.denyNetworkIPv6Set = set
+			}
+
+			var ruleName = []byte(nftablesDenyNetworkRuleName)
+			rule, err := chain.GetRuleWithUserData(ruleName)
+			if err != nil {
+				if nftables.IsNotFound(err) {
+					if tableDef.IsIPv4 {
+						rule, err = chain.AddRejectIPv4SetRule(setName, ruleName)
+					} else if tableDef.IsIPv6 {
+						rule, err = chain.AddRejectIPv6SetRule(setName, ruleName)
+					}
+					if err != nil {
+						return fmt.Errorf("add rule failed: %w", err)
+					}
+				} else {
+					return fmt.Errorf("get rule failed: %w", err)
+				}
+			}
+			if rule == nil {
+				return errors.New("can not create rule '" + string(ruleName) + "'")
+			}
+		}
 	}
 
 	this.isReady = true
@@ -451,6 +512,75 @@ func (this *NFTablesFirewall) RemoveSourceIP(ip string) error {
 	return nil
 }
 
+// DroppedIPs 列出所有被封禁的IP
+func (this *NFTablesFirewall) DroppedIPs() ([]string, error) {
+	var result = []string{}
+	for _, sets := range [][]*nftables.Set{this.denyIPv4Sets, this.denyIPv6Sets} {
+		for _, set := range sets {
+			ips, err := set.GetIPElements()
+			if err != nil {
+				return nil, fmt.Errorf("list elements of set '%s' failed: %w", set.Name(), err)
+			}
+			result = append(result, ips...)
+		}
+	}
+	return result, nil
+}
+
+// DropSourceNetwork 丢弃某个源网段数据
+func (this *NFTablesFirewall) DropSourceNetwork(network string, timeoutSeconds int) error {
+	set, from, toExclusive, err := this.parseNetwork(network)
+	if err != nil {
+		return err
+	}
+	return set.AddIntervalElement(from, toExclusive, &nftables.ElementOptions{
+		Timeout: time.Duration(timeoutSeconds) * time.Second,
+	})
+}
+
+// RemoveSourceNetwork 删除某个源网段
+func (this *NFTablesFirewall) RemoveSourceNetwork(network string) error {
+	set, from, toExclusive, err := this.parseNetwork(network)
+	if err != nil {
+		return err
+	}
+	return set.DeleteIntervalElement(from, toExclusive)
+}
+
+// 分析网段，返回对应的集合、起始IP和结束IP的下一个IP
+func (this *NFTablesFirewall) parseNetwork(network string) (set *nftables.Set, from []byte, toExclusive []byte, err error) {
+	_, ipNet, err := net.ParseCIDR(network)
+	if err != nil {
+		return nil, nil, nil, errors.New("invalid network '" + network + "'")
+	}
+
+	from = ipNet.IP.To4()
+	if from != nil {
+		set = this.denyNetworkIPv4Set
+	} else {
+		from = ipNet.IP.To16()
+		set = this.denyNetworkIPv6Set
+	}
+	if set == nil {
+		return nil, nil, nil, errors.New("network set not found")
+	}
+
+	// 结束IP的下一个IP
+	toExclusive = make([]byte, len(from))
+	for i := range from {
+		toExclusive[i] = from[i] | ^ipNet.Mask[len(ipNet.Mask)-len(from)+i]
+	}
+	for i := len(toExclusive) - 1; i >= 0; i-- {
+		toExclusive[i]++
+		if toExclusive[i] != 0 {
+			return
+		}
+	}
+
+	// 已经是最大值
+	return set, from, nil, nil
+}
+
 // 读取版本号
 func (this *NFTablesFirewall) readVersion(nftPath string) string {
 	var cmd = executils.NewTimeoutCmd(10*time.Second, nftPath, "--version")
@@ -59,3 +59,13 @@ func (this *NFTablesFirewall) DropSourceIP(ip string, timeoutSeconds int, async
 func (this *NFTablesFirewall) RemoveSourceIP(ip string) error {
 	return nil
 }
+
+// DropSourceNetwork 丢弃某个源网段数据
+func (this *NFTablesFirewall) DropSourceNetwork(network string, timeoutSeconds int) error {
+	return nil
+}
+
+// RemoveSourceNetwork 删除某个源网段
+func (this *NFTablesFirewall) RemoveSourceNetwork(network string) error {
+	return nil
+}
@@ -0,0 +1,478 @@
+// Copyright 2024 GoEdge CDN goedge.cdn@gmail.com. All rights reserved. Official site: https://goedge.cloud .
+
+package firewalls
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	teaconst "github.com/TeaOSLab/EdgeNode/internal/const"
+	"github.com/TeaOSLab/EdgeNode/internal/events"
+	"github.com/TeaOSLab/EdgeNode/internal/remotelogs"
+	"github.com/TeaOSLab/EdgeNode/internal/utils/goman"
+	"github.com/TeaOSLab/EdgeNode/internal/utils/kvstore"
+	"github.com/iwind/TeaGo/maps"
+)
+
+const (
+	firewallStateReconcileInterval = 5 * time.Minute
+	firewallStateTombstoneLife     = 86400 // 删除记录保留时间，单位：秒
+	firewallStateMaxReportIPs      = 100   // 报告中最多列出的IP数量
+)
+
+var SharedFirewallState = NewFirewallState()
+
+func init() {
+	if !teaconst.IsMain {
+		return
+	}
+
+	events.On(events.EventLoaded, func() {
+		store, err := kvstore.DefaultStore()
+		if err != nil {
+			remotelogs.Error("FIREWALL", "init firewall state failed: "+err.Error())
+			return
+		}
+		err = SharedFirewallState.Init(store)
+		if err != nil {
+			remotelogs.Error("FIREWALL", "init firewall state failed: "+err.Error())
+			return
+		}
+
+		goman.New(func() {
+			SharedFirewallState.Start(Firewall)
+		})
+	})
+	events.OnClose(func() {
+		SharedFirewallState.Stop()
+	})
+}
+
+// FirewallStateItem 持久化的本地防火墙封禁记录
+type FirewallStateItem struct {
+	IP        string `json:"ip"`
+	Action    string `json:"action"`    // drop, reject
+	ExpiresAt int64  `json:"expiresAt"` // 过期时间，0表示永久
+	IsDeleted bool   `json:"isDeleted"` // 是否已删除，用来清理防火墙中残留的IP
+	UpdatedAt int64  `json:"updatedAt"`
+}
+
+type FirewallStateItemEncoder[T interface{ *FirewallStateItem }] struct {
+	kvstore.BaseObjectEncoder[T]
+}
+
+func (this *FirewallStateItemEncoder[T]) EncodeField(value T, fieldName string) ([]byte, error) {
+	return nil, errors.New("invalid field name '" + fieldName + "'")
+}
+
+// FirewallDriftReport 本地防火墙和持久化记录之间的差异报告
+type FirewallDriftReport struct {
+	Firewall     string   `json:"firewall"`     // 防火墙名称
+	ReconciledAt int64    `json:"reconciledAt"` // 对账时间
+	CountActive  int      `json:"countActive"`  // 有效的记录数
+	CountExpired int      `json:"countExpired"` // 清理的过期记录数
+	CountMissing int      `json:"countMissing"` // 防火墙中缺失并重新添加的IP数
+	CountStale   int      `json:"countStale"`   // 已删除但仍残留在防火墙中的IP数
+	CountUnknown int      `json:"countUnknown"` // 防火墙中存在但没有记录的IP数，比如DDoS防护自动封禁的IP
+	MissingIPs   []string `json:"missingIPs"`
+	StaleIPs     []string `json:"staleIPs"`
+	IsReplayed   bool     `json:"isReplayed"` // 防火墙不支持读取已封禁的IP，所有有效记录都被重新添加
+	Error        string   `json:"error"`
+}
+
+// HasDrift 是否有差异
+func (this *FirewallDriftReport) HasDrift() bool {
+	return (this.CountMissing > 0 && !this.IsReplayed) || this.CountStale > 0
+}
+
+func (this *FirewallDriftReport) AsMap() maps.Map {
+	return maps.Map{
+		"firewall":     this.Firewall,
+		"reconciledAt": this.ReconciledAt,
+		"countActive":  this.CountActive,
+		"countExpired": this.CountExpired,
+		"countMissing": this.CountMissing,
+		"countStale":   this.CountStale,
+		"countUnknown": this.CountUnknown,
+		"missingIPs":   this.MissingIPs,
+		"staleIPs":     this.StaleIPs,
+		"isReplayed":   this.IsReplayed,
+		"error":        this.Error,
+	}
+}
+
+// 可以读取已封禁IP的防火墙
+type firewallDroppedIPLister interface {
+	// DroppedIPs 列出防火墙中所有被封禁的IP
+	DroppedIPs() ([]string, error)
+}
+
+// FirewallState 本地防火墙封禁状态
+// 将通过 DropSourceIP()、RejectSourceIP() 封禁的IP连同过期时间保存到本地KV数据库中，
+// 并定期和防火墙中实际的IP对账，重新添加缺失的IP、删除残留的IP
+type FirewallState struct {
+	table *kvstore.Table[*FirewallStateItem]
+
+	isReplayed bool
+	lastReport *FirewallDriftReport
+
+	recentMap map[string]int64 // ip@action => expiresAt，避免短时间内重复写入
+
+	ticker      *time.Ticker
+	locker      sync.Mutex
+	reconcileMu sync.Mutex
+}
+
+func NewFirewallState() *FirewallState {
+	return &FirewallState{
+		recentMap: map[string]int64{},
+	}
+}
+
+// Init 初始化
+func (this *FirewallState) Init(store *kvstore.Store) error {
+	db, err := store.NewDB("firewalls")
+	if err != nil {
+		return err
+	}
+
+	table, err := kvstore.NewTable[*FirewallStateItem]("drops", &FirewallStateItemEncoder[*FirewallStateItem]{})
+	if err != nil {
+		return err
+	}
+	db.AddTable(table)
+
+	this.locker.Lock()
+	this.table = table
+	this.locker.Unlock()
+	return nil
+}
+
+// Start 启动对账
+func (this *FirewallState) Start(firewallFunc func() FirewallInterface) {
+	this.locker.Lock()
+	if this.ticker != nil {
+		this.locker.Unlock()
+		return
+	}
+	var ticker = time.NewTicker(firewallStateReconcileInterval)
+	this.ticker = ticker
+	this.locker.Unlock()
+
+	this.reconcileAndLog(firewallFunc())
+	for range ticker.C {
+		this.reconcileAndLog(firewallFunc())
+	}
+}
+
+// Stop 停止对账
+func (this *FirewallState) Stop() {
+	this.locker.Lock()
+	if this.ticker != nil {
+		this.ticker.Stop()
+	}
+	this.locker.Unlock()
+}
+
+// RecordDrop 记录封禁的IP
+func (this *FirewallState) RecordDrop(ip string, action string, timeoutSeconds int) {
+	var table = this.currentTable()
+	if table == nil {
+		return
+	}
+
+	var now = time.Now().Unix()
+	var expiresAt int64
+	if timeoutSeconds > 0 {
+		expiresAt = now + int64(timeoutSeconds)
+	}
+
+	// 过期时间变化不大时不再重复写入
+	var recentKey = ip + "@" + action
+	this.locker.Lock()
+	oldExpiresAt, ok := this.recentMap[recentKey]
+	if ok && (oldExpiresAt == expiresAt || (oldExpiresAt > 0 && expiresAt > 0 && expiresAt-oldExpiresAt < 10)) {
+		this.locker.Unlock()
+		return
+	}
+	const maxRecentItems = 100_000
+	if len(this.recentMap) >= maxRecentItems {
+		this.recentMap = map[string]int64{}
+	}
+	this.recentMap[recentKey] = expiresAt
+	this.locker.Unlock()
+
+	err := table.Set(ip, &FirewallStateItem{
+		IP:        ip,
+		Action:    action,
+		ExpiresAt: expiresAt,
+		UpdatedAt: now,
+	})
+	if err != nil {
+		remotelogs.Warn("FIREWALL", "save firewall state for '"+ip+"' failed: "+err.Error())
+	}
+}
+
+// RecordRemove 记录删除的IP
+func (this *FirewallState) RecordRemove(ip string) {
+	var table = this.currentTable()
+	if table == nil {
+		return
+	}
+
+	this.locker.Lock()
+	delete(this.recentMap, ip+"@drop")
+	delete(this.recentMap, ip+"@reject")
+	this.locker.Unlock()
+
+	err := table.Set(ip, &FirewallStateItem{
+		IP:        ip,
+		IsDeleted: true,
+		UpdatedAt: time.Now().Unix(),
+	})
+	if err != nil {
+		remotelogs.Warn("FIREWALL", "save firewall state for '"+ip+"' failed: "+err.Error())
+	}
+}
+
+// ActiveItems 列出所有有效的记录
+func (this *FirewallState) ActiveItems() ([]*FirewallStateItem, error) {
+	var table = this.currentTable()
+	if table == nil {
+		return nil, nil
+	}
+
+	var now = time.Now().Unix()
+	var result = []*FirewallStateItem{}
+	err := table.
+		Query().
+		FindAll(func(tx *kvstore.Tx[*FirewallStateItem], item kvstore.Item[*FirewallStateItem]) (goNext bool, err error) {
+			if !item.Value.IsDeleted && (item.Value.ExpiresAt == 0 || item.Value.ExpiresAt > now) {
+				result = append(result, item.Value)
+			}
+			return true, nil
+		})
+	return result, err
+}
+
+// Reconcile 和防火墙中实际封禁的IP对账
+func (this *FirewallState) Reconcile(firewall FirewallInterface) (*FirewallDriftReport, error) {
+	var table = this.currentTable()
+	if table == nil {
+		return nil, errors.New("firewall state has not been initialized")
+	}
+
+	this.reconcileMu.Lock()
+	defer this.reconcileMu.Unlock()
+
+	// 使用原始的防火墙，避免重复记录
+	if stateFirewall, ok := firewall.(*StateFirewall); ok {
+		firewall = stateFirewall.FirewallInterface
+	}
+
+	var now = time.Now().Unix()
+	var report = &FirewallDriftReport{
+		Firewall:     firewall.Name(),
+		ReconciledAt: now,
+		MissingIPs:   []string{},
+		StaleIPs:     []string{},
+	}
+	defer func() {
+		this.locker.Lock()
+		this.lastReport = report
+		this.locker.Unlock()
+	}()
+
+	// 读取防火墙中的IP
+	var firewallIPMap map[string]bool
+	lister, canList := firewall.(firewallDroppedIPLister)
+	if canList {
+		ips, err := lister.DroppedIPs()
+		if err != nil {
+			report.Error = err.Error()
+			return report, err
+		}
+		firewallIPMap = map[string]bool{}
+		for _, ip := range ips {
+			firewallIPMap[ip] = true
+		}
+	}
+
+	var knownIPMap = map[string]bool{}
+	var activeItems = []*FirewallStateItem{}
+	var staleIPs = []string{}
+	var expiredKeys = []string{}
+	err := table.
+		Query().
+		FindAll(func(tx *kvstore.Tx[*FirewallStateItem], item kvstore.Item[*FirewallStateItem]) (goNext bool, err error) {
+			var value = item.Value
+			knownIPMap[value.IP] = true
+
+			if value.IsDeleted {
+				if firewallIPMap[value.IP] {
+					staleIPs = append(staleIPs, value.IP)
+				}
+				if value.UpdatedAt < now-firewallStateTombstoneLife {
+					expiredKeys = append(expiredKeys, item.Key)
+				}
+				return true, nil
+			}
+
+			if value.ExpiresAt > 0 && value.ExpiresAt <= now {
+				expiredKeys = append(expiredKeys, item.Key)
+				return true, nil
+			}
+
+			activeItems = append(activeItems, value)
+			return true, nil
+		})
+	if err != nil {
+		report.Error = err.Error()
+		return report, err
+	}
+
+	// 清理过期的记录
+	if len(expiredKeys) > 0 {
+		err = table.Delete(expiredKeys...)
+		if err != nil {
+			report.Error = err.Error()
+			return report, err
+		}
+		report.CountExpired = len(expiredKeys)
+	}
+	report.CountActive = len(activeItems)
+
+	// 重新添加缺失的IP
+	var shouldReplay = !canList && !this.isReplayed
+	for _, item := range activeItems {
+		if canList {
+			if firewallIPMap[item.IP] {
+				continue
+			}
+		} else if !shouldReplay {
+			break
+		}
+
+		var timeoutSeconds int
+		if item.ExpiresAt > 0 {
+			timeoutSeconds = int(item.ExpiresAt - now)
+			if timeoutSeconds <= 0 {
+				continue
+			}
+		}
+
+		var addErr error
+		if item.Action == "reject" {
+			addErr = firewall.RejectSourceIP(item.IP, timeoutSeconds)
+		} else {
+			addErr = firewall.DropSourceIP(item.IP, timeoutSeconds, false)
+		}
+		if addErr != nil {
+			remotelogs.Warn("FIREWALL", "re-add '"+item.IP+"' failed: "+addErr.Error())
+			continue
+		}
+		report.CountMissing++
+		if len(report.MissingIPs) < firewallStateMaxReportIPs {
+			report.MissingIPs = append(report.MissingIPs, item.IP)
+		}
+	}
+	if shouldReplay {
+		this.isReplayed = true
+		report.IsReplayed = true
+	}
+
+	// 删除残留的IP
+	for _, ip := range staleIPs {
+		removeErr := firewall.RemoveSourceIP(ip)
+		if removeErr != nil {
+			remotelogs.Warn("FIREWALL", "remove stale '"+ip+"' failed: "+removeErr.Error())
+			continue
+		}
+		report.CountStale++
+		if len(report.StaleIPs) < firewallStateMaxReportIPs {
+			report.StaleIPs = append(report.StaleIPs, ip)
+		}
+	}
+
+	// 未记录的IP
+	for ip := range firewallIPMap {
+		if !knownIPMap[ip] {
+			report.CountUnknown++
+		}
+	}
+
+	return report, nil
+}
+
+// LastReport 最近一次对账的报告
+func (this *FirewallState) LastReport() *FirewallDriftReport {
+	this.locker.Lock()
+	defer this.locker.Unlock()
+	return this.lastReport
+}
+
+func (this *FirewallState) reconcileAndLog(firewall FirewallInterface) {
+	if firewall == nil || firewall.IsMock() {
+		return
+	}
+
+	report, err := this.Reconcile(firewall)
+	if err != nil {
+		remotelogs.Warn("FIREWALL", "reconcile firewall state failed: "+err.Error())
+		return
+	}
+	if report.HasDrift() {
+		remotelogs.Warn("FIREWALL", "firewall state drift detected: re-added "+strconv.Itoa(report.CountMissing)+" missing ips, removed "+strconv.Itoa(report.CountStale)+" stale ips")
+	}
+}
+
+func (this *FirewallState) currentTable() *kvstore.Table[*FirewallStateItem] {
+	// 数据库已经交给其他进程
+	if kvstore.IsReleased() {
+		return nil
+	}
+
+	this.locker.Lock()
+	defer this.locker.Unlock()
+	return this.table
+}
+
+// StateFirewall 记录封禁状态的防火墙
+type StateFirewall struct {
+	FirewallInterface
+
+	state *FirewallState
+}
+
+func NewStateFirewall(firewall FirewallInterface, state *FirewallState) *StateFirewall {
+	return &StateFirewall{
+		FirewallInterface: firewall,
+		state:             state,
+	}
+}
+
+// RejectSourceIP 拒绝某个源IP连接
+func (this *StateFirewall) RejectSourceIP(ip string, timeoutSeconds int) error {
+	err := this.FirewallInterface.RejectSourceIP(ip, timeoutSeconds)
+	if err == nil {
+		this.state.RecordDrop(ip, "reject", timeoutSeconds)
+	}
+	return err
+}
+
+// DropSourceIP 丢弃某个源IP数据
+func (this *StateFirewall) DropSourceIP(ip string, timeoutSeconds int, async bool) error {
+	err := this.FirewallInterface.DropSourceIP(ip, timeoutSeconds, async)
+	if err == nil {
+		this.state.RecordDrop(ip, "drop", timeoutSeconds)
+	}
+	return err
+}
+
+// RemoveSourceIP 删除某个源IP
+func (this *StateFirewall) RemoveSourceIP(ip string) error {
+	this.state.RecordRemove(ip)
+	return this.FirewallInterface.RemoveSourceIP(ip)
+}
@@ -0,0 +1,180 @@
+// Copyright 2024 GoEdge CDN goedge.cdn@gmail.com. All rights reserved. Official site: https://goedge.cloud .
+
+package firewalls_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/TeaOSLab/EdgeNode/internal/firewalls"
+	"github.com/TeaOSLab/EdgeNode/internal/utils/kvstore"
+	"github.com/iwind/TeaGo/assert"
+)
+
+type testStateFirewall struct {
+	firewalls.MockFirewall
+
+	locker sync.Mutex
+	ips    map[string]int // ip => timeout
+
+	countDrops int
+}
+
+func newTestStateFirewall() *testStateFirewall {
+	return &testStateFirewall{
+		ips: map[string]int{},
+	}
+}
+
+func (this *testStateFirewall) DropSourceIP(ip string, timeoutSeconds int, async bool) error {
+	this.locker.Lock()
+	this.ips[ip] = timeoutSeconds
+	this.countDrops++
+	this.locker.Unlock()
+	return nil
+}
+
+func (this *testStateFirewall) RejectSourceIP(ip string, timeoutSeconds int) error {
+	return this.DropSourceIP(ip, timeoutSeconds, true)
+}
+
+func (this *testStateFirewall) RemoveSourceIP(ip string) error {
+	this.locker.Lock()
+	delete(this.ips, ip)
+	this.locker.Unlock()
+	return nil
+}
+
+// 可以列出已封禁IP的防火墙
+type testListStateFirewall struct {
+	*testStateFirewall
+}
+
+func (this *testListStateFirewall) DroppedIPs() ([]string, error) {
+	this.locker.Lock()
+	defer this.locker.Unlock()
+	var result = []string{}
+	for ip := range this.ips {
+		result = append(result, ip)
+	}
+	return result, nil
+}
+
+func newTestFirewallState(t *testing.T) *firewalls.FirewallState {
+	store, err := kvstore.OpenStoreDir(t.TempDir(), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = store.Close()
+	})
+
+	var state = firewalls.NewFirewallState()
+	err = state.Init(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return state
+}
+
+func TestFirewallState_Reconcile(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	var state = newTestFirewallState(t)
+	var rawFirewall = &testListStateFirewall{testStateFirewall: newTestStateFirewall()}
+	var fw = firewalls.NewStateFirewall(rawFirewall, state)
+
+	a.IsNil(fw.DropSourceIP("192.168.1.1", 600, true))
+	a.IsNil(fw.RejectSourceIP("192.168.1.2", 0))
+	a.IsNil(fw.DropSourceIP("2001:db8::1", 600, false))
+	a.IsNil(fw.DropSourceIP("192.168.1.3", 600, true))
+	a.IsNil(fw.RemoveSourceIP("192.168.1.3"))
+
+	items, err := state.ActiveItems()
+	a.IsNil(err)
+	a.IsTrue(len(items) == 3)
+
+	// 无差异
+	report, err := state.Reconcile(fw)
+	a.IsNil(err)
+	a.IsFalse(report.HasDrift())
+	a.IsTrue(report.CountActive == 3)
+
+	// 模拟防火墙被清空，同时残留了被删除的IP和其他IP
+	rawFirewall.locker.Lock()
+	rawFirewall.ips = map[string]int{
+		"192.168.1.3": 600,
+		"10.0.0.1":    60,
+	}
+	rawFirewall.locker.Unlock()
+
+	report, err = state.Reconcile(fw)
+	a.IsNil(err)
+	a.IsTrue(report.HasDrift())
+	a.IsTrue(report.CountMissing == 3)
+	a.IsTrue(report.CountStale == 1 && report.StaleIPs[0] == "192.168.1.3")
+	a.IsTrue(report.CountUnknown == 1)
+	a.IsTrue(state.LastReport() == report)
+
+	_, ok := rawFirewall.ips["192.168.1.3"]
+	a.IsFalse(ok)
+	_, ok = rawFirewall.ips["10.0.0.1"]
+	a.IsTrue(ok)
+
+	// 使用剩余的过期时间
+	var timeout = rawFirewall.ips["192.168.1.1"]
+	a.IsTrue(timeout > 590 && timeout <= 600)
+	timeout, ok = rawFirewall.ips["192.168.1.2"]
+	a.IsTrue(ok && timeout == 0)
+
+	report, err = state.Reconcile(fw)
+	a.IsNil(err)
+	a.IsFalse(report.HasDrift())
+}
+
+func TestFirewallState_Expired(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	var state = newTestFirewallState(t)
+	var rawFirewall = &testListStateFirewall{testStateFirewall: newTestStateFirewall()}
+	var fw = firewalls.NewStateFirewall(rawFirewall, state)
+
+	a.IsNil(fw.DropSourceIP("192.168.1.1", 1, false))
+	rawFirewall.locker.Lock()
+	rawFirewall.ips = map[string]int{}
+	rawFirewall.locker.Unlock()
+
+	time.Sleep(1100 * time.Millisecond)
+	report, err := state.Reconcile(fw)
+	a.IsNil(err)
+	a.IsTrue(report.CountExpired == 1)
+	a.IsTrue(report.CountMissing == 0)
+	a.IsTrue(len(rawFirewall.ips) == 0)
+}
+
+func TestFirewallState_Replay(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	var state = newTestFirewallState(t)
+	var rawFirewall = newTestStateFirewall()
+	var fw = firewalls.NewStateFirewall(rawFirewall, state)
+
+	a.IsNil(fw.DropSourceIP("192.168.1.1", 600, true))
+	a.IsNil(fw.DropSourceIP("192.168.1.2", 600, true))
+	a.IsTrue(rawFirewall.countDrops == 2)
+
+	// 不能读取防火墙中的IP时只在第一次对账时全部重放
+	report, err := state.Reconcile(fw)
+	a.IsNil(err)
+	a.IsTrue(report.IsReplayed)
+	a.IsTrue(report.CountMissing == 2)
+	a.IsFalse(report.HasDrift())
+	a.IsTrue(rawFirewall.countDrops == 4)
+
+	report, err = state.Reconcile(fw)
+	a.IsNil(err)
+	a.IsFalse(report.IsReplayed)
+	a.IsTrue(report.CountMissing == 0)
+	a.IsTrue(rawFirewall.countDrops == 4)
+}
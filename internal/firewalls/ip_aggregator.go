@@ -0,0 +1,436 @@
+// Copyright 2024 GoEdge CDN goedge.cdn@gmail.com. All rights reserved. Official site: https://goedge.cloud .
+
+package firewalls
+
+import (
+	"errors"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/TeaOSLab/EdgeNode/internal/configs"
+	teaconst "github.com/TeaOSLab/EdgeNode/internal/const"
+	"github.com/TeaOSLab/EdgeNode/internal/events"
+	"github.com/TeaOSLab/EdgeNode/internal/remotelogs"
+	"github.com/TeaOSLab/EdgeNode/internal/utils/goman"
+	"github.com/iwind/TeaGo/types"
+)
+
+// IPAggregatorConfigFileName 网段聚合配置文件
+const IPAggregatorConfigFileName = "ip_aggregator.yaml"
+
+var SharedIPAggregator = NewIPAggregator(DefaultIPAggregatorConfig(), Firewall)
+
+func init() {
+	if !teaconst.IsMain {
+		return
+	}
+
+	events.On(events.EventLoaded, func() {
+		config, err := LoadIPAggregatorConfig()
+		if err != nil {
+			remotelogs.Error("IP_AGGREGATOR", "load '"+IPAggregatorConfigFileName+"' failed: "+err.Error())
+		} else {
+			SharedIPAggregator.UpdateConfig(config)
+		}
+
+		goman.New(func() {
+			SharedIPAggregator.Start()
+		})
+	})
+	events.OnClose(func() {
+		SharedIPAggregator.Stop()
+	})
+}
+
+// IPAggregatorConfig 网段聚合配置
+type IPAggregatorConfig struct {
+	IsOn           bool `yaml:"isOn" json:"isOn"`                     // 是否启用
+	IPv4PrefixBits int  `yaml:"ipv4PrefixBits" json:"ipv4PrefixBits"` // IPv4网段前缀长度
+	IPv6PrefixBits int  `yaml:"ipv6PrefixBits" json:"ipv6PrefixBits"` // IPv6网段前缀长度
+	Threshold      int  `yaml:"threshold" json:"threshold"`           // 统计窗口内同一个网段中被封禁的IP数量达到此值时封禁整个网段
+	Window         int  `yaml:"window" json:"window"`                 // 统计窗口，单位：秒
+	Timeout        int  `yaml:"timeout" json:"timeout"`               // 网段最长封禁时间，单位：秒
+}
+
+// DefaultIPAggregatorConfig 默认配置
+func DefaultIPAggregatorConfig() *IPAggregatorConfig {
+	return &IPAggregatorConfig{
+		IsOn:           true,
+		IPv4PrefixBits: 24,
+		IPv6PrefixBits: 64,
+		Threshold:      32,
+		Window:         60,
+		Timeout:        3600,
+	}
+}
+
+// Init 初始化
+func (this *IPAggregatorConfig) Init() error {
+	if this.IPv4PrefixBits < 8 || this.IPv4PrefixBits > 31 {
+		return errors.New("'ipv4PrefixBits' should be between 8 and 31")
+	}
+	if this.IPv6PrefixBits < 16 || this.IPv6PrefixBits > 127 {
+		return errors.New("'ipv6PrefixBits' should be between 16 and 127")
+	}
+	if this.Threshold < 2 {
+		return errors.New("'threshold' should be greater than 1")
+	}
+	if this.Window <= 0 {
+		return errors.New("'window' should be greater than 0")
+	}
+	if this.Timeout <= 0 {
+		return errors.New("'timeout' should be greater than 0")
+	}
+	return nil
+}
+
+// LoadIPAggregatorConfig 从配置文件中加载网段聚合配置
+// 如果配置文件不存在，则返回默认配置
+func LoadIPAggregatorConfig() (*IPAggregatorConfig, error) {
+	return configs.LoadLocalConfig(IPAggregatorConfigFileName, DefaultIPAggregatorConfig())
+}
+
+// IPAggregatedNetwork 聚合后的网段
+type IPAggregatedNetwork struct {
+	Network   string `json:"network"`   // CIDR，比如 192.168.1.0/24
+	IPFrom    string `json:"ipFrom"`    // 起始IP
+	IPTo      string `json:"ipTo"`      // 结束IP
+	ExpiresAt int64  `json:"expiresAt"` // 过期时间
+	CountIPs  int    `json:"countIPs"`  // 聚合的IP数量
+}
+
+type ipAggregatorMember struct {
+	expiresAt int64 // 封禁过期时间，为0表示长期有效
+	blockedAt int64 // 最近一次封禁时间
+}
+
+type ipAggregatorGroup struct {
+	network      *IPAggregatedNetwork
+	members      map[string]*ipAggregatorMember // ip => member
+	isAggregated bool
+}
+
+// IPAggregator 被封禁IP的网段聚合
+// 同一个网段中短时间内被封禁的IP数量超过阈值时，使用一条网段规则替代所有的单IP规则，网段规则过期后恢复未过期的单IP规则
+type IPAggregator struct {
+	config       *IPAggregatorConfig
+	firewallFunc func() FirewallInterface
+
+	groups map[string]*ipAggregatorGroup // network => group
+
+	aggregateHooks []func(network *IPAggregatedNetwork)
+	releaseHooks   []func(network *IPAggregatedNetwork)
+
+	ticker *time.Ticker
+	locker sync.Mutex
+}
+
+// NewIPAggregator 获取新对象
+func NewIPAggregator(config *IPAggregatorConfig, firewallFunc func() FirewallInterface) *IPAggregator {
+	return &IPAggregator{
+		config:       config,
+		firewallFunc: firewallFunc,
+		groups:       map[string]*ipAggregatorGroup{},
+	}
+}
+
+// UpdateConfig 修改配置
+func (this *IPAggregator) UpdateConfig(config *IPAggregatorConfig) {
+	this.locker.Lock()
+	this.config = config
+	this.locker.Unlock()
+}
+
+// OnAggregate 添加网段聚合后的回调
+func (this *IPAggregator) OnAggregate(hook func(network *IPAggregatedNetwork)) {
+	this.locker.Lock()
+	this.aggregateHooks = append(this.aggregateHooks, hook)
+	this.locker.Unlock()
+}
+
+// OnRelease 添加网段解除封禁后的回调
+func (this *IPAggregator) OnRelease(hook func(network *IPAggregatedNetwork)) {
+	this.locker.Lock()
+	this.releaseHooks = append(this.releaseHooks, hook)
+	this.locker.Unlock()
+}
+
+func (this *IPAggregator) Start() {
+	this.ticker = time.NewTicker(5 * time.Second)
+	for range this.ticker.C {
+		this.GC()
+	}
+}
+
+func (this *IPAggregator) Stop() {
+	if this.ticker != nil {
+		this.ticker.Stop()
+	}
+}
+
+// Record 记录被封禁的IP
+// 返回 true 表示IP所在网段已经被封禁，调用者不需要再单独封禁此IP
+func (this *IPAggregator) Record(ip string, expiresAt int64) (aggregated bool) {
+	this.locker.Lock()
+	var config = this.config
+	if config == nil || !config.IsOn {
+		this.locker.Unlock()
+		return false
+	}
+
+	network, ok := this.parseNetwork(config, ip)
+	if !ok {
+		this.locker.Unlock()
+		return false
+	}
+
+	var now = time.Now().Unix()
+	var group = this.groups[network.Network]
+	if group == nil {
+		group = &ipAggregatorGroup{
+			network: network,
+			members: map[string]*ipAggregatorMember{},
+		}
+		this.groups[network.Network] = group
+	}
+
+	var member = group.members[ip]
+	if member == nil {
+		member = &ipAggregatorMember{
+			expiresAt: expiresAt,
+		}
+		group.members[ip] = member
+	} else if member.expiresAt > 0 && (expiresAt <= 0 || expiresAt > member.expiresAt) {
+		member.expiresAt = expiresAt
+	}
+	member.blockedAt = now
+
+	if group.isAggregated {
+		this.locker.Unlock()
+		return true
+	}
+
+	// 统计窗口内被封禁的IP数量
+	var countRecent = 0
+	var maxExpiresAt int64 = 0
+	var isForever = false
+	for _, m := range group.members {
+		if m.blockedAt >= now-int64(config.Window) {
+			countRecent++
+		}
+		if m.expiresAt <= 0 {
+			isForever = true
+		} else if m.expiresAt > maxExpiresAt {
+			maxExpiresAt = m.expiresAt
+		}
+	}
+	if countRecent < config.Threshold {
+		this.locker.Unlock()
+		return false
+	}
+
+	// 网段封禁时间不超过其中IP的最长封禁时间
+	var timeout = int64(config.Timeout)
+	if !isForever && maxExpiresAt-now < timeout {
+		timeout = maxExpiresAt - now
+	}
+	if timeout <= 0 {
+		this.locker.Unlock()
+		return false
+	}
+
+	group.isAggregated = true
+	group.network.ExpiresAt = now + timeout
+	group.network.CountIPs = len(group.members)
+
+	var memberIPs = []string{}
+	for memberIP := range group.members {
+		memberIPs = append(memberIPs, memberIP)
+	}
+	var networkCopy = *group.network
+	var hooks = this.aggregateHooks
+	this.locker.Unlock()
+
+	var fw = this.firewallFunc()
+	if fw != nil {
+		err := fw.DropSourceNetwork(networkCopy.Network, int(timeout))
+		if err != nil {
+			remotelogs.Warn("IP_AGGREGATOR", "drop network '"+networkCopy.Network+"' failed: "+err.Error())
+
+			this.locker.Lock()
+			group.isAggregated = false
+			group.network.ExpiresAt = 0
+			this.locker.Unlock()
+			return false
+		}
+
+		// 删除单IP规则
+		for _, memberIP := range memberIPs {
+			if memberIP == ip {
+				continue
+			}
+			_ = fw.RemoveSourceIP(memberIP)
+		}
+	}
+
+	remotelogs.Println("IP_AGGREGATOR", "aggregate "+types.String(len(memberIPs))+" blocked ips to network '"+networkCopy.Network+"' for "+types.String(timeout)+" seconds")
+
+	for _, hook := range hooks {
+		hook(&networkCopy)
+	}
+
+	return true
+}
+
+// Remove 删除被封禁的IP
+// 删除后网段解除封禁时不会再恢复此IP的封禁
+func (this *IPAggregator) Remove(ip string) {
+	this.locker.Lock()
+	defer this.locker.Unlock()
+
+	if this.config == nil {
+		return
+	}
+	network, ok := this.parseNetwork(this.config, ip)
+	if !ok {
+		return
+	}
+	var group = this.groups[network.Network]
+	if group == nil {
+		return
+	}
+	delete(group.members, ip)
+	if len(group.members) == 0 && !group.isAggregated {
+		delete(this.groups, network.Network)
+	}
+}
+
+// GC 解除过期的网段封禁，并清理过期的IP
+func (this *IPAggregator) GC() {
+	type releasedGroup struct {
+		network   IPAggregatedNetwork
+		restoreIP map[string]int // ip => timeout seconds
+	}
+
+	var now = time.Now().Unix()
+	var releasedGroups = []*releasedGroup{}
+
+	this.locker.Lock()
+	var window int64 = 60
+	if this.config != nil {
+		window = int64(this.config.Window)
+	}
+	for key, group := range this.groups {
+		if group.isAggregated {
+			if group.network.ExpiresAt > now {
+				continue
+			}
+
+			var released = &releasedGroup{
+				network:   *group.network,
+				restoreIP: map[string]int{},
+			}
+			for ip, member := range group.members {
+				if member.expiresAt <= 0 {
+					released.restoreIP[ip] = 0
+				} else if member.expiresAt > now {
+					released.restoreIP[ip] = int(member.expiresAt - now)
+				}
+
+				// 重新开始统计
+				member.blockedAt = 0
+			}
+			releasedGroups = append(releasedGroups, released)
+
+			group.isAggregated = false
+			group.network.ExpiresAt = 0
+			group.network.CountIPs = 0
+		}
+
+		for ip, member := range group.members {
+			if member.expiresAt > 0 && member.expiresAt <= now && member.blockedAt < now-window {
+				delete(group.members, ip)
+			}
+		}
+		if len(group.members) == 0 {
+			delete(this.groups, key)
+		}
+	}
+	var hooks = this.releaseHooks
+	this.locker.Unlock()
+
+	if len(releasedGroups) == 0 {
+		return
+	}
+
+	var fw = this.firewallFunc()
+	for _, released := range releasedGroups {
+		if fw != nil {
+			err := fw.RemoveSourceNetwork(released.network.Network)
+			if err != nil {
+				remotelogs.Warn("IP_AGGREGATOR", "remove network '"+released.network.Network+"' failed: "+err.Error())
+			}
+
+			// 恢复未过期的单IP规则
+			for ip, timeout := range released.restoreIP {
+				_ = fw.DropSourceIP(ip, timeout, true)
+			}
+		}
+
+		remotelogs.Println("IP_AGGREGATOR", "release network '"+released.network.Network+"', restore "+types.String(len(released.restoreIP))+" blocked ips")
+
+		for _, hook := range hooks {
+			hook(&released.network)
+		}
+	}
+}
+
+// Networks 当前被封禁的网段
+func (this *IPAggregator) Networks() []*IPAggregatedNetwork {
+	this.locker.Lock()
+	defer this.locker.Unlock()
+
+	var result = []*IPAggregatedNetwork{}
+	for _, group := range this.groups {
+		if group.isAggregated {
+			var network = *group.network
+			result = append(result, &network)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Network < result[j].Network
+	})
+	return result
+}
+
+// 计算IP所在网段
+func (this *IPAggregator) parseNetwork(config *IPAggregatorConfig, ip string) (network *IPAggregatedNetwork, ok bool) {
+	var ipObj = net.ParseIP(ip)
+	if ipObj == nil {
+		return nil, false
+	}
+
+	var mask net.IPMask
+	if ipObj.To4() != nil {
+		ipObj = ipObj.To4()
+		mask = net.CIDRMask(config.IPv4PrefixBits, 32)
+	} else {
+		mask = net.CIDRMask(config.IPv6PrefixBits, 128)
+	}
+	var from = ipObj.Mask(mask)
+	var to = make(net.IP, len(from))
+	for i := range from {
+		to[i] = from[i] | ^mask[i]
+	}
+	var ipNet = &net.IPNet{
+		IP:   from,
+		Mask: mask,
+	}
+	return &IPAggregatedNetwork{
+		Network: ipNet.String(),
+		IPFrom:  from.String(),
+		IPTo:    to.String(),
+	}, true
+}
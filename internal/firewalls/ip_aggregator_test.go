@@ -0,0 +1,174 @@
+// Copyright 2024 GoEdge CDN goedge.cdn@gmail.com. All rights reserved. Official site: https://goedge.cloud .
+
+package firewalls_test
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/TeaOSLab/EdgeNode/internal/firewalls"
+	"github.com/iwind/TeaGo/assert"
+)
+
+type testAggregatorFirewall struct {
+	firewalls.MockFirewall
+
+	locker   sync.Mutex
+	ips      map[string]int // ip => timeout
+	networks map[string]int // network => timeout
+}
+
+func newTestAggregatorFirewall() *testAggregatorFirewall {
+	return &testAggregatorFirewall{
+		ips:      map[string]int{},
+		networks: map[string]int{},
+	}
+}
+
+func (this *testAggregatorFirewall) DropSourceIP(ip string, timeoutSeconds int, async bool) error {
+	this.locker.Lock()
+	this.ips[ip] = timeoutSeconds
+	this.locker.Unlock()
+	return nil
+}
+
+func (this *testAggregatorFirewall) RemoveSourceIP(ip string) error {
+	this.locker.Lock()
+	delete(this.ips, ip)
+	this.locker.Unlock()
+	return nil
+}
+
+func (this *testAggregatorFirewall) DropSourceNetwork(network string, timeoutSeconds int) error {
+	this.locker.Lock()
+	this.networks[network] = timeoutSeconds
+	this.locker.Unlock()
+	return nil
+}
+
+func (this *testAggregatorFirewall) RemoveSourceNetwork(network string) error {
+	this.locker.Lock()
+	delete(this.networks, network)
+	this.locker.Unlock()
+	return nil
+}
+
+// 模拟调用者：没有被聚合时单独封禁IP
+func testAggregatorDrop(aggregator *firewalls.IPAggregator, fw *testAggregatorFirewall, ip string, timeout int) bool {
+	if aggregator.Record(ip, time.Now().Unix()+int64(timeout)) {
+		return true
+	}
+	_ = fw.DropSourceIP(ip, timeout, true)
+	return false
+}
+
+func TestIPAggregator_Record(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	var fw = newTestAggregatorFirewall()
+	var config = firewalls.DefaultIPAggregatorConfig()
+	config.Threshold = 10
+	config.Timeout = 600
+	var aggregator = firewalls.NewIPAggregator(config, func() firewalls.FirewallInterface {
+		return fw
+	})
+
+	var aggregatedNetworks = []string{}
+	aggregator.OnAggregate(func(network *firewalls.IPAggregatedNetwork) {
+		aggregatedNetworks = append(aggregatedNetworks, network.Network)
+	})
+
+	for i := 1; i < 10; i++ {
+		a.IsFalse(testAggregatorDrop(aggregator, fw, "192.168.1."+strconv.Itoa(i), 300))
+	}
+
+	// 其他网段
+	a.IsFalse(testAggregatorDrop(aggregator, fw, "192.168.2.1", 300))
+	a.IsTrue(len(fw.ips) == 10)
+	a.IsTrue(len(fw.networks) == 0)
+
+	// 达到阈值
+	a.IsTrue(testAggregatorDrop(aggregator, fw, "192.168.1.10", 300))
+	a.IsTrue(len(fw.networks) == 1)
+	a.IsTrue(len(aggregatedNetworks) == 1 && aggregatedNetworks[0] == "192.168.1.0/24")
+
+	// 网段封禁时间不超过IP的封禁时间
+	var timeout = fw.networks["192.168.1.0/24"]
+	a.IsTrue(timeout > 0 && timeout <= 300)
+
+	// 单IP规则被删除
+	a.IsTrue(len(fw.ips) == 1)
+	_, ok := fw.ips["192.168.2.1"]
+	a.IsTrue(ok)
+
+	// 新的IP被吸收
+	a.IsTrue(testAggregatorDrop(aggregator, fw, "192.168.1.100", 300))
+	a.IsTrue(len(fw.ips) == 1)
+
+	var networks = aggregator.Networks()
+	a.IsTrue(len(networks) == 1)
+	a.IsTrue(networks[0].IPFrom == "192.168.1.0")
+	a.IsTrue(networks[0].IPTo == "192.168.1.255")
+	a.IsTrue(networks[0].CountIPs == 10)
+}
+
+func TestIPAggregator_Release(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	var fw = newTestAggregatorFirewall()
+	var config = firewalls.DefaultIPAggregatorConfig()
+	config.Threshold = 3
+	config.IPv6PrefixBits = 64
+	var aggregator = firewalls.NewIPAggregator(config, func() firewalls.FirewallInterface {
+		return fw
+	})
+
+	var releasedNetworks = []string{}
+	aggregator.OnRelease(func(network *firewalls.IPAggregatedNetwork) {
+		releasedNetworks = append(releasedNetworks, network.Network)
+	})
+
+	a.IsFalse(testAggregatorDrop(aggregator, fw, "2001:db8:1:2::1", 1))
+	a.IsFalse(testAggregatorDrop(aggregator, fw, "2001:db8:1:2::2", 1))
+	a.IsTrue(testAggregatorDrop(aggregator, fw, "2001:db8:1:2:ffff::3", 1))
+	a.IsTrue(fw.networks["2001:db8:1:2::/64"] == 1)
+
+	// 长期封禁的IP
+	a.IsTrue(aggregator.Record("2001:db8:1:2::4", 0))
+
+	// 被手动删除的IP
+	a.IsTrue(aggregator.Record("2001:db8:1:2::5", time.Now().Unix()+3600))
+	aggregator.Remove("2001:db8:1:2::5")
+
+	// 未过期
+	aggregator.GC()
+	a.IsTrue(len(releasedNetworks) == 0)
+
+	time.Sleep(1100 * time.Millisecond)
+	aggregator.GC()
+	a.IsTrue(len(releasedNetworks) == 1)
+	a.IsTrue(len(fw.networks) == 0)
+	a.IsTrue(len(aggregator.Networks()) == 0)
+
+	// 只恢复未过期的IP
+	a.IsTrue(len(fw.ips) == 1)
+	timeout, ok := fw.ips["2001:db8:1:2::4"]
+	a.IsTrue(ok && timeout == 0)
+}
+
+func TestIPAggregator_Off(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	var config = firewalls.DefaultIPAggregatorConfig()
+	config.IsOn = false
+	config.Threshold = 2
+	var aggregator = firewalls.NewIPAggregator(config, func() firewalls.FirewallInterface {
+		return firewalls.NewMockFirewall()
+	})
+	for i := 0; i < 10; i++ {
+		a.IsFalse(aggregator.Record("10.0.0."+strconv.Itoa(i), 0))
+	}
+	a.IsTrue(len(aggregator.Networks()) == 0)
+}
@@ -183,6 +183,59 @@ func (this *Set) DeleteIPElement(ip string) error {
 	}
 }
 
+// AddIntervalElement 添加区间元素，只适用于 Interval 类型的集合
+// from 为区间起始值，toExclusive 为区间结束值的下一个值，为空表示直到最大值
+func (this *Set) AddIntervalElement(from []byte, toExclusive []byte, options *ElementOptions) error {
+	var rawElement = nft.SetElement{
+		Key: from,
+	}
+	if options != nil {
+		rawElement.Timeout = options.Timeout
+	}
+	var rawElements = []nft.SetElement{rawElement}
+	if len(toExclusive) > 0 {
+		rawElements = append(rawElements, nft.SetElement{
+			Key:         toExclusive,
+			IntervalEnd: true,
+		})
+	}
+
+	err := this.conn.Raw().SetAddElements(this.rawSet, rawElements)
+	if err != nil {
+		return err
+	}
+	err = this.conn.Commit()
+	if err != nil && strings.Contains(err.Error(), "file exists") {
+		return nil
+	}
+	return err
+}
+
+// DeleteIntervalElement 删除区间元素
+func (this *Set) DeleteIntervalElement(from []byte, toExclusive []byte) error {
+	var rawElements = []nft.SetElement{
+		{
+			Key: from,
+		},
+	}
+	if len(toExclusive) > 0 {
+		rawElements = append(rawElements, nft.SetElement{
+			Key:         toExclusive,
+			IntervalEnd: true,
+		})
+	}
+
+	err := this.conn.Raw().SetDeleteElements(this.rawSet, rawElements)
+	if err != nil {
+		return err
+	}
+	err = this.conn.Commit()
+	if err != nil && strings.Contains(err.Error(), "no such file or directory") {
+		return nil
+	}
+	return err
+}
+
 func (this *Set) Batch() *SetBatch {
 	return this.batch
 }
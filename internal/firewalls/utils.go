@@ -4,6 +4,8 @@ package firewalls
 
 import (
 	"time"
+
+	"github.com/TeaOSLab/EdgeNode/internal/conns"
 )
 
 // DropTemporaryTo 使用本地防火墙临时拦截IP数据包
@@ -21,6 +23,12 @@ func DropTemporaryTo(ip string, expiresAt int64) {
 		timeout = 3600
 	}
 
+	// 所在网段已经被封禁
+	if SharedIPAggregator.Record(ip, expiresAt) {
+		conns.SharedMap.CloseIPConns(ip)
+		return
+	}
+
 	// 使用本地防火墙延长封禁
 	var fw = Firewall()
 	if fw != nil && !fw.IsMock() {
@@ -0,0 +1,103 @@
+// Copyright 2024 GoEdge CDN goedge.cdn@gmail.com. All rights reserved. Official site: https://goedge.cloud .
+
+package iplibrary
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/TeaOSLab/EdgeCommon/pkg/serverconfigs/firewallconfigs"
+	"github.com/TeaOSLab/EdgeNode/internal/configs"
+)
+
+// IPFeedsConfigFileName IP名单订阅配置文件
+const IPFeedsConfigFileName = "ip_feeds.yaml"
+
+const (
+	IPFeedDefaultInterval = 3600 // 默认更新间隔，单位：秒
+	IPFeedMinInterval     = 60   // 最小更新间隔，单位：秒
+)
+
+// IPFeedsConfig IP名单订阅配置
+type IPFeedsConfig struct {
+	Feeds []*IPFeedConfig `yaml:"feeds" json:"feeds"`
+}
+
+// Init 初始化
+func (this *IPFeedsConfig) Init() error {
+	var nameMap = map[string]bool{}
+	for _, feed := range this.Feeds {
+		err := feed.Init()
+		if err != nil {
+			return err
+		}
+		if nameMap[feed.Name] {
+			return errors.New("duplicate feed name '" + feed.Name + "'")
+		}
+		nameMap[feed.Name] = true
+	}
+	return nil
+}
+
+// IPFeedConfig 单个IP名单订阅配置
+type IPFeedConfig struct {
+	Name       string       `yaml:"name" json:"name"`             // 名称，用来区分不同的订阅，修改名称会导致原有条目被删除
+	URL        string       `yaml:"url" json:"url"`               // 地址，支持 http://、https://、file:// 和本地文件路径
+	Format     IPFeedFormat `yaml:"format" json:"format"`         // 格式，为空时根据内容自动识别
+	Interval   int          `yaml:"interval" json:"interval"`     // 更新间隔，单位：秒
+	TTL        int          `yaml:"ttl" json:"ttl"`               // 条目有效期，单位：秒，默认为更新间隔的3倍，小于0表示不过期
+	ListType   IPListType   `yaml:"listType" json:"listType"`     // 名单类型：black、white
+	ServerId   int64        `yaml:"serverId" json:"serverId"`     // 服务ID，为0表示全局名单
+	EventLevel string       `yaml:"eventLevel" json:"eventLevel"` // 触发动作的事件级别
+}
+
+// Init 初始化
+func (this *IPFeedConfig) Init() error {
+	this.Name = strings.TrimSpace(this.Name)
+	if len(this.Name) == 0 {
+		return errors.New("'name' required")
+	}
+	if len(this.URL) == 0 {
+		return errors.New("feed '" + this.Name + "': 'url' required")
+	}
+
+	switch this.Format {
+	case "", IPFeedFormatPlain, IPFeedFormatDROP, IPFeedFormatNetset, IPFeedFormatCSV:
+	default:
+		return errors.New("feed '" + this.Name + "': invalid format '" + this.Format + "'")
+	}
+
+	switch this.ListType {
+	case "":
+		this.ListType = IPListTypeBlack
+	case IPListTypeBlack, IPListTypeWhite:
+	default:
+		return errors.New("feed '" + this.Name + "': invalid list type '" + this.ListType + "'")
+	}
+
+	if this.Interval <= 0 {
+		this.Interval = IPFeedDefaultInterval
+	} else if this.Interval < IPFeedMinInterval {
+		this.Interval = IPFeedMinInterval
+	}
+	if this.TTL == 0 {
+		this.TTL = this.Interval * 3
+	}
+
+	if len(this.EventLevel) == 0 {
+		this.EventLevel = firewallconfigs.DefaultEventLevel
+	}
+
+	return nil
+}
+
+// LoadIPFeedsConfig 从配置文件中加载订阅配置
+// 如果配置文件不存在，则返回空的配置
+func LoadIPFeedsConfig() (*IPFeedsConfig, error) {
+	return configs.LoadLocalConfig(IPFeedsConfigFileName, &IPFeedsConfig{})
+}
+
+// ParseIPFeedsConfig 分析订阅配置
+func ParseIPFeedsConfig(data []byte) (*IPFeedsConfig, error) {
+	return configs.ParseLocalConfig(data, &IPFeedsConfig{})
+}
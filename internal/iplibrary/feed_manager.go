@@ -0,0 +1,556 @@
+// Copyright 2024 GoEdge CDN goedge.cdn@gmail.com. All rights reserved. Official site: https://goedge.cloud .
+
+package iplibrary
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TeaOSLab/EdgeCommon/pkg/iputils"
+	"github.com/TeaOSLab/EdgeCommon/pkg/rpc/pb"
+	teaconst "github.com/TeaOSLab/EdgeNode/internal/const"
+	"github.com/TeaOSLab/EdgeNode/internal/events"
+	"github.com/TeaOSLab/EdgeNode/internal/remotelogs"
+	"github.com/TeaOSLab/EdgeNode/internal/utils/goman"
+	"github.com/cespare/xxhash/v2"
+	"github.com/iwind/TeaGo/Tea"
+	"github.com/iwind/TeaGo/maps"
+)
+
+// 订阅内容最大尺寸
+const ipFeedMaxSize = 64 << 20
+
+// 更新失败后重试的间隔，单位：秒
+const ipFeedRetryInterval = 300
+
+var SharedIPFeedManager = NewIPFeedManager()
+
+func init() {
+	if !teaconst.IsMain {
+		return
+	}
+
+	events.On(events.EventLoaded, func() {
+		goman.New(func() {
+			SharedIPFeedManager.Start()
+		})
+	})
+	events.OnClose(func() {
+		SharedIPFeedManager.Stop()
+	})
+}
+
+// IPFeedStatus 订阅状态
+type IPFeedStatus struct {
+	Name          string `json:"name"`
+	URL           string `json:"url"`
+	Format        string `json:"format"`
+	ListType      string `json:"listType"`
+	ServerId      int64  `json:"serverId"`
+	CountItems    int    `json:"countItems"`    // 当前条目数
+	CountAdded    int    `json:"countAdded"`    // 最近一次更新时添加的条目数
+	CountRemoved  int    `json:"countRemoved"`  // 最近一次更新时删除的条目数
+	CountInvalid  int    `json:"countInvalid"`  // 最近一次更新时不合法的行数
+	IsNotModified bool   `json:"isNotModified"` // 最近一次更新时内容是否没有变化
+	UpdatedAt     int64  `json:"updatedAt"`     // 最近一次尝试更新的时间
+	SucceededAt   int64  `json:"succeededAt"`   // 最近一次成功更新的时间
+	NextUpdateAt  int64  `json:"nextUpdateAt"`  // 下一次更新的时间
+	Error         string `json:"error"`         // 最近一次更新的错误
+}
+
+func (this *IPFeedStatus) AsMap() maps.Map {
+	return maps.Map{
+		"name":          this.Name,
+		"url":           this.URL,
+		"format":        this.Format,
+		"listType":      this.ListType,
+		"serverId":      this.ServerId,
+		"countItems":    this.CountItems,
+		"countAdded":    this.CountAdded,
+		"countRemoved":  this.CountRemoved,
+		"countInvalid":  this.CountInvalid,
+		"isNotModified": this.IsNotModified,
+		"updatedAt":     this.UpdatedAt,
+		"succeededAt":   this.SucceededAt,
+		"nextUpdateAt":  this.NextUpdateAt,
+		"error":         this.Error,
+	}
+}
+
+// IPFeed 单个订阅
+type IPFeed struct {
+	config  *IPFeedConfig
+	items   map[int64]*pb.IPItem // itemId => item
+	entries []*IPFeedEntry       // 最近一次读取的条目
+
+	etag         string
+	lastModified string
+
+	status *IPFeedStatus
+}
+
+func (this *IPFeed) Config() *IPFeedConfig {
+	return this.config
+}
+
+// IPFeedManager IP名单订阅管理
+// 定期从本地文件或者URL中读取第三方IP名单，和上一次的结果对比后更新到本地IP名单中
+type IPFeedManager struct {
+	feeds map[string]*IPFeed // name => feed
+
+	configModifiedAt time.Time
+	httpClient       *http.Client
+	actionManager    *ActionManager
+
+	ticker     *time.Ticker
+	updateChan chan bool
+
+	mu       sync.Mutex // 保护 feeds
+	updateMu sync.Mutex // 保证同一时间只有一个更新任务
+}
+
+func NewIPFeedManager() *IPFeedManager {
+	return &IPFeedManager{
+		feeds: map[string]*IPFeed{},
+		httpClient: &http.Client{
+			Timeout: 120 * time.Second,
+		},
+		actionManager: SharedActionManager,
+		updateChan:    make(chan bool, 1),
+	}
+}
+
+func (this *IPFeedManager) Start() {
+	this.ticker = time.NewTicker(10 * time.Second)
+	for {
+		this.reloadConfig()
+		this.UpdateDueFeeds()
+
+		select {
+		case <-this.ticker.C:
+		case <-this.updateChan:
+		}
+	}
+}
+
+func (this *IPFeedManager) Stop() {
+	if this.ticker != nil {
+		this.ticker.Stop()
+	}
+}
+
+// 配置文件变化时重新加载
+func (this *IPFeedManager) reloadConfig() {
+	stat, err := os.Stat(Tea.ConfigFile(IPFeedsConfigFileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			if !this.configModifiedAt.IsZero() {
+				this.configModifiedAt = time.Time{}
+				this.UpdateConfigs(nil)
+			}
+			return
+		}
+		remotelogs.Error("IP_FEED_MANAGER", "read config failed: "+err.Error())
+		return
+	}
+	if stat.ModTime().Equal(this.configModifiedAt) {
+		return
+	}
+	this.configModifiedAt = stat.ModTime()
+
+	config, err := LoadIPFeedsConfig()
+	if err != nil {
+		remotelogs.Error("IP_FEED_MANAGER", "load '"+IPFeedsConfigFileName+"' failed: "+err.Error())
+		return
+	}
+	this.UpdateConfigs(config.Feeds)
+}
+
+// UpdateConfigs 更新订阅配置
+// 已删除订阅中的条目会从IP名单中删除
+func (this *IPFeedManager) UpdateConfigs(configs []*IPFeedConfig) {
+	this.updateMu.Lock()
+	defer this.updateMu.Unlock()
+
+	var configMap = map[string]*IPFeedConfig{}
+	for _, config := range configs {
+		configMap[config.Name] = config
+	}
+
+	this.mu.Lock()
+	var oldFeeds = this.feeds
+	var newFeeds = map[string]*IPFeed{}
+	for name, config := range configMap {
+		var feed = oldFeeds[name]
+		if feed != nil && feed.config.ListType == config.ListType && feed.config.ServerId == config.ServerId {
+			// 地址或格式变化后立即更新
+			if feed.config.URL != config.URL || feed.config.Format != config.Format {
+				feed.etag = ""
+				feed.lastModified = ""
+				feed.status.NextUpdateAt = 0
+			}
+			feed.config = config
+			feed.status.URL = config.URL
+			feed.status.Format = config.Format
+		} else {
+			feed = &IPFeed{
+				config: config,
+				items:  map[int64]*pb.IPItem{},
+				status: &IPFeedStatus{
+					Name:     config.Name,
+					URL:      config.URL,
+					Format:   config.Format,
+					ListType: config.ListType,
+					ServerId: config.ServerId,
+				},
+			}
+		}
+		newFeeds[name] = feed
+	}
+	this.feeds = newFeeds
+	this.mu.Unlock()
+
+	// 清除已经删除的订阅
+	for name, oldFeed := range oldFeeds {
+		var newFeed = newFeeds[name]
+		if newFeed == oldFeed {
+			continue
+		}
+		this.apply(oldFeed, map[int64]*pb.IPItem{}, false)
+		if len(oldFeed.items) > 0 {
+			remotelogs.Println("IP_FEED_MANAGER", "feed '"+name+"' removed")
+		}
+	}
+}
+
+// UpdateDueFeeds 更新所有到期的订阅
+func (this *IPFeedManager) UpdateDueFeeds() {
+	var now = time.Now().Unix()
+	for _, feed := range this.findFeeds() {
+		this.mu.Lock()
+		var isDue = feed.status.NextUpdateAt <= now
+		this.mu.Unlock()
+		if isDue {
+			_ = this.UpdateFeed(feed.config.Name)
+		}
+	}
+}
+
+// TriggerUpdate 立即更新某个订阅，name为空时更新所有订阅
+func (this *IPFeedManager) TriggerUpdate(name string) error {
+	var found = false
+	this.mu.Lock()
+	for feedName, feed := range this.feeds {
+		if len(name) == 0 || feedName == name {
+			feed.status.NextUpdateAt = 0
+			found = true
+		}
+	}
+	this.mu.Unlock()
+
+	if !found && len(name) > 0 {
+		return errors.New("feed '" + name + "' not found")
+	}
+
+	select {
+	case this.updateChan <- true:
+	default:
+	}
+	return nil
+}
+
+// UpdateFeed 更新某个订阅
+func (this *IPFeedManager) UpdateFeed(name string) error {
+	this.updateMu.Lock()
+	defer this.updateMu.Unlock()
+
+	this.mu.Lock()
+	var feed = this.feeds[name]
+	this.mu.Unlock()
+	if feed == nil {
+		return errors.New("feed '" + name + "' not found")
+	}
+
+	var config = feed.config
+	var now = time.Now().Unix()
+
+	data, notModified, err := this.fetch(feed)
+	var entries = feed.entries
+	var countInvalid int
+	if err == nil && !notModified {
+		entries, countInvalid, err = ParseIPFeed(data, config.Format)
+		if err != nil {
+			// 下次重新读取完整内容
+			feed.etag = ""
+			feed.lastModified = ""
+		}
+	}
+
+	if err != nil {
+		this.mu.Lock()
+		feed.status.UpdatedAt = now
+		feed.status.NextUpdateAt = now + int64(min(config.Interval, ipFeedRetryInterval))
+		feed.status.Error = err.Error()
+		this.mu.Unlock()
+
+		remotelogs.Error("IP_FEED_MANAGER", "update feed '"+name+"' failed: "+err.Error())
+		return err
+	}
+
+	// 内容没有变化时也需要重新计算过期时间，以免条目过期
+	var newItems = this.convertEntries(config, entries, now)
+	countAdded, countRemoved := this.apply(feed, newItems, true)
+	feed.entries = entries
+
+	this.mu.Lock()
+	feed.status.CountItems = len(newItems)
+	feed.status.CountAdded = countAdded
+	feed.status.CountRemoved = countRemoved
+	if !notModified {
+		feed.status.CountInvalid = countInvalid
+	}
+	feed.status.IsNotModified = notModified
+	feed.status.UpdatedAt = now
+	feed.status.SucceededAt = now
+	feed.status.NextUpdateAt = now + int64(config.Interval)
+	feed.status.Error = ""
+	this.mu.Unlock()
+
+	if countAdded > 0 || countRemoved > 0 {
+		remotelogs.Println("IP_FEED_MANAGER", "feed '"+name+"' updated: "+strconv.Itoa(len(newItems))+" items, +"+strconv.Itoa(countAdded)+" -"+strconv.Itoa(countRemoved))
+	}
+
+	return nil
+}
+
+// Status 所有订阅的状态
+func (this *IPFeedManager) Status() []*IPFeedStatus {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	var result = []*IPFeedStatus{}
+	for _, feed := range this.feeds {
+		var status = *feed.status
+		result = append(result, &status)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+func (this *IPFeedManager) findFeeds() []*IPFeed {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	var result = []*IPFeed{}
+	for _, feed := range this.feeds {
+		result = append(result, feed)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].config.Name < result[j].config.Name
+	})
+	return result
+}
+
+// 读取订阅内容
+func (this *IPFeedManager) fetch(feed *IPFeed) (data []byte, notModified bool, err error) {
+	var url = feed.config.URL
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		var path = strings.TrimPrefix(url, "file://")
+		if !strings.HasPrefix(path, "/") {
+			path = Tea.Root + "/" + path
+		}
+		stat, err := os.Stat(path)
+		if err != nil {
+			return nil, false, err
+		}
+		var modifiedAt = stat.ModTime().String()
+		if modifiedAt == feed.lastModified {
+			return nil, true, nil
+		}
+		if stat.Size() > ipFeedMaxSize {
+			return nil, false, errors.New("file is too large")
+		}
+		data, err = os.ReadFile(path)
+		if err != nil {
+			return nil, false, err
+		}
+		feed.lastModified = modifiedAt
+		return data, false, nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	req.Header.Set("User-Agent", teaconst.ProductName+"/"+teaconst.Version)
+	if len(feed.etag) > 0 {
+		req.Header.Set("If-None-Match", feed.etag)
+	}
+	if len(feed.lastModified) > 0 {
+		req.Header.Set("If-Modified-Since", feed.lastModified)
+	}
+	resp, err := this.httpClient.Do(req)
+	if err != nil {
+		return nil, false, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		return nil, true, nil
+	default:
+		return nil, false, errors.New("unexpected response status '" + resp.Status + "'")
+	}
+
+	data, err = io.ReadAll(io.LimitReader(resp.Body, ipFeedMaxSize+1))
+	if err != nil {
+		return nil, false, err
+	}
+	if len(data) > ipFeedMaxSize {
+		return nil, false, errors.New("response body is too large")
+	}
+
+	feed.etag = resp.Header.Get("ETag")
+	feed.lastModified = resp.Header.Get("Last-Modified")
+	return data, false, nil
+}
+
+// 将订阅条目转换为IP条目
+func (this *IPFeedManager) convertEntries(config *IPFeedConfig, entries []*IPFeedEntry, now int64) map[int64]*pb.IPItem {
+	var result = map[int64]*pb.IPItem{}
+	for _, entry := range entries {
+		var expiresAt = this.expiresAt(config, entry.ExpiresAt, now)
+		if expiresAt > 0 && expiresAt <= now {
+			continue
+		}
+
+		var itemType = IPItemTypeIPv4
+		if strings.Contains(entry.IPFrom, ":") {
+			itemType = IPItemTypeIPv6
+		}
+
+		var itemId = IPFeedItemId(config.Name, entry.Key())
+		result[itemId] = &pb.IPItem{
+			Id:         itemId,
+			IpFrom:     entry.IPFrom,
+			IpTo:       entry.IPTo,
+			ExpiredAt:  expiresAt,
+			Type:       itemType,
+			EventLevel: config.EventLevel,
+			ListType:   config.ListType,
+			IsGlobal:   config.ServerId == 0,
+			ServerId:   config.ServerId,
+		}
+	}
+	return result
+}
+
+func (this *IPFeedManager) expiresAt(config *IPFeedConfig, entryExpiresAt int64, now int64) int64 {
+	if entryExpiresAt > 0 {
+		return entryExpiresAt
+	}
+	if config.TTL > 0 {
+		return now + int64(config.TTL)
+	}
+	return 0
+}
+
+// 对比新旧条目并应用到IP名单中
+func (this *IPFeedManager) apply(feed *IPFeed, newItems map[int64]*pb.IPItem, allowCreatingList bool) (countAdded int, countRemoved int) {
+	var config = feed.config
+	var list = this.findList(config, allowCreatingList)
+	if list == nil {
+		feed.items = newItems
+		return
+	}
+
+	for itemId, oldItem := range feed.items {
+		_, ok := newItems[itemId]
+		if ok {
+			continue
+		}
+		countRemoved++
+		list.Delete(uint64(itemId))
+		this.actionManager.DeleteItem(config.ListType, this.cloneItem(oldItem))
+	}
+
+	for itemId, item := range newItems {
+		oldItem, ok := feed.items[itemId]
+		if ok && oldItem.ExpiredAt == item.ExpiredAt {
+			continue
+		}
+
+		list.AddDelay(&IPItem{
+			Id:         uint64(itemId),
+			Type:       item.Type,
+			IPFrom:     iputils.ToBytes(item.IpFrom),
+			IPTo:       iputils.ToBytes(item.IpTo),
+			ExpiredAt:  item.ExpiredAt,
+			EventLevel: item.EventLevel,
+		})
+
+		// 新条目和过期时间变化的条目都需要通知动作，以便于更新防火墙中的超时时间
+		if !ok {
+			countAdded++
+		}
+		this.actionManager.AddItem(config.ListType, this.cloneItem(item))
+	}
+
+	if countAdded > 0 || countRemoved > 0 || len(newItems) > 0 {
+		list.Sort()
+	}
+
+	feed.items = newItems
+	return
+}
+
+func (this *IPFeedManager) findList(config *IPFeedConfig, create bool) *IPList {
+	if config.ServerId > 0 {
+		switch config.ListType {
+		case IPListTypeWhite:
+			return SharedServerListManager.FindWhiteList(config.ServerId, create)
+		default:
+			return SharedServerListManager.FindBlackList(config.ServerId, create)
+		}
+	}
+
+	switch config.ListType {
+	case IPListTypeWhite:
+		return GlobalWhiteIPList
+	default:
+		return GlobalBlackIPList
+	}
+}
+
+// 动作执行时可能会修改条目，所以每次都使用新的对象
+func (this *IPFeedManager) cloneItem(item *pb.IPItem) *pb.IPItem {
+	return &pb.IPItem{
+		Id:         item.Id,
+		IpFrom:     item.IpFrom,
+		IpTo:       item.IpTo,
+		ExpiredAt:  item.ExpiredAt,
+		Type:       item.Type,
+		EventLevel: item.EventLevel,
+		ListType:   item.ListType,
+		IsGlobal:   item.IsGlobal,
+		ServerId:   item.ServerId,
+	}
+}
+
+// IPFeedItemId 根据订阅名称和条目生成条目ID
+// 为了避免和API中的条目ID冲突，ID的第62位固定为1
+func IPFeedItemId(feedName string, key string) int64 {
+	return int64(xxhash.Sum64String(feedName+"@"+key)&(1<<62-1)) | 1<<62
+}
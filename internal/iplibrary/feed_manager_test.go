@@ -0,0 +1,184 @@
+// Copyright 2024 GoEdge CDN goedge.cdn@gmail.com. All rights reserved. Official site: https://goedge.cloud .
+
+package iplibrary
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/TeaOSLab/EdgeCommon/pkg/iputils"
+	"github.com/TeaOSLab/EdgeCommon/pkg/rpc/pb"
+	"github.com/TeaOSLab/EdgeCommon/pkg/serverconfigs/firewallconfigs"
+	"github.com/iwind/TeaGo/assert"
+)
+
+type testFeedAction struct {
+	BaseAction
+
+	locker  sync.Mutex
+	added   map[string]int64 // ip => expiredAt
+	deleted []string
+}
+
+func newTestFeedAction() *testFeedAction {
+	return &testFeedAction{
+		added: map[string]int64{},
+	}
+}
+
+func (this *testFeedAction) Init(config *firewallconfigs.FirewallActionConfig) error {
+	return nil
+}
+
+func (this *testFeedAction) AddItem(listType IPListType, item *pb.IPItem) error {
+	this.locker.Lock()
+	defer this.locker.Unlock()
+	this.added[item.IpFrom] = item.ExpiredAt
+	return nil
+}
+
+func (this *testFeedAction) DeleteItem(listType IPListType, item *pb.IPItem) error {
+	this.locker.Lock()
+	defer this.locker.Unlock()
+	delete(this.added, item.IpFrom)
+	this.deleted = append(this.deleted, item.IpFrom)
+	return nil
+}
+
+func newTestFeedManager(action ActionInterface) *IPFeedManager {
+	var actionManager = NewActionManager()
+	actionManager.eventMap = map[string][]ActionInterface{
+		firewallconfigs.DefaultEventLevel: {action},
+	}
+
+	var manager = NewIPFeedManager()
+	manager.actionManager = actionManager
+	return manager
+}
+
+func TestIPFeedManager_File(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	var path = filepath.Join(t.TempDir(), "blocked.txt")
+	err := os.WriteFile(path, []byte("10.88.0.1\n10.88.1.0/24\n"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var action = newTestFeedAction()
+	var manager = newTestFeedManager(action)
+
+	var config = &IPFeedConfig{
+		Name:     "test-file",
+		URL:      path,
+		Format:   IPFeedFormatPlain,
+		ServerId: 100001,
+	}
+	a.IsNil(config.Init())
+	manager.UpdateConfigs([]*IPFeedConfig{config})
+	a.IsNil(manager.UpdateFeed("test-file"))
+
+	var list = SharedServerListManager.FindBlackList(100001, false)
+	a.IsNotNil(list)
+	a.IsTrue(list.Contains(iputils.ToBytes("10.88.0.1")))
+	a.IsTrue(list.Contains(iputils.ToBytes("10.88.1.100")))
+	a.IsFalse(list.Contains(iputils.ToBytes("10.88.2.1")))
+	a.IsTrue(len(action.added) == 2)
+	a.IsTrue(action.added["10.88.0.1"] > time.Now().Unix())
+
+	var status = manager.Status()
+	a.IsTrue(len(status) == 1)
+	a.IsTrue(status[0].CountItems == 2)
+	a.IsTrue(status[0].CountAdded == 2)
+
+	// 文件变化
+	err = os.WriteFile(path, []byte("10.88.1.0/24\n10.88.3.1\n"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var modifiedAt = time.Now().Add(time.Second)
+	_ = os.Chtimes(path, modifiedAt, modifiedAt)
+	a.IsNil(manager.UpdateFeed("test-file"))
+
+	a.IsFalse(list.Contains(iputils.ToBytes("10.88.0.1")))
+	a.IsTrue(list.Contains(iputils.ToBytes("10.88.3.1")))
+	a.IsTrue(len(action.deleted) == 1 && action.deleted[0] == "10.88.0.1")
+	status = manager.Status()
+	a.IsTrue(status[0].CountAdded == 1)
+	a.IsTrue(status[0].CountRemoved == 1)
+
+	// 删除订阅
+	manager.UpdateConfigs(nil)
+	a.IsFalse(list.Contains(iputils.ToBytes("10.88.1.100")))
+	a.IsFalse(list.Contains(iputils.ToBytes("10.88.3.1")))
+	a.IsTrue(len(action.added) == 0)
+	a.IsTrue(len(manager.Status()) == 0)
+}
+
+func TestIPFeedManager_HTTP(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	var body = "ip,expires_at\n10.99.0.1,\n10.99.0.2," + time.Now().Add(10*time.Minute).Format(time.RFC3339) + "\n10.99.0.3,1\n"
+	var countRequests = 0
+	var failing = false
+	var server = httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, req *http.Request) {
+		countRequests++
+		if failing {
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if req.Header.Get("If-None-Match") == `"v1"` {
+			writer.WriteHeader(http.StatusNotModified)
+			return
+		}
+		writer.Header().Set("ETag", `"v1"`)
+		_, _ = writer.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	var action = newTestFeedAction()
+	var manager = newTestFeedManager(action)
+
+	var config = &IPFeedConfig{
+		Name:     "test-http",
+		URL:      server.URL + "/feed.csv",
+		ServerId: 100002,
+		TTL:      600,
+	}
+	a.IsNil(config.Init())
+	manager.UpdateConfigs([]*IPFeedConfig{config})
+	a.IsNil(manager.UpdateFeed("test-http"))
+
+	var list = SharedServerListManager.FindBlackList(100002, false)
+	a.IsNotNil(list)
+	a.IsTrue(list.Contains(iputils.ToBytes("10.99.0.1")))
+	a.IsTrue(list.Contains(iputils.ToBytes("10.99.0.2")))
+
+	// 已经过期的条目
+	a.IsFalse(list.Contains(iputils.ToBytes("10.99.0.3")))
+	a.IsTrue(len(action.added) == 2)
+
+	// 未修改
+	a.IsNil(manager.UpdateFeed("test-http"))
+	a.IsTrue(countRequests == 2)
+	var status = manager.Status()
+	a.IsTrue(status[0].IsNotModified)
+	a.IsTrue(status[0].CountItems == 2)
+	a.IsTrue(list.Contains(iputils.ToBytes("10.99.0.1")))
+
+	// 失败时保留原有的条目
+	failing = true
+	a.IsNotNil(manager.UpdateFeed("test-http"))
+	status = manager.Status()
+	a.IsTrue(len(status[0].Error) > 0)
+	a.IsTrue(status[0].CountItems == 2)
+	a.IsTrue(list.Contains(iputils.ToBytes("10.99.0.1")))
+
+	manager.UpdateConfigs(nil)
+	a.IsFalse(list.Contains(iputils.ToBytes("10.99.0.1")))
+}
@@ -0,0 +1,242 @@
+// Copyright 2024 GoEdge CDN goedge.cdn@gmail.com. All rights reserved. Official site: https://goedge.cloud .
+
+package iplibrary
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type IPFeedFormat = string
+
+const (
+	IPFeedFormatPlain  IPFeedFormat = "plain"  // 每行一个IP、CIDR或者IP范围（IP1-IP2），支持 # 和 ; 注释
+	IPFeedFormatDROP   IPFeedFormat = "drop"   // Spamhaus DROP，比如 1.10.16.0/20 ; SBL256894，也支持新的JSON行格式
+	IPFeedFormatNetset IPFeedFormat = "netset" // FireHOL netset，每行一个IP或CIDR，支持 # 注释
+	IPFeedFormatCSV    IPFeedFormat = "csv"    // CSV：IP,过期时间[,其他字段]，过期时间可以是时间戳或者日期
+)
+
+// IPFeedEntry 订阅中的条目
+type IPFeedEntry struct {
+	IPFrom    string
+	IPTo      string
+	ExpiresAt int64 // 条目中指定的过期时间，为0表示使用订阅的TTL
+}
+
+// Key 条目唯一标识
+func (this *IPFeedEntry) Key() string {
+	if len(this.IPTo) == 0 {
+		return this.IPFrom
+	}
+	return this.IPFrom + "-" + this.IPTo
+}
+
+// ParseIPFeed 分析订阅内容
+// 返回的条目已经去重，不合法的行会被忽略并计入 countInvalid
+func ParseIPFeed(data []byte, format IPFeedFormat) (entries []*IPFeedEntry, countInvalid int, err error) {
+	if len(format) == 0 {
+		format = DetectIPFeedFormat(data)
+	}
+
+	var keyMap = map[string]bool{}
+	var addEntry = func(entry *IPFeedEntry) {
+		var key = entry.Key()
+		if keyMap[key] {
+			return
+		}
+		keyMap[key] = true
+		entries = append(entries, entry)
+	}
+
+	switch format {
+	case IPFeedFormatCSV:
+		var reader = csv.NewReader(bytes.NewReader(data))
+		reader.Comment = '#'
+		reader.FieldsPerRecord = -1
+		reader.TrimLeadingSpace = true
+		var isFirst = true
+		for {
+			record, readErr := reader.Read()
+			if readErr != nil {
+				if readErr == io.EOF {
+					break
+				}
+				return nil, 0, readErr
+			}
+			if len(record) == 0 || len(strings.TrimSpace(record[0])) == 0 {
+				continue
+			}
+
+			ipFrom, ipTo, ok := ParseIPFeedRange(record[0])
+			if !ok {
+				// 第一行可能是标题
+				if !isFirst {
+					countInvalid++
+				}
+				isFirst = false
+				continue
+			}
+			isFirst = false
+
+			var entry = &IPFeedEntry{
+				IPFrom: ipFrom,
+				IPTo:   ipTo,
+			}
+			if len(record) > 1 && len(strings.TrimSpace(record[1])) > 0 {
+				expiresAt, ok := parseIPFeedTime(strings.TrimSpace(record[1]))
+				if !ok {
+					countInvalid++
+					continue
+				}
+				entry.ExpiresAt = expiresAt
+			}
+			addEntry(entry)
+		}
+	case IPFeedFormatPlain, IPFeedFormatDROP, IPFeedFormatNetset:
+		var scanner = bufio.NewScanner(bytes.NewReader(data))
+		scanner.Buffer(make([]byte, 0, 4096), 1<<20)
+		for scanner.Scan() {
+			var line = strings.TrimSpace(scanner.Text())
+			if len(line) == 0 {
+				continue
+			}
+
+			// Spamhaus DROP JSON格式：{"cidr":"1.10.16.0/20","sblid":"SBL256894","rir":"apnic"}
+			if format == IPFeedFormatDROP && line[0] == '{' {
+				var m = map[string]any{}
+				if json.Unmarshal([]byte(line), &m) != nil {
+					countInvalid++
+					continue
+				}
+				cidr, ok := m["cidr"].(string)
+				if !ok {
+					// 最后一行为统计信息
+					continue
+				}
+				line = cidr
+			}
+
+			// 注释
+			var commentIndex = strings.IndexAny(line, "#;")
+			if format == IPFeedFormatNetset {
+				commentIndex = strings.IndexByte(line, '#')
+			}
+			if commentIndex >= 0 {
+				line = strings.TrimSpace(line[:commentIndex])
+				if len(line) == 0 {
+					continue
+				}
+			}
+
+			// 只取第一列
+			var fields = strings.Fields(line)
+			ipFrom, ipTo, ok := ParseIPFeedRange(fields[0])
+			if !ok {
+				countInvalid++
+				continue
+			}
+			addEntry(&IPFeedEntry{
+				IPFrom: ipFrom,
+				IPTo:   ipTo,
+			})
+		}
+		err = scanner.Err()
+		if err != nil {
+			return nil, 0, err
+		}
+	default:
+		return nil, 0, errors.New("unknown feed format '" + format + "'")
+	}
+
+	return
+}
+
+// DetectIPFeedFormat 根据内容识别订阅格式
+func DetectIPFeedFormat(data []byte) IPFeedFormat {
+	var scanner = bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		var line = strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if line[0] == '{' || strings.Contains(line, " ; SBL") {
+			return IPFeedFormatDROP
+		}
+		if strings.Contains(line, ",") {
+			return IPFeedFormatCSV
+		}
+		break
+	}
+	return IPFeedFormatPlain
+}
+
+// ParseIPFeedRange 分析IP、CIDR或者IP范围，返回起始和结束IP
+// 单个IP返回的ipTo为空
+func ParseIPFeedRange(s string) (ipFrom string, ipTo string, ok bool) {
+	s = strings.TrimSpace(s)
+	if len(s) == 0 {
+		return
+	}
+
+	// CIDR
+	if strings.Contains(s, "/") {
+		_, ipNet, err := net.ParseCIDR(s)
+		if err != nil {
+			return
+		}
+		var first = ipNet.IP
+		var last = make(net.IP, len(first))
+		for i := range first {
+			last[i] = first[i] | ^ipNet.Mask[i]
+		}
+		ones, bits := ipNet.Mask.Size()
+		if ones == bits {
+			return first.String(), "", true
+		}
+		return first.String(), last.String(), true
+	}
+
+	// IP范围
+	var dashIndex = strings.Index(s, "-")
+	if dashIndex > 0 {
+		var from = net.ParseIP(strings.TrimSpace(s[:dashIndex]))
+		var to = net.ParseIP(strings.TrimSpace(s[dashIndex+1:]))
+		if from == nil || to == nil || (from.To4() == nil) != (to.To4() == nil) {
+			return
+		}
+		if from.Equal(to) {
+			return from.String(), "", true
+		}
+		return from.String(), to.String(), true
+	}
+
+	var ip = net.ParseIP(s)
+	if ip == nil {
+		return
+	}
+	return ip.String(), "", true
+}
+
+func parseIPFeedTime(s string) (timestamp int64, ok bool) {
+	// 时间戳
+	timestamp, err := strconv.ParseInt(s, 10, 64)
+	if err == nil {
+		return timestamp, true
+	}
+
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02T15:04:05", "2006-01-02"} {
+		t, err := time.ParseInLocation(layout, s, time.Local)
+		if err == nil {
+			return t.Unix(), true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,160 @@
+// Copyright 2024 GoEdge CDN goedge.cdn@gmail.com. All rights reserved. Official site: https://goedge.cloud .
+
+package iplibrary_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/TeaOSLab/EdgeNode/internal/iplibrary"
+	"github.com/iwind/TeaGo/assert"
+)
+
+func TestParseIPFeed_Plain(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	entries, countInvalid, err := iplibrary.ParseIPFeed([]byte(`# comment
+1.2.3.4
+1.2.3.4
+10.0.0.0/8 # private
+192.168.1.1-192.168.1.10
+2001:db8::/32
+2001:db8::1/128
+invalid-ip
+
+`), iplibrary.IPFeedFormatPlain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.IsTrue(countInvalid == 1)
+	a.IsTrue(len(entries) == 5)
+	a.IsTrue(entries[0].IPFrom == "1.2.3.4" && len(entries[0].IPTo) == 0)
+	a.IsTrue(entries[1].IPFrom == "10.0.0.0" && entries[1].IPTo == "10.255.255.255")
+	a.IsTrue(entries[2].IPFrom == "192.168.1.1" && entries[2].IPTo == "192.168.1.10")
+	a.IsTrue(entries[3].IPFrom == "2001:db8::" && entries[3].IPTo == "2001:db8:ffff:ffff:ffff:ffff:ffff:ffff")
+	a.IsTrue(entries[4].IPFrom == "2001:db8::1" && len(entries[4].IPTo) == 0)
+}
+
+func TestParseIPFeed_DROP(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	{
+		entries, countInvalid, err := iplibrary.ParseIPFeed([]byte(`; Spamhaus DROP List 2024/05/01
+; Last-Modified: Wed, 01 May 2024 08:00:00 GMT
+1.10.16.0/20 ; SBL256894
+1.19.0.0/16 ; SBL434604
+`), iplibrary.IPFeedFormatDROP)
+		if err != nil {
+			t.Fatal(err)
+		}
+		a.IsTrue(countInvalid == 0)
+		a.IsTrue(len(entries) == 2)
+		a.IsTrue(entries[0].IPFrom == "1.10.16.0" && entries[0].IPTo == "1.10.31.255")
+	}
+
+	// JSON行格式
+	{
+		entries, countInvalid, err := iplibrary.ParseIPFeed([]byte(`{"cidr":"1.10.16.0/20","sblid":"SBL256894","rir":"apnic"}
+{"cidr":"1.19.0.0/16","sblid":"SBL434604","rir":"apnic"}
+{"type":"metadata","timestamp":1714550400,"size":2,"records":2}
+`), iplibrary.IPFeedFormatDROP)
+		if err != nil {
+			t.Fatal(err)
+		}
+		a.IsTrue(countInvalid == 0)
+		a.IsTrue(len(entries) == 2)
+		a.IsTrue(entries[1].IPFrom == "1.19.0.0" && entries[1].IPTo == "1.19.255.255")
+	}
+}
+
+func TestParseIPFeed_Netset(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	entries, countInvalid, err := iplibrary.ParseIPFeed([]byte(`#
+# firehol_level1
+#
+0.0.0.0/8
+1.10.16.0/20
+5.188.10.179
+`), iplibrary.IPFeedFormatNetset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.IsTrue(countInvalid == 0)
+	a.IsTrue(len(entries) == 3)
+	a.IsTrue(entries[2].IPFrom == "5.188.10.179")
+}
+
+func TestParseIPFeed_CSV(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	var expiresAt = time.Now().Unix() + 3600
+	entries, countInvalid, err := iplibrary.ParseIPFeed([]byte(`ip,expires_at,reason
+1.2.3.4,`+time.Unix(expiresAt, 0).Format(time.RFC3339)+`,scanner
+5.6.7.0/24,,spam
+8.8.8.8,not-a-time,bad
+9.9.9.9,1700000000
+`), iplibrary.IPFeedFormatCSV)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.IsTrue(countInvalid == 1)
+	a.IsTrue(len(entries) == 3)
+	a.IsTrue(entries[0].IPFrom == "1.2.3.4" && entries[0].ExpiresAt == expiresAt)
+	a.IsTrue(entries[1].IPTo == "5.6.7.255" && entries[1].ExpiresAt == 0)
+	a.IsTrue(entries[2].ExpiresAt == 1700000000)
+}
+
+func TestDetectIPFeedFormat(t *testing.T) {
+	var a = assert.NewAssertion(t)
+	a.IsTrue(iplibrary.DetectIPFeedFormat([]byte("; comment\n1.10.16.0/20 ; SBL256894\n")) == iplibrary.IPFeedFormatDROP)
+	a.IsTrue(iplibrary.DetectIPFeedFormat([]byte(`{"cidr":"1.10.16.0/20"}`)) == iplibrary.IPFeedFormatDROP)
+	a.IsTrue(iplibrary.DetectIPFeedFormat([]byte("ip,expires\n1.2.3.4,0\n")) == iplibrary.IPFeedFormatCSV)
+	a.IsTrue(iplibrary.DetectIPFeedFormat([]byte("# comment\n1.2.3.4\n")) == iplibrary.IPFeedFormatPlain)
+}
+
+func TestParseIPFeedsConfig(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	{
+		config, err := iplibrary.ParseIPFeedsConfig([]byte(`feeds:
+  - name: spamhaus-drop
+    url: https://www.spamhaus.org/drop/drop.txt
+    format: drop
+  - name: local
+    url: /etc/edge/blocked.csv
+    interval: 10
+    ttl: -1
+    listType: white
+    serverId: 1
+`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		a.IsTrue(len(config.Feeds) == 2)
+		a.IsTrue(config.Feeds[0].Interval == iplibrary.IPFeedDefaultInterval)
+		a.IsTrue(config.Feeds[0].TTL == iplibrary.IPFeedDefaultInterval*3)
+		a.IsTrue(config.Feeds[0].ListType == iplibrary.IPListTypeBlack)
+		a.IsTrue(config.Feeds[1].Interval == iplibrary.IPFeedMinInterval)
+		a.IsTrue(config.Feeds[1].TTL == -1)
+	}
+
+	{
+		_, err := iplibrary.ParseIPFeedsConfig([]byte(`feeds:
+  - name: a
+    url: /tmp/a.txt
+  - name: a
+    url: /tmp/b.txt
+`))
+		a.IsNotNil(err)
+	}
+
+	{
+		_, err := iplibrary.ParseIPFeedsConfig([]byte(`feeds:
+  - name: a
+    url: /tmp/a.txt
+    format: xml
+`))
+		a.IsNotNil(err)
+	}
+}
@@ -1,7 +1,6 @@
 package iplibrary
 
 import (
-	"sort"
 	"sync"
 
 	"github.com/TeaOSLab/EdgeCommon/pkg/iputils"
@@ -19,9 +18,9 @@ type IPList struct {
 
 	itemsMap map[uint64]*IPItem // id => item
 
-	sortedRangeItems []*IPItem
-	ipMap            map[string]*IPItem // ipFrom => IPItem
-	bufferItemsMap   map[uint64]*IPItem // id => IPItem
+	rangeTree      *IPRangeTree       // IP范围索引，支持重叠的范围
+	ipMap          map[string]*IPItem // ipFrom => IPItem
+	bufferItemsMap map[uint64]*IPItem // id => IPItem
 
 	allItemsMap map[uint64]*IPItem // id => item
 
@@ -36,6 +35,7 @@ func NewIPList() *IPList {
 		bufferItemsMap: map[uint64]*IPItem{},
 		allItemsMap:    map[uint64]*IPItem{},
 		ipMap:          map[string]*IPItem{},
+		rangeTree:      NewIPRangeTree(),
 	}
 
 	var expireList = expires.NewList()
@@ -161,7 +161,7 @@ func (this *IPList) SetDeleted() {
 }
 
 func (this *IPList) SortedRangeItems() []*IPItem {
-	return this.sortedRangeItems
+	return this.rangeTree.Items()
 }
 
 func (this *IPList) IPMap() map[string]*IPItem {
@@ -189,8 +189,6 @@ func (this *IPList) addItem(item *IPItem, lock bool, sortable bool) {
 		return
 	}
 
-	var shouldSort bool
-
 	if iputils.CompareBytes(item.IPFrom, item.IPTo) == 0 {
 		item.IPTo = nil
 	}
@@ -226,8 +224,14 @@ func (this *IPList) addItem(item *IPItem, lock bool, sortable bool) {
 		this.allItemsMap[item.Id] = item
 	} else if !IsZero(item.IPFrom) {
 		if !IsZero(item.IPTo) {
-			this.sortedRangeItems = append(this.sortedRangeItems, item)
-			shouldSort = true
+			if sortable && len(this.bufferItemsMap) == 0 {
+				this.rangeTree.Insert(item)
+			} else {
+				this.rangeTree.Append(item)
+				if sortable {
+					this.sortRangeItems(true)
+				}
+			}
 		} else {
 			this.ipMap[ToHex(item.IPFrom)] = item
 		}
@@ -236,10 +240,6 @@ func (this *IPList) addItem(item *IPItem, lock bool, sortable bool) {
 	if item.ExpiredAt > 0 {
 		this.expireList.Add(item.Id, item.ExpiredAt)
 	}
-
-	if shouldSort && sortable {
-		this.sortRangeItems(true)
-	}
 }
 
 // 对列表进行排序
@@ -253,56 +253,22 @@ func (this *IPList) sortRangeItems(force bool) {
 	}
 
 	if force {
-		sort.Slice(this.sortedRangeItems, func(i, j int) bool {
-			var item1 = this.sortedRangeItems[i]
-			var item2 = this.sortedRangeItems[j]
-			if iputils.CompareBytes(item1.IPFrom, item2.IPFrom) == 0 {
-				return iputils.CompareBytes(item1.IPTo, item2.IPTo) < 0
-			}
-			return iputils.CompareBytes(item1.IPFrom, item2.IPFrom) < 0
-		})
+		this.rangeTree.Sort()
 	}
 }
 
 // 不加锁的情况下查找Item
+// 单个IP优先，其次是包含此IP的最小的范围
 func (this *IPList) lookupIP(ipBytes []byte) *IPItem {
+	var now = fasttime.Now().Unix()
 	{
 		item, ok := this.ipMap[ToHex(ipBytes)]
-		if ok && (item.ExpiredAt == 0 || item.ExpiredAt > fasttime.Now().Unix()) {
+		if ok && (item.ExpiredAt == 0 || item.ExpiredAt > now) {
 			return item
 		}
 	}
 
-	if len(this.sortedRangeItems) == 0 {
-		return nil
-	}
-
-	var count = len(this.sortedRangeItems)
-	var resultIndex = -1
-	sort.Search(count, func(i int) bool {
-		var item = this.sortedRangeItems[i]
-		var cmp = iputils.CompareBytes(item.IPFrom, ipBytes)
-		if cmp < 0 {
-			if iputils.CompareBytes(item.IPTo, ipBytes) >= 0 {
-				resultIndex = i
-			}
-			return false
-		} else if cmp == 0 {
-			resultIndex = i
-			return false
-		}
-		return true
-	})
-
-	if resultIndex < 0 || resultIndex >= count {
-		return nil
-	}
-
-	var item = this.sortedRangeItems[resultIndex]
-	if item.ExpiredAt == 0 || item.ExpiredAt > fasttime.Now().Unix() {
-		return item
-	}
-	return nil
+	return this.rangeTree.Lookup(ipBytes, now)
 }
 
 // 在不加锁的情况下删除某个Item
@@ -336,16 +302,6 @@ func (this *IPList) deleteItem(itemId uint64) {
 
 	// 删除排序中的Item
 	if !IsZero(oldItem.IPTo) {
-		var index = -1
-		for itemIndex, item := range this.sortedRangeItems {
-			if item.Id == itemId {
-				index = itemIndex
-				break
-			}
-		}
-		if index >= 0 {
-			copy(this.sortedRangeItems[index:], this.sortedRangeItems[index+1:])
-			this.sortedRangeItems = this.sortedRangeItems[:len(this.sortedRangeItems)-1]
-		}
+		this.rangeTree.Remove(oldItem)
 	}
 }
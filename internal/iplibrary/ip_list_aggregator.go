@@ -0,0 +1,49 @@
+// Copyright 2024 GoEdge CDN goedge.cdn@gmail.com. All rights reserved. Official site: https://goedge.cloud .
+
+package iplibrary
+
+import (
+	"strings"
+
+	"github.com/TeaOSLab/EdgeCommon/pkg/iputils"
+	"github.com/TeaOSLab/EdgeCommon/pkg/serverconfigs/firewallconfigs"
+	teaconst "github.com/TeaOSLab/EdgeNode/internal/const"
+	"github.com/TeaOSLab/EdgeNode/internal/firewalls"
+	"github.com/cespare/xxhash/v2"
+)
+
+func init() {
+	if !teaconst.IsMain {
+		return
+	}
+
+	BindIPAggregator(firewalls.SharedIPAggregator, GlobalBlackIPList)
+}
+
+// BindIPAggregator 将聚合后的网段同步到IP名单中
+// 网段在名单中使用一条会过期的IP范围条目，网段解除封禁时删除
+func BindIPAggregator(aggregator *firewalls.IPAggregator, list *IPList) {
+	aggregator.OnAggregate(func(network *firewalls.IPAggregatedNetwork) {
+		var itemType = IPItemTypeIPv4
+		if strings.Contains(network.IPFrom, ":") {
+			itemType = IPItemTypeIPv6
+		}
+		list.Add(&IPItem{
+			Id:         IPAggregatorItemId(network.Network),
+			Type:       itemType,
+			IPFrom:     iputils.ToBytes(network.IPFrom),
+			IPTo:       iputils.ToBytes(network.IPTo),
+			ExpiredAt:  network.ExpiresAt,
+			EventLevel: firewallconfigs.DefaultEventLevel,
+		})
+	})
+	aggregator.OnRelease(func(network *firewalls.IPAggregatedNetwork) {
+		list.Delete(IPAggregatorItemId(network.Network))
+	})
+}
+
+// IPAggregatorItemId 聚合网段对应的条目ID
+// 和订阅条目一样设置第62位，并使用单独的前缀以避免冲突
+func IPAggregatorItemId(network string) uint64 {
+	return (xxhash.Sum64String("aggregator@"+network) & (1<<62 - 1)) | 1<<62
+}
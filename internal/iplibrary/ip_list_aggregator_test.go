@@ -0,0 +1,37 @@
+// Copyright 2024 GoEdge CDN goedge.cdn@gmail.com. All rights reserved. Official site: https://goedge.cloud .
+
+package iplibrary_test
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/TeaOSLab/EdgeCommon/pkg/iputils"
+	"github.com/TeaOSLab/EdgeNode/internal/firewalls"
+	"github.com/TeaOSLab/EdgeNode/internal/iplibrary"
+	"github.com/iwind/TeaGo/assert"
+)
+
+func TestBindIPAggregator(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	var config = firewalls.DefaultIPAggregatorConfig()
+	config.Threshold = 5
+	var aggregator = firewalls.NewIPAggregator(config, func() firewalls.FirewallInterface {
+		return firewalls.NewMockFirewall()
+	})
+
+	var list = iplibrary.NewIPList()
+	iplibrary.BindIPAggregator(aggregator, list)
+
+	for i := 1; i <= 5; i++ {
+		aggregator.Record("172.16.8."+strconv.Itoa(i), time.Now().Unix()+1)
+	}
+	a.IsTrue(list.Contains(iputils.ToBytes("172.16.8.200")))
+	a.IsFalse(list.Contains(iputils.ToBytes("172.16.9.1")))
+
+	time.Sleep(1100 * time.Millisecond)
+	aggregator.GC()
+	a.IsFalse(list.Contains(iputils.ToBytes("172.16.8.200")))
+}
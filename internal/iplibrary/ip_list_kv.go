@@ -80,7 +80,7 @@ func (this *KVIPList) init() error {
 		})
 
 		idles.RunTicker(this.cleanTicker, func() {
-			if this.isClosed {
+			if this.isClosed || kvstore.IsReleased() {
 				return
 			}
 			deleteErr := this.DeleteExpiredItems()
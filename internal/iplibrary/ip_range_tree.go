@@ -0,0 +1,500 @@
+// Copyright 2024 GoEdge CDN goedge.cdn@gmail.com. All rights reserved. Official site: https://goedge.cloud .
+
+package iplibrary
+
+import (
+	"sort"
+
+	"github.com/TeaOSLab/EdgeCommon/pkg/iputils"
+)
+
+// IPRangeTree IP范围索引
+// 支持互相重叠和嵌套的IP范围，查询时返回包含IP的最小的、未过期的范围；
+// IPv4和IPv6分别使用单独的索引，IPFrom和IPTo类型不一致的范围（通常不会出现）单独存放并逐个检查
+type IPRangeTree struct {
+	ipv4  *ipRangeIndex[ipv4Key]
+	ipv6  *ipRangeIndex[ipv6Key]
+	mixed []*IPItem
+}
+
+func NewIPRangeTree() *IPRangeTree {
+	return &IPRangeTree{
+		ipv4: newIPRangeIndex[ipv4Key](toIPv4Key),
+		ipv6: newIPRangeIndex[ipv6Key](toIPv6Key),
+	}
+}
+
+// Len 范围数量
+func (this *IPRangeTree) Len() int {
+	return this.ipv4.len() + this.ipv6.len() + len(this.mixed)
+}
+
+// Items 按IPFrom排序后的范围列表
+func (this *IPRangeTree) Items() []*IPItem {
+	var result = make([]*IPItem, 0, this.Len())
+	result = this.ipv4.appendItems(result)
+	result = this.ipv6.appendItems(result)
+	result = append(result, this.mixed...)
+	sort.SliceStable(result, func(i, j int) bool {
+		var cmp = iputils.CompareBytes(result[i].IPFrom, result[j].IPFrom)
+		if cmp == 0 {
+			return iputils.CompareBytes(result[i].IPTo, result[j].IPTo) < 0
+		}
+		return cmp < 0
+	})
+	return result
+}
+
+// Insert 插入一个范围，插入后可以直接查询
+func (this *IPRangeTree) Insert(item *IPItem) {
+	switch {
+	case len(item.IPFrom) == 4 && len(item.IPTo) == 4:
+		this.ipv4.insert(item)
+	case len(item.IPFrom) == 16 && len(item.IPTo) == 16:
+		this.ipv6.insert(item)
+	default:
+		this.mixed = append(this.mixed, item)
+	}
+}
+
+// Append 添加一个范围，但不重建索引
+// 添加完成后需要调用 Sort() 才能查询
+func (this *IPRangeTree) Append(item *IPItem) {
+	switch {
+	case len(item.IPFrom) == 4 && len(item.IPTo) == 4:
+		this.ipv4.append(item)
+	case len(item.IPFrom) == 16 && len(item.IPTo) == 16:
+		this.ipv6.append(item)
+	default:
+		this.mixed = append(this.mixed, item)
+	}
+}
+
+// Sort 对所有范围进行排序并重建索引
+func (this *IPRangeTree) Sort() {
+	this.ipv4.sort()
+	this.ipv6.sort()
+}
+
+// Remove 删除一个范围
+func (this *IPRangeTree) Remove(item *IPItem) bool {
+	switch {
+	case len(item.IPFrom) == 4 && len(item.IPTo) == 4:
+		return this.ipv4.remove(item)
+	case len(item.IPFrom) == 16 && len(item.IPTo) == 16:
+		return this.ipv6.remove(item)
+	default:
+		for index, mixedItem := range this.mixed {
+			if mixedItem == item {
+				this.mixed = append(this.mixed[:index], this.mixed[index+1:]...)
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Lookup 查找包含某个IP的最小的、未过期的范围
+// 范围越小越精确，范围大小相同时ID大的（更新的）优先
+func (this *IPRangeTree) Lookup(ipBytes []byte, now int64) (result *IPItem) {
+	switch len(ipBytes) {
+	case 4:
+		result = this.ipv4.lookup(toIPv4Key(ipBytes), now)
+	case 16:
+		result = this.ipv6.lookup(toIPv6Key(ipBytes), now)
+	}
+	if result != nil {
+		return
+	}
+
+	for _, item := range this.mixed {
+		if (item.ExpiredAt == 0 || item.ExpiredAt > now) &&
+			iputils.CompareBytes(item.IPFrom, ipBytes) <= 0 &&
+			iputils.CompareBytes(item.IPTo, ipBytes) >= 0 &&
+			(result == nil || item.Id > result.Id) {
+			result = item
+		}
+	}
+	return
+}
+
+// 待合并的范围最大数量，超出后合并到索引中
+const ipRangeIndexMaxPending = 128
+
+// ipRangeKey IP范围索引中使用的IP数值
+type ipRangeKey[K any] interface {
+	comparable
+	compare(other K) int
+	sub(other K) K
+}
+
+// ipRangeIndex 某一类IP的范围索引
+//
+// 所有范围按照IPFrom排序后存储在数组中，数组隐式地构成一棵平衡二叉树（区间[lo, hi)的根节点为(lo+hi)/2），
+// 每个节点上记录子树中最大的IPTo，查询时跳过不可能包含目标IP的子树；
+// 找到一个包含目标IP的范围后，IPFrom小于 IP - 范围大小 的范围一定更大，也可以直接跳过。
+//
+// 为了避免每次添加和删除时都重建索引：
+// 新添加的范围先放在pending中，查询时逐个检查，数量超出 ipRangeIndexMaxPending 后再合并；
+// 删除的范围只标记为nil，数量超出总数的1/4后再清理。
+type ipRangeIndex[K ipRangeKey[K]] struct {
+	toKey func(ipBytes []byte) K
+
+	items    []*IPItem // 已删除的为nil
+	from     []K
+	to       []K
+	maxTo    []K // 子树中最大的IPTo
+	isSorted bool
+
+	pendingItems []*IPItem
+	pendingFrom  []K
+	pendingTo    []K
+
+	countRemoved int
+}
+
+func newIPRangeIndex[K ipRangeKey[K]](toKey func(ipBytes []byte) K) *ipRangeIndex[K] {
+	return &ipRangeIndex[K]{
+		toKey:    toKey,
+		isSorted: true,
+	}
+}
+
+func (this *ipRangeIndex[K]) len() int {
+	return len(this.items) - this.countRemoved + len(this.pendingItems)
+}
+
+func (this *ipRangeIndex[K]) appendItems(result []*IPItem) []*IPItem {
+	for _, item := range this.items {
+		if item != nil {
+			result = append(result, item)
+		}
+	}
+	return append(result, this.pendingItems...)
+}
+
+func (this *ipRangeIndex[K]) append(item *IPItem) {
+	this.items = append(this.items, item)
+	this.from = append(this.from, this.toKey(item.IPFrom))
+	this.to = append(this.to, this.toKey(item.IPTo))
+	this.isSorted = false
+}
+
+func (this *ipRangeIndex[K]) insert(item *IPItem) {
+	if !this.isSorted {
+		this.append(item)
+		this.sort()
+		return
+	}
+
+	this.pendingItems = append(this.pendingItems, item)
+	this.pendingFrom = append(this.pendingFrom, this.toKey(item.IPFrom))
+	this.pendingTo = append(this.pendingTo, this.toKey(item.IPTo))
+	if len(this.pendingItems) >= ipRangeIndexMaxPending {
+		this.merge()
+	}
+}
+
+func (this *ipRangeIndex[K]) sort() {
+	this.items = append(this.items, this.pendingItems...)
+	this.from = append(this.from, this.pendingFrom...)
+	this.to = append(this.to, this.pendingTo...)
+	this.resetPending()
+	this.compact()
+
+	sort.Sort(&ipRangeSorter[K]{
+		items: this.items,
+		from:  this.from,
+		to:    this.to,
+	})
+	this.isSorted = true
+	this.rebuild()
+}
+
+func (this *ipRangeIndex[K]) remove(item *IPItem) bool {
+	for index, pendingItem := range this.pendingItems {
+		if pendingItem == item {
+			this.pendingItems = append(this.pendingItems[:index], this.pendingItems[index+1:]...)
+			this.pendingFrom = append(this.pendingFrom[:index], this.pendingFrom[index+1:]...)
+			this.pendingTo = append(this.pendingTo[:index], this.pendingTo[index+1:]...)
+			return true
+		}
+	}
+
+	var index = -1
+	if this.isSorted {
+		var from = this.toKey(item.IPFrom)
+		for i := sort.Search(len(this.from), func(i int) bool {
+			return this.from[i].compare(from) >= 0
+		}); i < len(this.from) && this.from[i] == from; i++ {
+			if this.items[i] == item {
+				index = i
+				break
+			}
+		}
+	} else {
+		for i, oldItem := range this.items {
+			if oldItem == item {
+				index = i
+				break
+			}
+		}
+	}
+	if index < 0 {
+		return false
+	}
+
+	// 只做标记，以保持索引结构不变
+	this.items[index] = nil
+	this.countRemoved++
+	if this.isSorted && this.countRemoved > len(this.items)/4 {
+		this.compact()
+		this.rebuild()
+	}
+	return true
+}
+
+func (this *ipRangeIndex[K]) lookup(ip K, now int64) *IPItem {
+	var result = &ipRangeResult[K]{
+		ip:  ip,
+		now: now,
+	}
+
+	for index, item := range this.pendingItems {
+		result.check(item, this.pendingFrom[index], this.pendingTo[index])
+	}
+
+	if this.isSorted {
+		this.lookupRange(0, len(this.items), result)
+	}
+
+	return result.item
+}
+
+func (this *ipRangeIndex[K]) lookupRange(lo int, hi int, result *ipRangeResult[K]) {
+	for lo < hi {
+		var mid = (lo + hi) / 2
+
+		// 子树中没有范围能够包含此IP
+		if this.maxTo[mid].compare(result.ip) < 0 {
+			return
+		}
+
+		// 右子树中的范围IPFrom都不小于当前节点，离目标IP更近，优先检查以便于尽早缩小范围
+		if this.from[mid].compare(result.ip) <= 0 {
+			this.lookupRange(mid+1, hi, result)
+			result.check(this.items[mid], this.from[mid], this.to[mid])
+		}
+
+		// 左子树中的范围IPFrom都不大于当前节点，如果当前节点已经低于下限，则左子树中不会有更小的范围
+		if result.hasLowerBound && this.from[mid].compare(result.lowerBound) < 0 {
+			return
+		}
+
+		hi = mid
+	}
+}
+
+// 合并待处理的范围
+func (this *ipRangeIndex[K]) merge() {
+	sort.Sort(&ipRangeSorter[K]{
+		items: this.pendingItems,
+		from:  this.pendingFrom,
+		to:    this.pendingTo,
+	})
+
+	// 从后往前合并两个有序列表
+	var i = len(this.items) - 1
+	var j = len(this.pendingItems) - 1
+	this.items = append(this.items, this.pendingItems...)
+	this.from = append(this.from, this.pendingFrom...)
+	this.to = append(this.to, this.pendingTo...)
+	for k := len(this.items) - 1; j >= 0; k-- {
+		if i >= 0 && ipRangeLess(this.pendingFrom[j], this.pendingTo[j], this.from[i], this.to[i]) {
+			this.items[k], this.from[k], this.to[k] = this.items[i], this.from[i], this.to[i]
+			i--
+		} else {
+			this.items[k], this.from[k], this.to[k] = this.pendingItems[j], this.pendingFrom[j], this.pendingTo[j]
+			j--
+		}
+	}
+	this.resetPending()
+
+	this.compact()
+	this.rebuild()
+}
+
+func (this *ipRangeIndex[K]) resetPending() {
+	this.pendingItems = nil
+	this.pendingFrom = nil
+	this.pendingTo = nil
+}
+
+// 清理已删除的范围
+func (this *ipRangeIndex[K]) compact() {
+	if this.countRemoved == 0 {
+		return
+	}
+	var count = 0
+	for index, item := range this.items {
+		if item != nil {
+			this.items[count], this.from[count], this.to[count] = item, this.from[index], this.to[index]
+			count++
+		}
+	}
+	clear(this.items[count:])
+	this.items = this.items[:count]
+	this.from = this.from[:count]
+	this.to = this.to[:count]
+	this.countRemoved = 0
+}
+
+func (this *ipRangeIndex[K]) rebuild() {
+	if cap(this.maxTo) >= len(this.items) {
+		this.maxTo = this.maxTo[:len(this.items)]
+	} else {
+		this.maxTo = make([]K, len(this.items))
+	}
+	if len(this.items) > 0 {
+		this.build(0, len(this.items))
+	}
+}
+
+func (this *ipRangeIndex[K]) build(lo int, hi int) (maxTo K) {
+	var mid = (lo + hi) / 2
+	maxTo = this.to[mid]
+	if lo < mid {
+		var leftMaxTo = this.build(lo, mid)
+		if leftMaxTo.compare(maxTo) > 0 {
+			maxTo = leftMaxTo
+		}
+	}
+	if mid+1 < hi {
+		var rightMaxTo = this.build(mid+1, hi)
+		if rightMaxTo.compare(maxTo) > 0 {
+			maxTo = rightMaxTo
+		}
+	}
+	this.maxTo[mid] = maxTo
+	return
+}
+
+// 单次查询的状态
+type ipRangeResult[K ipRangeKey[K]] struct {
+	ip  K
+	now int64
+
+	item          *IPItem
+	size          K
+	lowerBound    K // IPFrom小于此值的范围一定比当前结果大
+	hasLowerBound bool
+}
+
+func (this *ipRangeResult[K]) check(item *IPItem, from K, to K) {
+	if from.compare(this.ip) > 0 || to.compare(this.ip) < 0 {
+		return
+	}
+	if item == nil || (item.ExpiredAt > 0 && item.ExpiredAt <= this.now) {
+		return
+	}
+
+	var size = to.sub(from)
+	if this.item != nil {
+		var cmp = size.compare(this.size)
+		if cmp > 0 || (cmp == 0 && item.Id < this.item.Id) {
+			return
+		}
+	}
+
+	this.item = item
+	this.size = size
+	this.hasLowerBound = size.compare(this.ip) <= 0
+	if this.hasLowerBound {
+		this.lowerBound = this.ip.sub(size)
+	}
+}
+
+type ipRangeSorter[K ipRangeKey[K]] struct {
+	items []*IPItem
+	from  []K
+	to    []K
+}
+
+func (this *ipRangeSorter[K]) Len() int {
+	return len(this.items)
+}
+
+func (this *ipRangeSorter[K]) Less(i, j int) bool {
+	return ipRangeLess(this.from[i], this.to[i], this.from[j], this.to[j])
+}
+
+func (this *ipRangeSorter[K]) Swap(i, j int) {
+	this.items[i], this.items[j] = this.items[j], this.items[i]
+	this.from[i], this.from[j] = this.from[j], this.from[i]
+	this.to[i], this.to[j] = this.to[j], this.to[i]
+}
+
+func ipRangeLess[K ipRangeKey[K]](from1 K, to1 K, from2 K, to2 K) bool {
+	var cmp = from1.compare(from2)
+	if cmp == 0 {
+		return to1.compare(to2) < 0
+	}
+	return cmp < 0
+}
+
+type ipv4Key uint32
+
+func toIPv4Key(ipBytes []byte) ipv4Key {
+	return ipv4Key(uint32(ipBytes[0])<<24 | uint32(ipBytes[1])<<16 | uint32(ipBytes[2])<<8 | uint32(ipBytes[3]))
+}
+
+func (this ipv4Key) compare(other ipv4Key) int {
+	if this < other {
+		return -1
+	}
+	if this > other {
+		return 1
+	}
+	return 0
+}
+
+func (this ipv4Key) sub(other ipv4Key) ipv4Key {
+	return this - other
+}
+
+type ipv6Key struct {
+	hi uint64
+	lo uint64
+}
+
+func toIPv6Key(ipBytes []byte) ipv6Key {
+	var key = ipv6Key{}
+	for i := 0; i < 8; i++ {
+		key.hi = key.hi<<8 | uint64(ipBytes[i])
+		key.lo = key.lo<<8 | uint64(ipBytes[8+i])
+	}
+	return key
+}
+
+func (this ipv6Key) compare(other ipv6Key) int {
+	switch {
+	case this.hi < other.hi:
+		return -1
+	case this.hi > other.hi:
+		return 1
+	case this.lo < other.lo:
+		return -1
+	case this.lo > other.lo:
+		return 1
+	}
+	return 0
+}
+
+func (this ipv6Key) sub(other ipv6Key) ipv6Key {
+	var lo = this.lo - other.lo
+	var hi = this.hi - other.hi
+	if this.lo < other.lo {
+		hi--
+	}
+	return ipv6Key{hi: hi, lo: lo}
+}
@@ -0,0 +1,333 @@
+// Copyright 2024 GoEdge CDN goedge.cdn@gmail.com. All rights reserved. Official site: https://goedge.cloud .
+
+package iplibrary_test
+
+import (
+	"math/big"
+	"math/rand"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/TeaOSLab/EdgeCommon/pkg/iputils"
+	"github.com/TeaOSLab/EdgeNode/internal/iplibrary"
+	"github.com/TeaOSLab/EdgeNode/internal/utils/fasttime"
+	"github.com/iwind/TeaGo/assert"
+)
+
+func TestIPList_Overlapping(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	var list = iplibrary.NewIPList()
+	list.Add(&iplibrary.IPItem{
+		Id:        1,
+		IPFrom:    iputils.ToBytes("10.1.0.0"),
+		IPTo:      iputils.ToBytes("10.1.255.255"),
+		ExpiredAt: 0,
+	})
+	list.Add(&iplibrary.IPItem{
+		Id:        2,
+		IPFrom:    iputils.ToBytes("10.1.2.0"),
+		IPTo:      iputils.ToBytes("10.1.2.255"),
+		ExpiredAt: time.Now().Unix() + 3600,
+	})
+	list.Add(&iplibrary.IPItem{
+		Id:     3,
+		IPFrom: iputils.ToBytes("10.0.0.0"),
+		IPTo:   iputils.ToBytes("10.1.0.10"),
+	})
+
+	// 嵌套的范围中选择最小的
+	{
+		expiresAt, ok := list.ContainsExpires(iputils.ToBytes("10.1.2.3"))
+		a.IsTrue(ok)
+		a.IsTrue(expiresAt > 0)
+	}
+	{
+		item, ok := list.ContainsIPStrings([]string{"10.1.2.3"})
+		a.IsTrue(ok)
+		a.IsTrue(item.Id == 2)
+	}
+
+	// 后面的范围IPFrom较大但是没有包含IP
+	{
+		item, ok := list.ContainsIPStrings([]string{"10.1.3.1"})
+		a.IsTrue(ok)
+		a.IsTrue(item.Id == 1)
+	}
+
+	// 排在前面的范围
+	{
+		item, ok := list.ContainsIPStrings([]string{"10.0.200.1"})
+		a.IsTrue(ok)
+		a.IsTrue(item.Id == 3)
+	}
+	{
+		item, ok := list.ContainsIPStrings([]string{"10.1.0.5"})
+		a.IsTrue(ok)
+		a.IsTrue(item.Id == 1)
+	}
+
+	// 删除后使用外层的范围
+	list.Delete(2)
+	{
+		expiresAt, ok := list.ContainsExpires(iputils.ToBytes("10.1.2.3"))
+		a.IsTrue(ok)
+		a.IsTrue(expiresAt == 0)
+	}
+
+	a.IsFalse(list.Contains(iputils.ToBytes("10.2.0.1")))
+	a.IsFalse(list.Contains(iputils.ToBytes("::1")))
+}
+
+func TestIPList_Overlapping_Property(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	var random = rand.New(rand.NewSource(time.Now().UnixNano()))
+	for round := 0; round < 20; round++ {
+		var list = iplibrary.NewIPList()
+		var items = map[uint64]*iplibrary.IPItem{}
+		var now = fasttime.Now().Unix()
+
+		// 使用较小的地址空间以便产生大量重叠
+		var randIP = func() uint32 {
+			return 0x0A000000 | uint32(random.Intn(1<<12))
+		}
+
+		for i := 1; i <= 500; i++ {
+			var from = randIP()
+			var to = from
+			if random.Intn(5) > 0 {
+				to = from + uint32(random.Intn(1<<(4+random.Intn(8))))
+			}
+			var item = &iplibrary.IPItem{
+				Id:     uint64(i),
+				IPFrom: uint32ToBytes(from),
+				IPTo:   uint32ToBytes(to),
+			}
+			if random.Intn(3) == 0 {
+				item.ExpiredAt = now + 3600
+			}
+
+			// 单个IP只能保留一条记录
+			if from == to && findSingleItem(items, item.IPFrom) != nil {
+				continue
+			}
+			if random.Intn(2) == 0 {
+				list.Add(item)
+			} else {
+				list.AddDelay(item)
+			}
+			items[item.Id] = item
+		}
+		list.Sort()
+
+		// 模拟部分条目过期
+		for _, item := range items {
+			if item.ExpiredAt > 0 && random.Intn(2) == 0 {
+				item.ExpiredAt = now - 1
+			}
+		}
+
+		// 删除部分条目
+		for id := range items {
+			if random.Intn(10) < round%5 {
+				list.Delete(id)
+				delete(items, id)
+			}
+		}
+
+		for i := 0; i < 2000; i++ {
+			var ip = randIP()
+			var ipBytes = uint32ToBytes(ip)
+			var expected = bruteForceLookup(items, ipBytes, now)
+
+			item, ok := list.ContainsIPStrings([]string{net.IP(ipBytes).String()})
+			if expected == nil {
+				if ok {
+					t.Fatal("round", round, "ip", net.IP(ipBytes).String(), "expected: nil, actual:", item.Id)
+				}
+				continue
+			}
+			if !ok {
+				t.Fatal("round", round, "ip", net.IP(ipBytes).String(), "expected:", expected.Id, "actual: nil")
+			}
+			if item.Id != expected.Id {
+				t.Fatal("round", round, "ip", net.IP(ipBytes).String(), "expected:", expected.Id, "actual:", item.Id)
+			}
+		}
+	}
+
+	a.IsTrue(true)
+}
+
+func TestIPRangeTree_IPv6(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	var tree = iplibrary.NewIPRangeTree()
+	tree.Insert(&iplibrary.IPItem{
+		Id:     1,
+		IPFrom: iputils.ToBytes("2001:db8::"),
+		IPTo:   iputils.ToBytes("2001:db8:ffff:ffff:ffff:ffff:ffff:ffff"),
+	})
+	var item2 = &iplibrary.IPItem{
+		Id:     2,
+		IPFrom: iputils.ToBytes("2001:db8:1::"),
+		IPTo:   iputils.ToBytes("2001:db8:1::ffff"),
+	}
+	tree.Insert(item2)
+	tree.Insert(&iplibrary.IPItem{
+		Id:     3,
+		IPFrom: iputils.ToBytes("192.168.0.0"),
+		IPTo:   iputils.ToBytes("192.168.255.255"),
+	})
+
+	var now = time.Now().Unix()
+	a.IsTrue(tree.Lookup(iputils.ToBytes("2001:db8:1::1"), now).Id == 2)
+	a.IsTrue(tree.Lookup(iputils.ToBytes("2001:db8:2::1"), now).Id == 1)
+	a.IsTrue(tree.Lookup(iputils.ToBytes("192.168.1.1"), now).Id == 3)
+	a.IsNil(tree.Lookup(iputils.ToBytes("2001:db9::1"), now))
+
+	a.IsTrue(tree.Remove(item2))
+	a.IsFalse(tree.Remove(item2))
+	a.IsTrue(tree.Lookup(iputils.ToBytes("2001:db8:1::1"), now).Id == 1)
+	a.IsTrue(tree.Len() == 2)
+
+	// 合并到索引后
+	tree.Sort()
+	a.IsTrue(tree.Lookup(iputils.ToBytes("2001:db8:2::1"), now).Id == 1)
+	a.IsTrue(tree.Lookup(iputils.ToBytes("192.168.1.1"), now).Id == 3)
+	a.IsTrue(len(tree.Items()) == 2)
+}
+
+func BenchmarkIPRangeTree_Lookup(b *testing.B) {
+	var tree = iplibrary.NewIPRangeTree()
+	var random = rand.New(rand.NewSource(1))
+	for i := 0; i < 1_000_000; i++ {
+		var from = random.Uint32()
+		var to = from + uint32(random.Intn(1<<(1+random.Intn(20))))
+		if to < from {
+			to = from
+		}
+		tree.Append(&iplibrary.IPItem{
+			Id:     uint64(i),
+			IPFrom: uint32ToBytes(from),
+			IPTo:   uint32ToBytes(to),
+		})
+	}
+
+	// 一些比较大的范围
+	for i := 0; i < 1000; i++ {
+		var from = random.Uint32() & 0xFF000000
+		tree.Append(&iplibrary.IPItem{
+			Id:     uint64(1_000_000 + i),
+			IPFrom: uint32ToBytes(from),
+			IPTo:   uint32ToBytes(from | 0x00FFFFFF),
+		})
+	}
+
+	var before = time.Now()
+	tree.Sort()
+	b.Log("sort cost:", time.Since(before).Seconds()*1000, "ms")
+
+	var now = time.Now().Unix()
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		var random = rand.New(rand.NewSource(time.Now().UnixNano()))
+		for pb.Next() {
+			_ = tree.Lookup(uint32ToBytes(random.Uint32()), now)
+		}
+	})
+}
+
+func BenchmarkIPList_Contains_Overlapping(b *testing.B) {
+	var list = iplibrary.NewIPList()
+	var random = rand.New(rand.NewSource(1))
+	for i := 0; i < 1_000_000; i++ {
+		var from = random.Uint32() & 0xFFFFFF00
+		var item = &iplibrary.IPItem{
+			Id:     uint64(i),
+			IPFrom: uint32ToBytes(from),
+			IPTo:   uint32ToBytes(from | 0xFF),
+		}
+		if i%10 == 0 {
+			item.IPTo = uint32ToBytes(from | 0xFFFF)
+		}
+		list.AddDelay(item)
+	}
+	list.Sort()
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		var random = rand.New(rand.NewSource(time.Now().UnixNano()))
+		for pb.Next() {
+			_ = list.Contains(uint32ToBytes(random.Uint32()))
+		}
+	})
+}
+
+func BenchmarkIPList_Add_Range(b *testing.B) {
+	var list = iplibrary.NewIPList()
+	var random = rand.New(rand.NewSource(1))
+	for i := 0; i < 1_000_000; i++ {
+		var from = random.Uint32()
+		list.AddDelay(&iplibrary.IPItem{
+			Id:     uint64(i),
+			IPFrom: uint32ToBytes(from),
+			IPTo:   uint32ToBytes(from | 0xFF),
+		})
+	}
+	list.Sort()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var from = random.Uint32()
+		list.Add(&iplibrary.IPItem{
+			Id:     uint64(2_000_000 + i%1000),
+			IPFrom: uint32ToBytes(from),
+			IPTo:   uint32ToBytes(from | 0xFF),
+		})
+	}
+}
+
+func uint32ToBytes(ip uint32) []byte {
+	return iputils.ToBytes(net.IPv4(byte(ip>>24), byte(ip>>16), byte(ip>>8), byte(ip)).String())
+}
+
+func findSingleItem(items map[uint64]*iplibrary.IPItem, ipBytes []byte) *iplibrary.IPItem {
+	for _, item := range items {
+		if iputils.CompareBytes(item.IPFrom, ipBytes) == 0 && (len(item.IPTo) == 0 || iputils.CompareBytes(item.IPTo, ipBytes) == 0) {
+			return item
+		}
+	}
+	return nil
+}
+
+// 逐个检查所有条目，找出包含IP的最小的范围
+func bruteForceLookup(items map[uint64]*iplibrary.IPItem, ipBytes []byte, now int64) *iplibrary.IPItem {
+	var result *iplibrary.IPItem
+	var resultSize *big.Int
+	for _, item := range items {
+		if item.ExpiredAt > 0 && item.ExpiredAt <= now {
+			continue
+		}
+		var to = item.IPTo
+		if len(to) == 0 {
+			to = item.IPFrom
+		}
+		if iputils.CompareBytes(item.IPFrom, ipBytes) > 0 || iputils.CompareBytes(to, ipBytes) < 0 {
+			continue
+		}
+		var size = new(big.Int).Sub(new(big.Int).SetBytes(to), new(big.Int).SetBytes(item.IPFrom))
+		if result == nil {
+			result, resultSize = item, size
+			continue
+		}
+		var cmp = size.Cmp(resultSize)
+		if cmp < 0 || (cmp == 0 && item.Id > result.Id) {
+			result, resultSize = item, size
+		}
+	}
+	return result
+}
@@ -14,6 +14,7 @@ import (
 	"github.com/TeaOSLab/EdgeNode/internal/rpc"
 	"github.com/TeaOSLab/EdgeNode/internal/utils/goman"
 	"github.com/TeaOSLab/EdgeNode/internal/utils/idles"
+	"github.com/TeaOSLab/EdgeNode/internal/utils/kvstore"
 	"github.com/TeaOSLab/EdgeNode/internal/utils/trackers"
 	"github.com/TeaOSLab/EdgeNode/internal/utils/zero"
 	"github.com/TeaOSLab/EdgeNode/internal/waf"
@@ -173,6 +174,11 @@ func (this *IPListManager) Loop() error {
 		return nil
 	}
 
+	// 本地数据库已经交给其他进程，等待下次同步
+	if kvstore.IsReleased() {
+		return nil
+	}
+
 	// 第一次同步则打印信息
 	if this.isFirstTime {
 		remotelogs.Println("IP_LIST_MANAGER", "initializing ip items ...")
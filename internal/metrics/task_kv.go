@@ -267,6 +267,11 @@ func (this *KVTask) Start() error {
 	}
 	goman.New(func() {
 		for this.statsTicker.Next() {
+			// 数据库已经交给其他进程时，数据暂时保留在内存中
+			if kvstore.IsReleased() {
+				continue
+			}
+
 			var tr = trackers.Begin("METRIC:DUMP_STATS_TO_LOCAL_DATABASE")
 
 			this.statsLocker.Lock()
@@ -289,6 +294,10 @@ func (this *KVTask) Start() error {
 	this.cleanTicker = time.NewTicker(24 * time.Hour)
 	goman.New(func() {
 		idles.RunTicker(this.cleanTicker, func() {
+			if kvstore.IsReleased() {
+				return
+			}
+
 			var tr = trackers.Begin("METRIC:CLEAN_EXPIRED")
 			err := this.CleanExpired()
 			tr.End()
@@ -302,6 +311,10 @@ func (this *KVTask) Start() error {
 	this.uploadTicker = utils.NewTicker(this.itemConfig.UploadDuration())
 	goman.New(func() {
 		for this.uploadTicker.Next() {
+			if kvstore.IsReleased() {
+				continue
+			}
+
 			err := this.Upload(1 * time.Second)
 			if err != nil && !rpc.IsConnError(err) {
 				remotelogs.Error("METRIC", "upload stats failed: "+err.Error())
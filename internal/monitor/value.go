@@ -2,6 +2,10 @@
 
 package monitor
 
+// NodeValueItemWAFRuleSets WAF规则集统计
+// 由节点自行定义的监控项，数据结构参考 waf.RuleSetStatManager
+const NodeValueItemWAFRuleSets = "wafRuleSets"
+
 // ItemValue 数据值定义
 type ItemValue struct {
 	Item      string
@@ -48,6 +48,8 @@ type ClientConn struct {
 	readDeadlineTime int64
 	isShortReading   bool // reading header or tls handshake
 
+	tarpitDelay time.Duration // 第一次读取前的延迟时间，用于多次超出连接数限制的IP
+
 	isDebugging      bool
 	autoReadTimeout  bool
 	autoWriteTimeout bool
@@ -113,6 +115,13 @@ func (this *ClientConn) Read(b []byte) (n int, err error) {
 		return
 	}
 
+	// 延迟处理
+	if this.tarpitDelay > 0 {
+		var delay = this.tarpitDelay
+		this.tarpitDelay = 0
+		time.Sleep(delay)
+	}
+
 	// 设置读超时时间
 	if this.isHTTP && !this.isPersistent && !this.isShortReading && this.autoReadTimeout {
 		this.setHTTPReadTimeout()
@@ -290,6 +299,23 @@ func (this *ClientConn) LastErr() error {
 	return this.lastErr
 }
 
+// SetServerId 设置服务ID，并检查网站的区域访问策略
+func (this *ClientConn) SetServerId(serverId int64) (goNext bool) {
+	if !this.BaseClientConn.SetServerId(serverId) {
+		return false
+	}
+
+	if serverId > 0 && !this.isInAllowList && sharedClientGeoPolicyManager.IsActive() {
+		allowed, _ := sharedClientGeoPolicyManager.CheckServer(serverId, this.RawIP())
+		if !allowed {
+			_ = this.rawConn.Close()
+			return false
+		}
+	}
+
+	return true
+}
+
 func (this *ClientConn) resetSYNFlood() {
 	counters.SharedCounter.ResetKey("SYN_FLOOD:" + this.RawIP())
 }
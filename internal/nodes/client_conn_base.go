@@ -52,7 +52,13 @@ func (this *BaseClientConn) Bind(serverId int64, remoteAddr string, maxConnsPerS
 	this.hasLimit = true
 
 	// 检查是否可以连接
-	return sharedClientConnLimiter.Add(this.rawConn.RemoteAddr().String(), serverId, remoteAddr, maxConnsPerServer, maxConnsPerIP)
+	ok, isIPExceeded := sharedClientConnLimiter.add(this.rawConn.RemoteAddr().String(), serverId, remoteAddr, maxConnsPerServer, maxConnsPerIP)
+
+	// 只惩罚直接连接的IP
+	if isIPExceeded && remoteAddr == this.RawIP() {
+		sharedClientConnPenaltyManager.RecordViolation(remoteAddr, serverId)
+	}
+	return ok
 }
 
 // SetServerId 设置服务ID
@@ -78,13 +84,13 @@ func (this *BaseClientConn) SetServerId(serverId int64) (goNext bool) {
 	case *tls.Conn:
 		nativeConn, ok := conn.NetConn().(ClientConnInterface)
 		if ok {
-			nativeConn.SetServerId(serverId)
+			goNext = nativeConn.SetServerId(serverId)
 		}
 	case *ClientConn:
-		conn.SetServerId(serverId)
+		goNext = conn.SetServerId(serverId)
 	}
 
-	return true
+	return
 }
 
 // ServerId 读取当前连接绑定的服务ID
@@ -155,12 +161,30 @@ func (this *BaseClientConn) TCPConn() (tcpConn *net.TCPConn, ok bool) {
 			return clientConn.TCPConn()
 		}
 		tcpConn, ok = internalConn.(*net.TCPConn)
+	case *ProxyProtocolConn:
+		tcpConn, ok = conn.NetConn().(*net.TCPConn)
 	default:
 		tcpConn, ok = this.rawConn.(*net.TCPConn)
 	}
 	return
 }
 
+// ProxyProtocol 读取通过PROXY协议传递的客户端信息，没有使用PROXY协议时返回nil
+func (this *BaseClientConn) ProxyProtocol() *ProxyProtocolInfo {
+	switch conn := this.rawConn.(type) {
+	case *tls.Conn:
+		clientConn, ok := conn.NetConn().(*ClientConn)
+		if ok {
+			return clientConn.ProxyProtocol()
+		}
+	case *ClientConn:
+		return conn.ProxyProtocol()
+	case *ProxyProtocolConn:
+		return conn.Info()
+	}
+	return nil
+}
+
 // SetLinger 设置Linger
 func (this *BaseClientConn) SetLinger(seconds int) error {
 	tcpConn, ok := this.TCPConn()
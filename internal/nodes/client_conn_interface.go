@@ -38,4 +38,7 @@ type ClientConnInterface interface {
 
 	// LastRequestBytes 读取上一次请求发送的字节数
 	LastRequestBytes() int64
+
+	// ProxyProtocol 读取通过PROXY协议传递的客户端信息
+	ProxyProtocol() *ProxyProtocolInfo
 }
@@ -36,8 +36,14 @@ func NewClientConnLimiter() *ClientConnLimiter {
 // Add 添加新连接
 // 返回值为true的时候表示允许添加；否则表示不允许添加
 func (this *ClientConnLimiter) Add(rawRemoteAddr string, serverId int64, remoteAddr string, maxConnsPerServer int, maxConnsPerIP int) bool {
+	ok, _ := this.add(rawRemoteAddr, serverId, remoteAddr, maxConnsPerServer, maxConnsPerIP)
+	return ok
+}
+
+// 添加新连接，并返回是否因为超出单IP连接数而不允许添加
+func (this *ClientConnLimiter) add(rawRemoteAddr string, serverId int64, remoteAddr string, maxConnsPerServer int, maxConnsPerIP int) (ok bool, isIPExceeded bool) {
 	if (maxConnsPerServer <= 0 && maxConnsPerIP <= 0) || len(remoteAddr) == 0 || serverId <= 0 {
-		return true
+		return true, false
 	}
 
 	this.locker.Lock()
@@ -52,7 +58,7 @@ func (this *ClientConnLimiter) Add(rawRemoteAddr string, serverId int64, remoteA
 		}
 
 		if maxConnsPerServer <= len(serverMap) {
-			return false
+			return false, false
 		}
 	}
 
@@ -64,7 +70,7 @@ func (this *ClientConnLimiter) Add(rawRemoteAddr string, serverId int64, remoteA
 			this.ipConns[remoteAddr] = ipMap
 		}
 		if maxConnsPerIP > 0 && maxConnsPerIP <= len(ipMap) {
-			return false
+			return false, true
 		}
 	}
 
@@ -80,7 +86,7 @@ func (this *ClientConnLimiter) Add(rawRemoteAddr string, serverId int64, remoteA
 		ipMap[rawRemoteAddr] = zero.New()
 	}
 
-	return true
+	return true, false
 }
 
 // Remove 删除连接
@@ -0,0 +1,355 @@
+// Copyright 2024 GoEdge CDN goedge.cdn@gmail.com. All rights reserved. Official site: https://goedge.cloud .
+
+package nodes
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/TeaOSLab/EdgeNode/internal/configs"
+	"github.com/TeaOSLab/EdgeNode/internal/conns"
+	teaconst "github.com/TeaOSLab/EdgeNode/internal/const"
+	"github.com/TeaOSLab/EdgeNode/internal/events"
+	"github.com/TeaOSLab/EdgeNode/internal/firewalls"
+	"github.com/TeaOSLab/EdgeNode/internal/iplibrary"
+	"github.com/TeaOSLab/EdgeNode/internal/remotelogs"
+	"github.com/TeaOSLab/EdgeNode/internal/utils/goman"
+	"github.com/iwind/TeaGo/maps"
+	"github.com/iwind/TeaGo/types"
+)
+
+// ClientConnPenaltyConfigFileName 连接数超限惩罚配置文件
+const ClientConnPenaltyConfigFileName = "conn_penalty.yaml"
+
+var sharedClientConnPenaltyManager = NewClientConnPenaltyManager(DefaultClientConnPenaltyConfig())
+
+func init() {
+	if !teaconst.IsMain {
+		return
+	}
+
+	events.On(events.EventLoaded, func() {
+		config, err := LoadClientConnPenaltyConfig()
+		if err != nil {
+			remotelogs.Error("CONN_PENALTY", "load '"+ClientConnPenaltyConfigFileName+"' failed: "+err.Error())
+		} else {
+			sharedClientConnPenaltyManager.UpdateConfig(config)
+		}
+
+		goman.New(func() {
+			sharedClientConnPenaltyManager.Start()
+		})
+	})
+	events.OnClose(func() {
+		sharedClientConnPenaltyManager.Stop()
+	})
+}
+
+// ClientConnPenaltyConfig 连接数超限惩罚配置
+type ClientConnPenaltyConfig struct {
+	IsOn            bool `yaml:"isOn" json:"isOn"`                       // 是否启用
+	Window          int  `yaml:"window" json:"window"`                   // 统计窗口，单位：秒
+	TarpitThreshold int  `yaml:"tarpitThreshold" json:"tarpitThreshold"` // 统计窗口内超限次数达到此值时延迟处理新连接
+	TarpitDelay     int  `yaml:"tarpitDelay" json:"tarpitDelay"`         // 延迟处理的时间，单位：毫秒
+	RejectThreshold int  `yaml:"rejectThreshold" json:"rejectThreshold"` // 统计窗口内超限次数达到此值时直接关闭新连接
+	DropThreshold   int  `yaml:"dropThreshold" json:"dropThreshold"`     // 统计窗口内超限次数达到此值时使用本地防火墙封禁
+	DropTimeout     int  `yaml:"dropTimeout" json:"dropTimeout"`         // 第一次封禁的时间，单位：秒，之后每次封禁时间加倍
+	MaxDropTimeout  int  `yaml:"maxDropTimeout" json:"maxDropTimeout"`   // 最长封禁时间，单位：秒
+	ResetAfter      int  `yaml:"resetAfter" json:"resetAfter"`           // 多长时间没有超限后重置惩罚，单位：秒
+}
+
+// DefaultClientConnPenaltyConfig 默认配置
+func DefaultClientConnPenaltyConfig() *ClientConnPenaltyConfig {
+	return &ClientConnPenaltyConfig{
+		IsOn:            false, // NAT后面的大量用户共用一个IP，所以需要明确启用
+		Window:          60,
+		TarpitThreshold: 5,
+		TarpitDelay:     2000,
+		RejectThreshold: 10,
+		DropThreshold:   20,
+		DropTimeout:     60,
+		MaxDropTimeout:  86400,
+		ResetAfter:      3600,
+	}
+}
+
+// Init 初始化
+func (this *ClientConnPenaltyConfig) Init() error {
+	if this.Window <= 0 {
+		return errors.New("'window' should be greater than 0")
+	}
+	if this.TarpitThreshold <= 0 || this.RejectThreshold <= this.TarpitThreshold || this.DropThreshold <= this.RejectThreshold {
+		return errors.New("thresholds should be greater than 0 and 'tarpitThreshold' < 'rejectThreshold' < 'dropThreshold'")
+	}
+	if this.TarpitDelay < 0 {
+		return errors.New("'tarpitDelay' should not be negative")
+	}
+	if this.DropTimeout <= 0 || this.MaxDropTimeout < this.DropTimeout {
+		return errors.New("'dropTimeout' should be greater than 0 and not greater than 'maxDropTimeout'")
+	}
+	if this.ResetAfter <= 0 {
+		return errors.New("'resetAfter' should be greater than 0")
+	}
+	return nil
+}
+
+// LoadClientConnPenaltyConfig 从配置文件中加载连接数超限惩罚配置
+// 如果配置文件不存在，则返回默认配置
+func LoadClientConnPenaltyConfig() (*ClientConnPenaltyConfig, error) {
+	return configs.LoadLocalConfig(ClientConnPenaltyConfigFileName, DefaultClientConnPenaltyConfig())
+}
+
+// ClientConnPenaltyLevel 惩罚级别
+type ClientConnPenaltyLevel int
+
+const (
+	ClientConnPenaltyLevelNone   ClientConnPenaltyLevel = 0
+	ClientConnPenaltyLevelTarpit ClientConnPenaltyLevel = 1 // 延迟处理
+	ClientConnPenaltyLevelReject ClientConnPenaltyLevel = 2 // 直接关闭连接
+	ClientConnPenaltyLevelDrop   ClientConnPenaltyLevel = 3 // 使用本地防火墙封禁
+)
+
+func (this ClientConnPenaltyLevel) String() string {
+	switch this {
+	case ClientConnPenaltyLevelTarpit:
+		return "tarpit"
+	case ClientConnPenaltyLevelReject:
+		return "reject"
+	case ClientConnPenaltyLevelDrop:
+		return "drop"
+	}
+	return "none"
+}
+
+type clientConnPenalty struct {
+	level        ClientConnPenaltyLevel
+	penaltyUntil int64 // 当前惩罚的结束时间
+
+	windowStartedAt int64
+	violations      int // 当前统计窗口内的超限次数
+
+	totalViolations int64
+	countDrops      int // 已经封禁的次数
+	lastViolatedAt  int64
+}
+
+// ClientConnPenaltyManager 连接数超限惩罚管理
+// 同一个IP多次超出连接数限制时，依次延迟处理新连接、直接关闭新连接、使用本地防火墙封禁，封禁时间按次数加倍
+type ClientConnPenaltyManager struct {
+	config    *ClientConnPenaltyConfig
+	penalties map[string]*clientConnPenalty // ip => penalty
+	dropFunc  func(ip string, timeoutSeconds int)
+	allowFunc func(ip string, serverId int64) bool
+
+	ticker *time.Ticker
+	locker sync.Mutex
+}
+
+func NewClientConnPenaltyManager(config *ClientConnPenaltyConfig) *ClientConnPenaltyManager {
+	return &ClientConnPenaltyManager{
+		config:    config,
+		penalties: map[string]*clientConnPenalty{},
+		dropFunc:  dropClientConnPenaltyIP,
+		allowFunc: isClientConnPenaltyAllowedIP,
+	}
+}
+
+// UpdateConfig 修改配置
+func (this *ClientConnPenaltyManager) UpdateConfig(config *ClientConnPenaltyConfig) {
+	this.locker.Lock()
+	this.config = config
+	if !config.IsOn {
+		this.penalties = map[string]*clientConnPenalty{}
+	}
+	this.locker.Unlock()
+}
+
+// Start 启动
+func (this *ClientConnPenaltyManager) Start() {
+	this.locker.Lock()
+	if this.ticker != nil {
+		this.locker.Unlock()
+		return
+	}
+	var ticker = time.NewTicker(1 * time.Minute)
+	this.ticker = ticker
+	this.locker.Unlock()
+
+	for range ticker.C {
+		this.GC()
+	}
+}
+
+// Stop 停止
+func (this *ClientConnPenaltyManager) Stop() {
+	this.locker.Lock()
+	if this.ticker != nil {
+		this.ticker.Stop()
+	}
+	this.locker.Unlock()
+}
+
+// Check 检查IP当前的惩罚级别
+func (this *ClientConnPenaltyManager) Check(ip string) (level ClientConnPenaltyLevel, tarpitDelay time.Duration) {
+	this.locker.Lock()
+	defer this.locker.Unlock()
+
+	if !this.config.IsOn {
+		return
+	}
+
+	penalty, ok := this.penalties[ip]
+	if !ok || penalty.penaltyUntil <= time.Now().Unix() {
+		return
+	}
+	level = penalty.level
+	if level == ClientConnPenaltyLevelTarpit {
+		tarpitDelay = time.Duration(this.config.TarpitDelay) * time.Millisecond
+	}
+	return
+}
+
+// RecordViolation 记录一次超限，并返回升级后的惩罚级别
+// 白名单中的IP不会受到惩罚
+func (this *ClientConnPenaltyManager) RecordViolation(ip string, serverId int64) ClientConnPenaltyLevel {
+	this.locker.Lock()
+
+	var config = this.config
+	var allowFunc = this.allowFunc
+	if !config.IsOn || len(ip) == 0 {
+		this.locker.Unlock()
+		return ClientConnPenaltyLevelNone
+	}
+	this.locker.Unlock()
+
+	if allowFunc != nil && allowFunc(ip, serverId) {
+		return ClientConnPenaltyLevelNone
+	}
+
+	this.locker.Lock()
+
+	var now = time.Now().Unix()
+	penalty, ok := this.penalties[ip]
+	if !ok {
+		penalty = &clientConnPenalty{}
+		this.penalties[ip] = penalty
+	}
+
+	// 封禁期间不再计数
+	if penalty.level == ClientConnPenaltyLevelDrop && penalty.penaltyUntil > now {
+		this.locker.Unlock()
+		return ClientConnPenaltyLevelDrop
+	}
+
+	if penalty.windowStartedAt <= now-int64(config.Window) {
+		penalty.windowStartedAt = now
+		penalty.violations = 0
+	}
+	penalty.violations++
+	penalty.totalViolations++
+	penalty.lastViolatedAt = now
+
+	var dropTimeout = 0
+	switch {
+	case penalty.violations >= config.DropThreshold:
+		dropTimeout = config.MaxDropTimeout
+		if penalty.countDrops < 30 {
+			dropTimeout = min(config.DropTimeout<<penalty.countDrops, config.MaxDropTimeout)
+		}
+		penalty.countDrops++
+		penalty.level = ClientConnPenaltyLevelDrop
+		penalty.penaltyUntil = now + int64(dropTimeout)
+
+		// 封禁结束后重新计数
+		penalty.violations = 0
+		penalty.windowStartedAt = 0
+	case penalty.violations >= config.RejectThreshold:
+		penalty.level = ClientConnPenaltyLevelReject
+		penalty.penaltyUntil = now + int64(config.Window)
+	case penalty.violations >= config.TarpitThreshold:
+		penalty.level = ClientConnPenaltyLevelTarpit
+		penalty.penaltyUntil = now + int64(config.Window)
+	}
+	var level = penalty.level
+	if penalty.penaltyUntil <= now {
+		level = ClientConnPenaltyLevelNone
+	}
+	var dropFunc = this.dropFunc
+	this.locker.Unlock()
+
+	if dropTimeout > 0 && dropFunc != nil {
+		dropFunc(ip, dropTimeout)
+	}
+
+	return level
+}
+
+// GC 清理过期的惩罚
+func (this *ClientConnPenaltyManager) GC() {
+	this.locker.Lock()
+	defer this.locker.Unlock()
+
+	var now = time.Now().Unix()
+	for ip, penalty := range this.penalties {
+		if penalty.penaltyUntil <= now && penalty.lastViolatedAt <= now-int64(this.config.ResetAfter) {
+			delete(this.penalties, ip)
+		}
+	}
+}
+
+// Penalties 列出所有IP的惩罚状态
+// 用于调试
+func (this *ClientConnPenaltyManager) Penalties() []maps.Map {
+	this.locker.Lock()
+	defer this.locker.Unlock()
+
+	var now = time.Now().Unix()
+	var result = []maps.Map{}
+	for ip, penalty := range this.penalties {
+		var level = penalty.level
+		var penaltyUntil = penalty.penaltyUntil
+		if penaltyUntil <= now {
+			level = ClientConnPenaltyLevelNone
+			penaltyUntil = 0
+		}
+		result = append(result, maps.Map{
+			"ip":              ip,
+			"level":           level.String(),
+			"penaltyUntil":    penaltyUntil,
+			"violations":      penalty.violations,
+			"totalViolations": penalty.totalViolations,
+			"countDrops":      penalty.countDrops,
+			"lastViolatedAt":  penalty.lastViolatedAt,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].GetInt64("lastViolatedAt") > result[j].GetInt64("lastViolatedAt")
+	})
+	return result
+}
+
+// 检查IP是否在白名单中
+func isClientConnPenaltyAllowedIP(ip string, serverId int64) bool {
+	_, inAllowList, _ := iplibrary.AllowIP(ip, serverId)
+	return inAllowList
+}
+
+// 使用本地防火墙封禁IP
+func dropClientConnPenaltyIP(ip string, timeoutSeconds int) {
+	conns.SharedMap.CloseIPConns(ip)
+
+	// 所在网段已经被封禁
+	if firewalls.SharedIPAggregator.Record(ip, time.Now().Unix()+int64(timeoutSeconds)) {
+		return
+	}
+
+	var fw = firewalls.Firewall()
+	if fw != nil && !fw.IsMock() {
+		err := fw.DropSourceIP(ip, timeoutSeconds, true)
+		if err != nil {
+			remotelogs.Warn("CONN_PENALTY", "drop ip '"+ip+"' for "+types.String(timeoutSeconds)+" seconds failed: "+err.Error())
+		}
+	}
+}
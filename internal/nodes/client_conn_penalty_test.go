@@ -0,0 +1,126 @@
+// Copyright 2024 GoEdge CDN goedge.cdn@gmail.com. All rights reserved. Official site: https://goedge.cloud .
+
+package nodes
+
+import (
+	"testing"
+	"time"
+
+	"github.com/iwind/TeaGo/assert"
+)
+
+func TestClientConnPenaltyManager_RecordViolation(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	var config = DefaultClientConnPenaltyConfig()
+	config.IsOn = true
+	config.TarpitThreshold = 2
+	config.RejectThreshold = 3
+	config.DropThreshold = 4
+	config.DropTimeout = 10
+	config.MaxDropTimeout = 30
+	a.IsNil(config.Init())
+
+	var manager = NewClientConnPenaltyManager(config)
+	var dropTimeouts = []int{}
+	manager.dropFunc = func(ip string, timeoutSeconds int) {
+		dropTimeouts = append(dropTimeouts, timeoutSeconds)
+	}
+	manager.allowFunc = nil
+
+	const ip = "192.168.1.100"
+	a.IsTrue(manager.RecordViolation(ip, 1) == ClientConnPenaltyLevelNone)
+	level, _ := manager.Check(ip)
+	a.IsTrue(level == ClientConnPenaltyLevelNone)
+
+	a.IsTrue(manager.RecordViolation(ip, 1) == ClientConnPenaltyLevelTarpit)
+	level, delay := manager.Check(ip)
+	a.IsTrue(level == ClientConnPenaltyLevelTarpit)
+	a.IsTrue(delay == 2*time.Second)
+
+	a.IsTrue(manager.RecordViolation(ip, 1) == ClientConnPenaltyLevelReject)
+	a.IsTrue(manager.RecordViolation(ip, 1) == ClientConnPenaltyLevelDrop)
+	a.IsTrue(len(dropTimeouts) == 1 && dropTimeouts[0] == 10)
+
+	// 封禁期间不再计数
+	a.IsTrue(manager.RecordViolation(ip, 1) == ClientConnPenaltyLevelDrop)
+	a.IsTrue(len(dropTimeouts) == 1)
+
+	// 封禁时间加倍，但不超过最长封禁时间
+	for _, expectedTimeout := range []int{20, 30, 30} {
+		manager.penalties[ip].penaltyUntil = 0
+		for i := 0; i < config.DropThreshold; i++ {
+			manager.RecordViolation(ip, 1)
+		}
+		a.IsTrue(dropTimeouts[len(dropTimeouts)-1] == expectedTimeout)
+	}
+
+	var penalties = manager.Penalties()
+	a.IsTrue(len(penalties) == 1)
+	a.IsTrue(penalties[0].GetString("level") == "drop")
+	a.IsTrue(penalties[0].GetInt("countDrops") == 4)
+
+	// 其他IP不受影响
+	level, _ = manager.Check("192.168.1.101")
+	a.IsTrue(level == ClientConnPenaltyLevelNone)
+}
+
+func TestClientConnPenaltyManager_GC(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	var config = DefaultClientConnPenaltyConfig()
+	config.IsOn = true
+
+	var manager = NewClientConnPenaltyManager(config)
+	manager.dropFunc = nil
+	manager.allowFunc = nil
+	manager.RecordViolation("192.168.1.100", 1)
+	manager.RecordViolation("192.168.1.101", 1)
+	manager.penalties["192.168.1.100"].lastViolatedAt = time.Now().Unix() - 7200
+
+	manager.GC()
+	a.IsTrue(len(manager.penalties) == 1)
+
+	// 关闭
+	manager.UpdateConfig(DefaultClientConnPenaltyConfig())
+	a.IsTrue(manager.RecordViolation("192.168.1.100", 1) == ClientConnPenaltyLevelNone)
+	a.IsTrue(len(manager.Penalties()) == 0)
+}
+
+func TestClientConnPenaltyManager_AllowedIP(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	// 默认不启用
+	a.IsFalse(DefaultClientConnPenaltyConfig().IsOn)
+
+	var config = DefaultClientConnPenaltyConfig()
+	config.IsOn = true
+	config.TarpitThreshold = 1
+	config.RejectThreshold = 2
+	config.DropThreshold = 3
+	a.IsNil(config.Init())
+
+	var manager = NewClientConnPenaltyManager(config)
+	var droppedIPs = []string{}
+	manager.dropFunc = func(ip string, timeoutSeconds int) {
+		droppedIPs = append(droppedIPs, ip)
+	}
+	manager.allowFunc = func(ip string, serverId int64) bool {
+		return ip == "192.168.1.100" && serverId == 1
+	}
+
+	// 白名单中的IP超限多少次都不会被惩罚
+	for i := 0; i < config.DropThreshold*2; i++ {
+		a.IsTrue(manager.RecordViolation("192.168.1.100", 1) == ClientConnPenaltyLevelNone)
+	}
+	level, _ := manager.Check("192.168.1.100")
+	a.IsTrue(level == ClientConnPenaltyLevelNone)
+	a.IsTrue(len(droppedIPs) == 0)
+	a.IsTrue(len(manager.Penalties()) == 0)
+
+	// 不在当前服务的白名单中
+	for i := 0; i < config.DropThreshold; i++ {
+		manager.RecordViolation("192.168.1.100", 2)
+	}
+	a.IsTrue(len(droppedIPs) == 1 && droppedIPs[0] == "192.168.1.100")
+}
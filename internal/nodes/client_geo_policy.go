@@ -0,0 +1,402 @@
+// Copyright 2024 GoEdge CDN goedge.cdn@gmail.com. All rights reserved. Official site: https://goedge.cloud .
+
+package nodes
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	iplib "github.com/TeaOSLab/EdgeCommon/pkg/iplibrary"
+	"github.com/TeaOSLab/EdgeCommon/pkg/serverconfigs"
+	"github.com/TeaOSLab/EdgeNode/internal/configs"
+	teaconst "github.com/TeaOSLab/EdgeNode/internal/const"
+	"github.com/TeaOSLab/EdgeNode/internal/events"
+	"github.com/TeaOSLab/EdgeNode/internal/remotelogs"
+	"github.com/TeaOSLab/EdgeNode/internal/utils/fasttime"
+	"github.com/TeaOSLab/EdgeNode/internal/utils/ttlcache"
+	"github.com/iwind/TeaGo/maps"
+	"github.com/iwind/TeaGo/types"
+)
+
+// ClientGeoPoliciesConfigFileName 连接层区域访问策略配置文件
+const ClientGeoPoliciesConfigFileName = "geo_policies.yaml"
+
+var sharedClientGeoPolicyManager = NewClientGeoPolicyManager()
+
+func init() {
+	if !teaconst.IsMain {
+		return
+	}
+
+	events.On(events.EventLoaded, func() {
+		config, err := LoadClientGeoPoliciesConfig()
+		if err != nil {
+			remotelogs.Error("GEO_POLICY", "load '"+ClientGeoPoliciesConfigFileName+"' failed: "+err.Error())
+			return
+		}
+		sharedClientGeoPolicyManager.UpdateConfig(config)
+	})
+}
+
+// ClientGeoPoliciesConfig 连接层区域访问策略配置
+type ClientGeoPoliciesConfig struct {
+	IsOn     bool               `yaml:"isOn" json:"isOn"`         // 是否启用
+	CacheTTL int                `yaml:"cacheTTL" json:"cacheTTL"` // IP查询结果缓存时间，单位：秒
+	Policies []*ClientGeoPolicy `yaml:"policies" json:"policies"` // 策略列表
+}
+
+// DefaultClientGeoPoliciesConfig 默认配置
+func DefaultClientGeoPoliciesConfig() *ClientGeoPoliciesConfig {
+	return &ClientGeoPoliciesConfig{
+		IsOn:     true,
+		CacheTTL: 3600,
+	}
+}
+
+// Init 初始化
+func (this *ClientGeoPoliciesConfig) Init() error {
+	if this.CacheTTL <= 0 {
+		return errors.New("'cacheTTL' should be greater than 0")
+	}
+
+	var nameMap = map[string]bool{}
+	for index, policy := range this.Policies {
+		if policy == nil {
+			return errors.New("policy #" + types.String(index+1) + " should not be empty")
+		}
+		if len(policy.Name) == 0 {
+			return errors.New("policy #" + types.String(index+1) + ": 'name' should not be empty")
+		}
+		if nameMap[policy.Name] {
+			return errors.New("duplicate policy name '" + policy.Name + "'")
+		}
+		nameMap[policy.Name] = true
+
+		err := policy.Init()
+		if err != nil {
+			return errors.New("policy '" + policy.Name + "': " + err.Error())
+		}
+	}
+	return nil
+}
+
+// LoadClientGeoPoliciesConfig 从配置文件中加载连接层区域访问策略
+// 如果配置文件不存在，则返回默认配置
+func LoadClientGeoPoliciesConfig() (*ClientGeoPoliciesConfig, error) {
+	return configs.LoadLocalConfig(ClientGeoPoliciesConfigFileName, DefaultClientGeoPoliciesConfig())
+}
+
+// ClientGeoPolicy 单个区域访问策略
+// 列表中的每一项可以是IP库中的ID，也可以是名称（不区分大小写）
+// IP库中没有单独的ASN数据，自治系统使用运营商（ISP）代替
+type ClientGeoPolicy struct {
+	Name      string  `yaml:"name" json:"name"`           // 策略名称，用于统计
+	ServerIds []int64 `yaml:"serverIds" json:"serverIds"` // 适用的网站ID，为空表示所有网站
+
+	AllowCountries []string `yaml:"allowCountries" json:"allowCountries"` // 允许的国家/地区，不为空时其他国家/地区均拒绝
+	DenyCountries  []string `yaml:"denyCountries" json:"denyCountries"`   // 拒绝的国家/地区
+	AllowRegions   []string `yaml:"allowRegions" json:"allowRegions"`     // 允许的省份/州，不为空时其他省份/州均拒绝
+	DenyRegions    []string `yaml:"denyRegions" json:"denyRegions"`       // 拒绝的省份/州
+	AllowProviders []string `yaml:"allowProviders" json:"allowProviders"` // 允许的运营商（ISP/ASN），不为空时其他运营商均拒绝
+	DenyProviders  []string `yaml:"denyProviders" json:"denyProviders"`   // 拒绝的运营商（ISP/ASN）
+	DenyUnknown    bool     `yaml:"denyUnknown" json:"denyUnknown"`       // 是否拒绝IP库中无法识别的IP，比如内网IP
+
+	serverIdMap map[int64]bool
+
+	allowCountries *clientGeoMatchList
+	denyCountries  *clientGeoMatchList
+	allowRegions   *clientGeoMatchList
+	denyRegions    *clientGeoMatchList
+	allowProviders *clientGeoMatchList
+	denyProviders  *clientGeoMatchList
+}
+
+// Init 初始化
+func (this *ClientGeoPolicy) Init() error {
+	this.serverIdMap = map[int64]bool{}
+	for _, serverId := range this.ServerIds {
+		if serverId <= 0 {
+			return errors.New("invalid server id '" + types.String(serverId) + "'")
+		}
+		this.serverIdMap[serverId] = true
+	}
+
+	this.allowCountries = newClientGeoMatchList(this.AllowCountries)
+	this.denyCountries = newClientGeoMatchList(this.DenyCountries)
+	this.allowRegions = newClientGeoMatchList(this.AllowRegions)
+	this.denyRegions = newClientGeoMatchList(this.DenyRegions)
+	this.allowProviders = newClientGeoMatchList(this.AllowProviders)
+	this.denyProviders = newClientGeoMatchList(this.DenyProviders)
+
+	return nil
+}
+
+// MatchServer 检查是否适用于某个网站
+func (this *ClientGeoPolicy) MatchServer(serverId int64) bool {
+	return len(this.serverIdMap) == 0 || this.serverIdMap[serverId]
+}
+
+// Denies 检查是否拒绝某个区域信息
+// info 为nil表示IP库中无法识别
+func (this *ClientGeoPolicy) Denies(info *ClientGeoInfo) bool {
+	if info == nil {
+		return this.DenyUnknown
+	}
+
+	if this.denyCountries.Match(info.CountryId, info.CountryName) ||
+		this.denyRegions.Match(info.ProvinceId, info.ProvinceName) ||
+		this.denyProviders.Match(info.ProviderId, info.ProviderName) {
+		return true
+	}
+
+	if !this.allowCountries.IsEmpty() && !this.allowCountries.Match(info.CountryId, info.CountryName) {
+		return true
+	}
+	if !this.allowRegions.IsEmpty() && !this.allowRegions.Match(info.ProvinceId, info.ProvinceName) {
+		return true
+	}
+	if !this.allowProviders.IsEmpty() && !this.allowProviders.Match(info.ProviderId, info.ProviderName) {
+		return true
+	}
+
+	return false
+}
+
+// ID和名称匹配列表
+type clientGeoMatchList struct {
+	idMap   map[int64]bool
+	nameMap map[string]bool
+}
+
+func newClientGeoMatchList(values []string) *clientGeoMatchList {
+	var list = &clientGeoMatchList{
+		idMap:   map[int64]bool{},
+		nameMap: map[string]bool{},
+	}
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if len(value) == 0 {
+			continue
+		}
+		var id = types.Int64(value)
+		if id > 0 && types.String(id) == value {
+			list.idMap[id] = true
+		} else {
+			list.nameMap[strings.ToLower(value)] = true
+		}
+	}
+	return list
+}
+
+func (this *clientGeoMatchList) IsEmpty() bool {
+	return len(this.idMap) == 0 && len(this.nameMap) == 0
+}
+
+func (this *clientGeoMatchList) Match(id int64, name string) bool {
+	if id > 0 && this.idMap[id] {
+		return true
+	}
+	return len(name) > 0 && len(this.nameMap) > 0 && this.nameMap[strings.ToLower(name)]
+}
+
+// ClientGeoInfo IP对应的区域信息
+type ClientGeoInfo struct {
+	CountryId    int64
+	CountryName  string
+	ProvinceId   int64
+	ProvinceName string
+	ProviderId   int64
+	ProviderName string
+}
+
+// 从IP库中查询区域信息
+func lookupClientGeoInfo(ip string) *ClientGeoInfo {
+	var result = iplib.LookupIP(ip)
+	if result == nil || !result.IsOk() {
+		return nil
+	}
+	return &ClientGeoInfo{
+		CountryId:    result.CountryId(),
+		CountryName:  result.CountryName(),
+		ProvinceId:   result.ProvinceId(),
+		ProvinceName: result.ProvinceName(),
+		ProviderId:   result.ProviderId(),
+		ProviderName: result.ProviderName(),
+	}
+}
+
+// 单个策略的统计
+type clientGeoPolicyStat struct {
+	countDenied int64
+}
+
+// ClientGeoPolicyManager 连接层区域访问策略管理
+// 在接受连接时（TLS握手之前）检查客户端IP所在区域
+type ClientGeoPolicyManager struct {
+	config   *ClientGeoPoliciesConfig
+	cache    *ttlcache.Cache[*ClientGeoInfo]
+	statMap  map[string]*clientGeoPolicyStat // policy name => stat
+	isActive int32                           // 是否有生效的策略，用来快速跳过检查
+
+	lookupFunc func(ip string) *ClientGeoInfo
+
+	locker sync.RWMutex
+}
+
+// NewClientGeoPolicyManager 获取新对象
+func NewClientGeoPolicyManager() *ClientGeoPolicyManager {
+	return &ClientGeoPolicyManager{
+		config:     DefaultClientGeoPoliciesConfig(),
+		cache:      ttlcache.NewCache[*ClientGeoInfo](ttlcache.NewMaxItemsOption(1_000_000)),
+		statMap:    map[string]*clientGeoPolicyStat{},
+		lookupFunc: lookupClientGeoInfo,
+	}
+}
+
+// UpdateConfig 修改配置
+func (this *ClientGeoPolicyManager) UpdateConfig(config *ClientGeoPoliciesConfig) {
+	if config == nil {
+		return
+	}
+
+	this.locker.Lock()
+	this.config = config
+
+	// 保留同名策略的统计数据
+	var statMap = map[string]*clientGeoPolicyStat{}
+	for _, policy := range config.Policies {
+		stat, ok := this.statMap[policy.Name]
+		if !ok {
+			stat = &clientGeoPolicyStat{}
+		}
+		statMap[policy.Name] = stat
+	}
+	this.statMap = statMap
+	this.locker.Unlock()
+
+	if config.IsOn && len(config.Policies) > 0 {
+		atomic.StoreInt32(&this.isActive, 1)
+	} else {
+		atomic.StoreInt32(&this.isActive, 0)
+	}
+
+	// 缓存时间可能已经变化
+	this.cache.Clean()
+}
+
+// IsActive 是否有生效的策略
+func (this *ClientGeoPolicyManager) IsActive() bool {
+	return atomic.LoadInt32(&this.isActive) == 1
+}
+
+// CheckServer 检查某个网站是否允许此IP访问
+func (this *ClientGeoPolicyManager) CheckServer(serverId int64, ip string) (allowed bool, policyName string) {
+	if !this.IsActive() {
+		return true, ""
+	}
+
+	var info = this.lookup(ip)
+
+	this.locker.RLock()
+	defer this.locker.RUnlock()
+
+	var policy = this.matchPolicy(serverId, info)
+	if policy == nil {
+		return true, ""
+	}
+	this.increaseDenied(policy.Name)
+	return false, policy.Name
+}
+
+// CheckGroup 检查某个监听分组是否允许此IP访问
+// 只有分组中所有网站都拒绝时才拒绝，此时可以在TLS握手之前直接关闭连接
+func (this *ClientGeoPolicyManager) CheckGroup(group *serverconfigs.ServerAddressGroup, ip string) (allowed bool, policyName string) {
+	if !this.IsActive() || group == nil {
+		return true, ""
+	}
+
+	var servers = group.Servers()
+	if len(servers) == 0 {
+		return true, ""
+	}
+
+	var info = this.lookup(ip)
+
+	this.locker.RLock()
+	defer this.locker.RUnlock()
+
+	var firstPolicy *ClientGeoPolicy
+	for _, server := range servers {
+		var policy = this.matchPolicy(server.Id, info)
+		if policy == nil {
+			return true, ""
+		}
+		if firstPolicy == nil {
+			firstPolicy = policy
+		}
+	}
+	this.increaseDenied(firstPolicy.Name)
+	return false, firstPolicy.Name
+}
+
+// Stats 各个策略的统计数据
+func (this *ClientGeoPolicyManager) Stats() []maps.Map {
+	this.locker.RLock()
+	defer this.locker.RUnlock()
+
+	var result = []maps.Map{}
+	for _, policy := range this.config.Policies {
+		var countDenied int64
+		stat, ok := this.statMap[policy.Name]
+		if ok {
+			countDenied = atomic.LoadInt64(&stat.countDenied)
+		}
+
+		var serverIds = policy.ServerIds
+		if serverIds == nil {
+			serverIds = []int64{}
+		}
+		result = append(result, maps.Map{
+			"name":        policy.Name,
+			"serverIds":   serverIds,
+			"countDenied": countDenied,
+		})
+	}
+	return result
+}
+
+// 查找第一个拒绝此区域的策略
+func (this *ClientGeoPolicyManager) matchPolicy(serverId int64, info *ClientGeoInfo) *ClientGeoPolicy {
+	for _, policy := range this.config.Policies {
+		if policy.MatchServer(serverId) && policy.Denies(info) {
+			return policy
+		}
+	}
+	return nil
+}
+
+func (this *ClientGeoPolicyManager) increaseDenied(policyName string) {
+	stat, ok := this.statMap[policyName]
+	if ok {
+		atomic.AddInt64(&stat.countDenied, 1)
+	}
+}
+
+// 查询IP区域信息，优先从缓存中读取
+func (this *ClientGeoPolicyManager) lookup(ip string) *ClientGeoInfo {
+	var item = this.cache.Read(ip)
+	if item != nil {
+		return item.Value
+	}
+
+	var info = this.lookupFunc(ip)
+
+	this.locker.RLock()
+	var ttl = this.config.CacheTTL
+	this.locker.RUnlock()
+
+	this.cache.Write(ip, info, fasttime.Now().Unix()+int64(ttl))
+	return info
+}
@@ -0,0 +1,151 @@
+// Copyright 2024 GoEdge CDN goedge.cdn@gmail.com. All rights reserved. Official site: https://goedge.cloud .
+
+package nodes
+
+import (
+	"testing"
+
+	"github.com/TeaOSLab/EdgeCommon/pkg/serverconfigs"
+	"github.com/iwind/TeaGo/assert"
+)
+
+func newTestClientGeoPolicyManager(t *testing.T, config *ClientGeoPoliciesConfig) (manager *ClientGeoPolicyManager, countLookups *int) {
+	err := config.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var count = 0
+	manager = NewClientGeoPolicyManager()
+	manager.lookupFunc = func(ip string) *ClientGeoInfo {
+		count++
+		switch ip {
+		case "1.1.1.1":
+			return &ClientGeoInfo{CountryId: 1, CountryName: "中国", ProvinceId: 10, ProvinceName: "浙江", ProviderId: 100, ProviderName: "电信"}
+		case "2.2.2.2":
+			return &ClientGeoInfo{CountryId: 2, CountryName: "United States", ProvinceId: 20, ProvinceName: "California", ProviderId: 200, ProviderName: "Cloudflare"}
+		}
+		return nil
+	}
+	manager.UpdateConfig(config)
+	return manager, &count
+}
+
+func TestClientGeoPolicyManager_CheckServer(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	var config = DefaultClientGeoPoliciesConfig()
+	config.Policies = []*ClientGeoPolicy{
+		{
+			Name:          "deny-providers",
+			DenyProviders: []string{"cloudflare"},
+		},
+		{
+			Name:           "allow-cn",
+			ServerIds:      []int64{1},
+			AllowCountries: []string{"1"},
+			DenyUnknown:    true,
+		},
+	}
+	manager, countLookups := newTestClientGeoPolicyManager(t, config)
+	a.IsTrue(manager.IsActive())
+
+	allowed, policyName := manager.CheckServer(1, "1.1.1.1")
+	a.IsTrue(allowed && len(policyName) == 0)
+
+	allowed, policyName = manager.CheckServer(2, "2.2.2.2")
+	a.IsFalse(allowed)
+	a.IsTrue(policyName == "deny-providers")
+
+	allowed, _ = manager.CheckServer(1, "3.3.3.3")
+	a.IsFalse(allowed)
+	allowed, _ = manager.CheckServer(2, "3.3.3.3")
+	a.IsTrue(allowed)
+
+	// 查询结果被缓存
+	a.IsTrue(*countLookups == 3)
+
+	var stats = manager.Stats()
+	a.IsTrue(len(stats) == 2)
+	a.IsTrue(stats[0].GetInt64("countDenied") == 1)
+	a.IsTrue(stats[1].GetInt64("countDenied") == 1)
+
+	// 重新加载后保留统计
+	config.Policies = config.Policies[1:]
+	manager.UpdateConfig(config)
+	stats = manager.Stats()
+	a.IsTrue(len(stats) == 1)
+	a.IsTrue(stats[0].GetString("name") == "allow-cn" && stats[0].GetInt64("countDenied") == 1)
+
+	// 关闭
+	config.IsOn = false
+	manager.UpdateConfig(config)
+	a.IsFalse(manager.IsActive())
+	allowed, _ = manager.CheckServer(1, "3.3.3.3")
+	a.IsTrue(allowed)
+}
+
+func TestClientGeoPolicyManager_CheckGroup(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	var config = DefaultClientGeoPoliciesConfig()
+	config.Policies = []*ClientGeoPolicy{
+		{
+			Name:          "deny-us",
+			ServerIds:     []int64{1},
+			DenyCountries: []string{"united states"},
+		},
+	}
+	manager, _ := newTestClientGeoPolicyManager(t, config)
+
+	var group = serverconfigs.NewServerAddressGroup("https://:1234")
+	group.Add(&serverconfigs.ServerConfig{Id: 1})
+
+	allowed, policyName := manager.CheckGroup(group, "2.2.2.2")
+	a.IsFalse(allowed)
+	a.IsTrue(policyName == "deny-us")
+
+	// 只要有一个网站允许就不能在接受连接时拒绝
+	group.Add(&serverconfigs.ServerConfig{Id: 2})
+	allowed, _ = manager.CheckGroup(group, "2.2.2.2")
+	a.IsTrue(allowed)
+	allowed, _ = manager.CheckServer(1, "2.2.2.2")
+	a.IsFalse(allowed)
+
+	a.IsTrue(manager.Stats()[0].GetInt64("countDenied") == 2)
+}
+
+func TestClientGeoPolicy_Denies(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	var policy = &ClientGeoPolicy{
+		Name:           "test",
+		AllowRegions:   []string{"浙江", " California "},
+		DenyProviders:  []string{"200"},
+		AllowProviders: []string{},
+	}
+	a.IsNil(policy.Init())
+
+	a.IsFalse(policy.Denies(&ClientGeoInfo{ProvinceName: "浙江", ProviderId: 100}))
+	a.IsFalse(policy.Denies(&ClientGeoInfo{ProvinceName: "california", ProviderId: 300}))
+	a.IsTrue(policy.Denies(&ClientGeoInfo{ProvinceName: "California", ProviderId: 200}))
+	a.IsTrue(policy.Denies(&ClientGeoInfo{ProvinceName: "江苏"}))
+	a.IsFalse(policy.Denies(nil))
+	a.IsTrue(policy.MatchServer(1))
+}
+
+func TestClientGeoPoliciesConfig_Init(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	var config = DefaultClientGeoPoliciesConfig()
+	a.IsNil(config.Init())
+
+	config.Policies = []*ClientGeoPolicy{{Name: "a"}, {Name: "a"}}
+	a.IsNotNil(config.Init())
+
+	config.Policies = []*ClientGeoPolicy{{Name: ""}}
+	a.IsNotNil(config.Init())
+
+	config.Policies = []*ClientGeoPolicy{{Name: "a", ServerIds: []int64{0}}}
+	a.IsNotNil(config.Init())
+}
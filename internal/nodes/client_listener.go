@@ -3,25 +3,52 @@
 package nodes
 
 import (
+	"errors"
 	"net"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/TeaOSLab/EdgeCommon/pkg/serverconfigs"
 	"github.com/TeaOSLab/EdgeCommon/pkg/serverconfigs/firewallconfigs"
 	"github.com/TeaOSLab/EdgeNode/internal/firewalls"
 	"github.com/TeaOSLab/EdgeNode/internal/iplibrary"
+	"github.com/TeaOSLab/EdgeNode/internal/remotelogs"
+	"github.com/TeaOSLab/EdgeNode/internal/utils/goman"
 	"github.com/TeaOSLab/EdgeNode/internal/waf"
+	"github.com/iwind/TeaGo/types"
 )
 
+// 接受连接的结果
+type clientListenerAcceptResult struct {
+	conn net.Conn
+	err  error
+}
+
 // ClientListener 客户端网络监听
 type ClientListener struct {
 	rawListener net.Listener
 	isHTTP      bool
 	isTLS       bool
+
+	groupFunc func() *serverconfigs.ServerAddressGroup // 获取当前监听分组，用于检查区域访问策略
+
+	// PROXY协议
+	// 一旦有适用的策略，就改为在单独的协程中接受连接；策略在每个连接接受时重新查找，所以修改配置后无需重启
+	portOnce        sync.Once
+	port            int
+	proxyIsLooping  bool
+	proxyResultChan chan *clientListenerAcceptResult
+	proxyCloseChan  chan struct{}
+	proxyCloseOnce  sync.Once
 }
 
 func NewClientListener(listener net.Listener, isHTTP bool) *ClientListener {
 	return &ClientListener{
-		rawListener: listener,
-		isHTTP:      isHTTP,
+		rawListener:     listener,
+		isHTTP:          isHTTP,
+		proxyResultChan: make(chan *clientListenerAcceptResult),
+		proxyCloseChan:  make(chan struct{}),
 	}
 }
 
@@ -33,15 +60,56 @@ func (this *ClientListener) IsTLS() bool {
 	return this.isTLS
 }
 
+// SetGroupFunc 设置获取监听分组的函数
+func (this *ClientListener) SetGroupFunc(groupFunc func() *serverconfigs.ServerAddressGroup) {
+	this.groupFunc = groupFunc
+}
+
 func (this *ClientListener) Accept() (net.Conn, error) {
-	conn, err := this.rawListener.Accept()
-	if err != nil {
-		return nil, err
+	if this.proxyIsLooping {
+		return this.acceptProxyProtocol()
+	}
+
+	for {
+		conn, err := this.rawListener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		// 需要接收PROXY协议
+		if this.findProxyPolicy() != nil {
+			this.proxyIsLooping = true
+			goman.New(func() {
+				this.loopProxyProtocol(conn)
+			})
+			return this.acceptProxyProtocol()
+		}
+
+		clientConn, ok := this.wrapConn(conn)
+		if ok {
+			return clientConn, nil
+		}
 	}
+}
 
+func (this *ClientListener) Close() error {
+	this.proxyCloseOnce.Do(func() {
+		close(this.proxyCloseChan)
+	})
+	return this.rawListener.Close()
+}
+
+func (this *ClientListener) Addr() net.Addr {
+	return this.rawListener.Addr()
+}
+
+// 检查连接并包装为客户端连接，连接被拒绝时返回false
+func (this *ClientListener) wrapConn(conn net.Conn) (net.Conn, bool) {
 	// 是否在WAF名单中
 	ip, _, err := net.SplitHostPort(conn.RemoteAddr().String())
 	var isInAllowList = false
+	var penaltyLevel = ClientConnPenaltyLevelNone
+	var tarpitDelay time.Duration
 	if err == nil {
 		canGoNext, inAllowList, expiresAt := iplibrary.AllowIP(ip, 0)
 		isInAllowList = inAllowList
@@ -58,25 +126,127 @@ func (this *ClientListener) Accept() (net.Conn, error) {
 			}
 		}
 
+		// 多次超出连接数限制的IP
+		if canGoNext && !isInAllowList {
+			penaltyLevel, tarpitDelay = sharedClientConnPenaltyManager.Check(ip)
+			if penaltyLevel >= ClientConnPenaltyLevelReject {
+				canGoNext = false
+				sharedClientConnPenaltyManager.RecordViolation(ip, 0)
+			}
+		}
+
+		// 区域访问策略，在TLS握手之前检查
+		if canGoNext && !isInAllowList && this.groupFunc != nil && sharedClientGeoPolicyManager.IsActive() {
+			canGoNext, _ = sharedClientGeoPolicyManager.CheckGroup(this.groupFunc(), ip)
+		}
+
 		if !canGoNext {
-			tcpConn, ok := conn.(*net.TCPConn)
+			lingerConn, ok := conn.(LingerConn)
 			if ok {
-				_ = tcpConn.SetLinger(0)
+				_ = lingerConn.SetLinger(0)
 			}
 
 			_ = conn.Close()
 
-			return this.Accept()
+			return nil, false
+		}
+	}
+
+	var clientConn = NewClientConn(conn, this.isHTTP, this.isTLS, isInAllowList)
+	if penaltyLevel == ClientConnPenaltyLevelTarpit {
+		clientConn.(*ClientConn).tarpitDelay = tarpitDelay
+	}
+	return clientConn, true
+}
+
+// 查找当前监听端口适用的PROXY协议策略
+func (this *ClientListener) findProxyPolicy() *ProxyProtocolPolicy {
+	this.portOnce.Do(func() {
+		if this.groupFunc != nil {
+			var group = this.groupFunc()
+			if group != nil {
+				var addr = group.Addr()
+				this.port = types.Int(addr[strings.LastIndex(addr, ":")+1:])
+			}
+		}
+		if this.port <= 0 {
+			_, portString, err := net.SplitHostPort(this.rawListener.Addr().String())
+			if err == nil {
+				this.port = types.Int(portString)
+			}
 		}
+	})
+	if this.port <= 0 {
+		return nil
 	}
+	return SharedProxyProtocolManager.FindPolicy(this.port)
+}
 
-	return NewClientConn(conn, this.isHTTP, this.isTLS, isInAllowList), nil
+// 读取使用PROXY协议的连接
+func (this *ClientListener) acceptProxyProtocol() (net.Conn, error) {
+	select {
+	case result := <-this.proxyResultChan:
+		return result.conn, result.err
+	case <-this.proxyCloseChan:
+		return nil, net.ErrClosed
+	}
 }
 
-func (this *ClientListener) Close() error {
-	return this.rawListener.Close()
+// 在单独的协程中接受连接，从可信来源的连接中读取PROXY协议头时不会阻塞其他连接
+// firstConn 为切换到此协程之前已经接受的连接
+func (this *ClientListener) loopProxyProtocol(firstConn net.Conn) {
+	var conn = firstConn
+	for {
+		if conn == nil {
+			var err error
+			conn, err = this.rawListener.Accept()
+			if err != nil {
+				if !this.sendProxyProtocolResult(&clientListenerAcceptResult{err: err}) || errors.Is(err, net.ErrClosed) {
+					return
+				}
+
+				// 防止持续出错时占用过多CPU
+				time.Sleep(5 * time.Millisecond)
+				continue
+			}
+		}
+
+		var rawConn = conn
+		conn = nil
+
+		var policy = this.findProxyPolicy()
+		ip, _, _ := net.SplitHostPort(rawConn.RemoteAddr().String())
+		if policy == nil || !policy.IsTrusted(ip) {
+			clientConn, ok := this.wrapConn(rawConn)
+			if ok && !this.sendProxyProtocolResult(&clientListenerAcceptResult{conn: clientConn}) {
+				_ = clientConn.Close()
+				return
+			}
+			continue
+		}
+
+		goman.New(func() {
+			proxyConn, err := ReadProxyProtocolConn(rawConn, policy)
+			if err != nil {
+				remotelogs.Debug("PROXY_PROTOCOL", "read header from '"+rawConn.RemoteAddr().String()+"' failed: "+err.Error())
+				_ = rawConn.Close()
+				return
+			}
+
+			clientConn, ok := this.wrapConn(proxyConn)
+			if ok && !this.sendProxyProtocolResult(&clientListenerAcceptResult{conn: clientConn}) {
+				_ = clientConn.Close()
+			}
+		})
+	}
 }
 
-func (this *ClientListener) Addr() net.Addr {
-	return this.rawListener.Addr()
+// 将连接交给Accept()，监听器已关闭时返回false
+func (this *ClientListener) sendProxyProtocolResult(result *clientListenerAcceptResult) bool {
+	select {
+	case this.proxyResultChan <- result:
+		return true
+	case <-this.proxyCloseChan:
+		return false
+	}
 }
@@ -0,0 +1,474 @@
+// Copyright 2024 GoEdge CDN goedge.cdn@gmail.com. All rights reserved. Official site: https://goedge.cloud .
+
+package nodes
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TeaOSLab/EdgeNode/internal/configs"
+	teaconst "github.com/TeaOSLab/EdgeNode/internal/const"
+	"github.com/TeaOSLab/EdgeNode/internal/events"
+	"github.com/TeaOSLab/EdgeNode/internal/remotelogs"
+	"github.com/TeaOSLab/EdgeNode/internal/utils"
+	"github.com/TeaOSLab/EdgeNode/internal/utils/goman"
+	"github.com/TeaOSLab/EdgeNode/internal/utils/kvstore"
+	"github.com/iwind/TeaGo/maps"
+	"github.com/iwind/TeaGo/types"
+	"github.com/iwind/gosock/pkg/gosock"
+)
+
+// GracefulRestartConfigFileName 优雅重启配置文件
+const GracefulRestartConfigFileName = "graceful_restart.yaml"
+
+// 新进程从此环境变量中读取用于接收监听器的Unix Socket路径
+const gracefulRestartSockEnv = "EdgeGracefulSock"
+
+// 新进程从此环境变量中读取守护进程ID
+const gracefulRestartDaemonPidEnv = "EdgeDaemonPid"
+
+// 等待新进程连接的超时时间
+const gracefulRestartHandoffTimeout = 30 * time.Second
+
+var ErrGracefulRestartNotSupported = errors.New("graceful restart is not supported on current platform")
+
+var SharedGracefulRestartManager = NewGracefulRestartManager()
+
+func init() {
+	if !teaconst.IsMain {
+		return
+	}
+
+	events.On(events.EventLoaded, func() {
+		config, err := LoadGracefulRestartConfig()
+		if err != nil {
+			remotelogs.Error("GRACEFUL_RESTART", "load '"+GracefulRestartConfigFileName+"' failed: "+err.Error())
+			return
+		}
+		SharedGracefulRestartManager.UpdateConfig(config)
+	})
+}
+
+// GracefulRestartConfig 优雅重启配置
+type GracefulRestartConfig struct {
+	IsOn         bool `yaml:"isOn" json:"isOn"`                 // 升级时是否使用优雅重启
+	ReadyTimeout int  `yaml:"readyTimeout" json:"readyTimeout"` // 等待新进程就绪的超时时间，超时后终止新进程并回滚，单位：秒
+	DrainTimeout int  `yaml:"drainTimeout" json:"drainTimeout"` // 旧进程等待已有连接结束的最长时间，单位：秒
+}
+
+// DefaultGracefulRestartConfig 默认配置
+func DefaultGracefulRestartConfig() *GracefulRestartConfig {
+	return &GracefulRestartConfig{
+		IsOn:         true,
+		ReadyTimeout: 120,
+		DrainTimeout: 300,
+	}
+}
+
+// Init 初始化
+func (this *GracefulRestartConfig) Init() error {
+	if this.ReadyTimeout <= 0 {
+		return errors.New("'readyTimeout' should be greater than 0")
+	}
+	if this.DrainTimeout <= 0 {
+		return errors.New("'drainTimeout' should be greater than 0")
+	}
+	return nil
+}
+
+// LoadGracefulRestartConfig 从配置文件中加载优雅重启配置
+// 如果配置文件不存在，则返回默认配置
+func LoadGracefulRestartConfig() (*GracefulRestartConfig, error) {
+	return configs.LoadLocalConfig(GracefulRestartConfigFileName, DefaultGracefulRestartConfig())
+}
+
+// 用于传递监听器的Unix Socket路径
+func gracefulSockPath(pid int) string {
+	return filepath.Join(os.TempDir(), teaconst.ProcessName+"-graceful-"+types.String(pid)+".sock")
+}
+
+// IsGracefulRestarting 检查是否有进程正在优雅重启
+// 优雅重启期间本地sock会短暂关闭，守护进程需要以此判断是否需要启动新的进程
+func IsGracefulRestarting() bool {
+	var prefix = teaconst.ProcessName + "-graceful-"
+	matches, err := filepath.Glob(filepath.Join(os.TempDir(), prefix+"*.sock"))
+	if err != nil {
+		return false
+	}
+	for _, match := range matches {
+		var pid = types.Int(strings.TrimSuffix(strings.TrimPrefix(filepath.Base(match), prefix), ".sock"))
+		if pid > 0 && isProcessAlive(pid) {
+			return true
+		}
+	}
+	return false
+}
+
+// 新旧进程之间传递的消息
+type gracefulRestartMessage struct {
+	Keys  []string `json:"keys,omitempty"`  // 随消息传递的监听器
+	Done  bool     `json:"done,omitempty"`  // 监听器已经传递完毕
+	Ready bool     `json:"ready,omitempty"` // 新进程已经就绪
+	Pid   int      `json:"pid,omitempty"`   // 新进程ID
+	Error string   `json:"error,omitempty"` // 新进程启动失败的原因
+}
+
+// 监听器在新旧进程之间传递时使用的名称
+func gracefulListenerKey(network string, addr string) string {
+	return network + "/" + addr
+}
+
+// GracefulRestartManager 优雅重启管理
+// 旧进程将监听器通过Unix Socket传递给新进程，新进程就绪后旧进程停止接受新连接，并等待已有连接结束后退出；
+// 新进程在超时时间内没有就绪时，旧进程终止新进程并继续提供服务
+type GracefulRestartManager struct {
+	config       *GracefulRestartConfig
+	isRestarting bool
+
+	// 新进程中从旧进程继承的数据
+	inheritedFiles map[string]*os.File
+	parentConn     *net.UnixConn
+
+	locker sync.Mutex
+}
+
+// NewGracefulRestartManager 获取新对象
+func NewGracefulRestartManager() *GracefulRestartManager {
+	return &GracefulRestartManager{
+		config: DefaultGracefulRestartConfig(),
+	}
+}
+
+// UpdateConfig 修改配置
+func (this *GracefulRestartManager) UpdateConfig(config *GracefulRestartConfig) {
+	if config == nil {
+		return
+	}
+	this.locker.Lock()
+	this.config = config
+	this.locker.Unlock()
+}
+
+// IsOn 升级时是否使用优雅重启
+func (this *GracefulRestartManager) IsOn() bool {
+	this.locker.Lock()
+	defer this.locker.Unlock()
+	return this.config.IsOn
+}
+
+// Restart 使用新的可执行文件优雅重启
+// 新进程就绪后返回nil，当前进程随后在后台等待已有连接结束并退出；新进程启动失败时返回错误，当前进程继续提供服务
+func (this *GracefulRestartManager) Restart(exe string) error {
+	this.locker.Lock()
+	if this.isRestarting {
+		this.locker.Unlock()
+		return errors.New("graceful restart is already in progress")
+	}
+	this.isRestarting = true
+	var config = this.config
+	this.locker.Unlock()
+
+	var isOk = false
+	defer func() {
+		if !isOk {
+			this.locker.Lock()
+			this.isRestarting = false
+			this.locker.Unlock()
+		}
+	}()
+
+	if sharedListenerManager == nil {
+		return errors.New("listener manager is not initialized")
+	}
+	keys, files, err := sharedListenerManager.GracefulFiles()
+	if err != nil {
+		return errors.New("read listener files failed: " + err.Error())
+	}
+	defer func() {
+		for _, file := range files {
+			_ = file.Close()
+		}
+	}()
+
+	var sockPath = gracefulSockPath(os.Getpid())
+	handoffListener, err := listenGracefulSock(sockPath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = handoffListener.Close()
+		_ = os.Remove(sockPath)
+	}()
+
+	// 关闭本地sock，新进程启动时会检查sock是否已经被占用
+	// 在此期间守护进程会因为上面的Unix Socket文件存在而不再启动其他进程，参考 IsGracefulRestarting()
+	this.pauseSock()
+
+	var cmd = newGracefulCmd(exe)
+	cmd.Env = append(os.Environ(), gracefulRestartSockEnv+"="+sockPath, "EdgeBackground=on")
+	if DaemonIsOn {
+		// 新进程不是守护进程的子进程，需要告诉它守护进程的ID
+		cmd.Env = append(cmd.Env, gracefulRestartDaemonPidEnv+"="+types.String(DaemonPid))
+	}
+	err = cmd.Start()
+	if err != nil {
+		this.resumeSock()
+		return err
+	}
+	remotelogs.Println("GRACEFUL_RESTART", "starting new process '"+exe+"', pid: "+types.String(cmd.Process.Pid)+", passing "+types.String(len(files))+" listeners ...")
+
+	// 新进程退出时中断等待
+	var exitChan = make(chan struct{})
+	var exitErr error
+	goman.New(func() {
+		exitErr = cmd.Wait()
+		close(exitChan)
+		_ = handoffListener.Close()
+	})
+
+	var rollback = func(reason error) error {
+		_ = cmd.Process.Kill()
+		<-exitChan
+
+		// 新进程退出后才能重新打开数据库
+		storeErr := kvstore.ReopenStores()
+		if storeErr != nil {
+			remotelogs.Error("GRACEFUL_RESTART", storeErr.Error())
+		}
+
+		this.resumeSock()
+		return reason
+	}
+	var exitReason = func(reason error) error {
+		select {
+		case <-exitChan:
+			if exitErr != nil {
+				return errors.New("new process exited: " + exitErr.Error())
+			}
+			return errors.New("new process exited")
+		default:
+			return reason
+		}
+	}
+
+	// 传递监听器
+	_ = handoffListener.SetDeadline(time.Now().Add(gracefulRestartHandoffTimeout))
+	conn, err := handoffListener.AcceptUnix()
+	if err != nil {
+		return rollback(exitReason(errors.New("wait for new process failed: " + err.Error())))
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	// 释放数据库，新进程在启动时会等待数据库释放后再打开
+	// 释放之后缓存索引、IP名单、指标等使用数据库的模块通过 kvstore.IsReleased() 暂停读写，回滚时通过 kvstore.ReopenStores() 恢复
+	err = kvstore.ReleaseStores()
+	if err != nil {
+		return rollback(err)
+	}
+
+	err = sendGracefulFiles(conn, keys, files)
+	if err != nil {
+		return rollback(exitReason(errors.New("send listeners failed: " + err.Error())))
+	}
+
+	// 等待新进程就绪
+	_ = conn.SetReadDeadline(time.Now().Add(time.Duration(config.ReadyTimeout) * time.Second))
+	message, fds, err := readGracefulMessage(conn)
+	closeGracefulFds(fds)
+	if err != nil {
+		return rollback(exitReason(errors.New("wait for new process to be ready failed: " + err.Error())))
+	}
+	if !message.Ready {
+		return rollback(errors.New("new process failed to start: " + message.Error))
+	}
+
+	// 检查新进程是否可以正常响应
+	reply, err := gosock.NewTmpSock(teaconst.ProcessName).SendTimeout(&gosock.Command{Code: "pid"}, 5*time.Second)
+	if err != nil {
+		return rollback(exitReason(errors.New("check new process failed: " + err.Error())))
+	}
+	var pid = maps.NewMap(reply.Params).GetInt("pid")
+	if pid != cmd.Process.Pid {
+		return rollback(errors.New("check new process failed: unexpected pid '" + types.String(pid) + "'"))
+	}
+
+	isOk = true
+	remotelogs.Println("GRACEFUL_RESTART", "new process is ready, pid: "+types.String(pid))
+
+	goman.New(func() {
+		this.drain(time.Duration(config.DrainTimeout) * time.Second)
+	})
+
+	return nil
+}
+
+// 停止接受新连接，等待已有连接结束后退出
+// 这里不能发送EventQuit和EventTerminated事件，因为它们会关闭缓存、IP库等数据，而已有连接仍然需要使用
+// 此时数据库已经释放，已有连接不再写入缓存；如果当前进程由守护进程启动，因为新进程已经在监听本地sock，守护进程不会再启动其他进程
+func (this *GracefulRestartManager) drain(timeout time.Duration) {
+	sharedListenerManager.StopAccepting()
+
+	// 尽快关闭空闲的长连接
+	sharedListenerManager.DisableKeepAlives()
+
+	var deadline = time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		var countActiveConnections = sharedListenerManager.TotalActiveConnections()
+		if countActiveConnections <= 0 {
+			break
+		}
+		time.Sleep(1 * time.Second)
+	}
+
+	remotelogs.Println("GRACEFUL_RESTART", "old process exited")
+	events.Notify(events.EventQuit)
+	utils.Exit() // 这里会发送EventTerminated事件
+}
+
+// 关闭本地sock
+func (this *GracefulRestartManager) pauseSock() {
+	if nodeInstance != nil {
+		_ = nodeInstance.sock.Close()
+	}
+}
+
+// 恢复本地sock
+func (this *GracefulRestartManager) resumeSock() {
+	if nodeInstance != nil {
+		goman.New(func() {
+			err := nodeInstance.sock.Listen()
+			if err != nil {
+				remotelogs.Error("GRACEFUL_RESTART", "listen sock failed: "+err.Error())
+			}
+		})
+	}
+}
+
+// ReceiveListeners 从旧进程中接收监听器，只在通过优雅重启启动的新进程中有效
+func (this *GracefulRestartManager) ReceiveListeners() {
+	var sockPath = os.Getenv(gracefulRestartSockEnv)
+	if len(sockPath) == 0 {
+		return
+	}
+	_ = os.Unsetenv(gracefulRestartSockEnv)
+
+	conn, err := dialGracefulSock(sockPath)
+	if err != nil {
+		remotelogs.Error("GRACEFUL_RESTART", "connect to old process failed: "+err.Error())
+		return
+	}
+	files, err := receiveGracefulFiles(conn)
+	if err != nil {
+		_ = conn.Close()
+		remotelogs.Error("GRACEFUL_RESTART", "receive listeners failed: "+err.Error())
+		return
+	}
+
+	this.locker.Lock()
+	this.inheritedFiles = files
+	this.parentConn = conn
+	this.locker.Unlock()
+
+	remotelogs.Println("GRACEFUL_RESTART", "received "+types.String(len(files))+" listeners from old process")
+}
+
+// 读取从旧进程继承的监听器文件
+func (this *GracefulRestartManager) takeInheritedFile(network string, addr string) *os.File {
+	this.locker.Lock()
+	defer this.locker.Unlock()
+
+	var key = gracefulListenerKey(network, addr)
+	file, ok := this.inheritedFiles[key]
+	if !ok {
+		return nil
+	}
+	delete(this.inheritedFiles, key)
+	return file
+}
+
+// InheritListener 读取从旧进程继承的TCP监听器，没有时返回nil
+func (this *GracefulRestartManager) InheritListener(network string, addr string) (net.Listener, error) {
+	var file = this.takeInheritedFile(network, addr)
+	if file == nil {
+		return nil, nil
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	return net.FileListener(file)
+}
+
+// InheritUDPConn 读取从旧进程继承的UDP监听器，没有时返回nil
+func (this *GracefulRestartManager) InheritUDPConn(network string, addr string) (*net.UDPConn, error) {
+	var file = this.takeInheritedFile(network, addr)
+	if file == nil {
+		return nil, nil
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	packetConn, err := net.FilePacketConn(file)
+	if err != nil {
+		return nil, err
+	}
+	udpConn, ok := packetConn.(*net.UDPConn)
+	if !ok {
+		_ = packetConn.Close()
+		return nil, errors.New("invalid udp listener '" + gracefulListenerKey(network, addr) + "'")
+	}
+	return udpConn, nil
+}
+
+// NotifyReady 通知旧进程新进程已经就绪
+// 如果有数据库没有成功打开，则通知旧进程启动失败
+func (this *GracefulRestartManager) NotifyReady() {
+	err := kvstore.OpenError()
+	if err != nil {
+		this.NotifyFailed(err)
+		return
+	}
+
+	this.notifyParent(&gracefulRestartMessage{
+		Ready: true,
+		Pid:   os.Getpid(),
+	})
+	notifySystemdMainPid()
+}
+
+// NotifyFailed 通知旧进程新进程启动失败
+func (this *GracefulRestartManager) NotifyFailed(err error) {
+	this.notifyParent(&gracefulRestartMessage{
+		Pid:   os.Getpid(),
+		Error: err.Error(),
+	})
+}
+
+func (this *GracefulRestartManager) notifyParent(message *gracefulRestartMessage) {
+	this.locker.Lock()
+	var conn = this.parentConn
+	var files = this.inheritedFiles
+	this.parentConn = nil
+	this.inheritedFiles = nil
+	this.locker.Unlock()
+
+	// 关闭没有用到的监听器
+	for _, file := range files {
+		_ = file.Close()
+	}
+
+	if conn == nil {
+		return
+	}
+	err := writeGracefulMessage(conn, message, nil)
+	if err != nil {
+		remotelogs.Error("GRACEFUL_RESTART", "notify old process failed: "+err.Error())
+	}
+	_ = conn.Close()
+}
@@ -0,0 +1,164 @@
+// Copyright 2024 GoEdge CDN goedge.cdn@gmail.com. All rights reserved. Official site: https://goedge.cloud .
+//go:build !windows
+
+package nodes
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+)
+
+// 单个消息中最多传递的文件数，Linux中SCM_MAX_FD为253
+const gracefulRestartMaxFilesPerMessage = 200
+
+// 监听用于传递监听器的Unix Socket
+func listenGracefulSock(path string) (*net.UnixListener, error) {
+	_ = os.Remove(path)
+	return net.ListenUnix("unixpacket", &net.UnixAddr{Name: path, Net: "unixpacket"})
+}
+
+// 连接旧进程的Unix Socket
+func dialGracefulSock(path string) (*net.UnixConn, error) {
+	return net.DialUnix("unixpacket", nil, &net.UnixAddr{Name: path, Net: "unixpacket"})
+}
+
+// 发送一条消息
+func writeGracefulMessage(conn *net.UnixConn, message *gracefulRestartMessage, files []*os.File) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	var oob []byte
+	if len(files) > 0 {
+		var fds = make([]int, 0, len(files))
+		for _, file := range files {
+			fds = append(fds, int(file.Fd()))
+		}
+		oob = syscall.UnixRights(fds...)
+	}
+	_, _, err = conn.WriteMsgUnix(data, oob, nil)
+	return err
+}
+
+// 读取一条消息
+func readGracefulMessage(conn *net.UnixConn) (message *gracefulRestartMessage, fds []int, err error) {
+	var buf = make([]byte, 64<<10)
+	var oob = make([]byte, syscall.CmsgSpace(gracefulRestartMaxFilesPerMessage*4))
+	n, oobn, _, _, err := conn.ReadMsgUnix(buf, oob)
+	if err != nil {
+		return nil, nil, err
+	}
+	if n == 0 {
+		return nil, nil, errors.New("connection closed by peer")
+	}
+
+	if oobn > 0 {
+		controlMessages, err := syscall.ParseSocketControlMessage(oob[:oobn])
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, controlMessage := range controlMessages {
+			messageFds, err := syscall.ParseUnixRights(&controlMessage)
+			if err != nil {
+				closeGracefulFds(fds)
+				return nil, nil, err
+			}
+			fds = append(fds, messageFds...)
+		}
+	}
+
+	message = &gracefulRestartMessage{}
+	err = json.Unmarshal(buf[:n], message)
+	if err != nil {
+		closeGracefulFds(fds)
+		return nil, nil, err
+	}
+	return message, fds, nil
+}
+
+func closeGracefulFds(fds []int) {
+	for _, fd := range fds {
+		_ = syscall.Close(fd)
+	}
+}
+
+// 发送监听器文件，文件较多时分成多个消息发送
+func sendGracefulFiles(conn *net.UnixConn, keys []string, files []*os.File) error {
+	for offset := 0; offset < len(files); offset += gracefulRestartMaxFilesPerMessage {
+		var end = offset + gracefulRestartMaxFilesPerMessage
+		if end > len(files) {
+			end = len(files)
+		}
+		err := writeGracefulMessage(conn, &gracefulRestartMessage{Keys: keys[offset:end]}, files[offset:end])
+		if err != nil {
+			return err
+		}
+	}
+	return writeGracefulMessage(conn, &gracefulRestartMessage{Done: true}, nil)
+}
+
+// 接收监听器文件
+func receiveGracefulFiles(conn *net.UnixConn) (map[string]*os.File, error) {
+	var result = map[string]*os.File{}
+	var closeAll = func() {
+		for _, file := range result {
+			_ = file.Close()
+		}
+	}
+
+	for {
+		message, fds, err := readGracefulMessage(conn)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		if message.Done {
+			closeGracefulFds(fds)
+			return result, nil
+		}
+		if len(fds) != len(message.Keys) {
+			closeGracefulFds(fds)
+			closeAll()
+			return nil, errors.New("invalid message: expected " + strconv.Itoa(len(message.Keys)) + " files, but got " + strconv.Itoa(len(fds)))
+		}
+		for index, fd := range fds {
+			syscall.CloseOnExec(fd)
+			result[message.Keys[index]] = os.NewFile(uintptr(fd), message.Keys[index])
+		}
+	}
+}
+
+// 构造启动新进程的命令
+func newGracefulCmd(exe string) *exec.Cmd {
+	var cmd = exec.Command(exe)
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Setsid: true,
+	}
+	return cmd
+}
+
+// 通知systemd主进程已经改变，需要在服务配置中设置 NotifyAccess=all
+func notifySystemdMainPid() {
+	var sockPath = os.Getenv("NOTIFY_SOCKET")
+	if len(sockPath) == 0 {
+		return
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: sockPath, Net: "unixgram"})
+	if err != nil {
+		return
+	}
+	_, _ = conn.Write([]byte("MAINPID=" + strconv.Itoa(os.Getpid()) + "\n"))
+	_ = conn.Close()
+}
+
+// 检查进程是否存在
+func isProcessAlive(pid int) bool {
+	var err = syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
@@ -0,0 +1,207 @@
+// Copyright 2024 GoEdge CDN goedge.cdn@gmail.com. All rights reserved. Official site: https://goedge.cloud .
+//go:build !windows
+
+package nodes
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/iwind/TeaGo/assert"
+)
+
+func newTestGracefulConns(t *testing.T) (server *net.UnixConn, client *net.UnixConn) {
+	listener, err := listenGracefulSock(filepath.Join(t.TempDir(), "graceful.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = listener.Close()
+	}()
+
+	client, err = dialGracefulSock(listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err = listener.AcceptUnix()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = server.Close()
+		_ = client.Close()
+	})
+	return
+}
+
+func TestGracefulRestartConfig_Init(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	a.IsNil(DefaultGracefulRestartConfig().Init())
+	a.IsNotNil((&GracefulRestartConfig{IsOn: true, DrainTimeout: 10}).Init())
+	a.IsNotNil((&GracefulRestartConfig{IsOn: true, ReadyTimeout: 10}).Init())
+}
+
+func TestGracefulRestart_SendReceiveFiles(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	server, client := newTestGracefulConns(t)
+
+	// 超过单个消息的文件数，以测试分批发送
+	var count = gracefulRestartMaxFilesPerMessage + 5
+	var keys []string
+	var files []*os.File
+	for i := 0; i < count; i++ {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		file, err := listener.(*net.TCPListener).File()
+		_ = listener.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var key = gracefulListenerKey("tcp", ":"+strconv.Itoa(i))
+		keys = append(keys, key)
+		files = append(files, file)
+	}
+	defer func() {
+		for _, file := range files {
+			_ = file.Close()
+		}
+	}()
+
+	var errChan = make(chan error, 1)
+	go func() {
+		errChan <- sendGracefulFiles(server, keys, files)
+	}()
+
+	received, err := receiveGracefulFiles(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.IsNil(<-errChan)
+	a.IsTrue(len(received) == count)
+	for _, file := range received {
+		_ = file.Close()
+	}
+}
+
+func TestGracefulRestartManager_Inherit(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	server, client := newTestGracefulConns(t)
+
+	tcpListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = tcpListener.Close()
+	}()
+	udpConn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = udpConn.Close()
+	}()
+
+	tcpFile, err := tcpListener.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	udpFile, err := udpConn.File()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var manager = NewGracefulRestartManager()
+	manager.inheritedFiles = map[string]*os.File{
+		gracefulListenerKey("tcp", ":80"):  tcpFile,
+		gracefulListenerKey("udp4", ":53"): udpFile,
+	}
+	manager.parentConn = client
+
+	// TCP
+	inheritedListener, err := manager.InheritListener("tcp", ":80")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.IsNotNil(inheritedListener)
+	a.IsTrue(inheritedListener.Addr().String() == tcpListener.Addr().String())
+
+	_ = tcpListener.Close()
+	var tcpAddr = inheritedListener.Addr().String()
+	go func() {
+		conn, dialErr := net.Dial("tcp", tcpAddr)
+		if dialErr == nil {
+			_ = conn.Close()
+		}
+	}()
+	conn, err := inheritedListener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.Close()
+	_ = inheritedListener.Close()
+
+	// 只能继承一次
+	inheritedListener, err = manager.InheritListener("tcp", ":80")
+	a.IsNil(err)
+	a.IsNil(inheritedListener)
+
+	// UDP
+	inheritedUDPConn, err := manager.InheritUDPConn("udp4", ":53")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.IsNotNil(inheritedUDPConn)
+	a.IsTrue(inheritedUDPConn.LocalAddr().String() == udpConn.LocalAddr().String())
+	_ = inheritedUDPConn.Close()
+
+	// 不存在的
+	inheritedUDPConn, err = manager.InheritUDPConn("udp6", ":53")
+	a.IsNil(err)
+	a.IsNil(inheritedUDPConn)
+
+	// 通知旧进程
+	manager.NotifyReady()
+	message, fds, err := readGracefulMessage(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.IsTrue(len(fds) == 0)
+	a.IsTrue(message.Ready)
+	a.IsTrue(message.Pid == os.Getpid())
+	a.IsNil(manager.parentConn)
+}
+
+func TestIsGracefulRestarting(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	// 已经退出的进程留下的文件
+	var stalePath = gracefulSockPath(1 << 30)
+	err := os.WriteFile(stalePath, nil, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.Remove(stalePath)
+	}()
+	a.IsFalse(IsGracefulRestarting())
+
+	var sockPath = gracefulSockPath(os.Getpid())
+	listener, err := listenGracefulSock(sockPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.IsTrue(IsGracefulRestarting())
+
+	_ = listener.Close()
+	_ = os.Remove(sockPath)
+	a.IsFalse(IsGracefulRestarting())
+}
@@ -0,0 +1,48 @@
+// Copyright 2024 GoEdge CDN goedge.cdn@gmail.com. All rights reserved. Official site: https://goedge.cloud .
+//go:build windows
+
+package nodes
+
+import (
+	"net"
+	"os"
+	"os/exec"
+)
+
+func listenGracefulSock(path string) (*net.UnixListener, error) {
+	return nil, ErrGracefulRestartNotSupported
+}
+
+func dialGracefulSock(path string) (*net.UnixConn, error) {
+	return nil, ErrGracefulRestartNotSupported
+}
+
+func writeGracefulMessage(conn *net.UnixConn, message *gracefulRestartMessage, files []*os.File) error {
+	return ErrGracefulRestartNotSupported
+}
+
+func readGracefulMessage(conn *net.UnixConn) (message *gracefulRestartMessage, fds []int, err error) {
+	return nil, nil, ErrGracefulRestartNotSupported
+}
+
+func closeGracefulFds(fds []int) {
+}
+
+func sendGracefulFiles(conn *net.UnixConn, keys []string, files []*os.File) error {
+	return ErrGracefulRestartNotSupported
+}
+
+func receiveGracefulFiles(conn *net.UnixConn) (map[string]*os.File, error) {
+	return nil, ErrGracefulRestartNotSupported
+}
+
+func newGracefulCmd(exe string) *exec.Cmd {
+	return exec.Command(exe)
+}
+
+func notifySystemdMainPid() {
+}
+
+func isProcessAlive(pid int) bool {
+	return false
+}
@@ -0,0 +1,206 @@
+// Copyright 2024 GoEdge CDN goedge.cdn@gmail.com. All rights reserved. Official site: https://goedge.cloud .
+
+package nodes
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+const (
+	grpcContentType        = "application/grpc"
+	grpcWebContentType     = "application/grpc-web"
+	grpcWebTextContentType = "application/grpc-web-text"
+
+	grpcWebTrailerFlag byte = 0x80
+)
+
+// 判断是否为gRPC相关的Content-Type，包括gRPC-Web
+func isGRPCContentType(contentType string) bool {
+	if !strings.HasPrefix(contentType, grpcContentType) {
+		return false
+	}
+	var rest = contentType[len(grpcContentType):]
+	return len(rest) == 0 || rest[0] == '+' || rest[0] == ';' || strings.HasPrefix(rest, "-web")
+}
+
+// 判断是否为gRPC-Web请求，同时返回是否为文本格式
+func isGRPCWebContentType(contentType string) (isWeb bool, isText bool) {
+	if strings.HasPrefix(contentType, grpcWebTextContentType) {
+		return true, true
+	}
+	return strings.HasPrefix(contentType, grpcWebContentType), false
+}
+
+// 将gRPC-Web的Content-Type转换为gRPC的Content-Type
+// 比如 application/grpc-web-text+proto => application/grpc+proto
+func grpcContentTypeFromWeb(contentType string) string {
+	if strings.HasPrefix(contentType, grpcWebTextContentType) {
+		return grpcContentType + contentType[len(grpcWebTextContentType):]
+	}
+	if strings.HasPrefix(contentType, grpcWebContentType) {
+		return grpcContentType + contentType[len(grpcWebContentType):]
+	}
+	return contentType
+}
+
+// 将gRPC的Content-Type转换为gRPC-Web的Content-Type
+func grpcWebContentTypeFromGRPC(contentType string, isText bool) string {
+	var suffix = ""
+	if strings.HasPrefix(contentType, grpcContentType) {
+		suffix = contentType[len(grpcContentType):]
+	}
+	if isText {
+		return grpcWebTextContentType + suffix
+	}
+	return grpcWebContentType + suffix
+}
+
+// 对grpc-message进行百分号编码
+func encodeGRPCMessage(message string) string {
+	var needEncode = false
+	for i := 0; i < len(message); i++ {
+		var c = message[i]
+		if c < ' ' || c > '~' || c == '%' {
+			needEncode = true
+			break
+		}
+	}
+	if !needEncode {
+		return message
+	}
+
+	const hexChars = "0123456789ABCDEF"
+	var builder strings.Builder
+	for i := 0; i < len(message); i++ {
+		var c = message[i]
+		if c < ' ' || c > '~' || c == '%' {
+			builder.WriteByte('%')
+			builder.WriteByte(hexChars[c>>4])
+			builder.WriteByte(hexChars[c&15])
+		} else {
+			builder.WriteByte(c)
+		}
+	}
+	return builder.String()
+}
+
+// 将Trailer编码为gRPC-Web的Trailer帧
+func encodeGRPCWebTrailers(trailer http.Header) []byte {
+	var keys = []string{}
+	for key := range trailer {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var buf = &bytes.Buffer{}
+	for _, key := range keys {
+		for _, value := range trailer[key] {
+			buf.WriteString(strings.ToLower(key))
+			buf.WriteString(":")
+			buf.WriteString(value)
+			buf.WriteString("\r\n")
+		}
+	}
+
+	var frame = make([]byte, 5+buf.Len())
+	frame[0] = grpcWebTrailerFlag
+	binary.BigEndian.PutUint32(frame[1:5], uint32(buf.Len()))
+	copy(frame[5:], buf.Bytes())
+	return frame
+}
+
+// gRPC-Web文本格式请求体解码
+// 客户端可能会分段编码，每段都可能有填充字符，所以需要按照4个字符为一组分别解码
+type grpcWebTextReader struct {
+	reader  io.Reader
+	buf     []byte
+	encoded []byte // 尚未解码的字符
+	decoded []byte // 已解码尚未读取的数据
+	err     error
+}
+
+func newGRPCWebTextReader(reader io.Reader) *grpcWebTextReader {
+	return &grpcWebTextReader{
+		reader: reader,
+	}
+}
+
+func (this *grpcWebTextReader) Read(p []byte) (n int, err error) {
+	for len(this.decoded) == 0 {
+		if this.err != nil {
+			if this.err == io.EOF && len(this.encoded) > 0 {
+				return 0, io.ErrUnexpectedEOF
+			}
+			return 0, this.err
+		}
+
+		if this.buf == nil {
+			this.buf = make([]byte, 4096)
+		}
+		readN, readErr := this.reader.Read(this.buf)
+		if readErr != nil {
+			this.err = readErr
+		}
+		for _, c := range this.buf[:readN] {
+			// 忽略换行等空白字符
+			if c == '\r' || c == '\n' || c == ' ' || c == '\t' {
+				continue
+			}
+			this.encoded = append(this.encoded, c)
+		}
+
+		var size = len(this.encoded) / 4 * 4
+		if size == 0 {
+			continue
+		}
+		var decoded = make([]byte, size/4*3)
+		var offset = 0
+		for i := 0; i < size; i += 4 {
+			decodedN, decodeErr := base64.StdEncoding.Decode(decoded[offset:], this.encoded[i:i+4])
+			if decodeErr != nil {
+				this.err = decodeErr
+				return 0, decodeErr
+			}
+			offset += decodedN
+		}
+		this.encoded = this.encoded[size:]
+		this.decoded = decoded[:offset]
+	}
+
+	n = copy(p, this.decoded)
+	this.decoded = this.decoded[n:]
+	return
+}
+
+func (this *grpcWebTextReader) Close() error {
+	closer, ok := this.reader.(io.Closer)
+	if ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// gRPC-Web文本格式响应编码
+// 每次写入的数据单独编码，以便于客户端可以及时解码
+type grpcWebTextWriter struct {
+	writer io.Writer
+}
+
+func (this *grpcWebTextWriter) Write(p []byte) (n int, err error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	var encoded = make([]byte, base64.StdEncoding.EncodedLen(len(p)))
+	base64.StdEncoding.Encode(encoded, p)
+	_, err = this.writer.Write(encoded)
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
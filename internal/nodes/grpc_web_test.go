@@ -0,0 +1,83 @@
+// Copyright 2024 GoEdge CDN goedge.cdn@gmail.com. All rights reserved. Official site: https://goedge.cloud .
+
+package nodes
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"testing"
+	"testing/iotest"
+
+	"github.com/iwind/TeaGo/assert"
+)
+
+func TestGRPCContentType(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	a.IsTrue(isGRPCContentType("application/grpc"))
+	a.IsTrue(isGRPCContentType("application/grpc+proto"))
+	a.IsTrue(isGRPCContentType("application/grpc-web-text+proto"))
+	a.IsFalse(isGRPCContentType("application/grpcx"))
+	a.IsFalse(isGRPCContentType("application/json"))
+
+	isWeb, isText := isGRPCWebContentType("application/grpc-web-text+proto")
+	a.IsTrue(isWeb && isText)
+	isWeb, isText = isGRPCWebContentType("application/grpc-web")
+	a.IsTrue(isWeb && !isText)
+
+	a.IsTrue(grpcContentTypeFromWeb("application/grpc-web-text+proto") == "application/grpc+proto")
+	a.IsTrue(grpcContentTypeFromWeb("application/grpc-web") == "application/grpc")
+	a.IsTrue(grpcWebContentTypeFromGRPC("application/grpc+proto", true) == "application/grpc-web-text+proto")
+	a.IsTrue(grpcWebContentTypeFromGRPC("application/grpc", false) == "application/grpc-web")
+}
+
+func TestGRPCWebTextReader(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	// 分段编码，每段都有填充字符
+	var encoded = base64.StdEncoding.EncodeToString([]byte("hello")) + "\r\n" + base64.StdEncoding.EncodeToString([]byte(", world"))
+	data, err := io.ReadAll(newGRPCWebTextReader(iotest.OneByteReader(bytes.NewReader([]byte(encoded)))))
+	a.IsNil(err)
+	a.IsTrue(string(data) == "hello, world")
+
+	_, err = io.ReadAll(newGRPCWebTextReader(bytes.NewReader([]byte("aGVsbG8"))))
+	a.IsTrue(err == io.ErrUnexpectedEOF)
+
+	_, err = io.ReadAll(newGRPCWebTextReader(bytes.NewReader([]byte("a$$$"))))
+	a.IsTrue(err != nil)
+}
+
+func TestGRPCWebTextWriter(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	var buf = &bytes.Buffer{}
+	var writer = &grpcWebTextWriter{writer: buf}
+	_, _ = writer.Write([]byte("hello"))
+	_, _ = writer.Write([]byte(", world"))
+
+	data, err := io.ReadAll(newGRPCWebTextReader(buf))
+	a.IsNil(err)
+	a.IsTrue(string(data) == "hello, world")
+}
+
+func TestEncodeGRPCWebTrailers(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	var frame = encodeGRPCWebTrailers(http.Header{
+		"Grpc-Status":  []string{"0"},
+		"Grpc-Message": []string{"OK"},
+	})
+	a.IsTrue(frame[0] == grpcWebTrailerFlag)
+	a.IsTrue(string(frame[5:]) == "grpc-message:OK\r\ngrpc-status:0\r\n")
+	a.IsTrue(int(frame[4]) == len(frame)-5)
+}
+
+func TestEncodeGRPCMessage(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	a.IsTrue(encodeGRPCMessage("origin unavailable") == "origin unavailable")
+	a.IsTrue(encodeGRPCMessage("100%\n") == "100%25%0A")
+	a.IsTrue(encodeGRPCMessage("源站") == "%E6%BA%90%E7%AB%99")
+}
@@ -89,6 +89,15 @@ func (this *HTTPClientPool) Client(req *HTTPRequest,
 		rawKey += "@follow"
 	}
 
+	// TLS校验配置变化时需要使用新的客户端
+	rawKey += "@tls:" + sharedOriginTLSManager.FindPolicy(origin.Id).Key()
+
+	// gRPC源站使用h2c
+	var isH2C = sharedOriginGRPCManager.IsH2COrigin(req.ReqServer.Id, req.reverseProxy, origin)
+	if isH2C {
+		rawKey += "@h2c"
+	}
+
 	var key = xxhash.Sum64String(rawKey)
 
 	var isLnRequest = origin.Id == 0
@@ -152,19 +161,7 @@ func (this *HTTPClientPool) Client(req *HTTPRequest,
 	}
 
 	// TLS通讯
-	var tlsConfig = &tls.Config{
-		InsecureSkipVerify: true,
-	}
-	if origin.Cert != nil {
-		var obj = origin.Cert.CertObject()
-		if obj != nil {
-			tlsConfig.InsecureSkipVerify = false
-			tlsConfig.Certificates = []tls.Certificate{*obj}
-			if len(origin.Cert.ServerName) > 0 {
-				tlsConfig.ServerName = origin.Cert.ServerName
-			}
-		}
-	}
+	var tlsConfig = sharedOriginTLSManager.BuildTLSConfig(origin, "")
 
 	var transport = &HTTPClientTransport{
 		Transport: &http.Transport{
@@ -210,6 +207,21 @@ func (this *HTTPClientPool) Client(req *HTTPRequest,
 		_ = http2.ConfigureTransport(transport.Transport)
 	}
 
+	// support h2c with prior knowledge
+	if isH2C {
+		transport.h2cTransport = &http2.Transport{
+			AllowHTTP: true,
+			DialTLSContext: func(ctx context.Context, network, addr string, cfg *tls.Config) (net.Conn, error) {
+				return transport.Transport.DialContext(ctx, network, addr)
+			},
+			ReadIdleTimeout: 30 * time.Second,
+			PingTimeout:     15 * time.Second,
+		}
+
+		// gRPC流可能会持续很长时间，由客户端通过grpc-timeout控制超时
+		readTimeout = 0
+	}
+
 	rawClient = &http.Client{
 		Timeout:   readTimeout,
 		Transport: transport,
@@ -4,16 +4,26 @@ package nodes
 
 import (
 	"net/http"
+
+	"golang.org/x/net/http2"
 )
 
 const emptyHTTPLocation = "/$EmptyHTTPLocation$"
 
 type HTTPClientTransport struct {
 	*http.Transport
+
+	h2cTransport *http2.Transport // 使用h2c连接源站时不为空
 }
 
 func (this *HTTPClientTransport) RoundTrip(req *http.Request) (*http.Response, error) {
-	resp, err := this.Transport.RoundTrip(req)
+	var resp *http.Response
+	var err error
+	if this.h2cTransport != nil {
+		resp, err = this.h2cTransport.RoundTrip(req)
+	} else {
+		resp, err = this.Transport.RoundTrip(req)
+	}
 	if err != nil {
 		return resp, err
 	}
@@ -24,3 +34,10 @@ func (this *HTTPClientTransport) RoundTrip(req *http.Request) (*http.Response, e
 	}
 	return resp, nil
 }
+
+func (this *HTTPClientTransport) CloseIdleConnections() {
+	this.Transport.CloseIdleConnections()
+	if this.h2cTransport != nil {
+		this.h2cTransport.CloseIdleConnections()
+	}
+}
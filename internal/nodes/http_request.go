@@ -24,6 +24,7 @@ import (
 	"github.com/TeaOSLab/EdgeNode/internal/stats"
 	"github.com/TeaOSLab/EdgeNode/internal/utils"
 	"github.com/TeaOSLab/EdgeNode/internal/utils/bytepool"
+	"github.com/TeaOSLab/EdgeNode/internal/waf"
 	"github.com/iwind/TeaGo/lists"
 	"github.com/iwind/TeaGo/maps"
 	"github.com/iwind/TeaGo/types"
@@ -90,6 +91,9 @@ type HTTPRequest struct {
 
 	isWebsocketResponse bool // 是否为Websocket响应（非请求）
 
+	grpc   *httpGRPCRequest   // gRPC请求相关数据，非gRPC请求时为nil
+	replay *httpRequestReplay // 为了重试回源而缓存的请求，没有适用的重试策略时为nil
+
 	// WAF相关
 	firewallPolicyId    int64
 	firewallRuleGroupId int64
@@ -97,6 +101,9 @@ type HTTPRequest struct {
 	firewallRuleId      int64
 	firewallActions     []string
 	wafHasRequestBody   bool
+	wafMaskActions      []*waf.MaskAction
+	wafHasMaskPolicy    bool           // WAF策略中是否有脱敏动作
+	wafCacheValues      map[string]any // WAF在当前请求中缓存的数据，比如参数转换结果
 
 	tags []string
 
@@ -237,6 +244,8 @@ func (this *HTTPRequest) Do() {
 				this.doEnd()
 				return
 			}
+
+			this.doWAFMaskPolicy()
 		}
 
 		// UAM
@@ -986,6 +995,11 @@ func (this *HTTPRequest) Format(source string) string {
 			}
 		}
 
+		// proxyProtocol.
+		if prefix == "proxyProtocol" {
+			return this.requestProxyProtocolVar(suffix)
+		}
+
 		// origin.
 		if prefix == "origin" {
 			if this.origin != nil {
@@ -22,6 +22,12 @@ import (
 
 // 读取缓存
 func (this *HTTPRequest) doCacheRead(useStale bool) (shouldStop bool) {
+	// 需要对响应内容脱敏的不读取缓存，因为从缓存中读取的内容不会再经过WAF出站规则
+	if this.wafHasMaskPolicy || len(this.wafMaskActions) > 0 {
+		this.varMapping["cache.status"] = "BYPASS"
+		return
+	}
+
 	// 需要动态Upgrade的不缓存
 	if len(this.RawReq.Header.Get("Upgrade")) > 0 {
 		return
@@ -71,6 +71,12 @@ func (this *HTTPRequest) write50x(err error, statusCode int, enMessage string, z
 		this.addError(err)
 	}
 
+	// gRPC客户端无法处理HTML页面
+	if this.grpc != nil {
+		this.writeGRPCError(GRPCStatusFromHTTPStatus(statusCode), enMessage)
+		return
+	}
+
 	// 尝试从缓存中恢复
 	if canTryStale &&
 		this.cacheCanTryStale &&
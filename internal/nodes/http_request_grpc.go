@@ -0,0 +1,208 @@
+// Copyright 2024 GoEdge CDN goedge.cdn@gmail.com. All rights reserved. Official site: https://goedge.cloud .
+
+package nodes
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/TeaOSLab/EdgeNode/internal/remotelogs"
+	"github.com/TeaOSLab/EdgeNode/internal/utils/bytepool"
+	"github.com/iwind/TeaGo/types"
+)
+
+// gRPC请求相关数据
+type httpGRPCRequest struct {
+	policy      *OriginGRPCPolicy // 适用的策略，可能为nil
+	isWeb       bool              // 是否为gRPC-Web请求
+	isWebText   bool              // 是否为gRPC-Web文本格式
+	bodyData    []byte            // 为了重试而缓存的请求体
+	hasBodyData bool
+}
+
+// CanRetry 检查在源站返回某个状态码时是否可以更换源站重试
+func (this *httpGRPCRequest) CanRetry(status GRPCStatus) bool {
+	return this.hasBodyData && this.policy != nil && this.policy.CanRetry(status)
+}
+
+// 准备gRPC请求，非gRPC请求返回nil
+func (this *HTTPRequest) prepareGRPCRequest() *httpGRPCRequest {
+	var contentType = this.RawReq.Header.Get("Content-Type")
+	if !isGRPCContentType(contentType) || !sharedOriginGRPCManager.IsOn() {
+		return nil
+	}
+
+	var grpcReq = &httpGRPCRequest{
+		policy: sharedOriginGRPCManager.FindPolicy(this.ReqServer.Id, this.reverseProxy),
+	}
+	var rawContentLength = this.RawReq.ContentLength
+
+	// gRPC-Web
+	isWeb, isWebText := isGRPCWebContentType(contentType)
+	if isWeb {
+		// 未启用转换时作为普通请求转发
+		if grpcReq.policy == nil || !grpcReq.policy.GRPCWeb {
+			return nil
+		}
+
+		grpcReq.isWeb = true
+		grpcReq.isWebText = isWebText
+		this.RawReq.Header.Set("Content-Type", grpcContentTypeFromWeb(contentType))
+		this.RawReq.Header.Del("X-Grpc-Web")
+		if isWebText {
+			this.RawReq.Body = newGRPCWebTextReader(this.RawReq.Body)
+			this.RawReq.ContentLength = -1
+		}
+	}
+	this.RawReq.Header.Set("Te", "trailers")
+
+	// 客户端使用HTTP/1.1时也需要能够同时读写
+	if this.RawReq.ProtoMajor == 1 && this.RawWriter != nil {
+		_ = http.NewResponseController(this.RawWriter).EnableFullDuplex()
+	}
+
+	// 缓存长度确定的较小请求体，以便于更换源站重试
+	// 流式请求的长度不确定，不能提前读取
+	if grpcReq.policy != nil &&
+		len(grpcReq.policy.retryStatuses) > 0 &&
+		rawContentLength >= 0 &&
+		rawContentLength <= grpcReq.policy.MaxRetryBodySize {
+		data, err := io.ReadAll(io.LimitReader(this.RawReq.Body, grpcReq.policy.MaxRetryBodySize+1))
+		if err == nil && int64(len(data)) <= grpcReq.policy.MaxRetryBodySize {
+			grpcReq.bodyData = data
+			grpcReq.hasBodyData = true
+			this.resetGRPCRequestBody(grpcReq)
+		} else {
+			this.RawReq.Body = io.NopCloser(io.MultiReader(bytes.NewReader(data), this.RawReq.Body))
+		}
+	}
+
+	return grpcReq
+}
+
+// 重置缓存的请求体
+func (this *HTTPRequest) resetGRPCRequestBody(grpcReq *httpGRPCRequest) {
+	if grpcReq == nil || !grpcReq.hasBodyData {
+		return
+	}
+	this.RawReq.Body = io.NopCloser(bytes.NewReader(grpcReq.bodyData))
+	this.RawReq.ContentLength = int64(len(grpcReq.bodyData))
+}
+
+// 源站返回的gRPC状态码，仅在没有响应内容（Trailers-Only）时有效
+func (this *HTTPRequest) grpcHeaderStatus(resp *http.Response) (status GRPCStatus, ok bool) {
+	if resp.StatusCode != http.StatusOK {
+		return GRPCStatusFromHTTPStatus(resp.StatusCode), true
+	}
+	var statusString = resp.Header.Get("Grpc-Status")
+	if len(statusString) == 0 {
+		return GRPCStatusOK, false
+	}
+	status, err := strconv.Atoi(statusString)
+	if err != nil {
+		return GRPCStatusUnknown, true
+	}
+	return status, true
+}
+
+// 输出gRPC错误
+func (this *HTTPRequest) writeGRPCError(status GRPCStatus, message string) {
+	var header = this.writer.Header()
+	if this.grpc.isWeb {
+		header.Set("Content-Type", grpcWebContentTypeFromGRPC(grpcContentType, this.grpc.isWebText))
+	} else {
+		header.Set("Content-Type", grpcContentType)
+	}
+	header.Del("Content-Length")
+	header.Set("Grpc-Status", types.String(status))
+	if len(message) > 0 {
+		header.Set("Grpc-Message", encodeGRPCMessage(message))
+	}
+
+	this.ProcessResponseHeaders(header, http.StatusOK)
+	this.writer.WriteHeader(http.StatusOK)
+}
+
+// 输出gRPC响应
+// 响应内容需要及时发送给客户端，并在最后转发Trailer
+func (this *HTTPRequest) writeGRPCResponse(resp *http.Response) (err error) {
+	// 源站返回的不是gRPC响应
+	if resp.StatusCode != http.StatusOK || !isGRPCContentType(resp.Header.Get("Content-Type")) {
+		_ = resp.Body.Close()
+		this.writeGRPCError(GRPCStatusFromHTTPStatus(resp.StatusCode), "origin site responded with HTTP status "+types.String(resp.StatusCode))
+		return nil
+	}
+
+	var header = this.writer.Header()
+	this.writer.AddHeaders(resp.Header)
+	this.ProcessResponseHeaders(header, resp.StatusCode)
+	header.Del("Content-Length")
+	header.Del("Trailer")
+	if this.grpc.isWeb {
+		header.Set("Content-Type", grpcWebContentTypeFromGRPC(resp.Header.Get("Content-Type"), this.grpc.isWebText))
+	}
+	this.writer.WriteHeader(resp.StatusCode)
+	this.writer.Flush()
+
+	var writer io.Writer = this.writer
+	if this.grpc.isWebText {
+		writer = &grpcWebTextWriter{writer: this.writer}
+	}
+
+	var buf = bytepool.Pool16k.Get()
+	for {
+		n, readErr := resp.Body.Read(buf.Bytes)
+		if n > 0 {
+			_, err = writer.Write(buf.Bytes[:n])
+			if err != nil {
+				break
+			}
+			this.writer.Flush()
+		}
+		if readErr != nil {
+			if readErr != io.EOF {
+				err = readErr
+			}
+			break
+		}
+	}
+	bytepool.Pool16k.Put(buf)
+	_ = resp.Body.Close()
+
+	// Trailer
+	var trailer = http.Header{}
+	for key, values := range resp.Trailer {
+		trailer[key] = values
+	}
+	if err != nil {
+		if !this.canIgnore(err) {
+			remotelogs.WarnServer("HTTP_REQUEST_GRPC", this.URL()+": proxy stream failed: "+err.Error())
+			this.addError(err)
+		}
+
+		// 源站没有正常结束时需要告知客户端
+		if len(trailer.Get("Grpc-Status")) == 0 {
+			trailer.Set("Grpc-Status", types.String(GRPCStatusUnavailable))
+			trailer.Set("Grpc-Message", encodeGRPCMessage("origin stream error: "+err.Error()))
+		}
+	}
+	if len(trailer) == 0 {
+		return
+	}
+
+	if this.grpc.isWeb {
+		_, err = writer.Write(encodeGRPCWebTrailers(trailer))
+		this.writer.Flush()
+		return
+	}
+	for key, values := range trailer {
+		if !strings.HasPrefix(key, http.TrailerPrefix) {
+			key = http.TrailerPrefix + key
+		}
+		header[key] = values
+	}
+	return
+}
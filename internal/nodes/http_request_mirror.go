@@ -0,0 +1,164 @@
+// Copyright 2024 GoEdge CDN goedge.cdn@gmail.com. All rights reserved. Official site: https://goedge.cloud .
+
+package nodes
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/TeaOSLab/EdgeCommon/pkg/serverconfigs"
+	"github.com/TeaOSLab/EdgeNode/internal/utils/goman"
+)
+
+// 镜像请求最多读取的响应内容尺寸，超出后直接关闭连接
+const httpMirrorMaxDiscardSize = 1 << 20
+
+// 镜像请求
+// 主请求和镜像请求都结束后比较两者的结果
+type httpMirrorRequest struct {
+	policy *OriginMirrorPolicy
+	method string
+	url    string
+
+	locker        sync.Mutex
+	hasPrimary    bool
+	primaryStatus int
+	primaryCost   time.Duration
+	hasMirror     bool
+	mirrorStatus  int
+	mirrorCost    time.Duration
+	mirrorErr     error
+}
+
+// SetPrimary 设置主请求结果
+func (this *httpMirrorRequest) SetPrimary(resp *http.Response, err error, cost time.Duration) {
+	if this == nil {
+		return
+	}
+
+	var status int
+	if err == nil && resp != nil {
+		status = resp.StatusCode
+	}
+
+	this.locker.Lock()
+	this.hasPrimary = true
+	this.primaryStatus = status
+	this.primaryCost = cost
+	var isDone = this.hasMirror
+	this.locker.Unlock()
+
+	if isDone {
+		this.compare()
+	}
+}
+
+func (this *httpMirrorRequest) setMirror(status int, cost time.Duration, err error) {
+	this.locker.Lock()
+	this.hasMirror = true
+	this.mirrorStatus = status
+	this.mirrorCost = cost
+	this.mirrorErr = err
+	var isDone = this.hasPrimary
+	this.locker.Unlock()
+
+	if isDone {
+		this.compare()
+	}
+}
+
+func (this *httpMirrorRequest) compare() {
+	this.policy.AddResult(this.method, this.url, this.primaryStatus, this.primaryCost, this.mirrorStatus, this.mirrorCost, this.mirrorErr)
+}
+
+// 异步发送镜像请求
+// 镜像请求不会阻塞主请求，也不会影响主请求的结果；不需要镜像时返回nil
+func (this *HTTPRequest) startMirrorRequest(origin *serverconfigs.OriginConfig) *httpMirrorRequest {
+	var policy = SharedOriginMirrorManager.FindPolicy(this.ReqServer.Id, this.reverseProxy)
+	if policy == nil || policy.MirrorOriginId == origin.Id || this.nodeConfig == nil {
+		return nil
+	}
+	if !policy.MatchRequest(this.RawReq) {
+		return nil
+	}
+
+	var mirrorOrigin = this.nodeConfig.FindOrigin(policy.MirrorOriginId)
+	if mirrorOrigin == nil || !mirrorOrigin.IsOn || mirrorOrigin.OSS != nil || mirrorOrigin.Addr == nil {
+		return nil
+	}
+
+	// 缓存请求体，主请求和镜像请求各自读取
+	var bodyData []byte
+	if this.RawReq.Body != nil && this.RawReq.Body != http.NoBody && (this.RawReq.ContentLength != 0 || len(this.RawReq.TransferEncoding) > 0) {
+		data, err := io.ReadAll(io.LimitReader(this.RawReq.Body, policy.MaxBodySize+1))
+		if err != nil || int64(len(data)) > policy.MaxBodySize {
+			this.RawReq.Body = io.NopCloser(io.MultiReader(bytes.NewReader(data), this.RawReq.Body))
+			policy.Skip()
+			return nil
+		}
+		bodyData = data
+		this.RawReq.Body = io.NopCloser(bytes.NewReader(data))
+		this.RawReq.ContentLength = int64(len(data))
+		this.RawReq.TransferEncoding = nil
+	}
+
+	var mirrorOriginAddr = mirrorOrigin.Addr.PickAddress()
+	if mirrorOrigin.Addr.HostHasVariables() {
+		mirrorOriginAddr = this.Format(mirrorOriginAddr)
+	}
+	client, err := SharedHTTPClientPool.Client(this, mirrorOrigin, mirrorOriginAddr, nil, false)
+	if err != nil {
+		return nil
+	}
+
+	if !policy.Acquire() {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(policy.Timeout)*time.Second)
+	var req = this.RawReq.Clone(ctx)
+	req.URL.Scheme = mirrorOrigin.Addr.Protocol.Primary().Scheme()
+	if len(mirrorOrigin.RequestHost) > 0 {
+		req.Host = mirrorOrigin.RequestHost
+		if mirrorOrigin.RequestHostHasVariables() {
+			req.Host = this.Format(req.Host)
+		}
+		req.URL.Host = req.Host
+	}
+	if bodyData != nil {
+		req.Body = io.NopCloser(bytes.NewReader(bodyData))
+		req.ContentLength = int64(len(bodyData))
+	} else {
+		req.Body = http.NoBody
+		req.ContentLength = 0
+	}
+	req.GetBody = nil
+
+	var mirror = &httpMirrorRequest{
+		policy: policy,
+		method: this.RawReq.Method,
+		url:    this.URL(),
+	}
+
+	goman.New(func() {
+		defer policy.Release()
+		defer cancel()
+
+		var startedAt = time.Now()
+		resp, err := client.Do(req)
+		var cost = time.Since(startedAt)
+		var status int
+		if err == nil {
+			status = resp.StatusCode
+			_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, httpMirrorMaxDiscardSize))
+			_ = resp.Body.Close()
+		}
+		mirror.setMirror(status, cost, err)
+	})
+
+	return mirror
+}
@@ -0,0 +1,58 @@
+// Copyright 2024 GoEdge CDN goedge.cdn@gmail.com. All rights reserved. Official site: https://goedge.cloud .
+
+package nodes
+
+import (
+	"github.com/iwind/TeaGo/types"
+)
+
+// 读取通过PROXY协议传递的客户端信息
+func (this *HTTPRequest) requestProxyProtocol() *ProxyProtocolInfo {
+	var requestConn = this.RawReq.Context().Value(HTTPConnContextKey)
+	if requestConn == nil {
+		return nil
+	}
+	clientConn, ok := requestConn.(ClientConnInterface)
+	if !ok {
+		return nil
+	}
+	return clientConn.ProxyProtocol()
+}
+
+// 读取 ${proxyProtocol.xxx} 变量值
+func (this *HTTPRequest) requestProxyProtocolVar(name string) string {
+	var info = this.requestProxyProtocol()
+	if info == nil {
+		return ""
+	}
+
+	switch name {
+	case "version":
+		return types.String(info.Version)
+	case "serverAddr":
+		if info.DestinationAddr != nil {
+			return info.DestinationAddr.String()
+		}
+	case "authority":
+		return info.Authority
+	case "alpn":
+		return info.ALPN
+	case "uniqueId":
+		return info.UniqueId
+	case "ssl":
+		if info.SSL {
+			return "1"
+		}
+		return "0"
+	case "sslVerified":
+		if info.SSLVerified {
+			return "1"
+		}
+		return "0"
+	case "sslVersion":
+		return info.SSLVersion
+	case "sslCN":
+		return info.SSLCN
+	}
+	return ""
+}
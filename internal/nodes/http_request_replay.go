@@ -0,0 +1,182 @@
+// Copyright 2024 GoEdge CDN goedge.cdn@gmail.com. All rights reserved. Official site: https://goedge.cloud .
+
+package nodes
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"os"
+	"sync/atomic"
+)
+
+// 为了重试回源而缓存的请求
+type httpRequestReplay struct {
+	policy            *OriginRetryPolicy
+	method            string
+	hasIdempotencyKey bool
+
+	req        *http.Request
+	isBuffered bool     // 请求体是否已经完整缓存，没有请求体时也为true
+	bodyData   []byte   // 内存中的请求体
+	bodyFile   *os.File // 超出内存限制时写入的临时文件
+	bodySize   int64
+
+	trace  *httptrace.ClientTrace
+	isSent int32 // 当前请求是否已经开始发送到源站
+}
+
+// 缓存请求体以便于重试
+func newHTTPRequestReplay(policy *OriginRetryPolicy, req *http.Request) *httpRequestReplay {
+	var replay = &httpRequestReplay{
+		policy:            policy,
+		method:            req.Method,
+		hasIdempotencyKey: len(req.Header.Get("Idempotency-Key")) > 0,
+		req:               req,
+		isBuffered:        true,
+	}
+	replay.trace = &httptrace.ClientTrace{
+		// 请求头写入后即认为已经发送，即使此时数据可能仍在缓冲区中
+		WroteHeaders: func() {
+			atomic.StoreInt32(&replay.isSent, 1)
+		},
+	}
+
+	if req.Body == nil || req.Body == http.NoBody || (req.ContentLength == 0 && len(req.TransferEncoding) == 0) {
+		return replay
+	}
+
+	// 请求体过大时不缓存
+	if req.ContentLength > policy.MaxBodySize {
+		replay.isBuffered = false
+		return replay
+	}
+
+	replay.bufferBody()
+	return replay
+}
+
+// 读取请求体，超出内存限制后写入临时文件
+func (this *httpRequestReplay) bufferBody() {
+	var req = this.req
+	var rawBody = req.Body
+
+	data, err := io.ReadAll(io.LimitReader(rawBody, this.policy.MaxMemoryBodySize+1))
+	if err != nil {
+		this.isBuffered = false
+		req.Body = io.NopCloser(io.MultiReader(bytes.NewReader(data), rawBody))
+		return
+	}
+	if int64(len(data)) <= this.policy.MaxMemoryBodySize {
+		this.bodyData = data
+		this.bodySize = int64(len(data))
+		this.setBody()
+		return
+	}
+
+	// 写入临时文件
+	fp, err := os.CreateTemp(this.policy.TempDir, "edge-request-body-*")
+	if err != nil {
+		this.isBuffered = false
+		req.Body = io.NopCloser(io.MultiReader(bytes.NewReader(data), rawBody))
+		return
+	}
+	this.bodyFile = fp
+
+	_, err = fp.Write(data)
+	var written int64
+	if err == nil {
+		written, err = io.Copy(fp, io.LimitReader(rawBody, this.policy.MaxBodySize-int64(len(data))+1))
+	}
+	this.bodySize = int64(len(data)) + written
+	if err != nil || this.bodySize > this.policy.MaxBodySize {
+		// 已经读取的内容仍然需要发送给源站
+		this.isBuffered = false
+		req.Body = io.NopCloser(io.MultiReader(io.NewSectionReader(fp, 0, this.bodySize), rawBody))
+		return
+	}
+	this.setBody()
+}
+
+// 使用缓存的请求体
+func (this *httpRequestReplay) setBody() {
+	var req = this.req
+	req.Body = this.newBodyReader()
+	req.ContentLength = this.bodySize
+	req.TransferEncoding = nil
+	req.GetBody = func() (io.ReadCloser, error) {
+		return this.newBodyReader(), nil
+	}
+}
+
+func (this *httpRequestReplay) newBodyReader() io.ReadCloser {
+	if this.bodyFile != nil {
+		return io.NopCloser(io.NewSectionReader(this.bodyFile, 0, this.bodySize))
+	}
+	return io.NopCloser(bytes.NewReader(this.bodyData))
+}
+
+// WithTrace 在请求上下文中加入发送状态跟踪
+func (this *httpRequestReplay) WithTrace(ctx context.Context) context.Context {
+	if this == nil {
+		return ctx
+	}
+	return httptrace.WithClientTrace(ctx, this.trace)
+}
+
+// Reset 重试之前重置请求体和发送状态
+func (this *httpRequestReplay) Reset() {
+	if this == nil {
+		return
+	}
+	atomic.StoreInt32(&this.isSent, 0)
+	if this.isBuffered && this.bodySize > 0 {
+		this.req.Body = this.newBodyReader()
+	}
+}
+
+// CanRetry 检查失败的请求是否可以重试
+// hasResponse 表示源站已经返回了响应
+func (this *httpRequestReplay) CanRetry(hasResponse bool) bool {
+	if this == nil {
+		return true
+	}
+
+	var mode = this.policy.RetryMode(this.method, this.hasIdempotencyKey)
+	if mode == OriginRetryModeNever {
+		return false
+	}
+
+	// 请求尚未发送，请求体也没有被读取
+	if !hasResponse && atomic.LoadInt32(&this.isSent) == 0 {
+		return true
+	}
+
+	return mode == OriginRetryModeAny && this.isBuffered
+}
+
+// Close 释放临时文件
+func (this *httpRequestReplay) Close() {
+	if this == nil {
+		return
+	}
+	if this.bodyFile != nil {
+		_ = this.bodyFile.Close()
+		_ = os.Remove(this.bodyFile.Name())
+		this.bodyFile = nil
+	}
+}
+
+// 准备回源重试需要的数据，没有适用的策略时返回nil
+func (this *HTTPRequest) prepareRequestReplay() *httpRequestReplay {
+	if this.grpc != nil {
+		return nil
+	}
+	var policy = SharedOriginRetryManager.FindPolicy(this.ReqServer.Id, this.reverseProxy)
+	if policy == nil {
+		return nil
+	}
+	return newHTTPRequestReplay(policy, this.RawReq)
+}
@@ -0,0 +1,137 @@
+// Copyright 2024 GoEdge CDN goedge.cdn@gmail.com. All rights reserved. Official site: https://goedge.cloud .
+
+package nodes
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/iwind/TeaGo/assert"
+)
+
+func newTestOriginRetryPolicy(t *testing.T, policy *OriginRetryPolicy) *OriginRetryPolicy {
+	err := policy.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return policy
+}
+
+func TestOriginRetryPolicy_RetryMode(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	a.IsNotNil((&OriginRetryPolicy{Methods: []*OriginRetryMethodRule{{Methods: []string{"POST"}, Retry: "always"}}}).Init())
+	a.IsNotNil((&OriginRetryPolicy{Methods: []*OriginRetryMethodRule{{Retry: OriginRetryModeAny}}}).Init())
+
+	var policy = newTestOriginRetryPolicy(t, &OriginRetryPolicy{
+		Methods: []*OriginRetryMethodRule{
+			{Methods: []string{"delete"}, Retry: OriginRetryModeNever},
+		},
+		IdempotencyKey: true,
+	})
+	a.IsTrue(policy.RetryMode(http.MethodGet, false) == OriginRetryModeAny)
+	a.IsTrue(policy.RetryMode(http.MethodPost, false) == OriginRetryModeConnect)
+	a.IsTrue(policy.RetryMode(http.MethodPost, true) == OriginRetryModeAny)
+	a.IsTrue(policy.RetryMode(http.MethodDelete, true) == OriginRetryModeNever)
+}
+
+func TestHTTPRequestReplay_Buffer(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	var policy = newTestOriginRetryPolicy(t, &OriginRetryPolicy{
+		MaxMemoryBodySize: 16,
+		MaxBodySize:       1024,
+		TempDir:           t.TempDir(),
+	})
+
+	// 内存
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("hello"))
+	var replay = newHTTPRequestReplay(policy, req)
+	a.IsTrue(replay.isBuffered)
+	a.IsNil(replay.bodyFile)
+	for i := 0; i < 2; i++ {
+		replay.Reset()
+		data, _ := io.ReadAll(req.Body)
+		a.IsTrue(string(data) == "hello")
+	}
+	replay.Close()
+
+	// 临时文件
+	var body = strings.Repeat("a", 512)
+	req = httptest.NewRequest(http.MethodPost, "/", io.NopCloser(strings.NewReader(body)))
+	req.ContentLength = -1
+	replay = newHTTPRequestReplay(policy, req)
+	a.IsTrue(replay.isBuffered)
+	a.IsNotNil(replay.bodyFile)
+	a.IsTrue(req.ContentLength == 512)
+	for i := 0; i < 2; i++ {
+		replay.Reset()
+		data, _ := io.ReadAll(req.Body)
+		a.IsTrue(string(data) == body)
+	}
+	replay.Close()
+	a.IsNil(replay.bodyFile)
+
+	// 超出尺寸，内容保持不变
+	body = strings.Repeat("b", 2048)
+	req = httptest.NewRequest(http.MethodPost, "/", io.NopCloser(strings.NewReader(body)))
+	req.ContentLength = -1
+	replay = newHTTPRequestReplay(policy, req)
+	a.IsFalse(replay.isBuffered)
+	data, _ := io.ReadAll(req.Body)
+	a.IsTrue(string(data) == body)
+	replay.Close()
+
+	req = httptest.NewRequest(http.MethodPost, "/", bytes.NewReader([]byte(body)))
+	replay = newHTTPRequestReplay(policy, req)
+	a.IsFalse(replay.isBuffered)
+	a.IsNil(replay.bodyFile)
+}
+
+func TestHTTPRequestReplay_CanRetry(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	// 读取请求头后关闭连接的源站
+	var server = httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, req *http.Request) {
+		conn, _, err := http.NewResponseController(writer).Hijack()
+		if err == nil {
+			_ = conn.Close()
+		}
+	}))
+	defer server.Close()
+
+	// 无法连接的源站
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var closedAddr = listener.Addr().String()
+	_ = listener.Close()
+
+	var policy = newTestOriginRetryPolicy(t, &OriginRetryPolicy{IdempotencyKey: true})
+	var doRequest = func(url string, idempotencyKey string) *httpRequestReplay {
+		req, _ := http.NewRequest(http.MethodPost, url, strings.NewReader("hello"))
+		if len(idempotencyKey) > 0 {
+			req.Header.Set("Idempotency-Key", idempotencyKey)
+		}
+		var replay = newHTTPRequestReplay(policy, req)
+		resp, err := http.DefaultClient.Do(req.WithContext(replay.WithTrace(req.Context())))
+		if err == nil {
+			_ = resp.Body.Close()
+		}
+		a.IsTrue(err != nil)
+		return replay
+	}
+
+	a.IsTrue(doRequest("http://"+closedAddr+"/", "").CanRetry(false))
+	a.IsFalse(doRequest(server.URL, "").CanRetry(false))
+	a.IsTrue(doRequest(server.URL, "abc").CanRetry(false))
+
+	var replay *httpRequestReplay
+	a.IsTrue(replay.CanRetry(true))
+}
@@ -8,6 +8,7 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/TeaOSLab/EdgeCommon/pkg/serverconfigs"
 	"github.com/TeaOSLab/EdgeCommon/pkg/serverconfigs/shared"
@@ -34,12 +35,42 @@ func (this *HTTPRequest) doReverseProxy(writeToClient bool) (resultResp *http.Re
 	var failedLnNodeIds []int64
 	var failStatusCode int
 
+	// 重试预算
+	var budget = SharedOriginHedgeManager.FindBudget(this.ReqServer.Id, this.reverseProxy)
+	if budget != nil {
+		budget.AddRequest()
+	}
+
+	// gRPC
+	this.grpc = this.prepareGRPCRequest()
+
+	// 缓存请求体以便于重试
+	this.replay = this.prepareRequestReplay()
+	defer this.replay.Close()
+
 	for i := 0; i < retries; i++ {
-		originId, lnNodeId, shouldRetry, resp := this.doOriginRequest(failedOriginIds, failedLnNodeIds, i == 0, i == retries-1, &failStatusCode, writeToClient)
+		var isLastRetry = i == retries-1 || (budget != nil && !budget.AllowRetry())
+		if i > 0 {
+			this.replay.Reset()
+		}
+
+		// 流式gRPC请求的请求体无法重复读取，所以不能重试
+		if this.grpc != nil {
+			if !this.grpc.hasBodyData {
+				isLastRetry = true
+			} else if i > 0 {
+				this.resetGRPCRequestBody(this.grpc)
+			}
+		}
+
+		originId, lnNodeId, shouldRetry, resp := this.doOriginRequest(failedOriginIds, failedLnNodeIds, i == 0, isLastRetry, &failStatusCode, writeToClient)
 		if !shouldRetry {
 			resultResp = resp
 			break
 		}
+		if budget != nil {
+			budget.AddRetry()
+		}
 		if originId > 0 {
 			failedOriginIds = append(failedOriginIds, originId)
 		}
@@ -94,8 +125,11 @@ func (this *HTTPRequest) doOriginRequest(failedOriginIds []int64, failedLnNodeId
 	}
 
 	// 自定义源站
+	var breakerTicket *OriginBreakerTicket
 	if origin == nil {
-		if !isFirstTry {
+		// 根据源站实际负载选择
+		origin = SharedOriginBalancer.Pick(this.ReqServer.Id, this.reverseProxy, failedOriginIds)
+		if origin == nil && !isFirstTry {
 			origin = this.reverseProxy.AnyOrigin(requestCall, failedOriginIds)
 		}
 		if origin == nil {
@@ -112,6 +146,16 @@ func (this *HTTPRequest) doOriginRequest(failedOriginIds []int64, failedLnNodeId
 			this.write50x(err, http.StatusBadGateway, "No origin site yet", "尚未配置源站", true)
 			return
 		}
+
+		// 熔断
+		origin, breakerTicket = this.checkOriginBreaker(origin, requestCall, failedOriginIds)
+		if origin == nil {
+			return
+		}
+		if breakerTicket != nil {
+			defer breakerTicket.Cancel() // 没有请求源站时释放
+		}
+
 		originId = origin.Id
 
 		if len(origin.StripPrefix) > 0 {
@@ -181,11 +225,20 @@ func (this *HTTPRequest) doOriginRequest(failedOriginIds []int64, failedLnNodeId
 	}
 
 	var originAddr = ""
+	var originEndpoint *OriginEndpoint
 	if isHTTPOrigin {
 		// 获取源站地址
-		originAddr = origin.Addr.PickAddress()
-		if origin.Addr.HostHasVariables() {
-			originAddr = this.Format(originAddr)
+		if !origin.FollowPort {
+			originEndpoint = SharedOriginDiscoveryManager.Pick(origin.Id)
+		}
+		if originEndpoint != nil {
+			defer originEndpoint.Release()
+			originAddr = originEndpoint.Addr
+		} else {
+			originAddr = origin.Addr.PickAddress()
+			if origin.Addr.HostHasVariables() {
+				originAddr = this.Format(originAddr)
+			}
 		}
 
 		// 端口跟随
@@ -314,8 +367,29 @@ func (this *HTTPRequest) doOriginRequest(failedOriginIds []int64, failedLnNodeId
 			}
 		}
 
+		// 流量镜像
+		var mirror *httpMirrorRequest
+		if isFirstTry && lnNodeId == 0 && this.grpc == nil {
+			mirror = this.startMirrorRequest(origin)
+		}
+
 		// 开始请求
-		resp, requestErr = client.Do(this.RawReq)
+		var respOrigin *serverconfigs.OriginConfig
+		var requestStartedAt = time.Now()
+		resp, respOrigin, originAddr, requestErr = this.doHTTPOriginRequest(client, origin, originAddr, requestHost, failedOriginIds)
+		mirror.SetPrimary(resp, requestErr, time.Since(requestStartedAt))
+		if respOrigin != origin {
+			// 使用对冲请求的响应
+			if breakerTicket != nil {
+				breakerTicket.Cancel()
+				breakerTicket = nil
+			}
+			originEndpoint = nil
+			origin = respOrigin
+			originId = origin.Id
+			this.origin = origin
+			this.originAddr = originAddr
+		}
 
 		// recover Accept-Encoding
 		if acceptEncodingChanged {
@@ -346,6 +420,8 @@ func (this *HTTPRequest) doOriginRequest(failedOriginIds []int64, failedLnNodeId
 		this.writeCode(http.StatusBadGateway, "The type of origin site has not been supported", "设置的源站类型尚未支持")
 		return
 	}
+	this.reportOriginBreaker(breakerTicket, resp, requestErr)
+	this.reportOriginEndpoint(originEndpoint, resp, requestErr)
 
 	if resp != nil && resp.Body != nil {
 		defer func() {
@@ -358,12 +434,17 @@ func (this *HTTPRequest) doOriginRequest(failedOriginIds []int64, failedLnNodeId
 	}
 
 	if requestErr != nil {
+		// 源站证书校验失败
+		if isHTTPOrigin && IsOriginTLSVerifyError(requestErr) {
+			remotelogs.ErrorServer("ORIGIN_TLS", this.URL()+": unable to verify origin server: "+requestErr.Error())
+		}
+
 		// 客户端取消请求，则不提示
 		var httpErr *url.Error
 		var ok = errors.As(requestErr, &httpErr)
 		if !ok {
 			if isHTTPOrigin {
-				SharedOriginStateManager.Fail(origin, requestHost, this.reverseProxy, func() {
+				SharedOriginStateManager.Fail(origin, requestHost, this.reverseProxy, requestErr, func() {
 					this.reverseProxy.ResetScheduling()
 				})
 			}
@@ -376,13 +457,13 @@ func (this *HTTPRequest) doOriginRequest(failedOriginIds []int64, failedLnNodeId
 			remotelogs.WarnServer("HTTP_REQUEST_REVERSE_PROXY", this.RawReq.URL.String()+": Request origin server failed: "+requestErr.Error())
 		} else if !errors.Is(httpErr, context.Canceled) {
 			if isHTTPOrigin {
-				SharedOriginStateManager.Fail(origin, requestHost, this.reverseProxy, func() {
+				SharedOriginStateManager.Fail(origin, requestHost, this.reverseProxy, requestErr, func() {
 					this.reverseProxy.ResetScheduling()
 				})
 			}
 
 			// 是否需要重试
-			if (originId > 0 || (lnNodeId > 0 && hasMultipleLnNodes)) && !isLastRetry {
+			if (originId > 0 || (lnNodeId > 0 && hasMultipleLnNodes)) && !isLastRetry && this.replay.CanRetry(false) {
 				shouldRetry = true
 				this.uri = oldURI // 恢复备份
 
@@ -442,10 +523,38 @@ func (this *HTTPRequest) doOriginRequest(failedOriginIds []int64, failedLnNodeId
 
 	// 40x && 50x
 	*failStatusCode = resp.StatusCode
+	if isHTTPOrigin {
+		SharedOriginHealthManager.ReportStatus(origin, resp.StatusCode)
+	}
+
+	// gRPC
+	if this.grpc != nil {
+		grpcStatus, hasGRPCStatus := this.grpcHeaderStatus(resp)
+		if hasGRPCStatus && this.grpc.CanRetry(grpcStatus) && originId > 0 && !isLastRetry {
+			_ = resp.Body.Close()
+			shouldRetry = true
+			return
+		}
+
+		this.originStatus = int32(resp.StatusCode)
+		if !origin.IsOk && isHTTPOrigin {
+			SharedOriginStateManager.Success(origin, func() {
+				this.reverseProxy.ResetScheduling()
+			})
+		}
+
+		var err = this.writeGRPCResponse(resp)
+		respBodyIsClosed = true
+		if err == nil {
+			this.writer.SetOk()
+		}
+		return
+	}
 	if ((resp.StatusCode >= 500 && resp.StatusCode < 510 && this.reverseProxy.Retry50X) ||
 		(resp.StatusCode >= 403 && resp.StatusCode <= 404 && this.reverseProxy.Retry40X)) &&
 		(originId > 0 || (lnNodeId > 0 && hasMultipleLnNodes)) &&
-		!isLastRetry {
+		!isLastRetry &&
+		this.replay.CanRetry(true) {
 		if resp.Body != nil {
 			_ = resp.Body.Close()
 		}
@@ -0,0 +1,82 @@
+// Copyright 2024 GoEdge CDN goedge.cdn@gmail.com. All rights reserved. Official site: https://goedge.cloud .
+
+package nodes
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+
+	"github.com/TeaOSLab/EdgeCommon/pkg/serverconfigs"
+	"github.com/TeaOSLab/EdgeCommon/pkg/serverconfigs/shared"
+	"github.com/iwind/TeaGo/lists"
+)
+
+// 检查源站熔断状态，如果源站正在熔断中，则尝试更换源站
+// 返回的源站为nil时表示已经输出响应
+func (this *HTTPRequest) checkOriginBreaker(origin *serverconfigs.OriginConfig, requestCall *shared.RequestCall, failedOriginIds []int64) (*serverconfigs.OriginConfig, *OriginBreakerTicket) {
+	allowed, ticket := SharedOriginBreakerManager.Acquire(this.ReqServer.Id, this.reverseProxy, origin)
+	if allowed {
+		return origin, ticket
+	}
+
+	var policy = SharedOriginBreakerManager.FindPolicy(this.ReqServer.Id, this.reverseProxy, origin.Id)
+
+	// 尝试其他源站
+	var excludingOriginIds = append([]int64{origin.Id}, failedOriginIds...)
+	for {
+		var otherOrigin = this.reverseProxy.AnyOrigin(requestCall, excludingOriginIds)
+		if otherOrigin == nil || lists.ContainsInt64(excludingOriginIds, otherOrigin.Id) {
+			break
+		}
+		allowed, ticket = SharedOriginBreakerManager.Acquire(this.ReqServer.Id, this.reverseProxy, otherOrigin)
+		if allowed {
+			return otherOrigin, ticket
+		}
+		excludingOriginIds = append(excludingOriginIds, otherOrigin.Id)
+	}
+
+	if policy != nil {
+		// 过期缓存
+		if policy.ServeStale && this.cacheCanTryStale && this.doCacheRead(true) {
+			return nil, nil
+		}
+
+		// 备用源站
+		if policy.FallbackOriginId > 0 && this.nodeConfig != nil {
+			var fallbackOrigin = this.nodeConfig.FindOrigin(policy.FallbackOriginId)
+			if fallbackOrigin != nil && fallbackOrigin.IsOn {
+				return fallbackOrigin, nil
+			}
+		}
+	}
+
+	var err = errors.New(this.URL() + ": no available origin sites: circuit breaker is open")
+	this.write50x(err, http.StatusServiceUnavailable, "Origin site is temporarily unavailable", "源站暂时不可用", true)
+	return nil, nil
+}
+
+// 记录源站请求结果到熔断器
+func (this *HTTPRequest) reportOriginBreaker(ticket *OriginBreakerTicket, resp *http.Response, requestErr error) {
+	if ticket == nil {
+		return
+	}
+
+	if requestErr != nil {
+		// 客户端取消请求
+		var httpErr *url.Error
+		if errors.As(requestErr, &httpErr) && errors.Is(httpErr, context.Canceled) {
+			ticket.Cancel()
+			return
+		}
+		ticket.Failure()
+		return
+	}
+
+	if resp == nil || resp.StatusCode >= http.StatusInternalServerError {
+		ticket.Failure()
+		return
+	}
+	ticket.Success()
+}
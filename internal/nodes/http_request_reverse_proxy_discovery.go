@@ -0,0 +1,29 @@
+// Copyright 2024 GoEdge CDN goedge.cdn@gmail.com. All rights reserved. Official site: https://goedge.cloud .
+
+package nodes
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+)
+
+// 记录源站请求结果到服务发现的端点
+func (this *HTTPRequest) reportOriginEndpoint(endpoint *OriginEndpoint, resp *http.Response, requestErr error) {
+	if endpoint == nil {
+		return
+	}
+
+	if requestErr != nil {
+		// 客户端取消请求
+		var httpErr *url.Error
+		if errors.As(requestErr, &httpErr) && errors.Is(httpErr, context.Canceled) {
+			return
+		}
+		endpoint.Report(false)
+		return
+	}
+
+	endpoint.Report(resp != nil && resp.StatusCode < http.StatusInternalServerError)
+}
@@ -0,0 +1,228 @@
+// Copyright 2024 GoEdge CDN goedge.cdn@gmail.com. All rights reserved. Official site: https://goedge.cloud .
+
+package nodes
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/TeaOSLab/EdgeCommon/pkg/serverconfigs"
+	"github.com/TeaOSLab/EdgeCommon/pkg/serverconfigs/shared"
+	"github.com/iwind/TeaGo/lists"
+)
+
+// 对冲请求结果
+type hedgeOriginResult struct {
+	resp       *http.Response
+	err        error
+	origin     *serverconfigs.OriginConfig
+	originAddr string
+	cancel     context.CancelFunc
+	index      int                  // 请求在 cancelFuncs 中的位置
+	ticket     *OriginBreakerTicket // 对冲请求的熔断器凭证，原请求的凭证由调用者处理
+	isHedge    bool
+}
+
+// 响应Body关闭时取消请求
+type hedgeResponseBody struct {
+	io.ReadCloser
+
+	cancel context.CancelFunc
+}
+
+func (this *hedgeResponseBody) Close() error {
+	var err = this.ReadCloser.Close()
+	this.cancel()
+	return err
+}
+
+// 请求HTTP源站
+// 如果启用了对冲请求，源站在一定时间内没有返回响应Header时，会同时请求另外一个源站，使用先返回的响应
+func (this *HTTPRequest) doHTTPOriginRequest(client *http.Client, origin *serverconfigs.OriginConfig, originAddr string, requestHost string, failedOriginIds []int64) (resp *http.Response, respOrigin *serverconfigs.OriginConfig, respOriginAddr string, err error) {
+	var budget = SharedOriginHedgeManager.FindBudget(this.ReqServer.Id, this.reverseProxy)
+	if budget == nil || !budget.Policy().Hedge || origin.Id <= 0 || !this.canHedgeRequest() {
+		var startedAt = time.Now()
+		var balanceReq = SharedOriginBalancer.Begin(this.ReqServer.Id, this.reverseProxy, origin)
+		var req = this.RawReq
+		if this.replay != nil {
+			req = req.WithContext(this.replay.WithTrace(req.Context()))
+		}
+		resp, err = client.Do(req)
+		balanceReq.End(resp, err)
+		if err == nil && budget != nil {
+			budget.AddLatency(time.Since(startedAt))
+		}
+		return resp, origin, originAddr, err
+	}
+
+	var resultChan = make(chan *hedgeOriginResult, 2)
+	var cancelFuncs = []context.CancelFunc{}
+	var doRequest = func(client *http.Client, origin *serverconfigs.OriginConfig, originAddr string, ticket *OriginBreakerTicket, isHedge bool) {
+		ctx, cancel := context.WithCancel(this.replay.WithTrace(this.RawReq.Context()))
+		var index = len(cancelFuncs)
+		cancelFuncs = append(cancelFuncs, cancel)
+		var req = this.RawReq.Clone(ctx)
+		go func() {
+			var startedAt = time.Now()
+			var balanceReq = SharedOriginBalancer.Begin(this.ReqServer.Id, this.reverseProxy, origin)
+			resp, err := client.Do(req)
+			balanceReq.End(resp, err)
+			if err == nil {
+				budget.AddLatency(time.Since(startedAt))
+			}
+			resultChan <- &hedgeOriginResult{
+				resp:       resp,
+				err:        err,
+				origin:     origin,
+				originAddr: originAddr,
+				cancel:     cancel,
+				index:      index,
+				ticket:     ticket,
+				isHedge:    isHedge,
+			}
+		}()
+	}
+
+	doRequest(client, origin, originAddr, nil, false)
+	var pending = 1
+	var hedgeSent = false
+
+	var timer = time.NewTimer(budget.HedgeDelay())
+	defer timer.Stop()
+
+	for {
+		var result *hedgeOriginResult
+		select {
+		case result = <-resultChan:
+		case <-timer.C:
+			if !hedgeSent {
+				hedgeSent = true
+				hedgeClient, hedgeOrigin, hedgeOriginAddr, hedgeTicket := this.findHedgeOrigin(origin, failedOriginIds)
+				if hedgeClient != nil {
+					if budget.AcquireRetry() {
+						budget.AddHedge()
+						doRequest(hedgeClient, hedgeOrigin, hedgeOriginAddr, hedgeTicket, true)
+						pending++
+					} else {
+						hedgeTicket.Cancel()
+					}
+				}
+			}
+			continue
+		}
+		pending--
+
+		if result.err != nil && pending > 0 {
+			// 另外一个请求仍有可能成功
+			result.cancel()
+			this.reportOriginBreaker(result.ticket, nil, result.err)
+			if !errors.Is(result.err, context.Canceled) {
+				SharedOriginStateManager.Fail(result.origin, requestHost, this.reverseProxy, result.err, func() {
+					this.reverseProxy.ResetScheduling()
+				})
+			}
+			continue
+		}
+
+		if pending > 0 {
+			// 立即取消较慢的请求，然后关闭其可能已经返回的响应
+			for index, cancel := range cancelFuncs {
+				if index != result.index {
+					cancel()
+				}
+			}
+			go func() {
+				var other = <-resultChan
+				other.ticket.Cancel()
+				if other.resp != nil && other.resp.Body != nil {
+					_ = other.resp.Body.Close()
+				}
+			}()
+		}
+		if result.isHedge && result.err == nil {
+			budget.AddHedgeWin()
+		}
+		this.reportOriginBreaker(result.ticket, result.resp, result.err)
+
+		if result.err != nil {
+			result.cancel()
+		} else if result.resp.Body != nil {
+			result.resp.Body = &hedgeResponseBody{
+				ReadCloser: result.resp.Body,
+				cancel:     result.cancel,
+			}
+		} else {
+			result.cancel()
+		}
+		return result.resp, result.origin, result.originAddr, result.err
+	}
+}
+
+// 检查当前请求是否可以发送对冲请求
+// 只有幂等的无请求体的GET和HEAD请求才可以
+func (this *HTTPRequest) canHedgeRequest() bool {
+	var req = this.RawReq
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return false
+	}
+	if req.ContentLength != 0 || len(req.TransferEncoding) > 0 {
+		return false
+	}
+	return req.Body == nil || req.Body == http.NoBody
+}
+
+// 查找用来发送对冲请求的源站
+// 对冲请求和原请求使用同样的URL和Host，所以只能选择改写规则相同的源站；正在熔断中的源站会被跳过
+func (this *HTTPRequest) findHedgeOrigin(origin *serverconfigs.OriginConfig, failedOriginIds []int64) (client *http.Client, hedgeOrigin *serverconfigs.OriginConfig, hedgeOriginAddr string, ticket *OriginBreakerTicket) {
+	if this.reverseProxy.RequestHostType == serverconfigs.RequestHostTypeOrigin {
+		return
+	}
+
+	var excludingOriginIds = append([]int64{origin.Id}, failedOriginIds...)
+	for {
+		hedgeOrigin = SharedOriginBalancer.Pick(this.ReqServer.Id, this.reverseProxy, excludingOriginIds)
+		if hedgeOrigin == nil {
+			var requestCall = shared.NewRequestCall()
+			requestCall.Request = this.RawReq
+			requestCall.Formatter = this.Format
+			requestCall.Domain = this.ReqHost
+			hedgeOrigin = this.reverseProxy.AnyOrigin(requestCall, excludingOriginIds)
+		}
+		if hedgeOrigin == nil || lists.ContainsInt64(excludingOriginIds, hedgeOrigin.Id) {
+			return nil, nil, "", nil
+		}
+		excludingOriginIds = append(excludingOriginIds, hedgeOrigin.Id)
+
+		if hedgeOrigin.OSS != nil ||
+			hedgeOrigin.Addr == nil ||
+			hedgeOrigin.FollowPort ||
+			SharedOriginDiscoveryManager.IsManaged(hedgeOrigin.Id) ||
+			hedgeOrigin.StripPrefix != origin.StripPrefix ||
+			hedgeOrigin.RequestURI != origin.RequestURI ||
+			hedgeOrigin.RequestHost != origin.RequestHost ||
+			hedgeOrigin.Addr.Protocol.Primary().Scheme() != origin.Addr.Protocol.Primary().Scheme() {
+			continue
+		}
+
+		// 熔断检查，和原请求一样需要获取凭证，以便半开状态下的探测请求计数
+		allowed, breakerTicket := SharedOriginBreakerManager.Acquire(this.ReqServer.Id, this.reverseProxy, hedgeOrigin)
+		if !allowed {
+			continue
+		}
+
+		hedgeOriginAddr = hedgeOrigin.Addr.PickAddress()
+		if hedgeOrigin.Addr.HostHasVariables() {
+			hedgeOriginAddr = this.Format(hedgeOriginAddr)
+		}
+
+		client, err := SharedHTTPClientPool.Client(this, hedgeOrigin, hedgeOriginAddr, this.reverseProxy.ProxyProtocol, this.reverseProxy.FollowRedirects)
+		if err != nil {
+			breakerTicket.Cancel()
+			return nil, nil, "", nil
+		}
+		return client, hedgeOrigin, hedgeOriginAddr, breakerTicket
+	}
+}
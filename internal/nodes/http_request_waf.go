@@ -484,6 +484,33 @@ func (this *HTTPRequest) checkWAFResponse(firewallPolicy *firewallconfigs.HTTPFi
 	return !result.GoNext, breakChecking
 }
 
+// 检查WAF策略中是否有脱敏动作
+// 出站规则在源站响应之后才会匹配，所以只要策略中有脱敏动作，就需要提前去除区间请求，并且不使用缓存
+func (this *HTTPRequest) doWAFMaskPolicy() {
+	if this.web.FirewallRef == nil || !this.web.FirewallRef.IsOn {
+		return
+	}
+
+	if this.wafPolicyHasMaskActions(this.web.FirewallPolicy) ||
+		(!this.web.FirewallRef.IgnoreGlobalRules && this.wafPolicyHasMaskActions(this.ReqServer.HTTPFirewallPolicy)) {
+		this.wafHasMaskPolicy = true
+
+		// 区间内容无法完整脱敏，所以总是请求完整的内容
+		this.RawReq.Header.Del("Range")
+		this.RawReq.Header.Del("If-Range")
+	}
+}
+
+// 检查某个WAF策略中是否有脱敏动作
+func (this *HTTPRequest) wafPolicyHasMaskActions(firewallPolicy *firewallconfigs.HTTPFirewallPolicy) bool {
+	if firewallPolicy == nil || !firewallPolicy.IsOn || firewallPolicy.Mode == firewallconfigs.FirewallModeBypass {
+		return false
+	}
+
+	var w = waf.SharedWAFManager.FindWAF(firewallPolicy.Id)
+	return w != nil && w.HasMaskActions()
+}
+
 // WAFRaw 原始请求
 func (this *HTTPRequest) WAFRaw() *http.Request {
 	return this.RawReq
@@ -545,6 +572,8 @@ func (this *HTTPRequest) WAFOnAction(action interface{}) (goNext bool) {
 	switch instance.Code() {
 	case waf.ActionTag:
 		this.tags = append(this.tags, action.(*waf.TagAction).Tags...)
+	case waf.ActionMask:
+		this.wafMaskActions = append(this.wafMaskActions, action.(*waf.MaskAction))
 	}
 	return true
 }
@@ -589,3 +618,25 @@ func (this *HTTPRequest) DisableStat() {
 
 	this.disableMetrics = true
 }
+
+// WAFSetAttr 设置日志属性
+func (this *HTTPRequest) WAFSetAttr(name string, value string) {
+	this.SetAttr(name, value)
+}
+
+// WAFGetCacheValue 读取当前请求中缓存的数据
+func (this *HTTPRequest) WAFGetCacheValue(key string) (value any, ok bool) {
+	if this.wafCacheValues == nil {
+		return nil, false
+	}
+	value, ok = this.wafCacheValues[key]
+	return
+}
+
+// WAFSetCacheValue 在当前请求中缓存数据
+func (this *HTTPRequest) WAFSetCacheValue(key string, value any) {
+	if this.wafCacheValues == nil {
+		this.wafCacheValues = map[string]any{}
+	}
+	this.wafCacheValues[key] = value
+}
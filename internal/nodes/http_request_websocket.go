@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"net/url"
 
+	"github.com/TeaOSLab/EdgeNode/internal/remotelogs"
 	"github.com/TeaOSLab/EdgeNode/internal/utils/bytepool"
 )
 
@@ -87,8 +88,12 @@ func (this *HTTPRequest) doWebsocket(requestHost string, isLastRetry bool) (shou
 			this.write50x(err, http.StatusBadGateway, "Failed to connect origin site", "源站连接失败", false)
 		}
 
+		if IsOriginTLSVerifyError(err) {
+			remotelogs.ErrorServer("ORIGIN_TLS", this.URL()+": unable to verify origin server: "+err.Error())
+		}
+
 		// 增加失败次数
-		SharedOriginStateManager.Fail(this.origin, requestHost, this.reverseProxy, func() {
+		SharedOriginStateManager.Fail(this.origin, requestHost, this.reverseProxy, err, func() {
 			this.reverseProxy.ResetScheduling()
 		})
 
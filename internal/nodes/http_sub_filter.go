@@ -0,0 +1,291 @@
+// Copyright 2024 GoEdge CDN goedge.cdn@gmail.com. All rights reserved. Official site: https://goedge.cloud .
+
+package nodes
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/TeaOSLab/EdgeNode/internal/configs"
+	teaconst "github.com/TeaOSLab/EdgeNode/internal/const"
+	"github.com/TeaOSLab/EdgeNode/internal/events"
+	"github.com/TeaOSLab/EdgeNode/internal/remotelogs"
+	"github.com/TeaOSLab/EdgeNode/internal/utils/readers"
+	"github.com/iwind/TeaGo/lists"
+	"github.com/iwind/TeaGo/types"
+)
+
+// HTTPSubFilterConfigFileName 响应内容替换配置文件
+const HTTPSubFilterConfigFileName = "sub_filter.yaml"
+
+var defaultSubFilterContentTypes = []string{
+	"text/html",
+	"text/css",
+	"text/xml",
+	"text/javascript",
+	"application/javascript",
+	"application/json",
+	"application/xml",
+}
+
+var SharedHTTPSubFilterManager = NewHTTPSubFilterManager()
+
+func init() {
+	if !teaconst.IsMain {
+		return
+	}
+
+	events.On(events.EventLoaded, func() {
+		config, err := LoadHTTPSubFilterConfig()
+		if err != nil {
+			remotelogs.Error("HTTP_SUB_FILTER", "load '"+HTTPSubFilterConfigFileName+"' failed: "+err.Error())
+			return
+		}
+		SharedHTTPSubFilterManager.UpdateConfig(config)
+	})
+}
+
+// HTTPSubFilterConfig 响应内容替换配置
+type HTTPSubFilterConfig struct {
+	IsOn     bool                   `yaml:"isOn" json:"isOn"`         // 是否启用
+	Policies []*HTTPSubFilterPolicy `yaml:"policies" json:"policies"` // 策略列表
+}
+
+// DefaultHTTPSubFilterConfig 默认配置
+func DefaultHTTPSubFilterConfig() *HTTPSubFilterConfig {
+	return &HTTPSubFilterConfig{
+		IsOn: true,
+	}
+}
+
+// Init 初始化
+func (this *HTTPSubFilterConfig) Init() error {
+	for index, policy := range this.Policies {
+		if policy == nil {
+			return errors.New("policy #" + types.String(index+1) + " should not be empty")
+		}
+		err := policy.Init()
+		if err != nil {
+			return errors.New("policy #" + types.String(index+1) + ": " + err.Error())
+		}
+	}
+	return nil
+}
+
+// LoadHTTPSubFilterConfig 从配置文件中加载响应内容替换配置
+// 如果配置文件不存在，则返回默认配置
+func LoadHTTPSubFilterConfig() (*HTTPSubFilterConfig, error) {
+	return configs.LoadLocalConfig(HTTPSubFilterConfigFileName, DefaultHTTPSubFilterConfig())
+}
+
+// HTTPSubFilterRule 单个替换规则
+type HTTPSubFilterRule struct {
+	Find       string `yaml:"find" json:"find"`             // 查找的内容
+	Replace    string `yaml:"replace" json:"replace"`       // 替换为的内容，使用正则表达式时支持 ${1}、${name} 等分组引用
+	IsRegexp   bool   `yaml:"isRegexp" json:"isRegexp"`     // 查找的内容是否为正则表达式
+	IgnoreCase bool   `yaml:"ignoreCase" json:"ignoreCase"` // 是否忽略大小写
+
+	reg *regexp.Regexp
+}
+
+// Init 初始化
+func (this *HTTPSubFilterRule) Init() error {
+	if len(this.Find) == 0 {
+		return errors.New("'find' should not be empty")
+	}
+
+	var pattern = this.Find
+	if !this.IsRegexp {
+		pattern = regexp.QuoteMeta(pattern)
+	}
+	if this.IgnoreCase {
+		pattern = "(?i)" + pattern
+	}
+	reg, err := regexp.Compile(pattern)
+	if err != nil {
+		return errors.New("invalid regexp '" + this.Find + "': " + err.Error())
+	}
+	this.reg = reg
+	return nil
+}
+
+// ReplaceRule 转换为替换规则
+func (this *HTTPSubFilterRule) ReplaceRule() *readers.ReplaceRule {
+	var replacement = []byte(this.Replace)
+	if !this.IsRegexp {
+		return &readers.ReplaceRule{
+			Regexp: this.reg,
+			Replace: func(match []byte) (result []byte, ok bool) {
+				return replacement, true
+			},
+		}
+	}
+
+	return &readers.ReplaceRule{
+		Regexp: this.reg,
+		Replace: func(match []byte) (result []byte, ok bool) {
+			var submatches = this.reg.FindSubmatchIndex(match)
+			if submatches == nil {
+				return replacement, true
+			}
+			return this.reg.Expand(nil, replacement, match, submatches), true
+		},
+	}
+}
+
+// HTTPSubFilterPolicy 单个响应内容替换策略
+type HTTPSubFilterPolicy struct {
+	ServerIds    []int64              `yaml:"serverIds" json:"serverIds"`       // 适用的网站ID，为空表示所有网站
+	ContentTypes []string             `yaml:"contentTypes" json:"contentTypes"` // 需要处理的内容类型，支持 text/* 形式的通配符，为空时表示常见的文本类型
+	URLPatterns  []string             `yaml:"urlPatterns" json:"urlPatterns"`   // 需要处理的URL正则表达式，匹配完整URL，为空表示所有URL
+	MaxMatchSize int                  `yaml:"maxMatchSize" json:"maxMatchSize"` // 单个匹配的最大长度，单位：字节
+	Rules        []*HTTPSubFilterRule `yaml:"rules" json:"rules"`               // 替换规则
+
+	urlRegs      []*regexp.Regexp
+	replaceRules []*readers.ReplaceRule
+}
+
+// Init 初始化
+func (this *HTTPSubFilterPolicy) Init() error {
+	if len(this.Rules) == 0 {
+		return errors.New("'rules' should not be empty")
+	}
+	this.replaceRules = nil
+	for index, rule := range this.Rules {
+		if rule == nil {
+			return errors.New("rule #" + types.String(index+1) + " should not be empty")
+		}
+		err := rule.Init()
+		if err != nil {
+			return errors.New("rule #" + types.String(index+1) + ": " + err.Error())
+		}
+		this.replaceRules = append(this.replaceRules, rule.ReplaceRule())
+	}
+
+	if len(this.ContentTypes) == 0 {
+		this.ContentTypes = defaultSubFilterContentTypes
+	}
+	for index, contentType := range this.ContentTypes {
+		this.ContentTypes[index] = strings.ToLower(strings.TrimSpace(contentType))
+	}
+
+	this.urlRegs = nil
+	for _, pattern := range this.URLPatterns {
+		reg, err := regexp.Compile(pattern)
+		if err != nil {
+			return errors.New("invalid url pattern '" + pattern + "': " + err.Error())
+		}
+		this.urlRegs = append(this.urlRegs, reg)
+	}
+
+	if this.MaxMatchSize <= 0 {
+		this.MaxMatchSize = readers.DefaultReplaceMaxMatchSize
+	}
+	return nil
+}
+
+// MatchServer 检查是否适用于某个网站
+func (this *HTTPSubFilterPolicy) MatchServer(serverId int64) bool {
+	return len(this.ServerIds) == 0 || lists.ContainsInt64(this.ServerIds, serverId)
+}
+
+// MatchContentType 检查内容类型
+func (this *HTTPSubFilterPolicy) MatchContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, pattern := range this.ContentTypes {
+		if pattern == mediaType || pattern == "*/*" {
+			return true
+		}
+
+		// text/*, application/*+json
+		var starIndex = strings.Index(pattern, "*")
+		if starIndex >= 0 && strings.HasPrefix(mediaType, pattern[:starIndex]) && strings.HasSuffix(mediaType, pattern[starIndex+1:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchURL 检查URL
+func (this *HTTPSubFilterPolicy) MatchURL(url string) bool {
+	if len(this.urlRegs) == 0 {
+		return true
+	}
+	for _, reg := range this.urlRegs {
+		if reg.MatchString(url) {
+			return true
+		}
+	}
+	return false
+}
+
+// HTTPSubFilterManager 响应内容替换管理
+type HTTPSubFilterManager struct {
+	config *HTTPSubFilterConfig
+
+	locker sync.RWMutex
+}
+
+// NewHTTPSubFilterManager 获取新对象
+func NewHTTPSubFilterManager() *HTTPSubFilterManager {
+	return &HTTPSubFilterManager{
+		config: DefaultHTTPSubFilterConfig(),
+	}
+}
+
+// UpdateConfig 修改配置
+func (this *HTTPSubFilterManager) UpdateConfig(config *HTTPSubFilterConfig) {
+	if config == nil {
+		return
+	}
+	this.locker.Lock()
+	this.config = config
+	this.locker.Unlock()
+}
+
+// IsOn 是否有可用的策略
+func (this *HTTPSubFilterManager) IsOn() bool {
+	this.locker.RLock()
+	defer this.locker.RUnlock()
+	return this.config.IsOn && len(this.config.Policies) > 0
+}
+
+// FindPolicies 查找适用的策略
+func (this *HTTPSubFilterManager) FindPolicies(serverId int64, contentType string, url string) []*HTTPSubFilterPolicy {
+	this.locker.RLock()
+	defer this.locker.RUnlock()
+
+	if !this.config.IsOn {
+		return nil
+	}
+	var result []*HTTPSubFilterPolicy
+	for _, policy := range this.config.Policies {
+		if policy.MatchServer(serverId) && policy.MatchContentType(contentType) && policy.MatchURL(url) {
+			result = append(result, policy)
+		}
+	}
+	return result
+}
+
+// WrapSubFilterReader 包装响应内容读取器，使用一组策略中的规则替换内容
+func WrapSubFilterReader(reader io.ReadCloser, policies []*HTTPSubFilterPolicy) io.ReadCloser {
+	var rules = []*readers.ReplaceRule{}
+	var maxMatchSize = 0
+	for _, policy := range policies {
+		rules = append(rules, policy.replaceRules...)
+		if policy.MaxMatchSize > maxMatchSize {
+			maxMatchSize = policy.MaxMatchSize
+		}
+	}
+	if len(rules) == 0 {
+		return reader
+	}
+	return readers.NewReplaceReaderCloser(reader, rules, maxMatchSize)
+}
@@ -0,0 +1,92 @@
+// Copyright 2024 GoEdge CDN goedge.cdn@gmail.com. All rights reserved. Official site: https://goedge.cloud .
+
+package nodes
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/iwind/TeaGo/assert"
+)
+
+func TestHTTPSubFilterConfig_Init(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	a.IsNotNil((&HTTPSubFilterConfig{Policies: []*HTTPSubFilterPolicy{{}}}).Init())
+	a.IsNotNil((&HTTPSubFilterConfig{Policies: []*HTTPSubFilterPolicy{{Rules: []*HTTPSubFilterRule{{}}}}}).Init())
+	a.IsNotNil((&HTTPSubFilterConfig{Policies: []*HTTPSubFilterPolicy{{Rules: []*HTTPSubFilterRule{{Find: "(", IsRegexp: true}}}}}).Init())
+	a.IsNotNil((&HTTPSubFilterConfig{Policies: []*HTTPSubFilterPolicy{{Rules: []*HTTPSubFilterRule{{Find: "a"}}, URLPatterns: []string{"("}}}}).Init())
+
+	var policy = &HTTPSubFilterPolicy{Rules: []*HTTPSubFilterRule{{Find: "("}}}
+	a.IsNil((&HTTPSubFilterConfig{Policies: []*HTTPSubFilterPolicy{policy}}).Init())
+	a.IsTrue(len(policy.ContentTypes) > 0)
+	a.IsTrue(policy.MaxMatchSize > 0)
+}
+
+func TestHTTPSubFilterManager_FindPolicies(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	var config = &HTTPSubFilterConfig{
+		IsOn: true,
+		Policies: []*HTTPSubFilterPolicy{
+			{
+				ServerIds: []int64{1},
+				Rules:     []*HTTPSubFilterRule{{Find: "a"}},
+			},
+			{
+				ContentTypes: []string{"text/*"},
+				URLPatterns:  []string{`/static/`},
+				Rules:        []*HTTPSubFilterRule{{Find: "b"}},
+			},
+		},
+	}
+	a.IsNil(config.Init())
+
+	var manager = NewHTTPSubFilterManager()
+	a.IsFalse(manager.IsOn())
+	manager.UpdateConfig(config)
+	a.IsTrue(manager.IsOn())
+
+	a.IsTrue(len(manager.FindPolicies(1, "text/html; charset=utf-8", "https://example.com/")) == 1)
+	a.IsTrue(len(manager.FindPolicies(1, "text/plain", "https://example.com/static/a.txt")) == 1)
+	a.IsTrue(len(manager.FindPolicies(1, "text/html", "https://example.com/static/a.html")) == 2)
+	a.IsTrue(len(manager.FindPolicies(1, "image/png", "https://example.com/static/a.png")) == 0)
+	a.IsTrue(len(manager.FindPolicies(2, "text/html", "https://example.com/")) == 0)
+	a.IsTrue(len(manager.FindPolicies(1, "invalid content type;;", "https://example.com/")) == 0)
+}
+
+func TestWrapSubFilterReader(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	var policy = &HTTPSubFilterPolicy{
+		MaxMatchSize: 64,
+		Rules: []*HTTPSubFilterRule{
+			{
+				Find:       "http://origin.internal",
+				Replace:    "https://www.example.com",
+				IgnoreCase: true,
+			},
+			{
+				Find:     `src="//cdn\.internal/([^"]+)"`,
+				Replace:  `src="https://static.example.com/${1}"`,
+				IsRegexp: true,
+			},
+		},
+	}
+	a.IsNil(policy.Init())
+
+	var source = strings.Repeat(`<a href="HTTP://Origin.Internal/a.html"><img src="//cdn.internal/b.png"/></a>`+"\n", 200)
+	var expected = strings.Repeat(`<a href="https://www.example.com/a.html"><img src="https://static.example.com/b.png"/></a>`+"\n", 200)
+
+	// 每次只读取一个字节，测试跨越边界的情况
+	var reader = WrapSubFilterReader(io.NopCloser(iotest.OneByteReader(bytes.NewBufferString(source))), []*HTTPSubFilterPolicy{policy})
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.IsTrue(string(data) == expected)
+	a.IsNil(reader.Close())
+}
@@ -31,6 +31,7 @@ import (
 	"github.com/TeaOSLab/EdgeNode/internal/utils/readers"
 	setutils "github.com/TeaOSLab/EdgeNode/internal/utils/sets"
 	"github.com/TeaOSLab/EdgeNode/internal/utils/writers"
+	"github.com/TeaOSLab/EdgeNode/internal/waf"
 	_ "github.com/biessek/golang-ico"
 	"github.com/iwind/TeaGo/types"
 	"github.com/iwind/gowebp"
@@ -129,12 +130,21 @@ func (this *HTTPWriter) Prepare(resp *http.Response, size int64, status int, ena
 		enableCache = false
 	}
 
+	// 需要脱敏的内容不写入缓存，防止脱敏后的内容返回给没有匹配脱敏规则的请求
+	if this.req.wafHasMaskPolicy || len(this.req.wafMaskActions) > 0 {
+		enableCache = false
+	}
+
 	if resp != nil && resp.Body != nil {
 		cacheReader, ok := resp.Body.(caches.Reader)
 		if ok {
 			this.cacheReader = cacheReader
 		}
 
+		// 需要在内容替换和压缩之前处理
+		size = this.PrepareMask(resp, size)
+		size = this.PrepareSubFilter(resp, size)
+
 		this.rawReader = resp.Body
 
 		if enableCache {
@@ -529,6 +539,113 @@ func (this *HTTPWriter) PrepareCache(resp *http.Response, size int64) {
 	})
 }
 
+// PrepareMask 准备响应内容脱敏
+// 有脱敏动作时已经去除了请求中的Range，如果仍然收到区间内容，也同样脱敏，不能原样输出
+func (this *HTTPWriter) PrepareMask(resp *http.Response, size int64) int64 {
+	if resp == nil || len(this.req.wafMaskActions) == 0 {
+		return size
+	}
+
+	if this.req.Method() == http.MethodHead {
+		return size
+	}
+
+	var status = this.StatusCode()
+	if status == http.StatusNoContent || status == http.StatusNotModified || resp.ContentLength == 0 {
+		return size
+	}
+
+	var contentType = this.GetHeader("Content-Type")
+	var actions = []*waf.MaskAction{}
+	var isLengthPreserving = true
+	for _, action := range this.req.wafMaskActions {
+		if action.HasRules() && action.MatchContentType(contentType) {
+			actions = append(actions, action)
+			if !action.IsLengthPreserving() {
+				isLengthPreserving = false
+			}
+		}
+	}
+	if len(actions) == 0 {
+		return size
+	}
+
+	// 解压缩内容，在后续的 PrepareCompression() 中会重新压缩
+	var contentEncoding = this.GetHeader("Content-Encoding")
+	if len(contentEncoding) > 0 {
+		if !compressions.SupportEncoding(contentEncoding) {
+			return size
+		}
+		reader, err := compressions.NewReader(resp.Body, contentEncoding)
+		if err != nil {
+			return size
+		}
+		this.Header().Del("Content-Encoding")
+		resp.Header.Del("Content-Encoding")
+		resp.Body = reader
+		isLengthPreserving = false
+	}
+
+	resp.Body = waf.WrapMaskReader(resp.Body, actions)
+
+	if !isLengthPreserving {
+		this.Header().Del("Content-Length")
+		resp.ContentLength = -1
+		size = -1
+	}
+
+	return size
+}
+
+// PrepareSubFilter 准备响应内容替换
+// 替换后的内容会被缓存，所以从缓存中读取的内容不再处理
+func (this *HTTPWriter) PrepareSubFilter(resp *http.Response, size int64) int64 {
+	if resp == nil || this.cacheReader != nil || !SharedHTTPSubFilterManager.IsOn() {
+		return size
+	}
+
+	if this.req.Method() == http.MethodHead || this.isPartial {
+		return size
+	}
+
+	var status = this.StatusCode()
+	if status == http.StatusNoContent || status == http.StatusNotModified || resp.ContentLength == 0 {
+		return size
+	}
+
+	var policies = SharedHTTPSubFilterManager.FindPolicies(this.req.ReqServer.Id, this.GetHeader("Content-Type"), this.req.URL())
+	if len(policies) == 0 {
+		return size
+	}
+
+	// 解压缩内容，在后续的 PrepareCompression() 中会重新压缩
+	var contentEncoding = this.GetHeader("Content-Encoding")
+	if len(contentEncoding) > 0 {
+		if !compressions.SupportEncoding(contentEncoding) {
+			return size
+		}
+		reader, err := compressions.NewReader(resp.Body, contentEncoding)
+		if err != nil {
+			return size
+		}
+		this.Header().Del("Content-Encoding")
+		resp.Header.Del("Content-Encoding")
+		resp.Body = reader
+	}
+
+	resp.Body = WrapSubFilterReader(resp.Body, policies)
+
+	// 内容已改变
+	this.Header().Del("Content-Length")
+	resp.ContentLength = -1
+	var eTag = this.GetHeader("ETag")
+	if len(eTag) > 0 && !strings.HasPrefix(eTag, "W/") {
+		this.Header().Set("ETag", "W/"+eTag)
+	}
+
+	return -1
+}
+
 // PrepareWebP 准备WebP
 func (this *HTTPWriter) PrepareWebP(resp *http.Response, size int64) {
 	if resp == nil {
@@ -0,0 +1,68 @@
+// Copyright 2024 GoEdge CDN goedge.cdn@gmail.com. All rights reserved. Official site: https://goedge.cloud .
+
+package nodes
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/TeaOSLab/EdgeCommon/pkg/serverconfigs"
+	"github.com/TeaOSLab/EdgeNode/internal/waf"
+	"github.com/iwind/TeaGo/assert"
+)
+
+func TestHTTPWriter_PrepareMask_Partial(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	var action = &waf.MaskAction{
+		Rules: []*waf.MaskRule{
+			{
+				Type:       waf.MaskRuleTypeCreditCard,
+				KeepSuffix: 4,
+			},
+		},
+	}
+	a.IsNil(action.Init(nil))
+
+	rawReq, err := http.NewRequest(http.MethodGet, "https://example.com/data.txt", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rawReq.Header.Set("Range", "bytes=0-")
+
+	// 源站返回区间内容之后，才由出站规则匹配到脱敏动作
+	var req = &HTTPRequest{
+		RawReq:           rawReq,
+		ReqServer:        &serverconfigs.ServerConfig{},
+		wafHasMaskPolicy: true,
+		wafMaskActions:   []*waf.MaskAction{action},
+	}
+
+	var body = "card: 4111 1111 1111 1111."
+	var recorder = httptest.NewRecorder()
+	recorder.Header().Set("Content-Type", "text/plain")
+	recorder.Header().Set("Content-Range", "bytes 0-25/26")
+
+	var resp = &http.Response{
+		StatusCode:    http.StatusPartialContent,
+		Header:        http.Header{},
+		Body:          io.NopCloser(strings.NewReader(body)),
+		ContentLength: int64(len(body)),
+	}
+
+	var writer = NewHTTPWriter(req, recorder)
+	req.writer = writer
+	writer.Prepare(resp, resp.ContentLength, resp.StatusCode, true)
+
+	// 区间内容同样需要脱敏，并且不能写入缓存
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Log(string(data))
+	a.IsTrue(string(data) == "card: **** **** **** 1111.")
+	a.IsTrue(writer.cacheWriter == nil)
+}
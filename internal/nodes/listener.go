@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"net"
+	"os"
 	"strings"
 	"sync"
 
@@ -19,9 +20,17 @@ type Listener struct {
 	group    *serverconfigs.ServerAddressGroup
 	listener ListenerInterface // 监听器
 
+	rawListeners map[string]fileListener // 底层监听器，用于优雅重启时传递给新进程
+	quitFunc     func()                  // 停止接受新连接
+
 	locker sync.RWMutex
 }
 
+// 可以导出文件描述符的监听器
+type fileListener interface {
+	File() (*os.File, error)
+}
+
 func NewListener() *Listener {
 	return &Listener{}
 }
@@ -64,10 +73,16 @@ func (this *Listener) listenTCP() error {
 		return err
 	}
 	var netListener = NewClientListener(tcpListener, protocol.IsHTTPFamily() || protocol.IsHTTPSFamily())
-	events.OnKey(events.EventQuit, this, func() {
+	netListener.SetGroupFunc(func() *serverconfigs.ServerAddressGroup {
+		this.locker.RLock()
+		defer this.locker.RUnlock()
+		return this.group
+	})
+	this.quitFunc = func() {
 		remotelogs.Println("LISTENER", "quit "+this.group.FullAddr())
 		_ = netListener.Close()
-	})
+	}
+	events.OnKey(events.EventQuit, this, this.quitFunc)
 
 	switch protocol {
 	case serverconfigs.ProtocolHTTP, serverconfigs.ProtocolHTTP4, serverconfigs.ProtocolHTTP6:
@@ -158,7 +173,7 @@ func (this *Listener) listenUDP() error {
 		}
 	}
 
-	events.OnKey(events.EventQuit, this, func() {
+	this.quitFunc = func() {
 		remotelogs.Println("LISTENER", "quit "+this.group.FullAddr())
 
 		if ipv4PacketListener != nil {
@@ -168,7 +183,8 @@ func (this *Listener) listenUDP() error {
 		if ipv6PacketListener != nil {
 			_ = ipv6PacketListener.Close()
 		}
-	})
+	}
+	events.OnKey(events.EventQuit, this, this.quitFunc)
 
 	this.listener = &UDPListener{
 		BaseListener: BaseListener{Group: this.group},
@@ -186,46 +202,123 @@ func (this *Listener) listenUDP() error {
 	return nil
 }
 
+// StopAccepting 停止接受新连接，已有连接不受影响
+func (this *Listener) StopAccepting() {
+	if this.quitFunc != nil {
+		this.quitFunc()
+	}
+
+	// 监听器已经传递给新进程，不再需要
+	this.locker.Lock()
+	this.rawListeners = nil
+	this.locker.Unlock()
+}
+
 func (this *Listener) Close() error {
 	events.Remove(this)
 
+	this.locker.Lock()
+	this.rawListeners = nil
+	this.locker.Unlock()
+
 	if this.listener == nil {
 		return nil
 	}
 	return this.listener.Close()
 }
 
-// 创建TCP监听器
-func (this *Listener) createTCPListener() (net.Listener, error) {
-	var listenConfig = net.ListenConfig{
-		Control:   nil,
-		KeepAlive: 0,
+// GracefulFiles 读取底层监听器的文件描述符
+func (this *Listener) GracefulFiles() (keys []string, files []*os.File, err error) {
+	this.locker.RLock()
+	defer this.locker.RUnlock()
+
+	for key, rawListener := range this.rawListeners {
+		file, fileErr := rawListener.File()
+		if fileErr != nil {
+			for _, f := range files {
+				_ = f.Close()
+			}
+			return nil, nil, errors.New("read file of '" + key + "' failed: " + fileErr.Error())
+		}
+		keys = append(keys, key)
+		files = append(files, file)
 	}
+	return
+}
 
+// 记录底层监听器
+func (this *Listener) addRawListener(network string, addr string, rawListener fileListener) {
+	this.locker.Lock()
+	if this.rawListeners == nil {
+		this.rawListeners = map[string]fileListener{}
+	}
+	this.rawListeners[gracefulListenerKey(network, addr)] = rawListener
+	this.locker.Unlock()
+}
+
+// 创建TCP监听器
+func (this *Listener) createTCPListener() (net.Listener, error) {
+	var network = "tcp"
 	switch this.group.Protocol() {
 	case serverconfigs.ProtocolHTTP4, serverconfigs.ProtocolHTTPS4, serverconfigs.ProtocolTLS4:
-		return listenConfig.Listen(context.Background(), "tcp4", this.group.Addr())
+		network = "tcp4"
 	case serverconfigs.ProtocolHTTP6, serverconfigs.ProtocolHTTPS6, serverconfigs.ProtocolTLS6:
-		return listenConfig.Listen(context.Background(), "tcp6", this.group.Addr())
+		network = "tcp6"
 	}
+	var addr = this.group.Addr()
 
-	return listenConfig.Listen(context.Background(), "tcp", this.group.Addr())
+	// 优先使用从旧进程继承的监听器
+	listener, err := SharedGracefulRestartManager.InheritListener(network, addr)
+	if err != nil {
+		remotelogs.Error("LISTENER", "inherit listener '"+addr+"' failed: "+err.Error())
+	}
+	if listener == nil {
+		var listenConfig = net.ListenConfig{
+			Control:   nil,
+			KeepAlive: 0,
+		}
+		listener, err = listenConfig.Listen(context.Background(), network, addr)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	rawListener, ok := listener.(fileListener)
+	if ok {
+		this.addRawListener(network, addr, rawListener)
+	}
+	return listener, nil
 }
 
 // 创建UDP IPv4监听器
 func (this *Listener) createUDPIPv4Listener() (*net.UDPConn, error) {
-	addr, err := net.ResolveUDPAddr("udp", this.group.Addr())
-	if err != nil {
-		return nil, err
-	}
-	return net.ListenUDP("udp4", addr)
+	return this.createUDPListener("udp4")
 }
 
 // 创建UDP监听器
 func (this *Listener) createUDPIPv6Listener() (*net.UDPConn, error) {
-	addr, err := net.ResolveUDPAddr("udp", this.group.Addr())
+	return this.createUDPListener("udp6")
+}
+
+func (this *Listener) createUDPListener(network string) (*net.UDPConn, error) {
+	var addr = this.group.Addr()
+
+	// 优先使用从旧进程继承的监听器
+	udpConn, err := SharedGracefulRestartManager.InheritUDPConn(network, addr)
 	if err != nil {
-		return nil, err
+		remotelogs.Error("LISTENER", "inherit udp listener '"+addr+"' failed: "+err.Error())
 	}
-	return net.ListenUDP("udp6", addr)
+	if udpConn == nil {
+		udpAddr, err := net.ResolveUDPAddr("udp", addr)
+		if err != nil {
+			return nil, err
+		}
+		udpConn, err = net.ListenUDP(network, udpAddr)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	this.addRawListener(network, addr, udpConn)
+	return udpConn, nil
 }
@@ -47,6 +47,12 @@ func (this *BaseListener) buildTLSConfig() *tls.Config {
 				}
 			}
 
+			// 区域访问策略
+			err := this.checkGeoPolicy(clientInfo)
+			if err != nil {
+				return nil, err
+			}
+
 			tlsPolicy, _, err := this.matchSSL(this.helloServerNames(clientInfo))
 			if err != nil {
 				return nil, err
@@ -185,6 +191,31 @@ func (this *BaseListener) matchSSL(domains []string) (*sslconfigs.SSLPolicy, *tl
 	return sslConfig, sslConfig.FirstCert(), nil
 }
 
+// 根据TLS域名检查区域访问策略，被拒绝时不再进行TLS握手
+// 单个网站的分组在接受连接时已经检查过
+func (this *BaseListener) checkGeoPolicy(clientInfo *tls.ClientHelloInfo) error {
+	if !sharedClientGeoPolicyManager.IsActive() || this.Group == nil || len(this.Group.Servers()) <= 1 || clientInfo.Conn == nil {
+		return nil
+	}
+
+	clientConn, ok := clientInfo.Conn.(*ClientConn)
+	if !ok || clientConn.isInAllowList {
+		return nil
+	}
+
+	for _, serverName := range this.helloServerNames(clientInfo) {
+		server, _ := this.findNamedServer(serverName, false)
+		if server != nil {
+			allowed, policyName := sharedClientGeoPolicyManager.CheckServer(server.Id, clientConn.RawIP())
+			if !allowed {
+				return errors.New("denied by geo policy '" + policyName + "'")
+			}
+			return nil
+		}
+	}
+	return nil
+}
+
 // 根据域名来查找匹配的域名
 func (this *BaseListener) findNamedServer(name string, exactly bool) (serverConfig *serverconfigs.ServerConfig, serverName string) {
 	serverConfig, serverName = this.findNamedServerMatched(name)
@@ -103,6 +103,13 @@ func (this *HTTPListener) Close() error {
 	return this.Listener.Close()
 }
 
+// DisableKeepAlives 关闭长连接，同时关闭当前空闲的连接
+func (this *HTTPListener) DisableKeepAlives() {
+	if this.httpServer != nil {
+		this.httpServer.SetKeepAlivesEnabled(false)
+	}
+}
+
 func (this *HTTPListener) Reload(group *serverconfigs.ServerAddressGroup) {
 	this.Group = group
 
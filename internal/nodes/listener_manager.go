@@ -3,6 +3,7 @@ package nodes
 import (
 	"fmt"
 	"net/url"
+	"os"
 	"regexp"
 	"runtime"
 	"sort"
@@ -181,6 +182,48 @@ func (this *ListenerManager) TotalActiveConnections() int {
 	return total
 }
 
+// GracefulFiles 读取所有监听器的文件描述符，用于优雅重启
+func (this *ListenerManager) GracefulFiles() (keys []string, files []*os.File, err error) {
+	this.locker.Lock()
+	defer this.locker.Unlock()
+
+	for _, listener := range this.listenersMap {
+		listenerKeys, listenerFiles, listenerErr := listener.GracefulFiles()
+		if listenerErr != nil {
+			for _, file := range files {
+				_ = file.Close()
+			}
+			return nil, nil, listenerErr
+		}
+		keys = append(keys, listenerKeys...)
+		files = append(files, listenerFiles...)
+	}
+	return
+}
+
+// StopAccepting 所有监听器停止接受新连接，已有连接不受影响
+func (this *ListenerManager) StopAccepting() {
+	this.locker.Lock()
+	defer this.locker.Unlock()
+
+	for _, listener := range this.listenersMap {
+		listener.StopAccepting()
+	}
+}
+
+// DisableKeepAlives 关闭所有HTTP监听器的长连接
+func (this *ListenerManager) DisableKeepAlives() {
+	this.locker.Lock()
+	defer this.locker.Unlock()
+
+	for _, listener := range this.listenersMap {
+		httpListener, ok := listener.listener.(*HTTPListener)
+		if ok {
+			httpListener.DisableKeepAlives()
+		}
+	}
+}
+
 // 返回更加友好格式的地址
 func (this *ListenerManager) prettyAddress(addr string) string {
 	u, err := url.Parse(addr)
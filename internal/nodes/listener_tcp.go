@@ -285,9 +285,13 @@ func (this *TCPListener) connectOrigin(serverId int64, requestHost string, rever
 		if err != nil {
 			failedOriginIds = append(failedOriginIds, origin.Id)
 
-			remotelogs.ServerError(serverId, "TCP_LISTENER", "unable to connect origin server: "+addr+": "+err.Error(), "", nil)
+			if IsOriginTLSVerifyError(err) {
+				remotelogs.ServerError(serverId, "ORIGIN_TLS", "unable to verify origin server: "+addr+": "+err.Error(), "", nil)
+			} else {
+				remotelogs.ServerError(serverId, "TCP_LISTENER", "unable to connect origin server: "+addr+": "+err.Error(), "", nil)
+			}
 
-			SharedOriginStateManager.Fail(origin, requestHost, reverseProxy, func() {
+			SharedOriginStateManager.Fail(origin, requestHost, reverseProxy, err, func() {
 				reverseProxy.ResetScheduling()
 			})
 
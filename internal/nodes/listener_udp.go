@@ -160,6 +160,10 @@ func (this *UDPListener) servePacketListener(listener UDPPacketListener) error {
 		return errors.New("no ReverseProxy configured for the server '" + firstServer.Name + "'")
 	}
 
+	// 接收PROXY协议，配置变化时重新查找
+	var proxyVersion = SharedProxyProtocolManager.Version()
+	var proxyPolicy = SharedProxyProtocolManager.FindPolicy(this.port)
+
 	this.connMap = map[string]*UDPConn{}
 	this.connTicker = utils.NewTicker(1 * time.Minute)
 	goman.New(func() {
@@ -187,19 +191,42 @@ func (this *UDPListener) servePacketListener(listener UDPPacketListener) error {
 			return err
 		}
 
+		// 数据包的来源地址，使用PROXY协议时为负载均衡器地址
+		var replyAddr = clientAddr
+		var payload = buffer[:n]
+		if version := SharedProxyProtocolManager.Version(); version != proxyVersion {
+			proxyVersion = version
+			proxyPolicy = SharedProxyProtocolManager.FindPolicy(this.port)
+		}
+		if proxyPolicy != nil {
+			var ok bool
+			clientAddr, payload, ok = this.parseProxyProtocolPacket(proxyPolicy, clientAddr, payload)
+			if !ok {
+				continue
+			}
+		}
+
 		// 检查IP名单
 		clientIP, _, parseHostErr := net.SplitHostPort(clientAddr.String())
 		if parseHostErr == nil {
-			ok, _, expiresAt := iplibrary.AllowIP(clientIP, firstServer.Id)
+			ok, isInAllowList, expiresAt := iplibrary.AllowIP(clientIP, firstServer.Id)
 			if !ok {
 				firewalls.DropTemporaryTo(clientIP, expiresAt)
 				continue
 			}
+
+			// 区域访问策略
+			if !isInAllowList && sharedClientGeoPolicyManager.IsActive() {
+				allowed, _ := sharedClientGeoPolicyManager.CheckServer(firstServer.Id, clientIP)
+				if !allowed {
+					continue
+				}
+			}
 		}
 
-		if n > 0 {
+		if len(payload) > 0 {
 			this.connLocker.Lock()
-			conn, ok := this.connMap[clientAddr.String()]
+			conn, ok := this.connMap[replyAddr.String()]
 			this.connLocker.Unlock()
 			if ok && !conn.IsOk() {
 				_ = conn.Close()
@@ -215,12 +242,12 @@ func (this *UDPListener) servePacketListener(listener UDPPacketListener) error {
 					remotelogs.Error("UDP_LISTENER", "unable to find a origin server")
 					continue
 				}
-				conn = NewUDPConn(firstServer, clientAddr, listener, cm, originConn.(*net.UDPConn))
+				conn = NewUDPConn(firstServer, clientAddr, replyAddr, listener, cm, originConn.(*net.UDPConn))
 				this.connLocker.Lock()
-				this.connMap[clientAddr.String()] = conn
+				this.connMap[replyAddr.String()] = conn
 				this.connLocker.Unlock()
 			}
-			_, _ = conn.Write(buffer[:n])
+			_, _ = conn.Write(payload)
 		}
 	}
 }
@@ -273,6 +300,39 @@ func (this *UDPListener) Reload(group *serverconfigs.ServerAddressGroup) {
 	this.reverseProxy = firstServer.ReverseProxy
 }
 
+// 解析数据包中的PROXY协议头，返回客户端地址和去掉协议头之后的数据，数据包需要丢弃时返回false
+func (this *UDPListener) parseProxyProtocolPacket(policy *ProxyProtocolPolicy, addr net.Addr, data []byte) (clientAddr net.Addr, payload []byte, ok bool) {
+	ip, _, _ := net.SplitHostPort(addr.String())
+	if !policy.IsTrusted(ip) {
+		return addr, data, true
+	}
+
+	header, payload, err := ParseProxyProtocolPacket(data)
+	if err != nil {
+		remotelogs.Debug("PROXY_PROTOCOL", "read udp header from '"+addr.String()+"' failed: "+err.Error())
+		return nil, nil, false
+	}
+	if header == nil {
+		// 同一个会话中后续的数据包可以不带协议头
+		this.connLocker.Lock()
+		conn, hasConn := this.connMap[addr.String()]
+		this.connLocker.Unlock()
+		if hasConn {
+			return conn.RemoteAddr(), data, true
+		}
+		if policy.Required {
+			return nil, nil, false
+		}
+		return addr, data, true
+	}
+
+	// LOCAL命令，通常为负载均衡器的健康检查
+	if header.Command.IsLocal() || header.SourceAddr == nil {
+		return addr, payload, true
+	}
+	return header.SourceAddr, payload, true
+}
+
 func (this *UDPListener) connectOrigin(serverId int64, reverseProxy *serverconfigs.ReverseProxyConfig, localAddr net.Addr, remoteAddr net.Addr) (conn net.Conn, err error) {
 	if reverseProxy == nil {
 		return nil, errors.New("no reverse proxy config")
@@ -301,7 +361,7 @@ func (this *UDPListener) connectOrigin(serverId int64, reverseProxy *serverconfi
 
 			remotelogs.ServerError(serverId, "UDP_LISTENER", "unable to connect origin server: "+addr+": "+err.Error(), "", nil)
 
-			SharedOriginStateManager.Fail(origin, "", reverseProxy, func() {
+			SharedOriginStateManager.Fail(origin, "", reverseProxy, err, func() {
 				reverseProxy.ResetScheduling()
 			})
 
@@ -373,7 +433,9 @@ type UDPConn struct {
 	isClosed      bool
 }
 
-func NewUDPConn(server *serverconfigs.ServerConfig, clientAddr net.Addr, proxyListener UDPPacketListener, cm any, serverConn *net.UDPConn) *UDPConn {
+// NewUDPConn 获取新的UDP连接
+// clientAddr 为客户端地址，replyAddr 为回复数据包的地址，使用PROXY协议时为负载均衡器地址，否则和 clientAddr 相同
+func NewUDPConn(server *serverconfigs.ServerConfig, clientAddr net.Addr, replyAddr net.Addr, proxyListener UDPPacketListener, cm any, serverConn *net.UDPConn) *UDPConn {
 	var conn = &UDPConn{
 		addr:          clientAddr,
 		proxyListener: proxyListener,
@@ -412,7 +474,7 @@ func NewUDPConn(server *serverconfigs.ServerConfig, clientAddr net.Addr, proxyLi
 			if n > 0 {
 				conn.activatedAt = time.Now().Unix()
 
-				_, writingErr := proxyListener.WriteTo(buf.Bytes[:n], cm, clientAddr)
+				_, writingErr := proxyListener.WriteTo(buf.Bytes[:n], cm, replyAddr)
 				if writingErr != nil {
 					conn.isOk = false
 					break
@@ -449,6 +511,11 @@ func (this *UDPConn) Write(b []byte) (n int, err error) {
 	return
 }
 
+// RemoteAddr 客户端地址
+func (this *UDPConn) RemoteAddr() net.Addr {
+	return this.addr
+}
+
 func (this *UDPConn) Close() error {
 	this.isOk = false
 	if this.isClosed {
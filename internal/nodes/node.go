@@ -120,6 +120,12 @@ func (this *Node) Start() {
 		remotelogs.Println("NODE", "start from daemon")
 		DaemonIsOn = true
 		DaemonPid = os.Getppid()
+
+		// 通过优雅重启启动的进程，父进程不是守护进程
+		var daemonPid = types.Int(os.Getenv(gracefulRestartDaemonPidEnv))
+		if daemonPid > 0 {
+			DaemonPid = daemonPid
+		}
 	}
 
 	// 处理异常
@@ -128,10 +134,14 @@ func (this *Node) Start() {
 	// 监听signal
 	this.listenSignals()
 
+	// 从旧进程接收监听器
+	SharedGracefulRestartManager.ReceiveListeners()
+
 	// 本地Sock
 	err := this.listenSock()
 	if err != nil {
 		remotelogs.Error("NODE", err.Error())
+		SharedGracefulRestartManager.NotifyFailed(err)
 		return
 	}
 
@@ -191,6 +201,7 @@ func (this *Node) Start() {
 	nodeConfig, err := nodeconfigs.SharedNodeConfig()
 	if err != nil {
 		remotelogs.Error("NODE", "start failed: read node config failed: "+err.Error())
+		SharedGracefulRestartManager.NotifyFailed(err)
 		return
 	}
 	teaconst.NodeId = nodeConfig.Id
@@ -199,6 +210,7 @@ func (this *Node) Start() {
 	err, serverErrors := nodeConfig.Init(context.Background())
 	if err != nil {
 		remotelogs.Error("NODE", "init node config failed: "+err.Error())
+		SharedGracefulRestartManager.NotifyFailed(err)
 		return
 	}
 	if len(serverErrors) > 0 {
@@ -240,9 +252,13 @@ func (this *Node) Start() {
 	err = sharedListenerManager.Start(nodeConfig)
 	if err != nil {
 		remotelogs.Error("NODE", "start failed: "+err.Error())
+		SharedGracefulRestartManager.NotifyFailed(err)
 		return
 	}
 
+	// 通知旧进程已经就绪
+	SharedGracefulRestartManager.NotifyReady()
+
 	// hold住进程
 	select {}
 }
@@ -253,6 +269,12 @@ func (this *Node) Daemon() {
 	for {
 		conn, err := this.sock.Dial()
 		if err != nil {
+			// 优雅重启期间本地sock会短暂关闭，此时不能再启动新的进程
+			if IsGracefulRestarting() {
+				time.Sleep(1 * time.Second)
+				continue
+			}
+
 			if isDebug {
 				log.Println("[DAEMON]starting ...")
 			}
@@ -645,6 +667,25 @@ func (this *Node) listenSock() error {
 						time.Sleep(1 * time.Second)
 					}
 				})
+			case "gracefulRestart":
+				exe, err := os.Executable()
+				if err == nil {
+					err = SharedGracefulRestartManager.Restart(exe)
+				}
+				if err != nil {
+					_ = cmd.Reply(&gosock.Command{
+						Params: map[string]interface{}{
+							"isOk":  false,
+							"error": err.Error(),
+						},
+					})
+				} else {
+					_ = cmd.Reply(&gosock.Command{
+						Params: map[string]interface{}{
+							"isOk": true,
+						},
+					})
+				}
 			case "trackers":
 				_ = cmd.Reply(&gosock.Command{
 					Params: map[string]interface{}{
@@ -735,8 +776,9 @@ func (this *Node) listenSock() error {
 
 				_ = cmd.Reply(&gosock.Command{
 					Params: map[string]interface{}{
-						"conns": connMaps,
-						"total": len(connMaps),
+						"conns":     connMaps,
+						"total":     len(connMaps),
+						"penalties": sharedClientConnPenaltyManager.Penalties(),
 					},
 				})
 			case "dropIP":
@@ -744,7 +786,17 @@ func (this *Node) listenSock() error {
 				var ip = m.GetString("ip")
 				var timeSeconds = m.GetInt("timeoutSeconds")
 				var async = m.GetBool("async")
-				err := firewalls.Firewall().DropSourceIP(ip, timeSeconds, async)
+				var expiresAt int64
+				if timeSeconds > 0 {
+					expiresAt = time.Now().Unix() + int64(timeSeconds)
+				}
+				var err error
+				if firewalls.SharedIPAggregator.Record(ip, expiresAt) {
+					// 所在网段已经被封禁
+					conns.SharedMap.CloseIPConns(ip)
+				} else {
+					err = firewalls.Firewall().DropSourceIP(ip, timeSeconds, async)
+				}
 				if err != nil {
 					_ = cmd.Reply(&gosock.Command{
 						Params: map[string]interface{}{
@@ -776,6 +828,7 @@ func (this *Node) listenSock() error {
 			case "removeIP":
 				var m = maps.NewMap(cmd.Params)
 				var ip = m.GetString("ip")
+				firewalls.SharedIPAggregator.Remove(ip)
 				err := firewalls.Firewall().RemoveSourceIP(ip)
 				if err != nil {
 					_ = cmd.Reply(&gosock.Command{
@@ -827,6 +880,73 @@ func (this *Node) listenSock() error {
 				} else {
 					_ = cmd.ReplyOk()
 				}
+			case "wafStats":
+				var minutes = maps.NewMap(cmd.Params).GetInt("minutes")
+				var itemMaps = []maps.Map{}
+				for _, item := range waf.SharedRuleSetStatManager.Stats(minutes) {
+					itemMaps = append(itemMaps, item.AsMap())
+				}
+				_ = cmd.Reply(&gosock.Command{Params: maps.Map{
+					"costBuckets": waf.RuleSetStatCostBuckets,
+					"items":       itemMaps,
+				}})
+			case "ipFeeds":
+				var params = maps.NewMap(cmd.Params)
+				if params.GetBool("update") {
+					err := iplibrary.SharedIPFeedManager.TriggerUpdate(params.GetString("name"))
+					if err != nil {
+						_ = cmd.Reply(&gosock.Command{Params: maps.Map{
+							"error": err.Error(),
+						}})
+						break
+					}
+				}
+
+				var feedMaps = []maps.Map{}
+				for _, status := range iplibrary.SharedIPFeedManager.Status() {
+					feedMaps = append(feedMaps, status.AsMap())
+				}
+				_ = cmd.Reply(&gosock.Command{Params: maps.Map{
+					"feeds": feedMaps,
+				}})
+			case "firewallState":
+				var report *firewalls.FirewallDriftReport
+				if maps.NewMap(cmd.Params).GetBool("reconcile") {
+					var err error
+					report, err = firewalls.SharedFirewallState.Reconcile(firewalls.Firewall())
+					if err != nil {
+						_ = cmd.Reply(&gosock.Command{Params: maps.Map{
+							"error": err.Error(),
+						}})
+						break
+					}
+				} else {
+					report = firewalls.SharedFirewallState.LastReport()
+				}
+
+				var reportMap maps.Map
+				if report != nil {
+					reportMap = report.AsMap()
+				}
+				_ = cmd.Reply(&gosock.Command{Params: maps.Map{
+					"firewall": firewalls.Firewall().Name(),
+					"report":   reportMap,
+				}})
+			case "originStates":
+				_ = cmd.Reply(&gosock.Command{Params: maps.Map{
+					"states":    SharedOriginStateManager.States(),
+					"health":    SharedOriginHealthManager.States(),
+					"balance":   SharedOriginBalancer.Stats(),
+					"hedge":     SharedOriginHedgeManager.Stats(),
+					"breakers":  SharedOriginBreakerManager.States(),
+					"mirror":    SharedOriginMirrorManager.Stats(),
+					"discovery": SharedOriginDiscoveryManager.States(),
+				}})
+			case "geoPolicies":
+				_ = cmd.Reply(&gosock.Command{Params: maps.Map{
+					"isActive": sharedClientGeoPolicyManager.IsActive(),
+					"policies": sharedClientGeoPolicyManager.Stats(),
+				}})
 			case "bandwidth":
 				var m = stats.SharedBandwidthStatManager.Map()
 				_ = cmd.Reply(&gosock.Command{Params: maps.Map{
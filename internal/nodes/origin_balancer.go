@@ -0,0 +1,434 @@
+// Copyright 2024 GoEdge CDN goedge.cdn@gmail.com. All rights reserved. Official site: https://goedge.cloud .
+
+package nodes
+
+import (
+	"errors"
+	"io"
+	"math"
+	"math/rand"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/TeaOSLab/EdgeCommon/pkg/serverconfigs"
+	"github.com/TeaOSLab/EdgeNode/internal/configs"
+	teaconst "github.com/TeaOSLab/EdgeNode/internal/const"
+	"github.com/TeaOSLab/EdgeNode/internal/events"
+	"github.com/TeaOSLab/EdgeNode/internal/remotelogs"
+	"github.com/iwind/TeaGo/lists"
+	"github.com/iwind/TeaGo/maps"
+	"github.com/iwind/TeaGo/types"
+)
+
+// OriginBalanceConfigFileName 源站负载均衡配置文件
+const OriginBalanceConfigFileName = "origin_balance.yaml"
+
+// OriginBalanceStrategy 负载均衡策略
+type OriginBalanceStrategy = string
+
+const (
+	OriginBalanceStrategyP2CEWMA          OriginBalanceStrategy = "p2c-ewma"          // 两次随机选择中选取响应时间EWMA和进行中请求数较小的源站
+	OriginBalanceStrategyLeastOutstanding OriginBalanceStrategy = "least-outstanding" // 选取进行中请求数最少的源站
+)
+
+// 慢启动期间源站最少分配的权重比例
+const originBalanceMinSlowStartFactor = 0.1
+
+var SharedOriginBalancer = NewOriginBalancer()
+
+func init() {
+	if !teaconst.IsMain {
+		return
+	}
+
+	events.On(events.EventLoaded, func() {
+		config, err := LoadOriginBalanceConfig()
+		if err != nil {
+			remotelogs.Error("ORIGIN_BALANCER", "load '"+OriginBalanceConfigFileName+"' failed: "+err.Error())
+			return
+		}
+		SharedOriginBalancer.UpdateConfig(config)
+	})
+}
+
+// OriginBalanceConfig 源站负载均衡配置
+type OriginBalanceConfig struct {
+	IsOn     bool                   `yaml:"isOn" json:"isOn"`         // 是否启用
+	Policies []*OriginBalancePolicy `yaml:"policies" json:"policies"` // 策略列表
+}
+
+// DefaultOriginBalanceConfig 默认配置
+func DefaultOriginBalanceConfig() *OriginBalanceConfig {
+	return &OriginBalanceConfig{
+		IsOn: true,
+	}
+}
+
+// Init 初始化
+func (this *OriginBalanceConfig) Init() error {
+	for index, policy := range this.Policies {
+		if policy == nil {
+			return errors.New("policy #" + types.String(index+1) + " should not be empty")
+		}
+		err := policy.Init()
+		if err != nil {
+			return errors.New("policy #" + types.String(index+1) + ": " + err.Error())
+		}
+	}
+	return nil
+}
+
+// LoadOriginBalanceConfig 从配置文件中加载源站负载均衡配置
+// 如果配置文件不存在，则返回默认配置
+func LoadOriginBalanceConfig() (*OriginBalanceConfig, error) {
+	return configs.LoadLocalConfig(OriginBalanceConfigFileName, DefaultOriginBalanceConfig())
+}
+
+// OriginBalancePolicy 单个负载均衡策略
+type OriginBalancePolicy struct {
+	ServerIds       []int64               `yaml:"serverIds" json:"serverIds"`             // 适用的网站ID
+	ReverseProxyIds []int64               `yaml:"reverseProxyIds" json:"reverseProxyIds"` // 适用的反向代理ID，和网站ID都为空时表示所有网站
+	Strategy        OriginBalanceStrategy `yaml:"strategy" json:"strategy"`               // 策略：p2c-ewma、least-outstanding，为空时仍然使用反向代理中设置的调度算法，只统计源站数据
+	DecayTime       int                   `yaml:"decayTime" json:"decayTime"`             // 响应时间EWMA的衰减时间，单位：秒
+	SlowStart       int                   `yaml:"slowStart" json:"slowStart"`             // 源站恢复后的慢启动时间，单位：秒，0表示不启用
+}
+
+// Init 初始化
+func (this *OriginBalancePolicy) Init() error {
+	switch this.Strategy {
+	case "", OriginBalanceStrategyP2CEWMA, OriginBalanceStrategyLeastOutstanding:
+	default:
+		return errors.New("invalid strategy '" + this.Strategy + "'")
+	}
+	if this.DecayTime <= 0 {
+		this.DecayTime = 10
+	}
+	if this.SlowStart < 0 {
+		return errors.New("'slowStart' should not be negative")
+	}
+	return nil
+}
+
+// MatchReverseProxy 检查是否适用于某个网站的反向代理
+func (this *OriginBalancePolicy) MatchReverseProxy(serverId int64, reverseProxyId int64) bool {
+	if len(this.ServerIds) == 0 && len(this.ReverseProxyIds) == 0 {
+		return true
+	}
+	return lists.ContainsInt64(this.ServerIds, serverId) || lists.ContainsInt64(this.ReverseProxyIds, reverseProxyId)
+}
+
+// 单个源站的统计
+type originBalanceStat struct {
+	inFlight int64 // 进行中的请求数
+
+	locker      sync.Mutex
+	ewma        float64 // 响应时间EWMA，单位：纳秒
+	updatedAt   time.Time
+	isOk        bool
+	recoveredAt time.Time
+}
+
+// 记录一次响应时间
+// 使用peak EWMA：比当前值大的响应时间立即生效，比当前值小的则按时间衰减
+func (this *originBalanceStat) observe(cost time.Duration, decayTime time.Duration, now time.Time) {
+	this.locker.Lock()
+	defer this.locker.Unlock()
+
+	var value = float64(cost)
+	if this.updatedAt.IsZero() || value > this.ewma {
+		this.ewma = value
+	} else {
+		var weight = math.Exp(-float64(now.Sub(this.updatedAt)) / float64(decayTime))
+		this.ewma = this.ewma*weight + value*(1-weight)
+	}
+	this.updatedAt = now
+}
+
+// 检查源站状态变化，用于慢启动
+func (this *originBalanceStat) updateState(isOk bool, now time.Time) {
+	this.locker.Lock()
+	if isOk && !this.isOk {
+		this.recoveredAt = now
+	}
+	this.isOk = isOk
+	this.locker.Unlock()
+}
+
+// 慢启动系数，范围为 (0, 1]
+func (this *originBalanceStat) slowStartFactor(slowStart time.Duration, now time.Time) float64 {
+	if slowStart <= 0 {
+		return 1
+	}
+
+	this.locker.Lock()
+	var recoveredAt = this.recoveredAt
+	this.locker.Unlock()
+
+	if recoveredAt.IsZero() {
+		return 1
+	}
+	var elapsed = now.Sub(recoveredAt)
+	if elapsed >= slowStart {
+		return 1
+	}
+	return math.Max(originBalanceMinSlowStartFactor, float64(elapsed)/float64(slowStart))
+}
+
+// OriginBalanceRequest 单个源站请求的统计
+type OriginBalanceRequest struct {
+	stat      *originBalanceStat
+	startedAt time.Time
+	decayTime time.Duration
+
+	isDone int32
+}
+
+// End 收到响应Header或者失败时调用
+// 响应时间计算到收到响应Header为止，进行中的请求数则在响应Body关闭后才减少
+func (this *OriginBalanceRequest) End(resp *http.Response, err error) {
+	if this == nil {
+		return
+	}
+
+	var now = time.Now()
+	var cost = now.Sub(this.startedAt)
+	if err != nil || resp.StatusCode >= http.StatusInternalServerError {
+		// 失败的请求按照衰减时间计算，以便在一段时间内减少分配
+		cost = max(cost, this.decayTime)
+	}
+	this.stat.observe(cost, this.decayTime, now)
+
+	if err != nil || resp.Body == nil {
+		this.done()
+		return
+	}
+	resp.Body = &originBalanceBody{
+		ReadCloser: resp.Body,
+		req:        this,
+	}
+}
+
+// 请求结束
+func (this *OriginBalanceRequest) done() {
+	if atomic.CompareAndSwapInt32(&this.isDone, 0, 1) {
+		atomic.AddInt64(&this.stat.inFlight, -1)
+	}
+}
+
+// 响应Body关闭时结束请求
+type originBalanceBody struct {
+	io.ReadCloser
+
+	req *OriginBalanceRequest
+}
+
+func (this *originBalanceBody) Close() error {
+	var err = this.ReadCloser.Close()
+	this.req.done()
+	return err
+}
+
+// OriginBalancer 根据源站实际响应时间和进行中请求数选择源站
+type OriginBalancer struct {
+	config  *OriginBalanceConfig
+	statMap map[int64]*originBalanceStat // originId => stat
+
+	locker sync.RWMutex
+}
+
+// NewOriginBalancer 获取新对象
+func NewOriginBalancer() *OriginBalancer {
+	return &OriginBalancer{
+		config:  DefaultOriginBalanceConfig(),
+		statMap: map[int64]*originBalanceStat{},
+	}
+}
+
+// UpdateConfig 修改配置
+func (this *OriginBalancer) UpdateConfig(config *OriginBalanceConfig) {
+	if config == nil {
+		return
+	}
+	this.locker.Lock()
+	this.config = config
+	this.locker.Unlock()
+}
+
+// FindPolicy 查找适用的策略
+func (this *OriginBalancer) FindPolicy(serverId int64, reverseProxy *serverconfigs.ReverseProxyConfig) *OriginBalancePolicy {
+	if reverseProxy == nil {
+		return nil
+	}
+
+	this.locker.RLock()
+	defer this.locker.RUnlock()
+
+	if !this.config.IsOn {
+		return nil
+	}
+	for _, policy := range this.config.Policies {
+		if policy.MatchReverseProxy(serverId, reverseProxy.Id) {
+			return policy
+		}
+	}
+	return nil
+}
+
+// Pick 选择源站
+// 没有适用的策略、策略中没有明确选择调度算法或者没有可用的源站时返回nil，此时应该使用反向代理中设置的调度算法
+func (this *OriginBalancer) Pick(serverId int64, reverseProxy *serverconfigs.ReverseProxyConfig, excludingOriginIds []int64) *serverconfigs.OriginConfig {
+	var policy = this.FindPolicy(serverId, reverseProxy)
+	if policy == nil || len(policy.Strategy) == 0 {
+		return nil
+	}
+
+	var now = time.Now()
+	var candidates = this.candidates(reverseProxy.PrimaryOrigins, excludingOriginIds, now)
+	if len(candidates) == 0 {
+		candidates = this.candidates(reverseProxy.BackupOrigins, excludingOriginIds, now)
+	}
+
+	switch len(candidates) {
+	case 0:
+		return nil
+	case 1:
+		return candidates[0]
+	}
+
+	var slowStart = time.Duration(policy.SlowStart) * time.Second
+	switch policy.Strategy {
+	case OriginBalanceStrategyLeastOutstanding:
+		var bestOrigin *serverconfigs.OriginConfig
+		var bestScore float64
+		// 从随机位置开始，避免分数相同时总是选择第一个
+		var offset = rand.Intn(len(candidates))
+		for i := range candidates {
+			var origin = candidates[(i+offset)%len(candidates)]
+			var score = this.score(origin, false, slowStart, now)
+			if bestOrigin == nil || score < bestScore {
+				bestOrigin = origin
+				bestScore = score
+			}
+		}
+		return bestOrigin
+	default: // p2c-ewma
+		var index1 = rand.Intn(len(candidates))
+		var index2 = rand.Intn(len(candidates) - 1)
+		if index2 >= index1 {
+			index2++
+		}
+		var origin1 = candidates[index1]
+		var origin2 = candidates[index2]
+		if this.score(origin2, true, slowStart, now) < this.score(origin1, true, slowStart, now) {
+			return origin2
+		}
+		return origin1
+	}
+}
+
+// Begin 开始请求源站
+// 没有适用的策略时返回nil，返回的对象需要在收到响应或者失败后调用 End()
+func (this *OriginBalancer) Begin(serverId int64, reverseProxy *serverconfigs.ReverseProxyConfig, origin *serverconfigs.OriginConfig) *OriginBalanceRequest {
+	if origin == nil || origin.Id <= 0 {
+		return nil
+	}
+	var policy = this.FindPolicy(serverId, reverseProxy)
+	if policy == nil {
+		return nil
+	}
+
+	var stat = this.findStat(origin.Id)
+	atomic.AddInt64(&stat.inFlight, 1)
+	return &OriginBalanceRequest{
+		stat:      stat,
+		startedAt: time.Now(),
+		decayTime: time.Duration(policy.DecayTime) * time.Second,
+	}
+}
+
+// Stats 源站统计数据
+func (this *OriginBalancer) Stats() []maps.Map {
+	this.locker.RLock()
+	defer this.locker.RUnlock()
+
+	var result = []maps.Map{}
+	for originId, stat := range this.statMap {
+		stat.locker.Lock()
+		var ewma = stat.ewma
+		stat.locker.Unlock()
+
+		result = append(result, maps.Map{
+			"originId": originId,
+			"inFlight": atomic.LoadInt64(&stat.inFlight),
+			"ewmaMs":   math.Round(ewma/float64(time.Millisecond)*100) / 100,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].GetInt64("originId") < result[j].GetInt64("originId")
+	})
+	return result
+}
+
+// 可用的源站
+func (this *OriginBalancer) candidates(origins []*serverconfigs.OriginConfig, excludingOriginIds []int64, now time.Time) []*serverconfigs.OriginConfig {
+	var result = []*serverconfigs.OriginConfig{}
+	for _, origin := range origins {
+		if origin == nil || !origin.IsOn {
+			continue
+		}
+
+		// 记录状态变化，以便于慢启动
+		if origin.Id > 0 {
+			this.findStat(origin.Id).updateState(origin.IsOk, now)
+		}
+
+		if !origin.IsOk || origin.CandidateWeight() == 0 || lists.ContainsInt64(excludingOriginIds, origin.Id) {
+			continue
+		}
+		result = append(result, origin)
+	}
+	return result
+}
+
+// 计算源站的分数，分数越小越优先
+func (this *OriginBalancer) score(origin *serverconfigs.OriginConfig, useEWMA bool, slowStart time.Duration, now time.Time) float64 {
+	var stat = this.findStat(origin.Id)
+	var score = float64(atomic.LoadInt64(&stat.inFlight) + 1)
+
+	if useEWMA {
+		stat.locker.Lock()
+		var ewma = stat.ewma
+		stat.locker.Unlock()
+
+		// 尚未有响应时间时使用1ms，让新源站也有机会被选中
+		score *= math.Max(ewma, float64(time.Millisecond))
+	}
+
+	var weight = float64(origin.CandidateWeight())
+	if weight <= 0 {
+		weight = 1
+	}
+	return score / (weight * stat.slowStartFactor(slowStart, now))
+}
+
+func (this *OriginBalancer) findStat(originId int64) *originBalanceStat {
+	this.locker.RLock()
+	stat, ok := this.statMap[originId]
+	this.locker.RUnlock()
+	if ok {
+		return stat
+	}
+
+	this.locker.Lock()
+	defer this.locker.Unlock()
+	stat, ok = this.statMap[originId]
+	if !ok {
+		// 新源站不需要慢启动
+		stat = &originBalanceStat{isOk: true}
+		this.statMap[originId] = stat
+	}
+	return stat
+}
@@ -0,0 +1,149 @@
+// Copyright 2024 GoEdge CDN goedge.cdn@gmail.com. All rights reserved. Official site: https://goedge.cloud .
+
+package nodes
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/TeaOSLab/EdgeCommon/pkg/serverconfigs"
+	"github.com/iwind/TeaGo/assert"
+)
+
+func newTestOriginBalancer(t *testing.T, policy *OriginBalancePolicy) (balancer *OriginBalancer, reverseProxy *serverconfigs.ReverseProxyConfig) {
+	var config = &OriginBalanceConfig{
+		IsOn:     true,
+		Policies: []*OriginBalancePolicy{policy},
+	}
+	err := config.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	balancer = NewOriginBalancer()
+	balancer.UpdateConfig(config)
+
+	reverseProxy = &serverconfigs.ReverseProxyConfig{
+		Id:   1,
+		IsOn: true,
+		PrimaryOrigins: []*serverconfigs.OriginConfig{
+			{Id: 1, IsOn: true, IsOk: true, Weight: 10},
+			{Id: 2, IsOn: true, IsOk: true, Weight: 10},
+		},
+		BackupOrigins: []*serverconfigs.OriginConfig{
+			{Id: 3, IsOn: true, IsOk: true, Weight: 10},
+		},
+	}
+	return
+}
+
+func TestOriginBalancer_LeastOutstanding(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	balancer, reverseProxy := newTestOriginBalancer(t, &OriginBalancePolicy{Strategy: OriginBalanceStrategyLeastOutstanding})
+	var origin1 = reverseProxy.PrimaryOrigins[0]
+
+	// 进行中的请求数在响应Body关闭后才减少
+	var balanceReq = balancer.Begin(1, reverseProxy, origin1)
+	var resp = &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewReader(nil))}
+	balanceReq.End(resp, nil)
+	for i := 0; i < 10; i++ {
+		a.IsTrue(balancer.Pick(1, reverseProxy, nil).Id == 2)
+	}
+	_ = resp.Body.Close()
+	_ = resp.Body.Close()
+	a.IsTrue(balancer.findStat(origin1.Id).inFlight == 0)
+
+	// 排除失败的源站
+	a.IsTrue(balancer.Pick(1, reverseProxy, []int64{2}).Id == 1)
+
+	// 主源站都不可用时使用备用源站
+	a.IsTrue(balancer.Pick(1, reverseProxy, []int64{1, 2}).Id == 3)
+	reverseProxy.BackupOrigins[0].IsOk = false
+	a.IsNil(balancer.Pick(1, reverseProxy, []int64{1, 2}))
+
+	// 不适用的网站
+	balancer, reverseProxy = newTestOriginBalancer(t, &OriginBalancePolicy{ServerIds: []int64{2}, Strategy: OriginBalanceStrategyLeastOutstanding})
+	a.IsNil(balancer.Pick(1, reverseProxy, nil))
+	a.IsNotNil(balancer.Pick(2, reverseProxy, nil))
+
+	// 没有明确选择调度算法时使用反向代理中设置的调度算法
+	balancer, reverseProxy = newTestOriginBalancer(t, &OriginBalancePolicy{})
+	a.IsNil(balancer.Pick(1, reverseProxy, nil))
+	a.IsNotNil(balancer.Begin(1, reverseProxy, origin1))
+}
+
+func TestOriginBalancer_P2CEWMA(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	balancer, reverseProxy := newTestOriginBalancer(t, &OriginBalancePolicy{Strategy: OriginBalanceStrategyP2CEWMA})
+	var now = time.Now()
+	balancer.findStat(1).observe(500*time.Millisecond, 10*time.Second, now)
+	balancer.findStat(2).observe(5*time.Millisecond, 10*time.Second, now)
+
+	// 只有两个源站时总是比较这两个源站
+	for i := 0; i < 10; i++ {
+		a.IsTrue(balancer.Pick(1, reverseProxy, nil).Id == 2)
+	}
+
+	// 较小的响应时间随时间逐步生效，较大的立即生效
+	var stat = balancer.findStat(1)
+	stat.observe(5*time.Millisecond, 10*time.Second, now.Add(1*time.Second))
+	a.IsTrue(stat.ewma > float64(400*time.Millisecond))
+	stat.observe(5*time.Millisecond, 10*time.Second, now.Add(60*time.Second))
+	a.IsTrue(stat.ewma < float64(10*time.Millisecond))
+	stat.observe(1*time.Second, 10*time.Second, now.Add(61*time.Second))
+	a.IsTrue(stat.ewma == float64(1*time.Second))
+
+	// 失败的请求
+	balancer.Begin(1, reverseProxy, reverseProxy.PrimaryOrigins[1]).End(nil, errors.New("connection refused"))
+	a.IsTrue(balancer.findStat(2).ewma >= float64(10*time.Second))
+	a.IsTrue(balancer.findStat(2).inFlight == 0)
+	a.IsTrue(len(balancer.Stats()) == 2)
+}
+
+func TestOriginBalancer_SlowStart(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	balancer, reverseProxy := newTestOriginBalancer(t, &OriginBalancePolicy{
+		Strategy:  OriginBalanceStrategyLeastOutstanding,
+		SlowStart: 30,
+	})
+	var origin1 = reverseProxy.PrimaryOrigins[0]
+
+	// 初次出现的源站不需要慢启动
+	a.IsNotNil(balancer.Pick(1, reverseProxy, nil))
+	var stat = balancer.findStat(origin1.Id)
+	a.IsTrue(stat.slowStartFactor(30*time.Second, time.Now()) == 1)
+
+	origin1.IsOk = false
+	a.IsTrue(balancer.Pick(1, reverseProxy, nil).Id == 2)
+	origin1.IsOk = true
+	a.IsTrue(balancer.Pick(1, reverseProxy, nil).Id == 2)
+
+	var now = time.Now()
+	a.IsTrue(stat.slowStartFactor(30*time.Second, now) == originBalanceMinSlowStartFactor)
+	a.IsTrue(stat.slowStartFactor(30*time.Second, now.Add(15*time.Second)) > 0.45)
+	a.IsTrue(stat.slowStartFactor(30*time.Second, now.Add(30*time.Second)) == 1)
+
+	// 慢启动期间，即使其他源站有进行中的请求也优先选择其他源站
+	var balanceReq = balancer.Begin(1, reverseProxy, reverseProxy.PrimaryOrigins[1])
+	a.IsTrue(balancer.Pick(1, reverseProxy, nil).Id == 2)
+	balanceReq.End(&http.Response{StatusCode: http.StatusOK}, nil)
+}
+
+func TestOriginBalanceConfig_Init(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	a.IsNotNil((&OriginBalanceConfig{Policies: []*OriginBalancePolicy{{Strategy: "random"}}}).Init())
+	a.IsNotNil((&OriginBalanceConfig{Policies: []*OriginBalancePolicy{{SlowStart: -1}}}).Init())
+
+	var policy = &OriginBalancePolicy{}
+	a.IsNil(policy.Init())
+	a.IsTrue(len(policy.Strategy) == 0)
+	a.IsTrue(policy.DecayTime == 10)
+}
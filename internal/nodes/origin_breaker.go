@@ -0,0 +1,446 @@
+// Copyright 2024 GoEdge CDN goedge.cdn@gmail.com. All rights reserved. Official site: https://goedge.cloud .
+
+package nodes
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/TeaOSLab/EdgeCommon/pkg/serverconfigs"
+	"github.com/TeaOSLab/EdgeNode/internal/configs"
+	teaconst "github.com/TeaOSLab/EdgeNode/internal/const"
+	"github.com/TeaOSLab/EdgeNode/internal/events"
+	"github.com/TeaOSLab/EdgeNode/internal/remotelogs"
+	"github.com/iwind/TeaGo/lists"
+	"github.com/iwind/TeaGo/maps"
+	"github.com/iwind/TeaGo/types"
+)
+
+// OriginBreakerConfigFileName 源站熔断配置文件
+const OriginBreakerConfigFileName = "origin_breaker.yaml"
+
+// OriginBreakerState 熔断器状态
+type OriginBreakerState = string
+
+const (
+	OriginBreakerStateClosed   OriginBreakerState = "closed"    // 正常
+	OriginBreakerStateOpen     OriginBreakerState = "open"      // 熔断中
+	OriginBreakerStateHalfOpen OriginBreakerState = "half-open" // 探测中
+)
+
+var SharedOriginBreakerManager = NewOriginBreakerManager()
+
+func init() {
+	if !teaconst.IsMain {
+		return
+	}
+
+	events.On(events.EventLoaded, func() {
+		config, err := LoadOriginBreakerConfig()
+		if err != nil {
+			remotelogs.Error("ORIGIN_BREAKER", "load '"+OriginBreakerConfigFileName+"' failed: "+err.Error())
+			return
+		}
+		SharedOriginBreakerManager.UpdateConfig(config)
+	})
+}
+
+// OriginBreakerConfig 源站熔断配置
+type OriginBreakerConfig struct {
+	IsOn     bool                   `yaml:"isOn" json:"isOn"`         // 是否启用
+	Policies []*OriginBreakerPolicy `yaml:"policies" json:"policies"` // 策略列表
+}
+
+// DefaultOriginBreakerConfig 默认配置
+func DefaultOriginBreakerConfig() *OriginBreakerConfig {
+	return &OriginBreakerConfig{
+		IsOn: true,
+	}
+}
+
+// Init 初始化
+func (this *OriginBreakerConfig) Init() error {
+	for index, policy := range this.Policies {
+		if policy == nil {
+			return errors.New("policy #" + types.String(index+1) + " should not be empty")
+		}
+		err := policy.Init()
+		if err != nil {
+			return errors.New("policy #" + types.String(index+1) + ": " + err.Error())
+		}
+	}
+	return nil
+}
+
+// LoadOriginBreakerConfig 从配置文件中加载源站熔断配置
+// 如果配置文件不存在，则返回默认配置
+func LoadOriginBreakerConfig() (*OriginBreakerConfig, error) {
+	return configs.LoadLocalConfig(OriginBreakerConfigFileName, DefaultOriginBreakerConfig())
+}
+
+// OriginBreakerPolicy 单个源站熔断策略
+type OriginBreakerPolicy struct {
+	ServerIds       []int64 `yaml:"serverIds" json:"serverIds"`             // 适用的网站ID
+	ReverseProxyIds []int64 `yaml:"reverseProxyIds" json:"reverseProxyIds"` // 适用的反向代理ID，和网站ID都为空时表示所有网站
+	OriginIds       []int64 `yaml:"originIds" json:"originIds"`             // 适用的源站ID，为空表示所有源站
+
+	Window              int     `yaml:"window" json:"window"`                           // 统计错误率的时间窗口，单位：秒
+	MinRequests         int64   `yaml:"minRequests" json:"minRequests"`                 // 计算错误率需要的最少请求数
+	ErrorRate           float64 `yaml:"errorRate" json:"errorRate"`                     // 错误率达到此百分比时熔断，0表示不检查
+	ConsecutiveFailures int64   `yaml:"consecutiveFailures" json:"consecutiveFailures"` // 连续失败（5xx、超时、连接错误）达到此次数时熔断，0表示不检查
+	OpenTimeout         int     `yaml:"openTimeout" json:"openTimeout"`                 // 熔断持续时间，之后进入探测状态，单位：秒
+	HalfOpenRequests    int64   `yaml:"halfOpenRequests" json:"halfOpenRequests"`       // 探测状态下允许的请求数，全部成功后恢复正常
+
+	FallbackOriginId int64 `yaml:"fallbackOriginId" json:"fallbackOriginId"` // 所有源站都熔断时使用的备用源站ID
+	ServeStale       bool  `yaml:"serveStale" json:"serveStale"`             // 所有源站都熔断时是否尝试使用过期缓存，需要在缓存策略中启用过期缓存
+}
+
+// Init 初始化
+func (this *OriginBreakerPolicy) Init() error {
+	if this.Window <= 0 {
+		this.Window = 10
+	}
+	if this.MinRequests <= 0 {
+		this.MinRequests = 20
+	}
+	if this.ErrorRate < 0 || this.ErrorRate > 100 {
+		return errors.New("'errorRate' should be between 0 and 100")
+	}
+	if this.ConsecutiveFailures < 0 {
+		return errors.New("'consecutiveFailures' should not be negative")
+	}
+	if this.ErrorRate == 0 && this.ConsecutiveFailures == 0 {
+		return errors.New("'errorRate' or 'consecutiveFailures' should be set")
+	}
+	if this.OpenTimeout <= 0 {
+		this.OpenTimeout = 30
+	}
+	if this.HalfOpenRequests <= 0 {
+		this.HalfOpenRequests = 3
+	}
+	return nil
+}
+
+// Match 检查是否适用于某个网站反向代理中的源站
+func (this *OriginBreakerPolicy) Match(serverId int64, reverseProxyId int64, originId int64) bool {
+	if len(this.OriginIds) > 0 && !lists.ContainsInt64(this.OriginIds, originId) {
+		return false
+	}
+	if len(this.ServerIds) == 0 && len(this.ReverseProxyIds) == 0 {
+		return true
+	}
+	return lists.ContainsInt64(this.ServerIds, serverId) || lists.ContainsInt64(this.ReverseProxyIds, reverseProxyId)
+}
+
+// 单个源站的熔断器
+type originBreaker struct {
+	policy *OriginBreakerPolicy
+
+	state              OriginBreakerState
+	seconds            []int64 // 按秒分桶
+	requests           []int64
+	failures           []int64
+	consecutiveFailure int64
+	openedAt           time.Time
+	countOpens         int64 // 熔断次数
+
+	probes         int64 // 探测状态下已发出的请求数
+	probeSuccesses int64 // 探测状态下成功的请求数
+}
+
+func newOriginBreaker(policy *OriginBreakerPolicy) *originBreaker {
+	return &originBreaker{
+		policy:   policy,
+		state:    OriginBreakerStateClosed,
+		seconds:  make([]int64, policy.Window),
+		requests: make([]int64, policy.Window),
+		failures: make([]int64, policy.Window),
+	}
+}
+
+// 统计窗口内的请求数和失败数
+func (this *originBreaker) counts(now int64) (requests int64, failures int64) {
+	var window = int64(len(this.seconds))
+	for index, second := range this.seconds {
+		if second > now-window && second <= now {
+			requests += this.requests[index]
+			failures += this.failures[index]
+		}
+	}
+	return
+}
+
+func (this *originBreaker) record(isOk bool, now time.Time) {
+	var second = now.Unix()
+	var index = int(second % int64(len(this.seconds)))
+	if this.seconds[index] != second {
+		this.seconds[index] = second
+		this.requests[index] = 0
+		this.failures[index] = 0
+	}
+	this.requests[index]++
+	if !isOk {
+		this.failures[index]++
+	}
+}
+
+func (this *originBreaker) open(now time.Time) {
+	this.state = OriginBreakerStateOpen
+	this.openedAt = now
+	this.countOpens++
+	this.probes = 0
+	this.probeSuccesses = 0
+}
+
+func (this *originBreaker) close() {
+	this.state = OriginBreakerStateClosed
+	this.consecutiveFailure = 0
+	this.probes = 0
+	this.probeSuccesses = 0
+	for index := range this.seconds {
+		this.seconds[index] = 0
+		this.requests[index] = 0
+		this.failures[index] = 0
+	}
+}
+
+// OriginBreakerTicket 熔断器允许的单次请求
+// 请求结束后需要调用 Success()、Failure() 或 Cancel() 中的一个
+type OriginBreakerTicket struct {
+	manager  *OriginBreakerManager
+	serverId int64
+	originId int64
+	breaker  *originBreaker
+	isProbe  bool
+	isDone   bool
+}
+
+// Success 请求成功
+func (this *OriginBreakerTicket) Success() {
+	if this == nil {
+		return
+	}
+	this.manager.report(this, true, false)
+}
+
+// Failure 请求失败
+func (this *OriginBreakerTicket) Failure() {
+	if this == nil {
+		return
+	}
+	this.manager.report(this, false, false)
+}
+
+// Cancel 请求被取消，不计入统计
+func (this *OriginBreakerTicket) Cancel() {
+	if this == nil {
+		return
+	}
+	this.manager.report(this, false, true)
+}
+
+// OriginBreakerManager 源站熔断管理
+type OriginBreakerManager struct {
+	config     *OriginBreakerConfig
+	breakerMap map[int64]*originBreaker // originId => breaker
+
+	locker sync.Mutex
+}
+
+// NewOriginBreakerManager 获取新对象
+func NewOriginBreakerManager() *OriginBreakerManager {
+	return &OriginBreakerManager{
+		config:     DefaultOriginBreakerConfig(),
+		breakerMap: map[int64]*originBreaker{},
+	}
+}
+
+// UpdateConfig 修改配置
+func (this *OriginBreakerManager) UpdateConfig(config *OriginBreakerConfig) {
+	if config == nil {
+		return
+	}
+	this.locker.Lock()
+	this.config = config
+	this.breakerMap = map[int64]*originBreaker{}
+	this.locker.Unlock()
+}
+
+// FindPolicy 查找适用的策略
+func (this *OriginBreakerManager) FindPolicy(serverId int64, reverseProxy *serverconfigs.ReverseProxyConfig, originId int64) *OriginBreakerPolicy {
+	if reverseProxy == nil || originId <= 0 {
+		return nil
+	}
+
+	this.locker.Lock()
+	defer this.locker.Unlock()
+	return this.findPolicy(serverId, reverseProxy.Id, originId)
+}
+
+// Acquire 检查是否允许请求某个源站
+// 允许时返回的ticket不为nil；没有适用的策略时返回 (true, nil)
+func (this *OriginBreakerManager) Acquire(serverId int64, reverseProxy *serverconfigs.ReverseProxyConfig, origin *serverconfigs.OriginConfig) (allowed bool, ticket *OriginBreakerTicket) {
+	if reverseProxy == nil || origin == nil || origin.Id <= 0 {
+		return true, nil
+	}
+
+	var now = time.Now()
+
+	this.locker.Lock()
+	defer this.locker.Unlock()
+
+	var policy = this.findPolicy(serverId, reverseProxy.Id, origin.Id)
+	if policy == nil {
+		return true, nil
+	}
+
+	breaker, ok := this.breakerMap[origin.Id]
+	if !ok || breaker.policy != policy {
+		breaker = newOriginBreaker(policy)
+		this.breakerMap[origin.Id] = breaker
+	}
+
+	switch breaker.state {
+	case OriginBreakerStateOpen:
+		if now.Sub(breaker.openedAt) < time.Duration(policy.OpenTimeout)*time.Second {
+			return false, nil
+		}
+		breaker.state = OriginBreakerStateHalfOpen
+		breaker.probes = 0
+		breaker.probeSuccesses = 0
+		remotelogs.ServerSuccess(serverId, "ORIGIN_BREAKER", "origin '"+types.String(origin.Id)+"' circuit breaker is half-open, probing", "", nil)
+		fallthrough
+	case OriginBreakerStateHalfOpen:
+		if breaker.probes >= policy.HalfOpenRequests {
+			return false, nil
+		}
+		breaker.probes++
+		return true, &OriginBreakerTicket{
+			manager:  this,
+			serverId: serverId,
+			originId: origin.Id,
+			breaker:  breaker,
+			isProbe:  true,
+		}
+	}
+
+	return true, &OriginBreakerTicket{
+		manager:  this,
+		serverId: serverId,
+		originId: origin.Id,
+		breaker:  breaker,
+	}
+}
+
+// States 熔断器状态
+func (this *OriginBreakerManager) States() []maps.Map {
+	this.locker.Lock()
+	defer this.locker.Unlock()
+
+	var now = time.Now().Unix()
+	var result = []maps.Map{}
+	for originId, breaker := range this.breakerMap {
+		requests, failures := breaker.counts(now)
+		var openedAt int64
+		if !breaker.openedAt.IsZero() {
+			openedAt = breaker.openedAt.Unix()
+		}
+		result = append(result, maps.Map{
+			"originId":            originId,
+			"state":               breaker.state,
+			"requests":            requests,
+			"failures":            failures,
+			"consecutiveFailures": breaker.consecutiveFailure,
+			"countOpens":          breaker.countOpens,
+			"openedAt":            openedAt,
+			"probes":              breaker.probes,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].GetInt64("originId") < result[j].GetInt64("originId")
+	})
+	return result
+}
+
+func (this *OriginBreakerManager) report(ticket *OriginBreakerTicket, isOk bool, isCanceled bool) {
+	if ticket == nil {
+		return
+	}
+
+	this.locker.Lock()
+	defer this.locker.Unlock()
+
+	if ticket.isDone {
+		return
+	}
+	ticket.isDone = true
+
+	// 配置已经变化
+	var breaker = ticket.breaker
+	if this.breakerMap[ticket.originId] != breaker {
+		return
+	}
+	var policy = breaker.policy
+	var now = time.Now()
+
+	if ticket.isProbe {
+		if breaker.state != OriginBreakerStateHalfOpen {
+			return
+		}
+		if isCanceled {
+			breaker.probes--
+			return
+		}
+		if !isOk {
+			breaker.open(now)
+			remotelogs.ServerError(ticket.serverId, "ORIGIN_BREAKER", "origin '"+types.String(ticket.originId)+"' circuit breaker is open again: probe failed", "", nil)
+			return
+		}
+		breaker.probeSuccesses++
+		if breaker.probeSuccesses >= policy.HalfOpenRequests {
+			breaker.close()
+			remotelogs.ServerSuccess(ticket.serverId, "ORIGIN_BREAKER", "origin '"+types.String(ticket.originId)+"' circuit breaker is closed", "", nil)
+		}
+		return
+	}
+
+	if isCanceled || breaker.state != OriginBreakerStateClosed {
+		return
+	}
+
+	breaker.record(isOk, now)
+	if isOk {
+		breaker.consecutiveFailure = 0
+		return
+	}
+	breaker.consecutiveFailure++
+
+	if policy.ConsecutiveFailures > 0 && breaker.consecutiveFailure >= policy.ConsecutiveFailures {
+		breaker.open(now)
+		remotelogs.ServerError(ticket.serverId, "ORIGIN_BREAKER", "origin '"+types.String(ticket.originId)+"' circuit breaker is open: "+types.String(breaker.consecutiveFailure)+" consecutive failures", "", nil)
+		return
+	}
+
+	if policy.ErrorRate > 0 {
+		requests, failures := breaker.counts(now.Unix())
+		if requests >= policy.MinRequests && float64(failures)*100/float64(requests) >= policy.ErrorRate {
+			breaker.open(now)
+			remotelogs.ServerError(ticket.serverId, "ORIGIN_BREAKER", "origin '"+types.String(ticket.originId)+"' circuit breaker is open: "+types.String(failures)+" failures in "+types.String(requests)+" requests", "", nil)
+		}
+	}
+}
+
+// 查找策略，调用前需要加锁
+func (this *OriginBreakerManager) findPolicy(serverId int64, reverseProxyId int64, originId int64) *OriginBreakerPolicy {
+	if !this.config.IsOn {
+		return nil
+	}
+	for _, policy := range this.config.Policies {
+		if policy.Match(serverId, reverseProxyId, originId) {
+			return policy
+		}
+	}
+	return nil
+}
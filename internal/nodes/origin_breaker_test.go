@@ -0,0 +1,122 @@
+// Copyright 2024 GoEdge CDN goedge.cdn@gmail.com. All rights reserved. Official site: https://goedge.cloud .
+
+package nodes
+
+import (
+	"testing"
+	"time"
+
+	"github.com/TeaOSLab/EdgeCommon/pkg/serverconfigs"
+	"github.com/iwind/TeaGo/assert"
+)
+
+func newTestOriginBreakerManager(t *testing.T, policy *OriginBreakerPolicy) *OriginBreakerManager {
+	var config = &OriginBreakerConfig{
+		IsOn:     true,
+		Policies: []*OriginBreakerPolicy{policy},
+	}
+	err := config.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var manager = NewOriginBreakerManager()
+	manager.UpdateConfig(config)
+	return manager
+}
+
+func TestOriginBreakerConfig_Init(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	a.IsNotNil((&OriginBreakerConfig{Policies: []*OriginBreakerPolicy{{}}}).Init())
+	a.IsNotNil((&OriginBreakerConfig{Policies: []*OriginBreakerPolicy{{ErrorRate: 101}}}).Init())
+
+	var policy = &OriginBreakerPolicy{ConsecutiveFailures: 3}
+	a.IsNil((&OriginBreakerConfig{Policies: []*OriginBreakerPolicy{policy}}).Init())
+	a.IsTrue(policy.Window == 10)
+	a.IsTrue(policy.OpenTimeout == 30)
+	a.IsTrue(policy.HalfOpenRequests == 3)
+}
+
+func TestOriginBreakerManager_ConsecutiveFailures(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	var manager = newTestOriginBreakerManager(t, &OriginBreakerPolicy{ConsecutiveFailures: 3, HalfOpenRequests: 2})
+	var reverseProxy = &serverconfigs.ReverseProxyConfig{Id: 1}
+	var origin = &serverconfigs.OriginConfig{Id: 1}
+
+	for i := 0; i < 3; i++ {
+		allowed, ticket := manager.Acquire(1, reverseProxy, origin)
+		a.IsTrue(allowed)
+		a.IsNotNil(ticket)
+		ticket.Failure()
+		ticket.Failure() // 重复调用无效
+	}
+	allowed, _ := manager.Acquire(1, reverseProxy, origin)
+	a.IsFalse(allowed)
+	a.IsTrue(manager.States()[0].GetString("state") == OriginBreakerStateOpen)
+
+	// 进入探测状态
+	manager.breakerMap[origin.Id].openedAt = time.Now().Add(-time.Minute)
+	allowed1, ticket1 := manager.Acquire(1, reverseProxy, origin)
+	allowed2, ticket2 := manager.Acquire(1, reverseProxy, origin)
+	allowed3, _ := manager.Acquire(1, reverseProxy, origin)
+	a.IsTrue(allowed1)
+	a.IsTrue(allowed2)
+	a.IsFalse(allowed3)
+	a.IsTrue(manager.States()[0].GetString("state") == OriginBreakerStateHalfOpen)
+
+	// 取消的探测请求不计入
+	ticket1.Cancel()
+	allowed1, ticket1 = manager.Acquire(1, reverseProxy, origin)
+	a.IsTrue(allowed1)
+
+	ticket1.Success()
+	ticket2.Success()
+	a.IsTrue(manager.States()[0].GetString("state") == OriginBreakerStateClosed)
+
+	// 探测失败时重新熔断
+	for i := 0; i < 3; i++ {
+		_, ticket := manager.Acquire(1, reverseProxy, origin)
+		ticket.Failure()
+	}
+	manager.breakerMap[origin.Id].openedAt = time.Now().Add(-time.Minute)
+	_, ticket := manager.Acquire(1, reverseProxy, origin)
+	ticket.Failure()
+	allowed, _ = manager.Acquire(1, reverseProxy, origin)
+	a.IsFalse(allowed)
+	a.IsTrue(manager.States()[0].GetInt("countOpens") == 3)
+}
+
+func TestOriginBreakerManager_ErrorRate(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	var manager = newTestOriginBreakerManager(t, &OriginBreakerPolicy{ErrorRate: 50, MinRequests: 10})
+	var reverseProxy = &serverconfigs.ReverseProxyConfig{Id: 1}
+	var origin = &serverconfigs.OriginConfig{Id: 1}
+
+	for i := 0; i < 9; i++ {
+		_, ticket := manager.Acquire(1, reverseProxy, origin)
+		if i%2 == 0 {
+			ticket.Failure()
+		} else {
+			ticket.Success()
+		}
+	}
+	allowed, ticket := manager.Acquire(1, reverseProxy, origin)
+	a.IsTrue(allowed)
+	ticket.Failure()
+
+	allowed, _ = manager.Acquire(1, reverseProxy, origin)
+	a.IsFalse(allowed)
+}
+
+func TestOriginBreakerManager_NoPolicy(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	var manager = newTestOriginBreakerManager(t, &OriginBreakerPolicy{OriginIds: []int64{2}, ConsecutiveFailures: 1})
+	allowed, ticket := manager.Acquire(1, &serverconfigs.ReverseProxyConfig{Id: 1}, &serverconfigs.OriginConfig{Id: 1})
+	a.IsTrue(allowed)
+	a.IsNil(ticket)
+	ticket.Failure() // nil ticket
+}
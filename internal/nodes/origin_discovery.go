@@ -0,0 +1,706 @@
+// Copyright 2024 GoEdge CDN goedge.cdn@gmail.com. All rights reserved. Official site: https://goedge.cloud .
+
+package nodes
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/TeaOSLab/EdgeCommon/pkg/configutils"
+	"github.com/TeaOSLab/EdgeNode/internal/configs"
+	teaconst "github.com/TeaOSLab/EdgeNode/internal/const"
+	"github.com/TeaOSLab/EdgeNode/internal/events"
+	"github.com/TeaOSLab/EdgeNode/internal/remotelogs"
+	"github.com/TeaOSLab/EdgeNode/internal/utils"
+	"github.com/TeaOSLab/EdgeNode/internal/utils/goman"
+	"github.com/fsnotify/fsnotify"
+	"github.com/iwind/TeaGo/Tea"
+	"github.com/iwind/TeaGo/maps"
+	"github.com/iwind/TeaGo/types"
+)
+
+// OriginDiscoveryConfigFileName 源站服务发现配置文件
+const OriginDiscoveryConfigFileName = "origin_discovery.yaml"
+
+// OriginDiscoveryType 服务发现类型
+type OriginDiscoveryType = string
+
+const (
+	OriginDiscoveryTypeDNS  OriginDiscoveryType = "dns"  // 解析域名的A/AAAA记录
+	OriginDiscoveryTypeSRV  OriginDiscoveryType = "srv"  // 解析SRV记录
+	OriginDiscoveryTypeFile OriginDiscoveryType = "file" // 从本地文件或者目录中读取
+)
+
+var SharedOriginDiscoveryManager = NewOriginDiscoveryManager()
+
+func init() {
+	if !teaconst.IsMain {
+		return
+	}
+
+	events.On(events.EventLoaded, func() {
+		config, err := LoadOriginDiscoveryConfig()
+		if err != nil {
+			remotelogs.Error("ORIGIN_DISCOVERY", "load '"+OriginDiscoveryConfigFileName+"' failed: "+err.Error())
+		} else {
+			SharedOriginDiscoveryManager.UpdateConfig(config)
+		}
+
+		goman.New(func() {
+			SharedOriginDiscoveryManager.Start()
+		})
+	})
+	events.On(events.EventQuit, func() {
+		SharedOriginDiscoveryManager.Stop()
+	})
+}
+
+// OriginDiscoveryConfig 源站服务发现配置
+type OriginDiscoveryConfig struct {
+	IsOn     bool                     `yaml:"isOn" json:"isOn"`         // 是否启用
+	Policies []*OriginDiscoveryPolicy `yaml:"policies" json:"policies"` // 策略列表
+}
+
+// DefaultOriginDiscoveryConfig 默认配置
+func DefaultOriginDiscoveryConfig() *OriginDiscoveryConfig {
+	return &OriginDiscoveryConfig{
+		IsOn: true,
+	}
+}
+
+// Init 初始化
+func (this *OriginDiscoveryConfig) Init() error {
+	var originIdMap = map[int64]bool{}
+	for index, policy := range this.Policies {
+		if policy == nil {
+			return errors.New("policy #" + types.String(index+1) + " should not be empty")
+		}
+		err := policy.Init()
+		if err != nil {
+			return errors.New("policy #" + types.String(index+1) + ": " + err.Error())
+		}
+		for _, originId := range policy.OriginIds {
+			if originIdMap[originId] {
+				return errors.New("policy #" + types.String(index+1) + ": origin '" + types.String(originId) + "' has been used in other policy")
+			}
+			originIdMap[originId] = true
+		}
+	}
+	return nil
+}
+
+// LoadOriginDiscoveryConfig 从配置文件中加载源站服务发现配置
+// 如果配置文件不存在，则返回默认配置
+func LoadOriginDiscoveryConfig() (*OriginDiscoveryConfig, error) {
+	return configs.LoadLocalConfig(OriginDiscoveryConfigFileName, DefaultOriginDiscoveryConfig())
+}
+
+// OriginDiscoveryPolicy 单个服务发现策略
+type OriginDiscoveryPolicy struct {
+	OriginIds []int64             `yaml:"originIds" json:"originIds"` // 使用服务发现的源站ID
+	Type      OriginDiscoveryType `yaml:"type" json:"type"`           // 类型：dns、srv、file
+	Name      string              `yaml:"name" json:"name"`           // 域名，类型为srv时为SRV记录名，比如 _http._tcp.example.com
+	Port      int                 `yaml:"port" json:"port"`           // 类型为dns时使用的端口
+	File      string              `yaml:"file" json:"file"`           // 类型为file时的文件或者目录路径，每行一个地址，格式为：host:port [weight]
+	Interval  int                 `yaml:"interval" json:"interval"`   // 刷新间隔，单位：秒
+
+	MaxFails     int `yaml:"maxFails" json:"maxFails"`         // 连续失败达到此次数时标记端点为不可用
+	FailTimeout  int `yaml:"failTimeout" json:"failTimeout"`   // 不可用的端点经过此时间后重新尝试，单位：秒
+	DrainTimeout int `yaml:"drainTimeout" json:"drainTimeout"` // 消失的端点等待已有请求结束的最长时间，单位：秒
+}
+
+// Init 初始化
+func (this *OriginDiscoveryPolicy) Init() error {
+	if len(this.OriginIds) == 0 {
+		return errors.New("'originIds' should not be empty")
+	}
+
+	switch this.Type {
+	case OriginDiscoveryTypeDNS:
+		if len(this.Name) == 0 {
+			return errors.New("'name' should not be empty")
+		}
+		if this.Port <= 0 || this.Port > 65535 {
+			return errors.New("'port' should be between 1 and 65535")
+		}
+	case OriginDiscoveryTypeSRV:
+		if len(this.Name) == 0 {
+			return errors.New("'name' should not be empty")
+		}
+	case OriginDiscoveryTypeFile:
+		if len(this.File) == 0 {
+			return errors.New("'file' should not be empty")
+		}
+		if !filepath.IsAbs(this.File) {
+			this.File = Tea.Root + string(os.PathSeparator) + this.File
+		}
+		this.File = filepath.Clean(this.File)
+	default:
+		return errors.New("invalid type '" + this.Type + "'")
+	}
+
+	if this.Interval <= 0 {
+		this.Interval = 30
+	}
+	if this.MaxFails <= 0 {
+		this.MaxFails = 3
+	}
+	if this.FailTimeout <= 0 {
+		this.FailTimeout = 30
+	}
+	if this.DrainTimeout <= 0 {
+		this.DrainTimeout = 60
+	}
+	return nil
+}
+
+// Source 服务发现的来源，用于日志等
+func (this *OriginDiscoveryPolicy) Source() string {
+	if this.Type == OriginDiscoveryTypeFile {
+		return this.File
+	}
+	return this.Name
+}
+
+// Discover 获取当前所有端点
+func (this *OriginDiscoveryPolicy) Discover() ([]*OriginEndpoint, error) {
+	switch this.Type {
+	case OriginDiscoveryTypeDNS:
+		ips, err := utils.LookupIPs(this.Name)
+		if err != nil {
+			return nil, err
+		}
+		var result = []*OriginEndpoint{}
+		for _, ip := range ips {
+			result = append(result, &OriginEndpoint{
+				Addr:   configutils.QuoteIP(ip) + ":" + types.String(this.Port),
+				Weight: 1,
+			})
+		}
+		return result, nil
+	case OriginDiscoveryTypeSRV:
+		records, err := utils.LookupSRV(this.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		// 只使用优先级最高（数值最小）的记录
+		var minPriority = -1
+		for _, record := range records {
+			if minPriority < 0 || int(record.Priority) < minPriority {
+				minPriority = int(record.Priority)
+			}
+		}
+
+		var result = []*OriginEndpoint{}
+		for _, record := range records {
+			if int(record.Priority) != minPriority {
+				continue
+			}
+			var target = strings.TrimSuffix(record.Target, ".")
+			ips, err := utils.LookupIPs(target)
+			if err != nil {
+				return nil, err
+			}
+			for _, ip := range ips {
+				result = append(result, &OriginEndpoint{
+					Addr:   configutils.QuoteIP(ip) + ":" + types.String(record.Port),
+					Weight: int(record.Weight),
+				})
+			}
+		}
+		return result, nil
+	case OriginDiscoveryTypeFile:
+		return this.readFile()
+	}
+	return nil, errors.New("invalid type '" + this.Type + "'")
+}
+
+// 从文件或者目录中读取端点
+func (this *OriginDiscoveryPolicy) readFile() ([]*OriginEndpoint, error) {
+	stat, err := os.Stat(this.File)
+	if err != nil {
+		return nil, err
+	}
+
+	var files = []string{this.File}
+	if stat.IsDir() {
+		entries, err := os.ReadDir(this.File)
+		if err != nil {
+			return nil, err
+		}
+		files = nil
+		for _, entry := range entries {
+			if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+				continue
+			}
+			files = append(files, filepath.Join(this.File, entry.Name()))
+		}
+	}
+
+	var result = []*OriginEndpoint{}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		endpoints, err := ParseOriginEndpoints(data)
+		if err != nil {
+			return nil, errors.New("parse '" + file + "' failed: " + err.Error())
+		}
+		result = append(result, endpoints...)
+	}
+	return result, nil
+}
+
+// ParseOriginEndpoints 分析端点列表
+// 每行一个端点，格式为：host:port [weight]，以#开头的行为注释
+func ParseOriginEndpoints(data []byte) ([]*OriginEndpoint, error) {
+	var result = []*OriginEndpoint{}
+	var scanner = bufio.NewScanner(bytes.NewReader(data))
+	var lineNumber = 0
+	for scanner.Scan() {
+		lineNumber++
+		var line = strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+
+		var fields = strings.Fields(line)
+		var addr = fields[0]
+		_, port, err := net.SplitHostPort(addr)
+		if err != nil || types.Int(port) <= 0 || types.Int(port) > 65535 {
+			return nil, errors.New("line " + types.String(lineNumber) + ": invalid address '" + addr + "'")
+		}
+
+		var weight = 1
+		if len(fields) > 1 {
+			weight = types.Int(fields[1])
+			if weight <= 0 {
+				return nil, errors.New("line " + types.String(lineNumber) + ": invalid weight '" + fields[1] + "'")
+			}
+		}
+		result = append(result, &OriginEndpoint{
+			Addr:   addr,
+			Weight: weight,
+		})
+	}
+	return result, scanner.Err()
+}
+
+// OriginEndpoint 服务发现得到的源站端点
+type OriginEndpoint struct {
+	Addr   string
+	Weight int
+
+	target *originDiscoveryTarget
+
+	isOk       bool
+	fails      int
+	failedAt   time.Time
+	drainingAt time.Time // 从服务发现中消失的时间
+	active     int64     // 正在进行的请求数
+}
+
+// IsDraining 是否已经从服务发现中消失
+func (this *OriginEndpoint) IsDraining() bool {
+	return !this.drainingAt.IsZero()
+}
+
+// Report 报告请求结果
+func (this *OriginEndpoint) Report(isOk bool) {
+	if this == nil {
+		return
+	}
+	this.target.manager.report(this, isOk)
+}
+
+// Release 请求结束
+func (this *OriginEndpoint) Release() {
+	if this == nil {
+		return
+	}
+	atomic.AddInt64(&this.active, -1)
+}
+
+func (this *OriginEndpoint) weight() int {
+	if this.Weight <= 0 {
+		return 1
+	}
+	return this.Weight
+}
+
+// 单个策略的状态
+type originDiscoveryTarget struct {
+	manager *OriginDiscoveryManager
+	policy  *OriginDiscoveryPolicy
+
+	endpoints    []*OriginEndpoint
+	refreshedAt  time.Time
+	nextAt       time.Time
+	lastErr      error
+	isRefreshing bool
+}
+
+// OriginDiscoveryManager 源站服务发现管理
+type OriginDiscoveryManager struct {
+	config    *OriginDiscoveryConfig
+	targets   []*originDiscoveryTarget
+	targetMap map[int64]*originDiscoveryTarget // originId => target
+
+	watcher *fsnotify.Watcher
+	ticker  *time.Ticker
+	locker  sync.RWMutex
+}
+
+// NewOriginDiscoveryManager 获取新对象
+func NewOriginDiscoveryManager() *OriginDiscoveryManager {
+	return &OriginDiscoveryManager{
+		config:    DefaultOriginDiscoveryConfig(),
+		targetMap: map[int64]*originDiscoveryTarget{},
+	}
+}
+
+// UpdateConfig 修改配置
+func (this *OriginDiscoveryManager) UpdateConfig(config *OriginDiscoveryConfig) {
+	if config == nil {
+		return
+	}
+
+	var targets = []*originDiscoveryTarget{}
+	var targetMap = map[int64]*originDiscoveryTarget{}
+	if config.IsOn {
+		for _, policy := range config.Policies {
+			var target = &originDiscoveryTarget{
+				manager: this,
+				policy:  policy,
+				nextAt:  time.Now(),
+			}
+			targets = append(targets, target)
+			for _, originId := range policy.OriginIds {
+				targetMap[originId] = target
+			}
+		}
+	}
+
+	this.locker.Lock()
+	this.config = config
+	this.targets = targets
+	this.targetMap = targetMap
+	this.locker.Unlock()
+}
+
+// Start 启动
+func (this *OriginDiscoveryManager) Start() {
+	// 监听文件变化
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		remotelogs.Error("ORIGIN_DISCOVERY", "create file watcher failed: "+err.Error())
+	} else {
+		this.locker.Lock()
+		this.watcher = watcher
+		for _, target := range this.targets {
+			if target.policy.Type == OriginDiscoveryTypeFile {
+				// 监听所在目录，以便于支持通过改名替换文件
+				_ = watcher.Add(filepath.Dir(target.policy.File))
+				_ = watcher.Add(target.policy.File)
+			}
+		}
+		this.locker.Unlock()
+
+		goman.New(func() {
+			for {
+				select {
+				case event, ok := <-watcher.Events:
+					if !ok {
+						return
+					}
+					this.notifyFile(event.Name)
+				case _, ok := <-watcher.Errors:
+					if !ok {
+						return
+					}
+				}
+			}
+		})
+	}
+
+	this.locker.Lock()
+	this.ticker = time.NewTicker(1 * time.Second)
+	this.locker.Unlock()
+
+	this.Loop()
+	for range this.ticker.C {
+		this.Loop()
+	}
+}
+
+// Stop 停止
+func (this *OriginDiscoveryManager) Stop() {
+	this.locker.Lock()
+	if this.ticker != nil {
+		this.ticker.Stop()
+	}
+	if this.watcher != nil {
+		_ = this.watcher.Close()
+	}
+	this.locker.Unlock()
+}
+
+// Loop 刷新已经到达刷新时间的策略，并清理已经消失的端点
+func (this *OriginDiscoveryManager) Loop() {
+	var now = time.Now()
+	var targets = []*originDiscoveryTarget{}
+
+	this.locker.Lock()
+	for _, target := range this.targets {
+		this.removeDrainedEndpoints(target, now)
+		if target.isRefreshing || target.nextAt.After(now) {
+			continue
+		}
+		target.isRefreshing = true
+		targets = append(targets, target)
+	}
+	this.locker.Unlock()
+
+	for _, target := range targets {
+		var targetCopy = target
+		goman.New(func() {
+			this.Refresh(targetCopy)
+		})
+	}
+}
+
+// Refresh 刷新某个策略的端点
+func (this *OriginDiscoveryManager) Refresh(target *originDiscoveryTarget) {
+	endpoints, err := target.policy.Discover()
+
+	this.locker.Lock()
+	defer this.locker.Unlock()
+
+	target.isRefreshing = false
+	target.nextAt = time.Now().Add(time.Duration(target.policy.Interval) * time.Second)
+
+	if err == nil && len(endpoints) == 0 {
+		err = errors.New("no endpoints found")
+	}
+	if err != nil {
+		// 出错时保留原有端点，只在错误变化时记录日志
+		if target.lastErr == nil || target.lastErr.Error() != err.Error() {
+			remotelogs.Error("ORIGIN_DISCOVERY", "discover '"+target.policy.Source()+"' failed: "+err.Error())
+		}
+		target.lastErr = err
+		return
+	}
+	target.lastErr = nil
+	target.refreshedAt = time.Now()
+
+	var newMap = map[string]*OriginEndpoint{}
+	for _, endpoint := range endpoints {
+		if oldEndpoint, ok := newMap[endpoint.Addr]; ok {
+			oldEndpoint.Weight += endpoint.Weight
+			continue
+		}
+		newMap[endpoint.Addr] = endpoint
+	}
+
+	var result = []*OriginEndpoint{}
+	var oldMap = map[string]*OriginEndpoint{}
+	for _, oldEndpoint := range target.endpoints {
+		oldMap[oldEndpoint.Addr] = oldEndpoint
+		newEndpoint, ok := newMap[oldEndpoint.Addr]
+		if ok {
+			// 保留原有的健康状态
+			oldEndpoint.Weight = newEndpoint.Weight
+			oldEndpoint.drainingAt = time.Time{}
+		} else if !oldEndpoint.IsDraining() {
+			oldEndpoint.drainingAt = time.Now()
+		}
+		result = append(result, oldEndpoint)
+	}
+	for _, endpoint := range endpoints {
+		if _, ok := oldMap[endpoint.Addr]; ok {
+			continue
+		}
+		if newMap[endpoint.Addr] != endpoint {
+			continue
+		}
+		endpoint.target = target
+		endpoint.isOk = true
+		result = append(result, endpoint)
+		oldMap[endpoint.Addr] = endpoint
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Addr < result[j].Addr
+	})
+	target.endpoints = result
+}
+
+// IsManaged 某个源站是否使用服务发现
+func (this *OriginDiscoveryManager) IsManaged(originId int64) bool {
+	this.locker.RLock()
+	_, ok := this.targetMap[originId]
+	this.locker.RUnlock()
+	return ok
+}
+
+// Pick 为某个源站选择一个端点
+// 源站没有使用服务发现或者尚未发现端点时返回nil；返回的端点使用完后需要调用 Release()
+func (this *OriginDiscoveryManager) Pick(originId int64) *OriginEndpoint {
+	this.locker.RLock()
+	defer this.locker.RUnlock()
+
+	target, ok := this.targetMap[originId]
+	if !ok {
+		return nil
+	}
+
+	// 优先选择可用的端点，全部不可用时从未消失的端点中选择
+	var now = time.Now()
+	var failTimeout = time.Duration(target.policy.FailTimeout) * time.Second
+	var candidates = []*OriginEndpoint{}
+	var fallbackCandidates = []*OriginEndpoint{}
+	var totalWeight = 0
+	for _, endpoint := range target.endpoints {
+		if endpoint.IsDraining() {
+			continue
+		}
+		fallbackCandidates = append(fallbackCandidates, endpoint)
+		if endpoint.isOk || now.Sub(endpoint.failedAt) >= failTimeout {
+			candidates = append(candidates, endpoint)
+			totalWeight += endpoint.weight()
+		}
+	}
+	if len(candidates) == 0 {
+		if len(fallbackCandidates) == 0 {
+			return nil
+		}
+		var endpoint = fallbackCandidates[rand.Intn(len(fallbackCandidates))]
+		atomic.AddInt64(&endpoint.active, 1)
+		return endpoint
+	}
+
+	var n = rand.Intn(totalWeight)
+	for _, endpoint := range candidates {
+		n -= endpoint.weight()
+		if n < 0 {
+			atomic.AddInt64(&endpoint.active, 1)
+			return endpoint
+		}
+	}
+	var endpoint = candidates[len(candidates)-1]
+	atomic.AddInt64(&endpoint.active, 1)
+	return endpoint
+}
+
+// States 端点状态
+func (this *OriginDiscoveryManager) States() []maps.Map {
+	this.locker.RLock()
+	defer this.locker.RUnlock()
+
+	var result = []maps.Map{}
+	for _, target := range this.targets {
+		var endpointMaps = []maps.Map{}
+		for _, endpoint := range target.endpoints {
+			var drainingAt int64
+			if endpoint.IsDraining() {
+				drainingAt = endpoint.drainingAt.Unix()
+			}
+			endpointMaps = append(endpointMaps, maps.Map{
+				"addr":       endpoint.Addr,
+				"weight":     endpoint.Weight,
+				"isOk":       endpoint.isOk,
+				"fails":      endpoint.fails,
+				"active":     atomic.LoadInt64(&endpoint.active),
+				"drainingAt": drainingAt,
+			})
+		}
+
+		var refreshedAt int64
+		if !target.refreshedAt.IsZero() {
+			refreshedAt = target.refreshedAt.Unix()
+		}
+		var errString = ""
+		if target.lastErr != nil {
+			errString = target.lastErr.Error()
+		}
+		result = append(result, maps.Map{
+			"originIds":   target.policy.OriginIds,
+			"type":        target.policy.Type,
+			"source":      target.policy.Source(),
+			"refreshedAt": refreshedAt,
+			"error":       errString,
+			"endpoints":   endpointMaps,
+		})
+	}
+	return result
+}
+
+// 文件变化时尽快刷新
+func (this *OriginDiscoveryManager) notifyFile(path string) {
+	path = filepath.Clean(path)
+
+	this.locker.Lock()
+	defer this.locker.Unlock()
+	for _, target := range this.targets {
+		var file = target.policy.File
+		if target.policy.Type == OriginDiscoveryTypeFile && (path == file || filepath.Dir(path) == file) {
+			target.nextAt = time.Now()
+		}
+	}
+}
+
+func (this *OriginDiscoveryManager) report(endpoint *OriginEndpoint, isOk bool) {
+	this.locker.Lock()
+	defer this.locker.Unlock()
+
+	if isOk {
+		if !endpoint.isOk {
+			remotelogs.Println("ORIGIN_DISCOVERY", "endpoint '"+endpoint.Addr+"' is available again")
+		}
+		endpoint.isOk = true
+		endpoint.fails = 0
+		return
+	}
+
+	endpoint.fails++
+	endpoint.failedAt = time.Now()
+	if endpoint.isOk && endpoint.fails >= endpoint.target.policy.MaxFails {
+		endpoint.isOk = false
+		remotelogs.Warn("ORIGIN_DISCOVERY", "endpoint '"+endpoint.Addr+"' is unavailable after "+types.String(endpoint.fails)+" failures")
+	}
+}
+
+// 删除已经没有请求或者超出等待时间的端点，调用前需要加锁
+func (this *OriginDiscoveryManager) removeDrainedEndpoints(target *originDiscoveryTarget, now time.Time) {
+	var drainTimeout = time.Duration(target.policy.DrainTimeout) * time.Second
+	var hasDrained = false
+	for _, endpoint := range target.endpoints {
+		if endpoint.IsDraining() && (atomic.LoadInt64(&endpoint.active) <= 0 || now.Sub(endpoint.drainingAt) >= drainTimeout) {
+			hasDrained = true
+			break
+		}
+	}
+	if !hasDrained {
+		return
+	}
+
+	var endpoints = []*OriginEndpoint{}
+	for _, endpoint := range target.endpoints {
+		if endpoint.IsDraining() && (atomic.LoadInt64(&endpoint.active) <= 0 || now.Sub(endpoint.drainingAt) >= drainTimeout) {
+			continue
+		}
+		endpoints = append(endpoints, endpoint)
+	}
+	target.endpoints = endpoints
+}
@@ -0,0 +1,158 @@
+// Copyright 2024 GoEdge CDN goedge.cdn@gmail.com. All rights reserved. Official site: https://goedge.cloud .
+
+package nodes
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/iwind/TeaGo/assert"
+)
+
+func TestOriginDiscoveryConfig_Init(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	a.IsNotNil((&OriginDiscoveryConfig{Policies: []*OriginDiscoveryPolicy{{Type: "dns", Name: "example.com", Port: 80}}}).Init())
+	a.IsNotNil((&OriginDiscoveryConfig{Policies: []*OriginDiscoveryPolicy{{OriginIds: []int64{1}, Type: "dns", Name: "example.com"}}}).Init())
+	a.IsNotNil((&OriginDiscoveryConfig{Policies: []*OriginDiscoveryPolicy{{OriginIds: []int64{1}, Type: "consul"}}}).Init())
+	a.IsNotNil((&OriginDiscoveryConfig{Policies: []*OriginDiscoveryPolicy{
+		{OriginIds: []int64{1}, Type: "srv", Name: "_http._tcp.example.com"},
+		{OriginIds: []int64{1}, Type: "file", File: "/tmp/origins.txt"},
+	}}).Init())
+
+	var policy = &OriginDiscoveryPolicy{OriginIds: []int64{1}, Type: "srv", Name: "_http._tcp.example.com"}
+	a.IsNil((&OriginDiscoveryConfig{Policies: []*OriginDiscoveryPolicy{policy}}).Init())
+	a.IsTrue(policy.Interval == 30)
+	a.IsTrue(policy.MaxFails == 3)
+}
+
+func TestParseOriginEndpoints(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	endpoints, err := ParseOriginEndpoints([]byte(`
+# comment
+10.0.0.1:8080
+10.0.0.2:8080 3
+[::1]:8080
+`))
+	a.IsNil(err)
+	a.IsTrue(len(endpoints) == 3)
+	a.IsTrue(endpoints[1].Addr == "10.0.0.2:8080")
+	a.IsTrue(endpoints[1].Weight == 3)
+
+	_, err = ParseOriginEndpoints([]byte("10.0.0.1"))
+	a.IsNotNil(err)
+	_, err = ParseOriginEndpoints([]byte("10.0.0.1:8080 abc"))
+	a.IsNotNil(err)
+}
+
+func TestOriginDiscoveryManager_File(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	var dir = t.TempDir()
+	var file = filepath.Join(dir, "origins.txt")
+	err := os.WriteFile(file, []byte("10.0.0.1:8080\n10.0.0.2:8080 2\n"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var config = &OriginDiscoveryConfig{
+		IsOn: true,
+		Policies: []*OriginDiscoveryPolicy{
+			{OriginIds: []int64{1}, Type: OriginDiscoveryTypeFile, File: dir, MaxFails: 2, DrainTimeout: 60},
+		},
+	}
+	a.IsNil(config.Init())
+
+	var manager = NewOriginDiscoveryManager()
+	manager.UpdateConfig(config)
+	a.IsTrue(manager.IsManaged(1))
+	a.IsFalse(manager.IsManaged(2))
+	a.IsNil(manager.Pick(1))
+
+	var target = manager.targets[0]
+	manager.Refresh(target)
+	a.IsTrue(len(target.endpoints) == 2)
+
+	var endpoint = manager.Pick(1)
+	a.IsNotNil(endpoint)
+	a.IsTrue(endpoint.Addr == "10.0.0.1:8080" || endpoint.Addr == "10.0.0.2:8080")
+
+	// 端点消失后不再接收新请求，已有请求结束后删除
+	err = os.WriteFile(file, []byte("10.0.0.3:8080\n"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager.Refresh(target)
+	a.IsTrue(len(target.endpoints) == 3)
+	for i := 0; i < 10; i++ {
+		var newEndpoint = manager.Pick(1)
+		a.IsTrue(newEndpoint.Addr == "10.0.0.3:8080")
+		newEndpoint.Release()
+	}
+	manager.Loop()
+	a.IsTrue(len(target.endpoints) == 2)
+	endpoint.Release()
+	manager.Loop()
+	a.IsTrue(len(target.endpoints) == 1)
+
+	// 出错时保留原有端点
+	err = os.Remove(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager.Refresh(target)
+	a.IsTrue(len(target.endpoints) == 1)
+	a.IsNotNil(target.lastErr)
+	t.Log(manager.States())
+}
+
+func TestOriginDiscoveryManager_Health(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	var file = filepath.Join(t.TempDir(), "origins.txt")
+	err := os.WriteFile(file, []byte("10.0.0.1:8080\n10.0.0.2:8080\n"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var config = &OriginDiscoveryConfig{
+		IsOn: true,
+		Policies: []*OriginDiscoveryPolicy{
+			{OriginIds: []int64{1}, Type: OriginDiscoveryTypeFile, File: file, MaxFails: 2},
+		},
+	}
+	a.IsNil(config.Init())
+
+	var manager = NewOriginDiscoveryManager()
+	manager.UpdateConfig(config)
+	manager.Refresh(manager.targets[0])
+
+	var badEndpoint = manager.targets[0].endpoints[0]
+	badEndpoint.Report(false)
+	a.IsTrue(badEndpoint.isOk)
+	badEndpoint.Report(false)
+	a.IsFalse(badEndpoint.isOk)
+
+	for i := 0; i < 10; i++ {
+		var endpoint = manager.Pick(1)
+		a.IsTrue(endpoint.Addr == "10.0.0.2:8080")
+		endpoint.Release()
+	}
+
+	// 经过一段时间后重新尝试
+	badEndpoint.failedAt = time.Now().Add(-time.Hour)
+	var picked = map[string]bool{}
+	for i := 0; i < 100; i++ {
+		var endpoint = manager.Pick(1)
+		picked[endpoint.Addr] = true
+		endpoint.Release()
+	}
+	a.IsTrue(picked["10.0.0.1:8080"])
+
+	badEndpoint.Report(true)
+	a.IsTrue(badEndpoint.isOk)
+	a.IsTrue(badEndpoint.fails == 0)
+}
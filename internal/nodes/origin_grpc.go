@@ -0,0 +1,262 @@
+// Copyright 2024 GoEdge CDN goedge.cdn@gmail.com. All rights reserved. Official site: https://goedge.cloud .
+
+package nodes
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/TeaOSLab/EdgeCommon/pkg/serverconfigs"
+	"github.com/TeaOSLab/EdgeNode/internal/configs"
+	teaconst "github.com/TeaOSLab/EdgeNode/internal/const"
+	"github.com/TeaOSLab/EdgeNode/internal/events"
+	"github.com/TeaOSLab/EdgeNode/internal/remotelogs"
+	"github.com/iwind/TeaGo/lists"
+	"github.com/iwind/TeaGo/types"
+)
+
+// OriginGRPCConfigFileName gRPC回源配置文件
+const OriginGRPCConfigFileName = "origin_grpc.yaml"
+
+// GRPCStatus gRPC状态码
+type GRPCStatus = int
+
+const (
+	GRPCStatusOK                 GRPCStatus = 0
+	GRPCStatusCanceled           GRPCStatus = 1
+	GRPCStatusUnknown            GRPCStatus = 2
+	GRPCStatusInvalidArgument    GRPCStatus = 3
+	GRPCStatusDeadlineExceeded   GRPCStatus = 4
+	GRPCStatusNotFound           GRPCStatus = 5
+	GRPCStatusAlreadyExists      GRPCStatus = 6
+	GRPCStatusPermissionDenied   GRPCStatus = 7
+	GRPCStatusResourceExhausted  GRPCStatus = 8
+	GRPCStatusFailedPrecondition GRPCStatus = 9
+	GRPCStatusAborted            GRPCStatus = 10
+	GRPCStatusOutOfRange         GRPCStatus = 11
+	GRPCStatusUnimplemented      GRPCStatus = 12
+	GRPCStatusInternal           GRPCStatus = 13
+	GRPCStatusUnavailable        GRPCStatus = 14
+	GRPCStatusDataLoss           GRPCStatus = 15
+	GRPCStatusUnauthenticated    GRPCStatus = 16
+)
+
+var grpcStatusNames = []string{
+	"OK",
+	"CANCELLED",
+	"UNKNOWN",
+	"INVALID_ARGUMENT",
+	"DEADLINE_EXCEEDED",
+	"NOT_FOUND",
+	"ALREADY_EXISTS",
+	"PERMISSION_DENIED",
+	"RESOURCE_EXHAUSTED",
+	"FAILED_PRECONDITION",
+	"ABORTED",
+	"OUT_OF_RANGE",
+	"UNIMPLEMENTED",
+	"INTERNAL",
+	"UNAVAILABLE",
+	"DATA_LOSS",
+	"UNAUTHENTICATED",
+}
+
+// ParseGRPCStatus 从名称或者数字中分析gRPC状态码
+func ParseGRPCStatus(s string) (status GRPCStatus, ok bool) {
+	s = strings.TrimSpace(s)
+	for index, name := range grpcStatusNames {
+		if strings.EqualFold(name, s) || strings.EqualFold(strings.ReplaceAll(name, "_", ""), s) {
+			return index, true
+		}
+	}
+	if len(s) > 0 && s[0] >= '0' && s[0] <= '9' {
+		status = types.Int(s)
+		if status >= 0 && status < len(grpcStatusNames) {
+			return status, true
+		}
+	}
+	return GRPCStatusUnknown, false
+}
+
+// GRPCStatusFromHTTPStatus 根据HTTP状态码计算gRPC状态码
+// 参考：https://github.com/grpc/grpc/blob/master/doc/http-grpc-status-mapping.md
+func GRPCStatusFromHTTPStatus(httpStatus int) GRPCStatus {
+	switch httpStatus {
+	case http.StatusBadRequest:
+		return GRPCStatusInternal
+	case http.StatusUnauthorized:
+		return GRPCStatusUnauthenticated
+	case http.StatusForbidden:
+		return GRPCStatusPermissionDenied
+	case http.StatusNotFound:
+		return GRPCStatusUnimplemented
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable:
+		return GRPCStatusUnavailable
+	case http.StatusGatewayTimeout:
+		return GRPCStatusDeadlineExceeded
+	}
+	return GRPCStatusUnknown
+}
+
+var sharedOriginGRPCManager = NewOriginGRPCManager()
+
+func init() {
+	if !teaconst.IsMain {
+		return
+	}
+
+	events.On(events.EventLoaded, func() {
+		config, err := LoadOriginGRPCConfig()
+		if err != nil {
+			remotelogs.Error("ORIGIN_GRPC", "load '"+OriginGRPCConfigFileName+"' failed: "+err.Error())
+			return
+		}
+		sharedOriginGRPCManager.UpdateConfig(config)
+	})
+}
+
+// OriginGRPCConfig gRPC回源配置
+type OriginGRPCConfig struct {
+	IsOn     bool                `yaml:"isOn" json:"isOn"`         // 是否启用gRPC请求的特殊处理
+	Policies []*OriginGRPCPolicy `yaml:"policies" json:"policies"` // 策略列表
+}
+
+// DefaultOriginGRPCConfig 默认配置
+func DefaultOriginGRPCConfig() *OriginGRPCConfig {
+	return &OriginGRPCConfig{
+		IsOn: true,
+	}
+}
+
+// Init 初始化
+func (this *OriginGRPCConfig) Init() error {
+	for index, policy := range this.Policies {
+		if policy == nil {
+			return errors.New("policy #" + types.String(index+1) + " should not be empty")
+		}
+		err := policy.Init()
+		if err != nil {
+			return errors.New("policy #" + types.String(index+1) + ": " + err.Error())
+		}
+	}
+	return nil
+}
+
+// LoadOriginGRPCConfig 从配置文件中加载gRPC回源配置
+// 如果配置文件不存在，则返回默认配置
+func LoadOriginGRPCConfig() (*OriginGRPCConfig, error) {
+	return configs.LoadLocalConfig(OriginGRPCConfigFileName, DefaultOriginGRPCConfig())
+}
+
+// OriginGRPCPolicy 单个gRPC回源策略
+type OriginGRPCPolicy struct {
+	ServerIds        []int64  `yaml:"serverIds" json:"serverIds"`               // 适用的网站ID
+	ReverseProxyIds  []int64  `yaml:"reverseProxyIds" json:"reverseProxyIds"`   // 适用的反向代理ID，和网站ID都为空时表示所有网站
+	OriginIds        []int64  `yaml:"originIds" json:"originIds"`               // 使用h2c连接的源站ID，为空表示所有HTTP源站
+	H2C              bool     `yaml:"h2c" json:"h2c"`                           // 是否使用h2c（明文HTTP/2，prior knowledge）连接HTTP源站
+	GRPCWeb          bool     `yaml:"grpcWeb" json:"grpcWeb"`                   // 是否将gRPC-Web请求转换为gRPC请求
+	RetryStatuses    []string `yaml:"retryStatuses" json:"retryStatuses"`       // 可以更换源站重试的grpc-status，比如UNAVAILABLE
+	MaxRetryBodySize int64    `yaml:"maxRetryBodySize" json:"maxRetryBodySize"` // 为了重试而缓存的最大请求体尺寸，单位：字节
+
+	retryStatuses []GRPCStatus
+}
+
+// Init 初始化
+func (this *OriginGRPCPolicy) Init() error {
+	this.retryStatuses = nil
+	for _, s := range this.RetryStatuses {
+		status, ok := ParseGRPCStatus(s)
+		if !ok {
+			return errors.New("invalid grpc status '" + s + "'")
+		}
+		if status == GRPCStatusOK {
+			return errors.New("grpc status 'OK' can not be retried")
+		}
+		this.retryStatuses = append(this.retryStatuses, status)
+	}
+
+	if this.MaxRetryBodySize <= 0 {
+		this.MaxRetryBodySize = 64 << 10
+	}
+	return nil
+}
+
+// MatchReverseProxy 检查是否适用于某个网站的反向代理
+func (this *OriginGRPCPolicy) MatchReverseProxy(serverId int64, reverseProxyId int64) bool {
+	if len(this.ServerIds) == 0 && len(this.ReverseProxyIds) == 0 {
+		return true
+	}
+	return lists.ContainsInt64(this.ServerIds, serverId) || lists.ContainsInt64(this.ReverseProxyIds, reverseProxyId)
+}
+
+// MatchOrigin 检查是否适用于某个源站
+func (this *OriginGRPCPolicy) MatchOrigin(originId int64) bool {
+	return len(this.OriginIds) == 0 || lists.ContainsInt64(this.OriginIds, originId)
+}
+
+// CanRetry 检查某个gRPC状态码是否可以重试
+func (this *OriginGRPCPolicy) CanRetry(status GRPCStatus) bool {
+	return lists.ContainsInt(this.retryStatuses, status)
+}
+
+// OriginGRPCManager gRPC回源配置管理
+type OriginGRPCManager struct {
+	config *OriginGRPCConfig
+
+	locker sync.RWMutex
+}
+
+// NewOriginGRPCManager 获取新对象
+func NewOriginGRPCManager() *OriginGRPCManager {
+	return &OriginGRPCManager{
+		config: DefaultOriginGRPCConfig(),
+	}
+}
+
+// UpdateConfig 修改配置
+func (this *OriginGRPCManager) UpdateConfig(config *OriginGRPCConfig) {
+	if config == nil {
+		return
+	}
+	this.locker.Lock()
+	this.config = config
+	this.locker.Unlock()
+}
+
+// IsOn 是否启用gRPC请求的特殊处理
+func (this *OriginGRPCManager) IsOn() bool {
+	this.locker.RLock()
+	defer this.locker.RUnlock()
+	return this.config.IsOn
+}
+
+// FindPolicy 查找适用于网站反向代理的策略
+func (this *OriginGRPCManager) FindPolicy(serverId int64, reverseProxy *serverconfigs.ReverseProxyConfig) *OriginGRPCPolicy {
+	if reverseProxy == nil {
+		return nil
+	}
+
+	this.locker.RLock()
+	defer this.locker.RUnlock()
+
+	if !this.config.IsOn {
+		return nil
+	}
+	for _, policy := range this.config.Policies {
+		if policy.MatchReverseProxy(serverId, reverseProxy.Id) {
+			return policy
+		}
+	}
+	return nil
+}
+
+// IsH2COrigin 检查是否需要使用h2c连接源站
+func (this *OriginGRPCManager) IsH2COrigin(serverId int64, reverseProxy *serverconfigs.ReverseProxyConfig, origin *serverconfigs.OriginConfig) bool {
+	if origin == nil || origin.Id <= 0 || origin.Addr == nil || origin.Addr.Protocol != serverconfigs.ProtocolHTTP {
+		return false
+	}
+	var policy = this.FindPolicy(serverId, reverseProxy)
+	return policy != nil && policy.H2C && policy.MatchOrigin(origin.Id)
+}
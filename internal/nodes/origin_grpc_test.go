@@ -0,0 +1,67 @@
+// Copyright 2024 GoEdge CDN goedge.cdn@gmail.com. All rights reserved. Official site: https://goedge.cloud .
+
+package nodes
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/TeaOSLab/EdgeCommon/pkg/serverconfigs"
+	"github.com/iwind/TeaGo/assert"
+)
+
+func TestParseGRPCStatus(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	for _, s := range []string{"UNAVAILABLE", "unavailable", "Unavailable", "14"} {
+		status, ok := ParseGRPCStatus(s)
+		a.IsTrue(ok)
+		a.IsTrue(status == GRPCStatusUnavailable)
+	}
+	status, ok := ParseGRPCStatus("DeadlineExceeded")
+	a.IsTrue(ok && status == GRPCStatusDeadlineExceeded)
+
+	_, ok = ParseGRPCStatus("17")
+	a.IsFalse(ok)
+	_, ok = ParseGRPCStatus("UNKNOWN_STATUS")
+	a.IsFalse(ok)
+
+	a.IsTrue(GRPCStatusFromHTTPStatus(http.StatusServiceUnavailable) == GRPCStatusUnavailable)
+	a.IsTrue(GRPCStatusFromHTTPStatus(http.StatusGatewayTimeout) == GRPCStatusDeadlineExceeded)
+	a.IsTrue(GRPCStatusFromHTTPStatus(http.StatusInternalServerError) == GRPCStatusUnknown)
+}
+
+func TestOriginGRPCManager(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	var config = &OriginGRPCConfig{
+		IsOn: true,
+		Policies: []*OriginGRPCPolicy{
+			{
+				ServerIds:     []int64{1},
+				OriginIds:     []int64{2},
+				H2C:           true,
+				RetryStatuses: []string{"UNAVAILABLE", "RESOURCE_EXHAUSTED"},
+			},
+		},
+	}
+	a.IsNil(config.Init())
+	a.IsTrue(config.Policies[0].MaxRetryBodySize > 0)
+	a.IsTrue(config.Policies[0].CanRetry(GRPCStatusResourceExhausted))
+	a.IsFalse(config.Policies[0].CanRetry(GRPCStatusInternal))
+
+	var manager = NewOriginGRPCManager()
+	manager.UpdateConfig(config)
+
+	var reverseProxy = &serverconfigs.ReverseProxyConfig{Id: 1}
+	var newOrigin = func(originId int64, protocol serverconfigs.Protocol) *serverconfigs.OriginConfig {
+		return &serverconfigs.OriginConfig{Id: originId, Addr: &serverconfigs.NetworkAddressConfig{Protocol: protocol}}
+	}
+	a.IsTrue(manager.IsH2COrigin(1, reverseProxy, newOrigin(2, serverconfigs.ProtocolHTTP)))
+	a.IsFalse(manager.IsH2COrigin(1, reverseProxy, newOrigin(2, serverconfigs.ProtocolHTTPS)))
+	a.IsFalse(manager.IsH2COrigin(1, reverseProxy, newOrigin(3, serverconfigs.ProtocolHTTP)))
+	a.IsFalse(manager.IsH2COrigin(2, reverseProxy, newOrigin(2, serverconfigs.ProtocolHTTP)))
+
+	a.IsNotNil((&OriginGRPCConfig{Policies: []*OriginGRPCPolicy{{RetryStatuses: []string{"OK"}}}}).Init())
+	a.IsNotNil((&OriginGRPCConfig{Policies: []*OriginGRPCPolicy{{RetryStatuses: []string{"NOT_A_STATUS"}}}}).Init())
+}
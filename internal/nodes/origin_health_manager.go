@@ -0,0 +1,560 @@
+// Copyright 2024 GoEdge CDN goedge.cdn@gmail.com. All rights reserved. Official site: https://goedge.cloud .
+
+package nodes
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TeaOSLab/EdgeCommon/pkg/nodeconfigs"
+	"github.com/TeaOSLab/EdgeCommon/pkg/serverconfigs"
+	"github.com/TeaOSLab/EdgeNode/internal/configs"
+	teaconst "github.com/TeaOSLab/EdgeNode/internal/const"
+	"github.com/TeaOSLab/EdgeNode/internal/events"
+	"github.com/TeaOSLab/EdgeNode/internal/remotelogs"
+	"github.com/TeaOSLab/EdgeNode/internal/utils/goman"
+	"github.com/iwind/TeaGo/lists"
+	"github.com/iwind/TeaGo/maps"
+	"github.com/iwind/TeaGo/types"
+)
+
+// OriginHealthConfigFileName 源站健康检查配置文件
+const OriginHealthConfigFileName = "origin_health.yaml"
+
+// 健康检查最多读取的响应内容长度
+const originHealthMaxBodySize = 64 << 10
+
+var SharedOriginHealthManager = NewOriginHealthManager()
+
+func init() {
+	if !teaconst.IsMain {
+		return
+	}
+
+	events.On(events.EventLoaded, func() {
+		config, err := LoadOriginHealthConfig()
+		if err != nil {
+			remotelogs.Error("ORIGIN_HEALTH", "load '"+OriginHealthConfigFileName+"' failed: "+err.Error())
+		} else {
+			SharedOriginHealthManager.UpdateConfig(config)
+		}
+
+		goman.New(func() {
+			SharedOriginHealthManager.Start()
+		})
+	})
+	events.On(events.EventQuit, func() {
+		SharedOriginHealthManager.Stop()
+	})
+}
+
+// OriginHealthConfig 源站健康检查配置
+type OriginHealthConfig struct {
+	IsOn   bool                 `yaml:"isOn" json:"isOn"`     // 是否启用
+	Checks []*OriginHealthCheck `yaml:"checks" json:"checks"` // 检查策略列表
+}
+
+// DefaultOriginHealthConfig 默认配置
+func DefaultOriginHealthConfig() *OriginHealthConfig {
+	return &OriginHealthConfig{
+		IsOn: true,
+	}
+}
+
+// Init 初始化
+func (this *OriginHealthConfig) Init() error {
+	for index, check := range this.Checks {
+		if check == nil {
+			return errors.New("check #" + types.String(index+1) + " should not be empty")
+		}
+		err := check.Init()
+		if err != nil {
+			return errors.New("check #" + types.String(index+1) + ": " + err.Error())
+		}
+	}
+	return nil
+}
+
+// LoadOriginHealthConfig 从配置文件中加载源站健康检查配置
+// 如果配置文件不存在，则返回默认配置
+func LoadOriginHealthConfig() (*OriginHealthConfig, error) {
+	return configs.LoadLocalConfig(OriginHealthConfigFileName, DefaultOriginHealthConfig())
+}
+
+// OriginHealthCheck 单个健康检查策略
+type OriginHealthCheck struct {
+	ServerIds       []int64 `yaml:"serverIds" json:"serverIds"`             // 适用的网站ID
+	ReverseProxyIds []int64 `yaml:"reverseProxyIds" json:"reverseProxyIds"` // 适用的反向代理ID，和网站ID都为空时表示所有网站
+
+	Method       string `yaml:"method" json:"method"`             // 请求方法
+	Path         string `yaml:"path" json:"path"`                 // 请求路径，可以包含查询参数
+	Host         string `yaml:"host" json:"host"`                 // 请求的Host，为空表示使用源站地址
+	ExpectStatus []int  `yaml:"expectStatus" json:"expectStatus"` // 期望的状态码，为空表示200-399
+	BodyRegex    string `yaml:"bodyRegex" json:"bodyRegex"`       // 响应内容需要匹配的正则表达式，只检查前64KB
+	Interval     int    `yaml:"interval" json:"interval"`         // 检查间隔，单位：秒
+	Timeout      int    `yaml:"timeout" json:"timeout"`           // 超时时间，单位：秒
+	Rise         int    `yaml:"rise" json:"rise"`                 // 连续成功多少次后认为恢复
+	Fall         int    `yaml:"fall" json:"fall"`                 // 连续失败多少次后认为异常
+
+	OutlierFailures int `yaml:"outlierFailures" json:"outlierFailures"` // 真实请求中连续失败（连接错误或者5xx）多少次后认为异常，0表示不检查
+
+	bodyReg *regexp.Regexp
+}
+
+// Init 初始化
+func (this *OriginHealthCheck) Init() error {
+	if len(this.Method) == 0 {
+		this.Method = http.MethodGet
+	}
+	this.Method = strings.ToUpper(this.Method)
+	if len(this.Path) == 0 {
+		this.Path = "/"
+	}
+	if !strings.HasPrefix(this.Path, "/") {
+		return errors.New("'path' should start with '/'")
+	}
+	if this.Interval <= 0 {
+		this.Interval = 10
+	}
+	if this.Timeout <= 0 {
+		this.Timeout = 5
+	}
+	if this.Rise <= 0 {
+		this.Rise = 2
+	}
+	if this.Fall <= 0 {
+		this.Fall = 3
+	}
+	if this.OutlierFailures < 0 {
+		return errors.New("'outlierFailures' should not be negative")
+	}
+
+	this.bodyReg = nil
+	if len(this.BodyRegex) > 0 {
+		reg, err := regexp.Compile(this.BodyRegex)
+		if err != nil {
+			return errors.New("invalid 'bodyRegex': " + err.Error())
+		}
+		this.bodyReg = reg
+	}
+	return nil
+}
+
+// MatchReverseProxy 检查是否适用于某个网站的反向代理
+func (this *OriginHealthCheck) MatchReverseProxy(serverId int64, reverseProxyId int64) bool {
+	if len(this.ServerIds) == 0 && len(this.ReverseProxyIds) == 0 {
+		return true
+	}
+	return lists.ContainsInt64(this.ServerIds, serverId) || lists.ContainsInt64(this.ReverseProxyIds, reverseProxyId)
+}
+
+// IntervalDuration 检查间隔
+func (this *OriginHealthCheck) IntervalDuration() time.Duration {
+	return time.Duration(this.Interval) * time.Second
+}
+
+// TimeoutDuration 超时时间
+func (this *OriginHealthCheck) TimeoutDuration() time.Duration {
+	return time.Duration(this.Timeout) * time.Second
+}
+
+// CheckResponse 检查响应是否符合期望
+func (this *OriginHealthCheck) CheckResponse(statusCode int, body []byte) error {
+	if len(this.ExpectStatus) > 0 {
+		if !lists.ContainsInt(this.ExpectStatus, statusCode) {
+			return errors.New("unexpected status code " + types.String(statusCode))
+		}
+	} else if statusCode < 200 || statusCode >= 400 {
+		return errors.New("unexpected status code " + types.String(statusCode))
+	}
+
+	if this.bodyReg != nil && !this.bodyReg.Match(body) {
+		return errors.New("response body does not match '" + this.BodyRegex + "'")
+	}
+	return nil
+}
+
+// 单个被检查的源站
+type originHealthTarget struct {
+	serverId     int64
+	origin       *serverconfigs.OriginConfig
+	reverseProxy *serverconfigs.ReverseProxyConfig
+	check        *OriginHealthCheck
+
+	isOk           bool
+	countSuccesses int // 连续成功次数
+	countFailures  int // 连续失败次数
+	countOutliers  int // 真实请求中连续失败次数
+	nextCheckAt    time.Time
+	isChecking     bool
+	lastError      string
+	changedAt      int64
+}
+
+// OriginHealthManager 源站主动健康检查和被动异常检测
+// 被管理的源站不再使用 OriginStateManager 中的失败计数
+type OriginHealthManager struct {
+	config    *OriginHealthConfig
+	targetMap map[int64]*originHealthTarget // originId => target
+
+	checkFunc func(target *originHealthTarget) error
+
+	ticker *time.Ticker
+	locker sync.RWMutex
+}
+
+// NewOriginHealthManager 获取新对象
+func NewOriginHealthManager() *OriginHealthManager {
+	var manager = &OriginHealthManager{
+		config:    DefaultOriginHealthConfig(),
+		targetMap: map[int64]*originHealthTarget{},
+	}
+	manager.checkFunc = manager.checkHTTP
+	return manager
+}
+
+// UpdateConfig 修改配置
+func (this *OriginHealthManager) UpdateConfig(config *OriginHealthConfig) {
+	if config == nil {
+		return
+	}
+	this.locker.Lock()
+	this.config = config
+	this.locker.Unlock()
+}
+
+// Start 启动
+func (this *OriginHealthManager) Start() {
+	this.locker.Lock()
+	this.ticker = time.NewTicker(1 * time.Second)
+	this.locker.Unlock()
+
+	var lastSyncAt time.Time
+	for range this.ticker.C {
+		// 定期同步源站列表，以便应用新的配置
+		if time.Since(lastSyncAt) >= 10*time.Second {
+			this.Sync(sharedNodeConfig)
+			lastSyncAt = time.Now()
+		}
+		this.Loop()
+	}
+}
+
+// Stop 停止
+func (this *OriginHealthManager) Stop() {
+	this.locker.Lock()
+	if this.ticker != nil {
+		this.ticker.Stop()
+	}
+	this.locker.Unlock()
+}
+
+// Sync 从节点配置中同步需要检查的源站
+func (this *OriginHealthManager) Sync(nodeConfig *nodeconfigs.NodeConfig) {
+	var targetMap = map[int64]*originHealthTarget{}
+
+	this.locker.Lock()
+	defer this.locker.Unlock()
+
+	if nodeConfig != nil && this.config.IsOn && len(this.config.Checks) > 0 {
+		for _, server := range nodeConfig.Servers {
+			if server == nil || !server.IsOn || server.ReverseProxy == nil || !server.ReverseProxy.IsOn {
+				continue
+			}
+			var reverseProxy = server.ReverseProxy
+			var check = this.findCheck(server.Id, reverseProxy.Id)
+			if check == nil {
+				continue
+			}
+
+			for _, origins := range [][]*serverconfigs.OriginConfig{reverseProxy.PrimaryOrigins, reverseProxy.BackupOrigins} {
+				for _, origin := range origins {
+					if !this.canCheckOrigin(origin) {
+						continue
+					}
+					if _, ok := targetMap[origin.Id]; ok {
+						continue
+					}
+
+					target, ok := this.targetMap[origin.Id]
+					if !ok {
+						target = &originHealthTarget{
+							isOk:        true,
+							nextCheckAt: time.Now(),
+						}
+					}
+					target.serverId = server.Id
+					target.origin = origin
+					target.reverseProxy = reverseProxy
+					target.check = check
+
+					// 新的配置对象也需要保持当前状态
+					origin.IsOk = target.isOk
+
+					targetMap[origin.Id] = target
+				}
+			}
+		}
+	}
+
+	// 不再检查的源站恢复为默认状态
+	for originId, target := range this.targetMap {
+		if _, ok := targetMap[originId]; !ok && !target.isOk {
+			target.origin.IsOk = true
+			target.reverseProxy.ResetScheduling()
+		}
+	}
+
+	this.targetMap = targetMap
+}
+
+// Loop 检查已经到达检查时间的源站
+func (this *OriginHealthManager) Loop() {
+	var now = time.Now()
+	var targets = []*originHealthTarget{}
+
+	this.locker.Lock()
+	for _, target := range this.targetMap {
+		if target.isChecking || target.nextCheckAt.After(now) {
+			continue
+		}
+		target.isChecking = true
+		target.nextCheckAt = now.Add(target.check.IntervalDuration())
+		targets = append(targets, target)
+	}
+	this.locker.Unlock()
+
+	for _, target := range targets {
+		goman.New(func() {
+			var err = this.checkFunc(target)
+
+			this.locker.Lock()
+			target.isChecking = false
+			this.locker.Unlock()
+
+			this.report(target, err)
+		})
+	}
+}
+
+// IsManaged 源站是否由健康检查管理
+func (this *OriginHealthManager) IsManaged(originId int64) bool {
+	if originId <= 0 {
+		return false
+	}
+	this.locker.RLock()
+	_, ok := this.targetMap[originId]
+	this.locker.RUnlock()
+	return ok
+}
+
+// ReportFailure 报告真实请求中的失败
+// 如果源站由健康检查管理，则返回true
+func (this *OriginHealthManager) ReportFailure(origin *serverconfigs.OriginConfig, err error) (isManaged bool) {
+	if origin == nil || origin.Id <= 0 {
+		return false
+	}
+
+	this.locker.Lock()
+	target, ok := this.targetMap[origin.Id]
+	if !ok {
+		this.locker.Unlock()
+		return false
+	}
+	target.countOutliers++
+	var shouldEject = target.isOk && target.check.OutlierFailures > 0 && target.countOutliers >= target.check.OutlierFailures
+	this.locker.Unlock()
+
+	if shouldEject {
+		var reason = "outlier detected: " + types.String(target.check.OutlierFailures) + " consecutive failures"
+		if err != nil {
+			reason += ", last error: " + err.Error()
+		}
+		this.changeState(target, false, reason)
+	}
+	return true
+}
+
+// ReportStatus 报告真实请求中源站返回的状态码
+func (this *OriginHealthManager) ReportStatus(origin *serverconfigs.OriginConfig, statusCode int) {
+	if origin == nil || origin.Id <= 0 {
+		return
+	}
+
+	if statusCode >= 500 {
+		this.ReportFailure(origin, errors.New("status code "+types.String(statusCode)))
+		return
+	}
+
+	this.locker.Lock()
+	target, ok := this.targetMap[origin.Id]
+	if ok {
+		target.countOutliers = 0
+	}
+	this.locker.Unlock()
+}
+
+// States 所有被检查的源站状态
+func (this *OriginHealthManager) States() []maps.Map {
+	this.locker.RLock()
+	defer this.locker.RUnlock()
+
+	var result = []maps.Map{}
+	for originId, target := range this.targetMap {
+		result = append(result, maps.Map{
+			"originId":       originId,
+			"serverId":       target.serverId,
+			"addr":           target.origin.Addr.PickAddress(),
+			"isOk":           target.isOk,
+			"countSuccesses": target.countSuccesses,
+			"countFailures":  target.countFailures,
+			"countOutliers":  target.countOutliers,
+			"lastError":      target.lastError,
+			"changedAt":      target.changedAt,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].GetInt64("originId") < result[j].GetInt64("originId")
+	})
+	return result
+}
+
+// 处理主动检查的结果
+func (this *OriginHealthManager) report(target *originHealthTarget, err error) {
+	this.locker.Lock()
+	var shouldChange bool
+	if err != nil {
+		target.countSuccesses = 0
+		target.countFailures++
+		target.lastError = err.Error()
+		shouldChange = target.isOk && target.countFailures >= target.check.Fall
+	} else {
+		target.countFailures = 0
+		target.countSuccesses++
+		shouldChange = !target.isOk && target.countSuccesses >= target.check.Rise
+	}
+	this.locker.Unlock()
+
+	if shouldChange {
+		if err != nil {
+			this.changeState(target, false, types.String(target.check.Fall)+" consecutive check failures, last error: "+err.Error())
+		} else {
+			this.changeState(target, true, types.String(target.check.Rise)+" consecutive check successes")
+		}
+	}
+}
+
+// 修改源站状态
+func (this *OriginHealthManager) changeState(target *originHealthTarget, isOk bool, reason string) {
+	this.locker.Lock()
+	if target.isOk == isOk {
+		this.locker.Unlock()
+		return
+	}
+	target.isOk = isOk
+	target.countSuccesses = 0
+	target.countFailures = 0
+	target.countOutliers = 0
+	target.changedAt = time.Now().Unix()
+	target.origin.IsOk = isOk
+	var reverseProxy = target.reverseProxy
+	var addr = target.origin.Addr.PickAddress()
+	this.locker.Unlock()
+
+	reverseProxy.ResetScheduling()
+
+	if isOk {
+		remotelogs.ServerSuccess(target.serverId, "ORIGIN_HEALTH", "origin '"+addr+"' (id: "+types.String(target.origin.Id)+") is up: "+reason, "", nil)
+	} else {
+		remotelogs.ServerError(target.serverId, "ORIGIN_HEALTH", "origin '"+addr+"' (id: "+types.String(target.origin.Id)+") is down: "+reason, "", nil)
+	}
+}
+
+// 使用HTTP请求检查源站
+func (this *OriginHealthManager) checkHTTP(target *originHealthTarget) error {
+	var origin = target.origin
+	var check = target.check
+
+	var scheme = "http"
+	if origin.Addr.Protocol.IsHTTPSFamily() {
+		scheme = "https"
+	}
+	var addr = origin.Addr.PickAddress()
+	var host = check.Host
+	if len(host) == 0 {
+		if len(origin.RequestHost) > 0 {
+			host = origin.RequestHost
+		} else {
+			host = addr
+		}
+	}
+
+	var ctx, cancel = context.WithTimeout(context.Background(), check.TimeoutDuration())
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, check.Method, scheme+"://"+addr+check.Path, nil)
+	if err != nil {
+		return err
+	}
+	req.Host = host
+	req.Header.Set("User-Agent", teaconst.ProductName+"/"+teaconst.Version+" (health check)")
+
+	var tlsConfig = sharedOriginTLSManager.BuildTLSConfig(origin, strings.Split(host, ":")[0])
+	var transport = &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: check.TimeoutDuration(),
+		}).DialContext,
+		TLSClientConfig:   tlsConfig,
+		DisableKeepAlives: true,
+		Proxy:             nil,
+	}
+	defer transport.CloseIdleConnections()
+
+	var client = &http.Client{
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, originHealthMaxBodySize))
+	if err != nil {
+		return err
+	}
+	return check.CheckResponse(resp.StatusCode, body)
+}
+
+// 查找适用的检查策略
+func (this *OriginHealthManager) findCheck(serverId int64, reverseProxyId int64) *OriginHealthCheck {
+	for _, check := range this.config.Checks {
+		if check.MatchReverseProxy(serverId, reverseProxyId) {
+			return check
+		}
+	}
+	return nil
+}
+
+// 是否可以使用HTTP检查源站
+func (this *OriginHealthManager) canCheckOrigin(origin *serverconfigs.OriginConfig) bool {
+	return origin != nil &&
+		origin.Id > 0 &&
+		origin.IsOn &&
+		origin.Addr != nil &&
+		!origin.Addr.HostHasVariables() &&
+		(origin.Addr.Protocol.IsHTTPFamily() || origin.Addr.Protocol.IsHTTPSFamily())
+}
@@ -0,0 +1,160 @@
+// Copyright 2024 GoEdge CDN goedge.cdn@gmail.com. All rights reserved. Official site: https://goedge.cloud .
+
+package nodes
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/TeaOSLab/EdgeCommon/pkg/nodeconfigs"
+	"github.com/TeaOSLab/EdgeCommon/pkg/serverconfigs"
+	"github.com/iwind/TeaGo/assert"
+)
+
+func newTestOriginHealthManager(t *testing.T, check *OriginHealthCheck, addr string) (manager *OriginHealthManager, origin *serverconfigs.OriginConfig) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	origin = &serverconfigs.OriginConfig{
+		Id:   1,
+		IsOn: true,
+		Addr: &serverconfigs.NetworkAddressConfig{Protocol: serverconfigs.ProtocolHTTP, Host: host, PortRange: port},
+	}
+	err = origin.Init(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var reverseProxy = &serverconfigs.ReverseProxyConfig{
+		Id:             1,
+		IsOn:           true,
+		PrimaryOrigins: []*serverconfigs.OriginConfig{origin},
+	}
+	err = reverseProxy.Init(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var config = &OriginHealthConfig{
+		IsOn:   true,
+		Checks: []*OriginHealthCheck{check},
+	}
+	err = config.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manager = NewOriginHealthManager()
+	manager.UpdateConfig(config)
+	manager.Sync(&nodeconfigs.NodeConfig{
+		Servers: []*serverconfigs.ServerConfig{
+			{Id: 1, IsOn: true, ReverseProxy: reverseProxy},
+		},
+	})
+	return
+}
+
+func TestOriginHealthManager_Check(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	var isHealthy int32 = 1
+	var server = httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/health" || req.Host != "example.com" {
+			writer.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if atomic.LoadInt32(&isHealthy) == 1 {
+			_, _ = writer.Write([]byte("status: ok"))
+		} else {
+			_, _ = writer.Write([]byte("status: failed"))
+		}
+	}))
+	defer server.Close()
+
+	manager, origin := newTestOriginHealthManager(t, &OriginHealthCheck{
+		Path:      "/health",
+		Host:      "example.com",
+		BodyRegex: "ok$",
+		Rise:      2,
+		Fall:      2,
+	}, server.Listener.Addr().String())
+	a.IsTrue(manager.IsManaged(origin.Id))
+
+	var target = manager.targetMap[origin.Id]
+	a.IsNotNil(target)
+	a.IsNil(manager.checkHTTP(target))
+
+	atomic.StoreInt32(&isHealthy, 0)
+	manager.report(target, manager.checkHTTP(target))
+	a.IsTrue(origin.IsOk)
+	manager.report(target, manager.checkHTTP(target))
+	a.IsFalse(origin.IsOk)
+	a.IsTrue(len(manager.States()) == 1)
+	a.IsFalse(manager.States()[0].GetBool("isOk"))
+
+	atomic.StoreInt32(&isHealthy, 1)
+	manager.report(target, manager.checkHTTP(target))
+	a.IsFalse(origin.IsOk)
+	manager.report(target, manager.checkHTTP(target))
+	a.IsTrue(origin.IsOk)
+}
+
+func TestOriginHealthManager_Outlier(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	manager, origin := newTestOriginHealthManager(t, &OriginHealthCheck{
+		Rise:            1,
+		OutlierFailures: 3,
+	}, "127.0.0.1:1234")
+
+	a.IsTrue(manager.ReportFailure(origin, errors.New("connection refused")))
+	manager.ReportStatus(origin, http.StatusBadGateway)
+	manager.ReportStatus(origin, http.StatusOK) // 成功后重新计数
+	manager.ReportStatus(origin, http.StatusBadGateway)
+	manager.ReportStatus(origin, http.StatusBadGateway)
+	a.IsTrue(origin.IsOk)
+	manager.ReportStatus(origin, http.StatusServiceUnavailable)
+	a.IsFalse(origin.IsOk)
+
+	// 只能通过主动检查恢复
+	manager.ReportStatus(origin, http.StatusOK)
+	a.IsFalse(origin.IsOk)
+	manager.report(manager.targetMap[origin.Id], nil)
+	a.IsTrue(origin.IsOk)
+
+	// 不再检查时恢复默认状态
+	manager.report(manager.targetMap[origin.Id], errors.New("timeout"))
+	manager.report(manager.targetMap[origin.Id], errors.New("timeout"))
+	manager.report(manager.targetMap[origin.Id], errors.New("timeout"))
+	a.IsFalse(origin.IsOk)
+	manager.UpdateConfig(DefaultOriginHealthConfig())
+	manager.Sync(&nodeconfigs.NodeConfig{})
+	a.IsTrue(origin.IsOk)
+	a.IsFalse(manager.IsManaged(origin.Id))
+	a.IsFalse(manager.ReportFailure(origin, nil))
+}
+
+func TestOriginHealthCheck_CheckResponse(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	var check = &OriginHealthCheck{}
+	a.IsNil(check.Init())
+	a.IsTrue(check.Method == http.MethodGet && check.Path == "/")
+	a.IsNil(check.CheckResponse(http.StatusFound, nil))
+	a.IsNotNil(check.CheckResponse(http.StatusInternalServerError, nil))
+
+	check = &OriginHealthCheck{ExpectStatus: []int{http.StatusNoContent}, BodyRegex: "^$"}
+	a.IsNil(check.Init())
+	a.IsNil(check.CheckResponse(http.StatusNoContent, nil))
+	a.IsNotNil(check.CheckResponse(http.StatusOK, nil))
+
+	a.IsNotNil((&OriginHealthCheck{Path: "health"}).Init())
+	a.IsNotNil((&OriginHealthCheck{BodyRegex: "("}).Init())
+}
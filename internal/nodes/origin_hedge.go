@@ -0,0 +1,405 @@
+// Copyright 2024 GoEdge CDN goedge.cdn@gmail.com. All rights reserved. Official site: https://goedge.cloud .
+
+package nodes
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/TeaOSLab/EdgeCommon/pkg/serverconfigs"
+	"github.com/TeaOSLab/EdgeNode/internal/configs"
+	teaconst "github.com/TeaOSLab/EdgeNode/internal/const"
+	"github.com/TeaOSLab/EdgeNode/internal/events"
+	"github.com/TeaOSLab/EdgeNode/internal/remotelogs"
+	"github.com/iwind/TeaGo/lists"
+	"github.com/iwind/TeaGo/maps"
+	"github.com/iwind/TeaGo/types"
+)
+
+// OriginHedgeConfigFileName 对冲请求和重试预算配置文件
+const OriginHedgeConfigFileName = "origin_hedge.yaml"
+
+// 用来计算响应时间分位数的最多样本数
+const originHedgeMaxLatencySamples = 1024
+
+var SharedOriginHedgeManager = NewOriginHedgeManager()
+
+func init() {
+	if !teaconst.IsMain {
+		return
+	}
+
+	events.On(events.EventLoaded, func() {
+		config, err := LoadOriginHedgeConfig()
+		if err != nil {
+			remotelogs.Error("ORIGIN_HEDGE", "load '"+OriginHedgeConfigFileName+"' failed: "+err.Error())
+			return
+		}
+		SharedOriginHedgeManager.UpdateConfig(config)
+	})
+}
+
+// OriginHedgeConfig 对冲请求和重试预算配置
+type OriginHedgeConfig struct {
+	IsOn     bool                 `yaml:"isOn" json:"isOn"`         // 是否启用
+	Policies []*OriginHedgePolicy `yaml:"policies" json:"policies"` // 策略列表
+}
+
+// DefaultOriginHedgeConfig 默认配置
+func DefaultOriginHedgeConfig() *OriginHedgeConfig {
+	return &OriginHedgeConfig{
+		IsOn: true,
+	}
+}
+
+// Init 初始化
+func (this *OriginHedgeConfig) Init() error {
+	for index, policy := range this.Policies {
+		if policy == nil {
+			return errors.New("policy #" + types.String(index+1) + " should not be empty")
+		}
+		err := policy.Init()
+		if err != nil {
+			return errors.New("policy #" + types.String(index+1) + ": " + err.Error())
+		}
+	}
+	return nil
+}
+
+// LoadOriginHedgeConfig 从配置文件中加载对冲请求和重试预算配置
+// 如果配置文件不存在，则返回默认配置
+func LoadOriginHedgeConfig() (*OriginHedgeConfig, error) {
+	return configs.LoadLocalConfig(OriginHedgeConfigFileName, DefaultOriginHedgeConfig())
+}
+
+// OriginHedgePolicy 单个对冲请求和重试预算策略
+type OriginHedgePolicy struct {
+	ServerIds       []int64 `yaml:"serverIds" json:"serverIds"`             // 适用的网站ID
+	ReverseProxyIds []int64 `yaml:"reverseProxyIds" json:"reverseProxyIds"` // 适用的反向代理ID，和网站ID都为空时表示所有网站
+
+	Hedge           bool    `yaml:"hedge" json:"hedge"`                     // 是否启用对冲请求，只对GET和HEAD请求有效
+	HedgePercentile float64 `yaml:"hedgePercentile" json:"hedgePercentile"` // 源站响应时间超过此分位数时发送对冲请求
+	HedgeMinDelay   int     `yaml:"hedgeMinDelay" json:"hedgeMinDelay"`     // 发送对冲请求的最小等待时间，单位：毫秒
+	HedgeDelay      int     `yaml:"hedgeDelay" json:"hedgeDelay"`           // 样本不足时发送对冲请求的等待时间，单位：毫秒
+	MinSamples      int     `yaml:"minSamples" json:"minSamples"`           // 计算分位数需要的最少样本数
+
+	BudgetRatio        float64 `yaml:"budgetRatio" json:"budgetRatio"`               // 重试和对冲请求最多占请求数的比例
+	BudgetMinPerSecond float64 `yaml:"budgetMinPerSecond" json:"budgetMinPerSecond"` // 每秒额外允许的重试和对冲请求数，用于请求量较小的网站
+	BudgetWindow       int     `yaml:"budgetWindow" json:"budgetWindow"`             // 统计窗口，单位：秒
+}
+
+// Init 初始化
+func (this *OriginHedgePolicy) Init() error {
+	if this.HedgePercentile == 0 {
+		this.HedgePercentile = 95
+	}
+	if this.HedgePercentile <= 0 || this.HedgePercentile >= 100 {
+		return errors.New("'hedgePercentile' should be between 0 and 100")
+	}
+	if this.HedgeMinDelay <= 0 {
+		this.HedgeMinDelay = 20
+	}
+	if this.HedgeDelay <= 0 {
+		this.HedgeDelay = 500
+	}
+	if this.MinSamples <= 0 {
+		this.MinSamples = 100
+	}
+	if this.MinSamples > originHedgeMaxLatencySamples {
+		this.MinSamples = originHedgeMaxLatencySamples
+	}
+
+	if this.BudgetRatio == 0 {
+		this.BudgetRatio = 0.1
+	}
+	if this.BudgetRatio < 0 {
+		return errors.New("'budgetRatio' should not be negative")
+	}
+	if this.BudgetMinPerSecond < 0 {
+		return errors.New("'budgetMinPerSecond' should not be negative")
+	}
+	if this.BudgetWindow <= 0 {
+		this.BudgetWindow = 10
+	}
+	return nil
+}
+
+// MatchReverseProxy 检查是否适用于某个网站的反向代理
+func (this *OriginHedgePolicy) MatchReverseProxy(serverId int64, reverseProxyId int64) bool {
+	if len(this.ServerIds) == 0 && len(this.ReverseProxyIds) == 0 {
+		return true
+	}
+	return lists.ContainsInt64(this.ServerIds, serverId) || lists.ContainsInt64(this.ReverseProxyIds, reverseProxyId)
+}
+
+// 单个网站的统计
+type originHedgeStat struct {
+	locker sync.Mutex
+
+	// 重试预算，按秒分桶
+	seconds  []int64
+	requests []int64
+	retries  []int64
+
+	// 响应时间样本
+	latencies    []time.Duration
+	latencyIndex int
+	percentile   time.Duration
+	percentileAt time.Time
+
+	hedges    int64 // 对冲请求总数
+	hedgeWins int64 // 对冲请求先返回的次数
+	denied    int64 // 因为超出预算而未发送的对冲请求数
+}
+
+func newOriginHedgeStat(window int) *originHedgeStat {
+	return &originHedgeStat{
+		seconds:  make([]int64, window),
+		requests: make([]int64, window),
+		retries:  make([]int64, window),
+	}
+}
+
+// 当前时间所在的桶，调用前需要加锁
+func (this *originHedgeStat) bucket(now int64) int {
+	var index = int(now % int64(len(this.seconds)))
+	if this.seconds[index] != now {
+		this.seconds[index] = now
+		this.requests[index] = 0
+		this.retries[index] = 0
+	}
+	return index
+}
+
+// 统计窗口内的请求数和重试数，调用前需要加锁
+func (this *originHedgeStat) counts(now int64) (requests int64, retries int64) {
+	var window = int64(len(this.seconds))
+	for index, second := range this.seconds {
+		if second > now-window && second <= now {
+			requests += this.requests[index]
+			retries += this.retries[index]
+		}
+	}
+	return
+}
+
+func (this *originHedgeStat) addLatency(latency time.Duration) {
+	this.locker.Lock()
+	if len(this.latencies) < originHedgeMaxLatencySamples {
+		this.latencies = append(this.latencies, latency)
+	} else {
+		this.latencies[this.latencyIndex] = latency
+		this.latencyIndex = (this.latencyIndex + 1) % originHedgeMaxLatencySamples
+	}
+	this.locker.Unlock()
+}
+
+// 计算响应时间分位数，每秒最多计算一次
+func (this *originHedgeStat) latencyPercentile(percentile float64, minSamples int, now time.Time) (latency time.Duration, ok bool) {
+	this.locker.Lock()
+	defer this.locker.Unlock()
+
+	if len(this.latencies) < minSamples {
+		return 0, false
+	}
+	if now.Sub(this.percentileAt) < time.Second {
+		return this.percentile, true
+	}
+
+	var samples = make([]time.Duration, len(this.latencies))
+	copy(samples, this.latencies)
+	sort.Slice(samples, func(i, j int) bool {
+		return samples[i] < samples[j]
+	})
+	var index = int(math.Ceil(float64(len(samples))*percentile/100)) - 1
+	if index < 0 {
+		index = 0
+	}
+	this.percentile = samples[index]
+	this.percentileAt = now
+	return this.percentile, true
+}
+
+// OriginRetryBudget 单个网站的重试预算
+type OriginRetryBudget struct {
+	policy *OriginHedgePolicy
+	stat   *originHedgeStat
+}
+
+// Policy 适用的策略
+func (this *OriginRetryBudget) Policy() *OriginHedgePolicy {
+	return this.policy
+}
+
+// AddRequest 记录一个新的请求
+func (this *OriginRetryBudget) AddRequest() {
+	var now = time.Now().Unix()
+	this.stat.locker.Lock()
+	this.stat.requests[this.stat.bucket(now)]++
+	this.stat.locker.Unlock()
+}
+
+// AllowRetry 检查是否还可以重试，但不占用预算
+func (this *OriginRetryBudget) AllowRetry() bool {
+	var now = time.Now().Unix()
+	this.stat.locker.Lock()
+	defer this.stat.locker.Unlock()
+	return this.allow(now)
+}
+
+// AddRetry 记录一次重试
+func (this *OriginRetryBudget) AddRetry() {
+	var now = time.Now().Unix()
+	this.stat.locker.Lock()
+	this.stat.retries[this.stat.bucket(now)]++
+	this.stat.locker.Unlock()
+}
+
+// AcquireRetry 检查是否还可以重试，如果可以则占用预算
+func (this *OriginRetryBudget) AcquireRetry() bool {
+	var now = time.Now().Unix()
+	this.stat.locker.Lock()
+	var allowed = this.allow(now)
+	if allowed {
+		this.stat.retries[this.stat.bucket(now)]++
+	}
+	this.stat.locker.Unlock()
+
+	if !allowed {
+		atomic.AddInt64(&this.stat.denied, 1)
+	}
+	return allowed
+}
+
+// AddLatency 记录源站返回响应Header所用时间
+func (this *OriginRetryBudget) AddLatency(latency time.Duration) {
+	this.stat.addLatency(latency)
+}
+
+// HedgeDelay 发送对冲请求前需要等待的时间
+func (this *OriginRetryBudget) HedgeDelay() time.Duration {
+	var minDelay = time.Duration(this.policy.HedgeMinDelay) * time.Millisecond
+	latency, ok := this.stat.latencyPercentile(this.policy.HedgePercentile, this.policy.MinSamples, time.Now())
+	if !ok {
+		return max(minDelay, time.Duration(this.policy.HedgeDelay)*time.Millisecond)
+	}
+	return max(minDelay, latency)
+}
+
+// AddHedge 记录一次对冲请求
+func (this *OriginRetryBudget) AddHedge() {
+	atomic.AddInt64(&this.stat.hedges, 1)
+}
+
+// AddHedgeWin 记录一次对冲请求先于原请求返回
+func (this *OriginRetryBudget) AddHedgeWin() {
+	atomic.AddInt64(&this.stat.hedgeWins, 1)
+}
+
+// 调用前需要加锁
+func (this *OriginRetryBudget) allow(now int64) bool {
+	requests, retries := this.stat.counts(now)
+	var limit = float64(requests)*this.policy.BudgetRatio + this.policy.BudgetMinPerSecond*float64(len(this.stat.seconds))
+	return float64(retries+1) <= limit
+}
+
+// OriginHedgeManager 对冲请求和重试预算管理
+type OriginHedgeManager struct {
+	config  *OriginHedgeConfig
+	statMap map[int64]*originHedgeStat // serverId => stat
+
+	locker sync.RWMutex
+}
+
+// NewOriginHedgeManager 获取新对象
+func NewOriginHedgeManager() *OriginHedgeManager {
+	return &OriginHedgeManager{
+		config:  DefaultOriginHedgeConfig(),
+		statMap: map[int64]*originHedgeStat{},
+	}
+}
+
+// UpdateConfig 修改配置
+func (this *OriginHedgeManager) UpdateConfig(config *OriginHedgeConfig) {
+	if config == nil {
+		return
+	}
+	this.locker.Lock()
+	this.config = config
+	this.statMap = map[int64]*originHedgeStat{} // 统计窗口可能发生变化
+	this.locker.Unlock()
+}
+
+// FindBudget 查找网站的重试预算
+// 没有适用的策略时返回nil，表示不限制重试
+func (this *OriginHedgeManager) FindBudget(serverId int64, reverseProxy *serverconfigs.ReverseProxyConfig) *OriginRetryBudget {
+	if reverseProxy == nil {
+		return nil
+	}
+
+	this.locker.RLock()
+	var config = this.config
+	var policy *OriginHedgePolicy
+	if config.IsOn {
+		for _, p := range config.Policies {
+			if p.MatchReverseProxy(serverId, reverseProxy.Id) {
+				policy = p
+				break
+			}
+		}
+	}
+	if policy == nil {
+		this.locker.RUnlock()
+		return nil
+	}
+	stat, ok := this.statMap[serverId]
+	this.locker.RUnlock()
+
+	if !ok {
+		this.locker.Lock()
+		stat, ok = this.statMap[serverId]
+		if !ok {
+			stat = newOriginHedgeStat(policy.BudgetWindow)
+			this.statMap[serverId] = stat
+		}
+		this.locker.Unlock()
+	}
+
+	return &OriginRetryBudget{
+		policy: policy,
+		stat:   stat,
+	}
+}
+
+// Stats 各网站统计数据
+func (this *OriginHedgeManager) Stats() []maps.Map {
+	this.locker.RLock()
+	defer this.locker.RUnlock()
+
+	var now = time.Now().Unix()
+	var result = []maps.Map{}
+	for serverId, stat := range this.statMap {
+		stat.locker.Lock()
+		requests, retries := stat.counts(now)
+		var percentile = stat.percentile
+		stat.locker.Unlock()
+
+		result = append(result, maps.Map{
+			"serverId":     serverId,
+			"requests":     requests,
+			"retries":      retries,
+			"denied":       atomic.LoadInt64(&stat.denied),
+			"hedges":       atomic.LoadInt64(&stat.hedges),
+			"hedgeWins":    atomic.LoadInt64(&stat.hedgeWins),
+			"percentileMs": percentile.Milliseconds(),
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].GetInt64("serverId") < result[j].GetInt64("serverId")
+	})
+	return result
+}
@@ -0,0 +1,111 @@
+// Copyright 2024 GoEdge CDN goedge.cdn@gmail.com. All rights reserved. Official site: https://goedge.cloud .
+
+package nodes
+
+import (
+	"testing"
+	"time"
+
+	"github.com/TeaOSLab/EdgeCommon/pkg/serverconfigs"
+	"github.com/iwind/TeaGo/assert"
+)
+
+func newTestOriginRetryBudget(t *testing.T, policy *OriginHedgePolicy) *OriginRetryBudget {
+	var config = &OriginHedgeConfig{
+		IsOn:     true,
+		Policies: []*OriginHedgePolicy{policy},
+	}
+	err := config.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var manager = NewOriginHedgeManager()
+	manager.UpdateConfig(config)
+	return manager.FindBudget(1, &serverconfigs.ReverseProxyConfig{Id: 1})
+}
+
+func TestOriginRetryBudget_Retry(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	var budget = newTestOriginRetryBudget(t, &OriginHedgePolicy{BudgetRatio: 0.1})
+	a.IsNotNil(budget)
+	a.IsFalse(budget.AllowRetry())
+
+	for i := 0; i < 20; i++ {
+		budget.AddRequest()
+	}
+	a.IsTrue(budget.AllowRetry())
+	a.IsTrue(budget.AcquireRetry())
+	budget.AddRetry()
+	a.IsFalse(budget.AllowRetry())
+	a.IsFalse(budget.AcquireRetry())
+
+	// 每秒额外允许的次数
+	budget = newTestOriginRetryBudget(t, &OriginHedgePolicy{BudgetMinPerSecond: 0.1, BudgetWindow: 10})
+	a.IsTrue(budget.AcquireRetry())
+	a.IsFalse(budget.AcquireRetry())
+}
+
+func TestOriginRetryBudget_Window(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	var stat = newOriginHedgeStat(10)
+	var now = time.Now().Unix()
+	stat.requests[stat.bucket(now-20)] += 100
+	stat.requests[stat.bucket(now-5)] += 10
+	stat.retries[stat.bucket(now)] += 1
+
+	requests, retries := stat.counts(now)
+	a.IsTrue(requests == 10)
+	a.IsTrue(retries == 1)
+}
+
+func TestOriginRetryBudget_HedgeDelay(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	var budget = newTestOriginRetryBudget(t, &OriginHedgePolicy{
+		Hedge:           true,
+		HedgePercentile: 90,
+		HedgeMinDelay:   5,
+		HedgeDelay:      300,
+		MinSamples:      10,
+	})
+	a.IsTrue(budget.HedgeDelay() == 300*time.Millisecond)
+
+	for i := 1; i <= 100; i++ {
+		budget.AddLatency(time.Duration(i) * time.Millisecond)
+	}
+	a.IsTrue(budget.HedgeDelay() == 90*time.Millisecond)
+
+	// 不小于最小等待时间
+	budget = newTestOriginRetryBudget(t, &OriginHedgePolicy{HedgeMinDelay: 50, MinSamples: 1})
+	budget.AddLatency(1 * time.Millisecond)
+	a.IsTrue(budget.HedgeDelay() == 50*time.Millisecond)
+}
+
+func TestOriginHedgeManager_FindBudget(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	var manager = NewOriginHedgeManager()
+	a.IsNil(manager.FindBudget(1, &serverconfigs.ReverseProxyConfig{Id: 1}))
+
+	var config = &OriginHedgeConfig{
+		IsOn: true,
+		Policies: []*OriginHedgePolicy{
+			{ReverseProxyIds: []int64{2}},
+		},
+	}
+	a.IsNil(config.Init())
+	manager.UpdateConfig(config)
+	a.IsNil(manager.FindBudget(1, &serverconfigs.ReverseProxyConfig{Id: 1}))
+	a.IsNotNil(manager.FindBudget(1, &serverconfigs.ReverseProxyConfig{Id: 2}))
+	a.IsNil(manager.FindBudget(1, nil))
+
+	// 同一个网站使用同一个预算
+	manager.FindBudget(1, &serverconfigs.ReverseProxyConfig{Id: 2}).AddRequest()
+	a.IsTrue(manager.Stats()[0].GetInt64("requests") == 1)
+
+	a.IsNotNil((&OriginHedgeConfig{Policies: []*OriginHedgePolicy{{HedgePercentile: 100}}}).Init())
+	a.IsNotNil((&OriginHedgeConfig{Policies: []*OriginHedgePolicy{{BudgetRatio: -1}}}).Init())
+}
@@ -0,0 +1,313 @@
+// Copyright 2024 GoEdge CDN goedge.cdn@gmail.com. All rights reserved. Official site: https://goedge.cloud .
+
+package nodes
+
+import (
+	"errors"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/TeaOSLab/EdgeCommon/pkg/serverconfigs"
+	"github.com/TeaOSLab/EdgeNode/internal/configs"
+	teaconst "github.com/TeaOSLab/EdgeNode/internal/const"
+	"github.com/TeaOSLab/EdgeNode/internal/events"
+	"github.com/TeaOSLab/EdgeNode/internal/remotelogs"
+	"github.com/iwind/TeaGo/lists"
+	"github.com/iwind/TeaGo/maps"
+	"github.com/iwind/TeaGo/types"
+)
+
+// OriginMirrorConfigFileName 流量镜像配置文件
+const OriginMirrorConfigFileName = "origin_mirror.yaml"
+
+// 保留的最近差异记录数
+const originMirrorMaxDivergences = 32
+
+var SharedOriginMirrorManager = NewOriginMirrorManager()
+
+func init() {
+	if !teaconst.IsMain {
+		return
+	}
+
+	events.On(events.EventLoaded, func() {
+		config, err := LoadOriginMirrorConfig()
+		if err != nil {
+			remotelogs.Error("ORIGIN_MIRROR", "load '"+OriginMirrorConfigFileName+"' failed: "+err.Error())
+			return
+		}
+		SharedOriginMirrorManager.UpdateConfig(config)
+	})
+}
+
+// OriginMirrorConfig 流量镜像配置
+type OriginMirrorConfig struct {
+	IsOn     bool                  `yaml:"isOn" json:"isOn"`         // 是否启用
+	Policies []*OriginMirrorPolicy `yaml:"policies" json:"policies"` // 策略列表
+}
+
+// DefaultOriginMirrorConfig 默认配置
+func DefaultOriginMirrorConfig() *OriginMirrorConfig {
+	return &OriginMirrorConfig{
+		IsOn: true,
+	}
+}
+
+// Init 初始化
+func (this *OriginMirrorConfig) Init() error {
+	for index, policy := range this.Policies {
+		if policy == nil {
+			return errors.New("policy #" + types.String(index+1) + " should not be empty")
+		}
+		err := policy.Init()
+		if err != nil {
+			return errors.New("policy #" + types.String(index+1) + ": " + err.Error())
+		}
+	}
+	return nil
+}
+
+// LoadOriginMirrorConfig 从配置文件中加载流量镜像配置
+// 如果配置文件不存在，则返回默认配置
+func LoadOriginMirrorConfig() (*OriginMirrorConfig, error) {
+	return configs.LoadLocalConfig(OriginMirrorConfigFileName, DefaultOriginMirrorConfig())
+}
+
+// OriginMirrorPolicy 单个流量镜像策略
+type OriginMirrorPolicy struct {
+	ServerIds       []int64 `yaml:"serverIds" json:"serverIds"`             // 适用的网站ID
+	ReverseProxyIds []int64 `yaml:"reverseProxyIds" json:"reverseProxyIds"` // 适用的反向代理ID，和网站ID都为空时表示所有网站
+
+	MirrorOriginId int64    `yaml:"mirrorOriginId" json:"mirrorOriginId"` // 镜像源站ID
+	Percent        float64  `yaml:"percent" json:"percent"`               // 镜像的请求百分比
+	Methods        []string `yaml:"methods" json:"methods"`               // 镜像的请求方法，为空表示所有方法
+	MaxBodySize    int64    `yaml:"maxBodySize" json:"maxBodySize"`       // 最大请求体尺寸，超出的请求不镜像，单位：字节
+	Timeout        int      `yaml:"timeout" json:"timeout"`               // 镜像请求超时时间，单位：秒
+	MaxConcurrent  int      `yaml:"maxConcurrent" json:"maxConcurrent"`   // 最多同时进行的镜像请求数，超出的请求不镜像
+	LatencyDiff    int      `yaml:"latencyDiff" json:"latencyDiff"`       // 响应时间相差超过此值时记录差异，0表示不记录，单位：毫秒
+
+	stat *originMirrorStat
+}
+
+// Init 初始化
+func (this *OriginMirrorPolicy) Init() error {
+	if this.MirrorOriginId <= 0 {
+		return errors.New("'mirrorOriginId' should be set")
+	}
+	if this.Percent <= 0 || this.Percent > 100 {
+		return errors.New("'percent' should be between 0 and 100")
+	}
+	for index, method := range this.Methods {
+		this.Methods[index] = strings.ToUpper(strings.TrimSpace(method))
+	}
+	if this.MaxBodySize <= 0 {
+		this.MaxBodySize = 64 << 10
+	}
+	if this.Timeout <= 0 {
+		this.Timeout = 10
+	}
+	if this.MaxConcurrent <= 0 {
+		this.MaxConcurrent = 64
+	}
+	if this.LatencyDiff < 0 {
+		return errors.New("'latencyDiff' should not be negative")
+	}
+
+	this.stat = newOriginMirrorStat(this.MaxConcurrent)
+	return nil
+}
+
+// MatchReverseProxy 检查是否适用于某个网站的反向代理
+func (this *OriginMirrorPolicy) MatchReverseProxy(serverId int64, reverseProxyId int64) bool {
+	if len(this.ServerIds) == 0 && len(this.ReverseProxyIds) == 0 {
+		return true
+	}
+	return lists.ContainsInt64(this.ServerIds, serverId) || lists.ContainsInt64(this.ReverseProxyIds, reverseProxyId)
+}
+
+// MatchRequest 检查某个请求是否需要镜像
+func (this *OriginMirrorPolicy) MatchRequest(req *http.Request) bool {
+	if len(this.Methods) > 0 && !lists.ContainsString(this.Methods, req.Method) {
+		return false
+	}
+	if req.ContentLength > this.MaxBodySize {
+		atomic.AddInt64(&this.stat.skipped, 1)
+		return false
+	}
+	return this.Percent >= 100 || rand.Float64()*100 < this.Percent
+}
+
+// Acquire 获取一个镜像请求名额，超出并发限制时返回false
+func (this *OriginMirrorPolicy) Acquire() bool {
+	select {
+	case this.stat.slots <- struct{}{}:
+		return true
+	default:
+		atomic.AddInt64(&this.stat.dropped, 1)
+		return false
+	}
+}
+
+// Release 释放镜像请求名额
+func (this *OriginMirrorPolicy) Release() {
+	<-this.stat.slots
+}
+
+// Skip 记录因为请求体过大等原因而放弃的镜像请求
+func (this *OriginMirrorPolicy) Skip() {
+	atomic.AddInt64(&this.stat.skipped, 1)
+}
+
+// AddResult 记录一对主请求和镜像请求的结果
+// primaryStatus 和 mirrorStatus 为0时表示请求出错
+func (this *OriginMirrorPolicy) AddResult(method string, url string, primaryStatus int, primaryCost time.Duration, mirrorStatus int, mirrorCost time.Duration, mirrorErr error) {
+	var stat = this.stat
+	atomic.AddInt64(&stat.requests, 1)
+	atomic.AddInt64(&stat.primaryCostMs, primaryCost.Milliseconds())
+	atomic.AddInt64(&stat.mirrorCostMs, mirrorCost.Milliseconds())
+
+	var reason string
+	if mirrorErr != nil {
+		atomic.AddInt64(&stat.errors, 1)
+		reason = "error"
+	} else if primaryStatus != mirrorStatus {
+		atomic.AddInt64(&stat.statusDiffs, 1)
+		reason = "status"
+	} else if this.LatencyDiff > 0 && (primaryCost-mirrorCost).Abs() >= time.Duration(this.LatencyDiff)*time.Millisecond {
+		atomic.AddInt64(&stat.latencyDiffs, 1)
+		reason = "latency"
+	} else {
+		return
+	}
+
+	var divergence = maps.Map{
+		"reason":        reason,
+		"method":        method,
+		"url":           url,
+		"primaryStatus": primaryStatus,
+		"primaryCostMs": primaryCost.Milliseconds(),
+		"mirrorStatus":  mirrorStatus,
+		"mirrorCostMs":  mirrorCost.Milliseconds(),
+		"createdAt":     time.Now().Unix(),
+	}
+	if mirrorErr != nil {
+		divergence["error"] = mirrorErr.Error()
+	}
+
+	stat.locker.Lock()
+	if len(stat.divergences) >= originMirrorMaxDivergences {
+		stat.divergences = stat.divergences[1:]
+	}
+	stat.divergences = append(stat.divergences, divergence)
+	stat.locker.Unlock()
+}
+
+// 单个策略的统计
+type originMirrorStat struct {
+	slots chan struct{} // 并发限制
+
+	requests      int64 // 完成的镜像请求数
+	skipped       int64 // 因为请求体过大等原因而放弃的请求数
+	dropped       int64 // 因为超出并发限制而放弃的请求数
+	errors        int64 // 镜像请求出错次数
+	statusDiffs   int64 // 状态码不同的次数
+	latencyDiffs  int64 // 响应时间相差过大的次数
+	primaryCostMs int64 // 主请求累计耗时
+	mirrorCostMs  int64 // 镜像请求累计耗时
+
+	locker      sync.Mutex
+	divergences []maps.Map // 最近的差异记录
+}
+
+func newOriginMirrorStat(maxConcurrent int) *originMirrorStat {
+	return &originMirrorStat{
+		slots: make(chan struct{}, maxConcurrent),
+	}
+}
+
+// OriginMirrorManager 流量镜像管理
+type OriginMirrorManager struct {
+	config *OriginMirrorConfig
+
+	locker sync.RWMutex
+}
+
+// NewOriginMirrorManager 获取新对象
+func NewOriginMirrorManager() *OriginMirrorManager {
+	return &OriginMirrorManager{
+		config: DefaultOriginMirrorConfig(),
+	}
+}
+
+// UpdateConfig 修改配置
+func (this *OriginMirrorManager) UpdateConfig(config *OriginMirrorConfig) {
+	if config == nil {
+		return
+	}
+	this.locker.Lock()
+	this.config = config
+	this.locker.Unlock()
+}
+
+// FindPolicy 查找适用于网站反向代理的策略
+func (this *OriginMirrorManager) FindPolicy(serverId int64, reverseProxy *serverconfigs.ReverseProxyConfig) *OriginMirrorPolicy {
+	if reverseProxy == nil {
+		return nil
+	}
+
+	this.locker.RLock()
+	defer this.locker.RUnlock()
+
+	if !this.config.IsOn {
+		return nil
+	}
+	for _, policy := range this.config.Policies {
+		if policy.MatchReverseProxy(serverId, reverseProxy.Id) {
+			return policy
+		}
+	}
+	return nil
+}
+
+// Stats 镜像统计
+func (this *OriginMirrorManager) Stats() []maps.Map {
+	this.locker.RLock()
+	defer this.locker.RUnlock()
+
+	var result = []maps.Map{}
+	for index, policy := range this.config.Policies {
+		var stat = policy.stat
+		var requests = atomic.LoadInt64(&stat.requests)
+		var avgPrimaryCostMs int64
+		var avgMirrorCostMs int64
+		if requests > 0 {
+			avgPrimaryCostMs = atomic.LoadInt64(&stat.primaryCostMs) / requests
+			avgMirrorCostMs = atomic.LoadInt64(&stat.mirrorCostMs) / requests
+		}
+
+		stat.locker.Lock()
+		var divergences = append([]maps.Map{}, stat.divergences...)
+		stat.locker.Unlock()
+
+		result = append(result, maps.Map{
+			"policy":           index + 1,
+			"mirrorOriginId":   policy.MirrorOriginId,
+			"percent":          policy.Percent,
+			"requests":         requests,
+			"pending":          len(stat.slots),
+			"skipped":          atomic.LoadInt64(&stat.skipped),
+			"dropped":          atomic.LoadInt64(&stat.dropped),
+			"errors":           atomic.LoadInt64(&stat.errors),
+			"statusDiffs":      atomic.LoadInt64(&stat.statusDiffs),
+			"latencyDiffs":     atomic.LoadInt64(&stat.latencyDiffs),
+			"avgPrimaryCostMs": avgPrimaryCostMs,
+			"avgMirrorCostMs":  avgMirrorCostMs,
+			"divergences":      divergences,
+		})
+	}
+	return result
+}
@@ -0,0 +1,92 @@
+// Copyright 2024 GoEdge CDN goedge.cdn@gmail.com. All rights reserved. Official site: https://goedge.cloud .
+
+package nodes
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/TeaOSLab/EdgeCommon/pkg/serverconfigs"
+	"github.com/iwind/TeaGo/assert"
+)
+
+func TestOriginMirrorConfig_Init(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	a.IsNotNil((&OriginMirrorConfig{Policies: []*OriginMirrorPolicy{{Percent: 10}}}).Init())
+	a.IsNotNil((&OriginMirrorConfig{Policies: []*OriginMirrorPolicy{{MirrorOriginId: 1}}}).Init())
+	a.IsNotNil((&OriginMirrorConfig{Policies: []*OriginMirrorPolicy{{MirrorOriginId: 1, Percent: 101}}}).Init())
+
+	var policy = &OriginMirrorPolicy{MirrorOriginId: 1, Percent: 10, Methods: []string{"get"}}
+	a.IsNil((&OriginMirrorConfig{Policies: []*OriginMirrorPolicy{policy}}).Init())
+	a.IsTrue(policy.Methods[0] == "GET")
+	a.IsTrue(policy.MaxBodySize == 64<<10)
+	a.IsTrue(policy.MaxConcurrent == 64)
+}
+
+func TestOriginMirrorPolicy_MatchRequest(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	var policy = &OriginMirrorPolicy{MirrorOriginId: 1, Percent: 100, Methods: []string{"GET", "POST"}, MaxBodySize: 1024}
+	a.IsNil(policy.Init())
+
+	req, _ := http.NewRequest(http.MethodGet, "http://example.com/", nil)
+	a.IsTrue(policy.MatchRequest(req))
+
+	req.Method = http.MethodDelete
+	a.IsFalse(policy.MatchRequest(req))
+
+	req.Method = http.MethodPost
+	req.ContentLength = 2048
+	a.IsFalse(policy.MatchRequest(req))
+	a.IsTrue(policy.stat.skipped == 1)
+}
+
+func TestOriginMirrorPolicy_Acquire(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	var policy = &OriginMirrorPolicy{MirrorOriginId: 1, Percent: 100, MaxConcurrent: 2}
+	a.IsNil(policy.Init())
+	a.IsTrue(policy.Acquire())
+	a.IsTrue(policy.Acquire())
+	a.IsFalse(policy.Acquire())
+	policy.Release()
+	a.IsTrue(policy.Acquire())
+	a.IsTrue(policy.stat.dropped == 1)
+}
+
+func TestOriginMirrorManager_Stats(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	var config = &OriginMirrorConfig{
+		IsOn:     true,
+		Policies: []*OriginMirrorPolicy{{ServerIds: []int64{1}, MirrorOriginId: 2, Percent: 50, LatencyDiff: 100}},
+	}
+	a.IsNil(config.Init())
+
+	var manager = NewOriginMirrorManager()
+	manager.UpdateConfig(config)
+	a.IsNil(manager.FindPolicy(2, &serverconfigs.ReverseProxyConfig{Id: 1}))
+
+	var policy = manager.FindPolicy(1, &serverconfigs.ReverseProxyConfig{Id: 1})
+	a.IsNotNil(policy)
+	policy.AddResult(http.MethodGet, "/a", 200, 10*time.Millisecond, 200, 30*time.Millisecond, nil)
+	policy.AddResult(http.MethodGet, "/b", 200, 10*time.Millisecond, 500, 30*time.Millisecond, nil)
+	policy.AddResult(http.MethodGet, "/c", 200, 10*time.Millisecond, 200, 300*time.Millisecond, nil)
+	policy.AddResult(http.MethodGet, "/d", 200, 10*time.Millisecond, 0, 40*time.Millisecond, errors.New("timeout"))
+	for i := 0; i < originMirrorMaxDivergences; i++ {
+		policy.AddResult(http.MethodGet, "/e", 200, 10*time.Millisecond, 404, 10*time.Millisecond, nil)
+	}
+
+	var stats = manager.Stats()
+	a.IsTrue(len(stats) == 1)
+	var stat = stats[0]
+	a.IsTrue(stat.GetInt64("requests") == int64(4+originMirrorMaxDivergences))
+	a.IsTrue(stat.GetInt64("statusDiffs") == int64(1+originMirrorMaxDivergences))
+	a.IsTrue(stat.GetInt64("latencyDiffs") == 1)
+	a.IsTrue(stat.GetInt64("errors") == 1)
+	a.IsTrue(len(policy.stat.divergences) == originMirrorMaxDivergences)
+	t.Log(stat)
+}
@@ -0,0 +1,216 @@
+// Copyright 2024 GoEdge CDN goedge.cdn@gmail.com. All rights reserved. Official site: https://goedge.cloud .
+
+package nodes
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/TeaOSLab/EdgeCommon/pkg/serverconfigs"
+	"github.com/TeaOSLab/EdgeNode/internal/configs"
+	teaconst "github.com/TeaOSLab/EdgeNode/internal/const"
+	"github.com/TeaOSLab/EdgeNode/internal/events"
+	"github.com/TeaOSLab/EdgeNode/internal/remotelogs"
+	"github.com/iwind/TeaGo/lists"
+	"github.com/iwind/TeaGo/types"
+)
+
+// OriginRetryConfigFileName 回源重试配置文件
+const OriginRetryConfigFileName = "origin_retry.yaml"
+
+// OriginRetryMode 重试方式
+type OriginRetryMode = string
+
+const (
+	OriginRetryModeNever   OriginRetryMode = "never"   // 不重试
+	OriginRetryModeConnect OriginRetryMode = "connect" // 只在请求尚未发送到源站时重试，比如连接失败
+	OriginRetryModeAny     OriginRetryMode = "any"     // 请求已经发送到源站后也可以重试
+)
+
+// 默认可以在请求发送后重试的幂等方法
+var defaultOriginRetryIdempotentMethods = []string{
+	http.MethodGet,
+	http.MethodHead,
+	http.MethodOptions,
+	http.MethodTrace,
+	http.MethodPut,
+	http.MethodDelete,
+}
+
+var SharedOriginRetryManager = NewOriginRetryManager()
+
+func init() {
+	if !teaconst.IsMain {
+		return
+	}
+
+	events.On(events.EventLoaded, func() {
+		config, err := LoadOriginRetryConfig()
+		if err != nil {
+			remotelogs.Error("ORIGIN_RETRY", "load '"+OriginRetryConfigFileName+"' failed: "+err.Error())
+			return
+		}
+		SharedOriginRetryManager.UpdateConfig(config)
+	})
+}
+
+// OriginRetryConfig 回源重试配置
+type OriginRetryConfig struct {
+	IsOn     bool                 `yaml:"isOn" json:"isOn"`         // 是否启用
+	Policies []*OriginRetryPolicy `yaml:"policies" json:"policies"` // 策略列表
+}
+
+// DefaultOriginRetryConfig 默认配置
+func DefaultOriginRetryConfig() *OriginRetryConfig {
+	return &OriginRetryConfig{
+		IsOn: true,
+	}
+}
+
+// Init 初始化
+func (this *OriginRetryConfig) Init() error {
+	for index, policy := range this.Policies {
+		if policy == nil {
+			return errors.New("policy #" + types.String(index+1) + " should not be empty")
+		}
+		err := policy.Init()
+		if err != nil {
+			return errors.New("policy #" + types.String(index+1) + ": " + err.Error())
+		}
+	}
+	return nil
+}
+
+// LoadOriginRetryConfig 从配置文件中加载回源重试配置
+// 如果配置文件不存在，则返回默认配置
+func LoadOriginRetryConfig() (*OriginRetryConfig, error) {
+	return configs.LoadLocalConfig(OriginRetryConfigFileName, DefaultOriginRetryConfig())
+}
+
+// OriginRetryMethodRule 请求方法的重试规则
+type OriginRetryMethodRule struct {
+	Methods []string        `yaml:"methods" json:"methods"` // 请求方法
+	Retry   OriginRetryMode `yaml:"retry" json:"retry"`     // 重试方式：never、connect、any
+}
+
+// OriginRetryPolicy 单个回源重试策略
+type OriginRetryPolicy struct {
+	ServerIds       []int64 `yaml:"serverIds" json:"serverIds"`             // 适用的网站ID
+	ReverseProxyIds []int64 `yaml:"reverseProxyIds" json:"reverseProxyIds"` // 适用的反向代理ID，和网站ID都为空时表示所有网站
+
+	MaxMemoryBodySize int64  `yaml:"maxMemoryBodySize" json:"maxMemoryBodySize"` // 在内存中缓存的最大请求体尺寸，超出后写入临时文件，单位：字节
+	MaxBodySize       int64  `yaml:"maxBodySize" json:"maxBodySize"`             // 缓存的最大请求体尺寸，超出的请求只在请求尚未发送时重试，单位：字节
+	TempDir           string `yaml:"tempDir" json:"tempDir"`                     // 临时文件目录，为空表示使用系统临时目录
+
+	Methods        []*OriginRetryMethodRule `yaml:"methods" json:"methods"`               // 请求方法的重试规则，没有匹配的规则时，幂等方法使用any，其他方法使用connect
+	IdempotencyKey bool                     `yaml:"idempotencyKey" json:"idempotencyKey"` // 请求中带有Idempotency-Key时，是否可以在请求发送后重试
+}
+
+// Init 初始化
+func (this *OriginRetryPolicy) Init() error {
+	if this.MaxMemoryBodySize <= 0 {
+		this.MaxMemoryBodySize = 64 << 10
+	}
+	if this.MaxBodySize <= 0 {
+		this.MaxBodySize = 8 << 20
+	}
+	if this.MaxBodySize < this.MaxMemoryBodySize {
+		this.MaxBodySize = this.MaxMemoryBodySize
+	}
+
+	for index, rule := range this.Methods {
+		if rule == nil {
+			return errors.New("method rule #" + types.String(index+1) + " should not be empty")
+		}
+		if len(rule.Methods) == 0 {
+			return errors.New("method rule #" + types.String(index+1) + ": 'methods' should not be empty")
+		}
+		for methodIndex, method := range rule.Methods {
+			rule.Methods[methodIndex] = strings.ToUpper(strings.TrimSpace(method))
+		}
+		switch rule.Retry {
+		case OriginRetryModeNever, OriginRetryModeConnect, OriginRetryModeAny:
+		default:
+			return errors.New("method rule #" + types.String(index+1) + ": invalid retry mode '" + rule.Retry + "'")
+		}
+	}
+	return nil
+}
+
+// MatchReverseProxy 检查是否适用于某个网站的反向代理
+func (this *OriginRetryPolicy) MatchReverseProxy(serverId int64, reverseProxyId int64) bool {
+	if len(this.ServerIds) == 0 && len(this.ReverseProxyIds) == 0 {
+		return true
+	}
+	return lists.ContainsInt64(this.ServerIds, serverId) || lists.ContainsInt64(this.ReverseProxyIds, reverseProxyId)
+}
+
+// RetryMode 计算某个请求的重试方式
+func (this *OriginRetryPolicy) RetryMode(method string, hasIdempotencyKey bool) OriginRetryMode {
+	var mode = ""
+	for _, rule := range this.Methods {
+		if lists.ContainsString(rule.Methods, method) || lists.ContainsString(rule.Methods, "*") {
+			mode = rule.Retry
+			break
+		}
+	}
+	if len(mode) == 0 {
+		if lists.ContainsString(defaultOriginRetryIdempotentMethods, method) {
+			mode = OriginRetryModeAny
+		} else {
+			mode = OriginRetryModeConnect
+		}
+	}
+
+	// 客户端声明了幂等
+	if mode == OriginRetryModeConnect && hasIdempotencyKey && this.IdempotencyKey {
+		mode = OriginRetryModeAny
+	}
+	return mode
+}
+
+// OriginRetryManager 回源重试配置管理
+type OriginRetryManager struct {
+	config *OriginRetryConfig
+
+	locker sync.RWMutex
+}
+
+// NewOriginRetryManager 获取新对象
+func NewOriginRetryManager() *OriginRetryManager {
+	return &OriginRetryManager{
+		config: DefaultOriginRetryConfig(),
+	}
+}
+
+// UpdateConfig 修改配置
+func (this *OriginRetryManager) UpdateConfig(config *OriginRetryConfig) {
+	if config == nil {
+		return
+	}
+	this.locker.Lock()
+	this.config = config
+	this.locker.Unlock()
+}
+
+// FindPolicy 查找适用于网站反向代理的策略
+func (this *OriginRetryManager) FindPolicy(serverId int64, reverseProxy *serverconfigs.ReverseProxyConfig) *OriginRetryPolicy {
+	if reverseProxy == nil {
+		return nil
+	}
+
+	this.locker.RLock()
+	defer this.locker.RUnlock()
+
+	if !this.config.IsOn {
+		return nil
+	}
+	for _, policy := range this.config.Policies {
+		if policy.MatchReverseProxy(serverId, reverseProxy.Id) {
+			return policy
+		}
+	}
+	return nil
+}
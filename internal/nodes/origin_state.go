@@ -11,4 +11,16 @@ type OriginState struct {
 	Addr         string
 	TLSHost      string
 	ReverseProxy *serverconfigs.ReverseProxyConfig
+
+	LastError        string // 最后一次错误信息
+	IsTLSVerifyError bool   // 最后一次错误是否为证书校验错误
+}
+
+// SetError 设置最后一次错误
+func (this *OriginState) SetError(err error) {
+	if err == nil {
+		return
+	}
+	this.LastError = err.Error()
+	this.IsTLSVerifyError = IsOriginTLSVerifyError(err)
 }
@@ -3,6 +3,7 @@
 package nodes
 
 import (
+	"sort"
 	"sync"
 	"time"
 
@@ -13,6 +14,7 @@ import (
 	"github.com/TeaOSLab/EdgeNode/internal/utils/goman"
 	"github.com/TeaOSLab/EdgeNode/internal/utils/trackers"
 	"github.com/iwind/TeaGo/Tea"
+	"github.com/iwind/TeaGo/maps"
 )
 
 var SharedOriginStateManager = NewOriginStateManager()
@@ -106,7 +108,11 @@ func (this *OriginStateManager) Loop() error {
 		go func(state *OriginState) {
 			defer wg.Done()
 			conn, _, err := OriginConnect(state.Config, 0, "", state.TLSHost)
-			if err == nil {
+			if err != nil {
+				this.locker.Lock()
+				state.SetError(err)
+				this.locker.Unlock()
+			} else {
 				_ = conn.Close()
 
 				// 已经恢复正常
@@ -128,11 +134,17 @@ func (this *OriginStateManager) Loop() error {
 }
 
 // Fail 添加失败的源站
-func (this *OriginStateManager) Fail(origin *serverconfigs.OriginConfig, tlsHost string, reverseProxy *serverconfigs.ReverseProxyConfig, callback func()) {
+// err 为导致失败的错误，用来区分证书校验错误等
+func (this *OriginStateManager) Fail(origin *serverconfigs.OriginConfig, tlsHost string, reverseProxy *serverconfigs.ReverseProxyConfig, err error, callback func()) {
 	if origin == nil || origin.Id <= 0 {
 		return
 	}
 
+	// 由健康检查管理的源站
+	if SharedOriginHealthManager.ReportFailure(origin, err) {
+		return
+	}
+
 	this.locker.Lock()
 	state, ok := this.stateMap[origin.Id]
 	var timestamp = time.Now().Unix()
@@ -143,6 +155,7 @@ func (this *OriginStateManager) Fail(origin *serverconfigs.OriginConfig, tlsHost
 		}
 
 		state.TLSHost = tlsHost
+		state.SetError(err)
 		state.CountFails++
 		state.Config = origin
 		state.ReverseProxy = reverseProxy
@@ -160,13 +173,15 @@ func (this *OriginStateManager) Fail(origin *serverconfigs.OriginConfig, tlsHost
 	} else {
 		// 同时最多监控 N 个源站地址
 		if len(this.stateMap) < maxOriginStates {
-			this.stateMap[origin.Id] = &OriginState{
+			state = &OriginState{
 				CountFails:   1,
 				Config:       origin,
 				TLSHost:      tlsHost,
 				ReverseProxy: reverseProxy,
 				UpdatedAt:    timestamp,
 			}
+			state.SetError(err)
+			this.stateMap[origin.Id] = state
 		}
 
 		origin.IsOk = true
@@ -180,6 +195,11 @@ func (this *OriginStateManager) Success(origin *serverconfigs.OriginConfig, call
 		return
 	}
 
+	// 由健康检查管理的源站，只能通过健康检查恢复
+	if SharedOriginHealthManager.IsManaged(origin.Id) {
+		return
+	}
+
 	if !origin.IsOk {
 		if callback != nil {
 			defer callback()
@@ -204,3 +224,30 @@ func (this *OriginStateManager) IsAvailable(originId int64) bool {
 
 	return !ok
 }
+
+// States 当前异常的源站状态
+func (this *OriginStateManager) States() []maps.Map {
+	this.locker.RLock()
+	defer this.locker.RUnlock()
+
+	var result = []maps.Map{}
+	for originId, state := range this.stateMap {
+		var addr string
+		if state.Config != nil && state.Config.Addr != nil {
+			addr = state.Config.Addr.PickAddress()
+		}
+		result = append(result, maps.Map{
+			"originId":         originId,
+			"addr":             addr,
+			"tlsHost":          state.TLSHost,
+			"countFails":       state.CountFails,
+			"updatedAt":        state.UpdatedAt,
+			"lastError":        state.LastError,
+			"isTLSVerifyError": state.IsTLSVerifyError,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].GetInt64("originId") < result[j].GetInt64("originId")
+	})
+	return result
+}
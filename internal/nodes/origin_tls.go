@@ -0,0 +1,371 @@
+// Copyright 2024 GoEdge CDN goedge.cdn@gmail.com. All rights reserved. Official site: https://goedge.cloud .
+
+package nodes
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/TeaOSLab/EdgeCommon/pkg/serverconfigs"
+	"github.com/TeaOSLab/EdgeNode/internal/configs"
+	teaconst "github.com/TeaOSLab/EdgeNode/internal/const"
+	"github.com/TeaOSLab/EdgeNode/internal/events"
+	"github.com/TeaOSLab/EdgeNode/internal/remotelogs"
+	"github.com/cespare/xxhash/v2"
+	"github.com/iwind/TeaGo/types"
+)
+
+// OriginTLSConfigFileName 源站TLS校验配置文件
+const OriginTLSConfigFileName = "origin_tls.yaml"
+
+var sharedOriginTLSManager = NewOriginTLSManager()
+
+func init() {
+	if !teaconst.IsMain {
+		return
+	}
+
+	// 重新加载节点配置时同时重新加载，新的策略对之后建立的源站连接生效
+	var loadConfig = func() {
+		config, err := LoadOriginTLSConfig()
+		if err != nil {
+			remotelogs.Error("ORIGIN_TLS", "load '"+OriginTLSConfigFileName+"' failed: "+err.Error())
+			return
+		}
+		sharedOriginTLSManager.UpdateConfig(config)
+	}
+	events.On(events.EventLoaded, loadConfig)
+	events.On(events.EventReload, loadConfig)
+}
+
+// OriginTLSVerifyError 源站证书校验错误
+type OriginTLSVerifyError struct {
+	Err error
+}
+
+func (this *OriginTLSVerifyError) Error() string {
+	return "origin tls verification failed: " + this.Err.Error()
+}
+
+func (this *OriginTLSVerifyError) Unwrap() error {
+	return this.Err
+}
+
+// IsOriginTLSVerifyError 判断是否为源站证书校验错误
+func IsOriginTLSVerifyError(err error) bool {
+	if err == nil {
+		return false
+	}
+	var verifyErr *OriginTLSVerifyError
+	return errors.As(err, &verifyErr)
+}
+
+// OriginTLSConfig 源站TLS校验配置
+type OriginTLSConfig struct {
+	Policies []*OriginTLSPolicy `yaml:"policies" json:"policies"` // 策略列表
+}
+
+// DefaultOriginTLSConfig 默认配置
+func DefaultOriginTLSConfig() *OriginTLSConfig {
+	return &OriginTLSConfig{}
+}
+
+// Init 初始化
+func (this *OriginTLSConfig) Init() error {
+	for index, policy := range this.Policies {
+		if policy == nil {
+			return errors.New("policy #" + types.String(index+1) + " should not be empty")
+		}
+		err := policy.Init()
+		if err != nil {
+			return errors.New("policy #" + types.String(index+1) + ": " + err.Error())
+		}
+	}
+	return nil
+}
+
+// LoadOriginTLSConfig 从配置文件中加载源站TLS校验配置
+// 如果配置文件不存在，则返回默认配置
+func LoadOriginTLSConfig() (*OriginTLSConfig, error) {
+	return configs.LoadLocalConfig(OriginTLSConfigFileName, DefaultOriginTLSConfig())
+}
+
+// OriginTLSPolicy 源站TLS校验策略
+type OriginTLSPolicy struct {
+	OriginIds      []int64  `yaml:"originIds" json:"originIds"`           // 适用的源站ID，为空表示所有源站
+	Verify         bool     `yaml:"verify" json:"verify"`                 // 是否校验源站证书链和域名
+	CAFile         string   `yaml:"caFile" json:"caFile"`                 // 自定义CA证书文件（PEM格式），为空表示使用系统根证书
+	ServerName     string   `yaml:"serverName" json:"serverName"`         // 握手时发送的SNI，为空表示使用回源主机名
+	VerifyHostname string   `yaml:"verifyHostname" json:"verifyHostname"` // 校验证书时使用的域名，为空表示使用SNI
+	Pins           []string `yaml:"pins" json:"pins"`                     // 证书公钥（SPKI）SHA256指纹，格式：sha256/BASE64 或者十六进制，匹配证书链中任一证书即可
+	MinVersion     string   `yaml:"minVersion" json:"minVersion"`         // 最低TLS版本：1.0、1.1、1.2、1.3
+	CipherSuites   []string `yaml:"cipherSuites" json:"cipherSuites"`     // 允许的加密套件（TLS 1.3不适用），为空表示使用默认套件
+
+	rootCAs      *x509.CertPool
+	pinMap       map[string]bool
+	minVersion   uint16
+	cipherSuites []uint16
+	key          string
+}
+
+// Init 初始化
+func (this *OriginTLSPolicy) Init() error {
+	var keyData, err = json.Marshal(this)
+	if err != nil {
+		return err
+	}
+
+	// CA
+	this.rootCAs = nil
+	if len(this.CAFile) > 0 {
+		caData, err := os.ReadFile(this.CAFile)
+		if err != nil {
+			return errors.New("read ca file failed: " + err.Error())
+		}
+		this.rootCAs = x509.NewCertPool()
+		if !this.rootCAs.AppendCertsFromPEM(caData) {
+			return errors.New("no valid certificates found in ca file '" + this.CAFile + "'")
+		}
+
+		// CA文件内容变化时也需要重建客户端
+		keyData = append(keyData, caData...)
+	}
+
+	// 公钥指纹
+	this.pinMap = map[string]bool{}
+	for _, pin := range this.Pins {
+		sum, err := this.decodePin(pin)
+		if err != nil {
+			return err
+		}
+		this.pinMap[string(sum)] = true
+	}
+
+	// 版本
+	switch this.MinVersion {
+	case "":
+		this.minVersion = 0
+	case "1.0":
+		this.minVersion = tls.VersionTLS10
+	case "1.1":
+		this.minVersion = tls.VersionTLS11
+	case "1.2":
+		this.minVersion = tls.VersionTLS12
+	case "1.3":
+		this.minVersion = tls.VersionTLS13
+	default:
+		return errors.New("invalid 'minVersion': " + this.MinVersion)
+	}
+
+	// 加密套件
+	this.cipherSuites = nil
+	if len(this.CipherSuites) > 0 {
+		var suiteMap = map[string]uint16{}
+		for _, suite := range tls.CipherSuites() {
+			suiteMap[suite.Name] = suite.ID
+		}
+		for _, suite := range tls.InsecureCipherSuites() {
+			suiteMap[suite.Name] = suite.ID
+		}
+		for _, name := range this.CipherSuites {
+			id, ok := suiteMap[name]
+			if !ok {
+				return errors.New("unknown cipher suite '" + name + "'")
+			}
+			this.cipherSuites = append(this.cipherSuites, id)
+		}
+	}
+
+	this.key = types.String(xxhash.Sum64(keyData))
+
+	return nil
+}
+
+// MatchOrigin 检查是否适用于某个源站
+func (this *OriginTLSPolicy) MatchOrigin(originId int64) bool {
+	if len(this.OriginIds) == 0 {
+		return true
+	}
+	for _, id := range this.OriginIds {
+		if id == originId {
+			return true
+		}
+	}
+	return false
+}
+
+// Key 策略唯一标识，配置或者CA文件内容变化时会改变
+func (this *OriginTLSPolicy) Key() string {
+	return this.key
+}
+
+// VerifyConnection 校验源站证书
+func (this *OriginTLSPolicy) VerifyConnection(state tls.ConnectionState, verifyChain bool) error {
+	if len(state.PeerCertificates) == 0 {
+		return &OriginTLSVerifyError{Err: errors.New("no certificates presented by origin")}
+	}
+
+	// 用于匹配公钥指纹的证书：校验证书链时只使用校验通过的证书链中的证书，否则只使用源站证书本身，
+	// 防止源站在证书列表中附加公开的CA证书绕过指纹检查
+	var pinCerts = state.PeerCertificates[:1]
+
+	if verifyChain {
+		var hostname = this.VerifyHostname
+		if len(hostname) == 0 {
+			hostname = state.ServerName
+		}
+		if len(hostname) == 0 {
+			return &OriginTLSVerifyError{Err: errors.New("no hostname to verify")}
+		}
+
+		var intermediates = x509.NewCertPool()
+		for _, cert := range state.PeerCertificates[1:] {
+			intermediates.AddCert(cert)
+		}
+		chains, err := state.PeerCertificates[0].Verify(x509.VerifyOptions{
+			Roots:         this.rootCAs,
+			DNSName:       hostname,
+			Intermediates: intermediates,
+		})
+		if err != nil {
+			return &OriginTLSVerifyError{Err: err}
+		}
+
+		pinCerts = nil
+		for _, chain := range chains {
+			pinCerts = append(pinCerts, chain...)
+		}
+	}
+
+	if len(this.pinMap) > 0 {
+		for _, cert := range pinCerts {
+			var sum = sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+			if this.pinMap[string(sum[:])] {
+				return nil
+			}
+		}
+		return &OriginTLSVerifyError{Err: errors.New("public key pinning mismatch")}
+	}
+
+	return nil
+}
+
+func (this *OriginTLSPolicy) decodePin(pin string) ([]byte, error) {
+	pin = strings.TrimSpace(pin)
+	if strings.HasPrefix(pin, "sha256/") {
+		sum, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(pin, "sha256/"))
+		if err == nil && len(sum) == sha256.Size {
+			return sum, nil
+		}
+	} else {
+		sum, err := hex.DecodeString(strings.ReplaceAll(pin, ":", ""))
+		if err == nil && len(sum) == sha256.Size {
+			return sum, nil
+		}
+	}
+	return nil, errors.New("invalid pin '" + pin + "'")
+}
+
+// OriginTLSManager 源站TLS校验策略管理
+type OriginTLSManager struct {
+	config        *OriginTLSConfig
+	defaultPolicy *OriginTLSPolicy // 没有匹配的策略时使用，校验证书链和域名
+	locker        sync.RWMutex
+}
+
+// NewOriginTLSManager 获取新对象
+func NewOriginTLSManager() *OriginTLSManager {
+	var defaultPolicy = &OriginTLSPolicy{Verify: true}
+	_ = defaultPolicy.Init()
+
+	return &OriginTLSManager{
+		config:        DefaultOriginTLSConfig(),
+		defaultPolicy: defaultPolicy,
+	}
+}
+
+// UpdateConfig 修改配置
+func (this *OriginTLSManager) UpdateConfig(config *OriginTLSConfig) {
+	if config == nil {
+		return
+	}
+	this.locker.Lock()
+	this.config = config
+	this.locker.Unlock()
+}
+
+// FindPolicy 查找某个源站适用的策略
+// 优先使用指定了源站ID的策略，没有匹配的策略时使用默认策略
+func (this *OriginTLSManager) FindPolicy(originId int64) *OriginTLSPolicy {
+	this.locker.RLock()
+	defer this.locker.RUnlock()
+
+	var defaultPolicy *OriginTLSPolicy
+	for _, policy := range this.config.Policies {
+		if len(policy.OriginIds) == 0 {
+			if defaultPolicy == nil {
+				defaultPolicy = policy
+			}
+			continue
+		}
+		if policy.MatchOrigin(originId) {
+			return policy
+		}
+	}
+	if defaultPolicy == nil {
+		defaultPolicy = this.defaultPolicy
+	}
+	return defaultPolicy
+}
+
+// BuildTLSConfig 构造连接源站使用的TLS配置
+// tlsHost 为回源主机名，为空时使用默认的SNI
+func (this *OriginTLSManager) BuildTLSConfig(origin *serverconfigs.OriginConfig, tlsHost string) *tls.Config {
+	var tlsConfig = &tls.Config{}
+	if origin.Cert != nil {
+		var obj = origin.Cert.CertObject()
+		if obj != nil {
+			tlsConfig.Certificates = []tls.Certificate{*obj}
+			if len(origin.Cert.ServerName) > 0 {
+				tlsConfig.ServerName = origin.Cert.ServerName
+			}
+		}
+	}
+	if len(tlsHost) > 0 {
+		tlsConfig.ServerName = tlsHost
+	}
+
+	var policy = this.FindPolicy(origin.Id)
+	if len(policy.ServerName) > 0 {
+		tlsConfig.ServerName = policy.ServerName
+	}
+	tlsConfig.MinVersion = policy.minVersion
+	tlsConfig.CipherSuites = policy.cipherSuites
+
+	// 使用IP连接源站时不会发送SNI，此时使用源站地址校验证书
+	var originHost string
+	if origin.Addr != nil {
+		originHost = origin.Addr.Host
+	}
+
+	// 使用自定义校验，以便支持自定义CA、单独的校验域名和公钥指纹
+	// 设置了客户端证书时总是校验源站证书，和之前的行为保持一致
+	var verifyChain = policy.Verify || len(tlsConfig.Certificates) > 0
+	tlsConfig.InsecureSkipVerify = true
+	tlsConfig.VerifyConnection = func(state tls.ConnectionState) error {
+		if len(state.ServerName) == 0 {
+			state.ServerName = originHost
+		}
+		return policy.VerifyConnection(state, verifyChain)
+	}
+
+	return tlsConfig
+}
@@ -0,0 +1,220 @@
+// Copyright 2024 GoEdge CDN goedge.cdn@gmail.com. All rights reserved. Official site: https://goedge.cloud .
+
+package nodes
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/TeaOSLab/EdgeCommon/pkg/serverconfigs"
+	"github.com/iwind/TeaGo/assert"
+)
+
+func newTestOriginTLSServer(t *testing.T) (server *httptest.Server, caFile string) {
+	server = httptest.NewTLSServer(http.HandlerFunc(func(writer http.ResponseWriter, req *http.Request) {
+		_, _ = writer.Write([]byte("ok"))
+	}))
+	t.Cleanup(server.Close)
+
+	caFile = filepath.Join(t.TempDir(), "ca.pem")
+	err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: server.Certificate().Raw,
+	}), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func testOriginTLSDial(manager *OriginTLSManager, server *httptest.Server, tlsHost string) error {
+	var tlsConfig = manager.BuildTLSConfig(&serverconfigs.OriginConfig{Id: 1}, tlsHost)
+	conn, err := tls.Dial("tcp", server.Listener.Addr().String(), tlsConfig)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func TestOriginTLSManager_BuildTLSConfig(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	var server, caFile = newTestOriginTLSServer(t)
+	var manager = NewOriginTLSManager()
+
+	// 没有策略时默认校验证书，系统根证书无法校验测试证书
+	var tlsConfig = manager.BuildTLSConfig(&serverconfigs.OriginConfig{Id: 1}, "")
+	a.IsTrue(tlsConfig.VerifyConnection != nil)
+	var defaultKey = manager.FindPolicy(1).Key()
+	a.IsTrue(len(defaultKey) > 0)
+	var err = testOriginTLSDial(manager, server, "example.com")
+	a.IsNotNil(err)
+	a.IsTrue(IsOriginTLSVerifyError(err))
+
+	// 明确关闭校验
+	var config = &OriginTLSConfig{
+		Policies: []*OriginTLSPolicy{
+			{Verify: false},
+		},
+	}
+	a.IsNil(config.Init())
+	manager.UpdateConfig(config)
+	a.IsNil(testOriginTLSDial(manager, server, "example.com"))
+
+	// 自定义CA
+	config = &OriginTLSConfig{
+		Policies: []*OriginTLSPolicy{
+			{Verify: true},
+			{OriginIds: []int64{1}, Verify: true, CAFile: caFile, MinVersion: "1.2"},
+		},
+	}
+	a.IsNil(config.Init())
+	manager.UpdateConfig(config)
+	a.IsNil(testOriginTLSDial(manager, server, "example.com"))
+
+	// 域名不匹配
+	err = testOriginTLSDial(manager, server, "example.org")
+	a.IsTrue(IsOriginTLSVerifyError(err))
+
+	// 单独指定校验的域名
+	config.Policies[1].VerifyHostname = "example.com"
+	a.IsNil(config.Init())
+	a.IsNil(testOriginTLSDial(manager, server, "example.org"))
+
+	var newKey = manager.FindPolicy(1).Key()
+	a.IsTrue(len(newKey) > 0 && newKey != defaultKey)
+	var otherKey = manager.FindPolicy(2).Key()
+	a.IsTrue(newKey != otherKey)
+}
+
+func TestOriginTLSPolicy_Pins(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	var server, _ = newTestOriginTLSServer(t)
+	var sum = sha256.Sum256(server.Certificate().RawSubjectPublicKeyInfo)
+
+	var manager = NewOriginTLSManager()
+	var config = &OriginTLSConfig{
+		Policies: []*OriginTLSPolicy{
+			{Pins: []string{"sha256/" + base64.StdEncoding.EncodeToString(sum[:])}},
+		},
+	}
+	a.IsNil(config.Init())
+	manager.UpdateConfig(config)
+	a.IsNil(testOriginTLSDial(manager, server, ""))
+
+	sum[0]++
+	config = &OriginTLSConfig{
+		Policies: []*OriginTLSPolicy{
+			{Pins: []string{base64.StdEncoding.EncodeToString(sum[:])}},
+		},
+	}
+	a.IsNotNil(config.Init()) // 缺少 sha256/ 前缀
+
+	config.Policies[0].Pins = []string{"sha256/" + base64.StdEncoding.EncodeToString(sum[:])}
+	a.IsNil(config.Init())
+	manager.UpdateConfig(config)
+	a.IsTrue(IsOriginTLSVerifyError(testOriginTLSDial(manager, server, "")))
+}
+
+// 生成测试证书，parent为nil时生成自签名的CA证书
+func newTestOriginTLSCert(t *testing.T, commonName string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var template = &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-1 * time.Hour),
+		NotAfter:     time.Now().Add(1 * time.Hour),
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign
+		parent = template
+		parentKey = key
+	} else {
+		template.DNSNames = []string{commonName}
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	}
+	certData, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(certData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func TestOriginTLSPolicy_PinsBypass(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	var pinnedCA, pinnedCAKey = newTestOriginTLSCert(t, "Pinned CA", nil, nil)
+	var pinnedLeaf, _ = newTestOriginTLSCert(t, "example.com", pinnedCA, pinnedCAKey)
+	var attackerCA, attackerCAKey = newTestOriginTLSCert(t, "Attacker CA", nil, nil)
+	var attackerLeaf, _ = newTestOriginTLSCert(t, "example.com", attackerCA, attackerCAKey)
+
+	// 两个CA都被信任
+	var caFile = filepath.Join(t.TempDir(), "ca.pem")
+	var caData = append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: pinnedCA.Raw}), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: attackerCA.Raw})...)
+	err := os.WriteFile(caFile, caData, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var caSum = sha256.Sum256(pinnedCA.RawSubjectPublicKeyInfo)
+	var policy = &OriginTLSPolicy{
+		CAFile: caFile,
+		Pins:   []string{"sha256/" + base64.StdEncoding.EncodeToString(caSum[:])},
+	}
+	a.IsNil(policy.Init())
+
+	// 校验证书链：固定的CA在校验通过的证书链中
+	a.IsNil(policy.VerifyConnection(tls.ConnectionState{ServerName: "example.com", PeerCertificates: []*x509.Certificate{pinnedLeaf}}, true))
+
+	// 校验证书链：在其他CA签发的证书后附加固定的CA证书
+	err = policy.VerifyConnection(tls.ConnectionState{ServerName: "example.com", PeerCertificates: []*x509.Certificate{attackerLeaf, pinnedCA}}, true)
+	a.IsTrue(IsOriginTLSVerifyError(err))
+
+	// 不校验证书链时只检查源站证书本身
+	err = policy.VerifyConnection(tls.ConnectionState{PeerCertificates: []*x509.Certificate{attackerLeaf, pinnedCA}}, false)
+	a.IsTrue(IsOriginTLSVerifyError(err))
+
+	var leafSum = sha256.Sum256(attackerLeaf.RawSubjectPublicKeyInfo)
+	policy.Pins = []string{"sha256/" + base64.StdEncoding.EncodeToString(leafSum[:])}
+	a.IsNil(policy.Init())
+	a.IsNil(policy.VerifyConnection(tls.ConnectionState{PeerCertificates: []*x509.Certificate{attackerLeaf, pinnedCA}}, false))
+}
+
+func TestOriginTLSConfig_Init(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	a.IsNotNil((&OriginTLSConfig{Policies: []*OriginTLSPolicy{{MinVersion: "2.0"}}}).Init())
+	a.IsNotNil((&OriginTLSConfig{Policies: []*OriginTLSPolicy{{CipherSuites: []string{"UNKNOWN"}}}}).Init())
+	a.IsNotNil((&OriginTLSConfig{Policies: []*OriginTLSPolicy{{CAFile: "/not-exists.pem"}}}).Init())
+
+	var policy = &OriginTLSPolicy{MinVersion: "1.3", CipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"}}
+	a.IsNil(policy.Init())
+	var key = policy.Key()
+	policy.MinVersion = "1.2"
+	a.IsNil(policy.Init())
+	a.IsTrue(key != policy.Key())
+}
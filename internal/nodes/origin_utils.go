@@ -53,23 +53,7 @@ func OriginConnect(origin *serverconfigs.OriginConfig, serverPort int, remoteAdd
 						// TODO 支持TCP4/TCP6
 						// TODO 支持指定特定网卡
 
-						var tlsConfig = &tls.Config{
-							InsecureSkipVerify: true,
-						}
-						if origin.Cert != nil {
-							var obj = origin.Cert.CertObject()
-							if obj != nil {
-								tlsConfig.InsecureSkipVerify = false
-								tlsConfig.Certificates = []tls.Certificate{*obj}
-								if len(origin.Cert.ServerName) > 0 {
-									tlsConfig.ServerName = origin.Cert.ServerName
-								}
-							}
-						}
-						if len(tlsHost) > 0 {
-							tlsConfig.ServerName = tlsHost
-						}
-
+						var tlsConfig = sharedOriginTLSManager.BuildTLSConfig(origin, tlsHost)
 						conn, err = tls.DialWithDialer(&dialer, "tcp", originAddr, tlsConfig)
 					}
 
@@ -99,23 +83,7 @@ func OriginConnect(origin *serverconfigs.OriginConfig, serverPort int, remoteAdd
 		// TODO 支持TCP4/TCP6
 		// TODO 支持指定特定网卡
 
-		var tlsConfig = &tls.Config{
-			InsecureSkipVerify: true,
-		}
-		if origin.Cert != nil {
-			var obj = origin.Cert.CertObject()
-			if obj != nil {
-				tlsConfig.InsecureSkipVerify = false
-				tlsConfig.Certificates = []tls.Certificate{*obj}
-				if len(origin.Cert.ServerName) > 0 {
-					tlsConfig.ServerName = origin.Cert.ServerName
-				}
-			}
-		}
-		if len(tlsHost) > 0 {
-			tlsConfig.ServerName = tlsHost
-		}
-
+		var tlsConfig = sharedOriginTLSManager.BuildTLSConfig(origin, tlsHost)
 		originConn, err = tls.Dial("tcp", originAddr, tlsConfig)
 		return originConn, originAddr, err
 	case serverconfigs.ProtocolUDP:
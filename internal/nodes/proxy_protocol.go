@@ -0,0 +1,259 @@
+// Copyright 2024 GoEdge CDN goedge.cdn@gmail.com. All rights reserved. Official site: https://goedge.cloud .
+
+package nodes
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/TeaOSLab/EdgeNode/internal/configs"
+	teaconst "github.com/TeaOSLab/EdgeNode/internal/const"
+	"github.com/TeaOSLab/EdgeNode/internal/events"
+	"github.com/TeaOSLab/EdgeNode/internal/remotelogs"
+	"github.com/iwind/TeaGo/lists"
+	"github.com/iwind/TeaGo/types"
+	"github.com/pires/go-proxyproto"
+	"github.com/pires/go-proxyproto/tlvparse"
+)
+
+// ProxyProtocolConfigFileName 接收PROXY协议配置文件
+const ProxyProtocolConfigFileName = "proxy_protocol.yaml"
+
+var SharedProxyProtocolManager = NewProxyProtocolManager()
+
+func init() {
+	if !teaconst.IsMain {
+		return
+	}
+
+	// 重新加载节点配置时同时重新加载，已经在监听的端口在接受新的连接时使用新的配置
+	var loadConfig = func() {
+		config, err := LoadProxyProtocolConfig()
+		if err != nil {
+			remotelogs.Error("PROXY_PROTOCOL", "load '"+ProxyProtocolConfigFileName+"' failed: "+err.Error())
+			return
+		}
+		SharedProxyProtocolManager.UpdateConfig(config)
+	}
+	events.On(events.EventLoaded, loadConfig)
+	events.On(events.EventReload, loadConfig)
+}
+
+// ProxyProtocolConfig 接收PROXY协议配置
+type ProxyProtocolConfig struct {
+	IsOn     bool                   `yaml:"isOn" json:"isOn"`         // 是否启用
+	Policies []*ProxyProtocolPolicy `yaml:"policies" json:"policies"` // 策略列表
+}
+
+// DefaultProxyProtocolConfig 默认配置
+func DefaultProxyProtocolConfig() *ProxyProtocolConfig {
+	return &ProxyProtocolConfig{
+		IsOn: true,
+	}
+}
+
+// Init 初始化
+func (this *ProxyProtocolConfig) Init() error {
+	for index, policy := range this.Policies {
+		if policy == nil {
+			return errors.New("policy #" + types.String(index+1) + " should not be empty")
+		}
+		err := policy.Init()
+		if err != nil {
+			return errors.New("policy #" + types.String(index+1) + ": " + err.Error())
+		}
+	}
+	return nil
+}
+
+// LoadProxyProtocolConfig 从配置文件中加载接收PROXY协议配置
+// 如果配置文件不存在，则返回默认配置
+func LoadProxyProtocolConfig() (*ProxyProtocolConfig, error) {
+	return configs.LoadLocalConfig(ProxyProtocolConfigFileName, DefaultProxyProtocolConfig())
+}
+
+// ProxyProtocolPolicy 单个接收PROXY协议策略
+type ProxyProtocolPolicy struct {
+	Ports         []int    `yaml:"ports" json:"ports"`                 // 适用的监听端口，为空表示所有端口
+	TrustedIPs    []string `yaml:"trustedIPs" json:"trustedIPs"`       // 可信的来源IP或者CIDR，只有从这些地址来的连接才会解析PROXY协议头
+	HeaderTimeout int      `yaml:"headerTimeout" json:"headerTimeout"` // 读取PROXY协议头的超时时间，单位：秒
+	Required      bool     `yaml:"required" json:"required"`           // 可信来源是否必须发送PROXY协议头，如果为false，没有协议头的连接作为普通连接处理
+
+	trustedNets []*net.IPNet
+}
+
+// Init 初始化
+func (this *ProxyProtocolPolicy) Init() error {
+	if len(this.TrustedIPs) == 0 {
+		return errors.New("'trustedIPs' should not be empty")
+	}
+
+	this.trustedNets = nil
+	for _, trustedIP := range this.TrustedIPs {
+		trustedIP = strings.TrimSpace(trustedIP)
+		if !strings.Contains(trustedIP, "/") {
+			var ip = net.ParseIP(trustedIP)
+			if ip == nil {
+				return errors.New("invalid trusted ip '" + trustedIP + "'")
+			}
+			if ip.To4() != nil {
+				trustedIP += "/32"
+			} else {
+				trustedIP += "/128"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(trustedIP)
+		if err != nil {
+			return errors.New("invalid trusted ip '" + trustedIP + "': " + err.Error())
+		}
+		this.trustedNets = append(this.trustedNets, ipNet)
+	}
+
+	if this.HeaderTimeout <= 0 {
+		this.HeaderTimeout = 5
+	}
+	return nil
+}
+
+// MatchPort 检查是否适用于某个监听端口
+func (this *ProxyProtocolPolicy) MatchPort(port int) bool {
+	return len(this.Ports) == 0 || lists.ContainsInt(this.Ports, port)
+}
+
+// IsTrusted 检查来源IP是否可信
+func (this *ProxyProtocolPolicy) IsTrusted(ip string) bool {
+	var netIP = net.ParseIP(ip)
+	if netIP == nil {
+		return false
+	}
+	for _, ipNet := range this.trustedNets {
+		if ipNet.Contains(netIP) {
+			return true
+		}
+	}
+	return false
+}
+
+// ProxyProtocolManager 接收PROXY协议配置管理
+type ProxyProtocolManager struct {
+	config  *ProxyProtocolConfig
+	version int64 // 每次修改配置后增加，用来检查配置是否有变化
+
+	locker sync.RWMutex
+}
+
+// NewProxyProtocolManager 获取新对象
+func NewProxyProtocolManager() *ProxyProtocolManager {
+	return &ProxyProtocolManager{
+		config: DefaultProxyProtocolConfig(),
+	}
+}
+
+// UpdateConfig 修改配置
+func (this *ProxyProtocolManager) UpdateConfig(config *ProxyProtocolConfig) {
+	if config == nil {
+		return
+	}
+	this.locker.Lock()
+	this.config = config
+	this.locker.Unlock()
+	atomic.AddInt64(&this.version, 1)
+}
+
+// Version 配置版本
+func (this *ProxyProtocolManager) Version() int64 {
+	return atomic.LoadInt64(&this.version)
+}
+
+// FindPolicy 查找适用于某个监听端口的策略
+func (this *ProxyProtocolManager) FindPolicy(port int) *ProxyProtocolPolicy {
+	this.locker.RLock()
+	defer this.locker.RUnlock()
+
+	if !this.config.IsOn {
+		return nil
+	}
+	for _, policy := range this.config.Policies {
+		if policy.MatchPort(port) {
+			return policy
+		}
+	}
+	return nil
+}
+
+// ProxyProtocolInfo 通过PROXY协议传递的客户端信息
+type ProxyProtocolInfo struct {
+	Version         byte     // 协议版本：1、2
+	IsLocal         bool     // 是否为LOCAL命令，比如负载均衡器的健康检查，此时没有客户端地址
+	SourceAddr      net.Addr // 客户端地址
+	DestinationAddr net.Addr // 客户端连接的目标地址，通常为负载均衡器的地址
+
+	Authority   string // 客户端请求的主机名，通常为TLS SNI
+	ALPN        string // 客户端协商的应用层协议
+	UniqueId    string // 连接唯一ID
+	SSL         bool   // 客户端是否通过TLS连接到负载均衡器
+	SSLVerified bool   // 客户端证书是否已经通过验证
+	SSLVersion  string // TLS版本
+	SSLCN       string // 客户端证书的Common Name
+}
+
+// 从PROXY协议头中解析客户端信息
+func newProxyProtocolInfo(header *proxyproto.Header) *ProxyProtocolInfo {
+	var info = &ProxyProtocolInfo{
+		Version:         header.Version,
+		IsLocal:         header.Command.IsLocal(),
+		SourceAddr:      header.SourceAddr,
+		DestinationAddr: header.DestinationAddr,
+	}
+	if header.Version != 2 {
+		return info
+	}
+
+	tlvs, err := header.TLVs()
+	if err != nil {
+		return info
+	}
+	for _, tlv := range tlvs {
+		switch tlv.Type {
+		case proxyproto.PP2_TYPE_AUTHORITY:
+			info.Authority = string(tlv.Value)
+		case proxyproto.PP2_TYPE_ALPN:
+			info.ALPN = string(tlv.Value)
+		case proxyproto.PP2_TYPE_UNIQUE_ID:
+			info.UniqueId = string(tlv.Value)
+		}
+	}
+	ssl, ok := tlvparse.FindSSL(tlvs)
+	if ok {
+		info.SSL = ssl.ClientSSL()
+		info.SSLVerified = (ssl.ClientCertConn() || ssl.ClientCertSess()) && ssl.Verified()
+		info.SSLVersion, _ = ssl.SSLVersion()
+		info.SSLCN, _ = ssl.ClientCN()
+	}
+	return info
+}
+
+// ParseProxyProtocolPacket 解析UDP数据包开头的PROXY协议头，只支持v2版本
+// 没有协议头时返回的header为nil
+func ParseProxyProtocolPacket(data []byte) (header *proxyproto.Header, payload []byte, err error) {
+	var bytesReader = bytes.NewReader(data)
+	var reader = bufio.NewReaderSize(bytesReader, len(data))
+	header, err = proxyproto.Read(reader)
+	if err != nil {
+		if errors.Is(err, proxyproto.ErrNoProxyProtocol) {
+			return nil, data, nil
+		}
+		return nil, nil, err
+	}
+	if header.Version != 2 {
+		return nil, nil, errors.New("proxy protocol version " + types.String(header.Version) + " is not supported for udp")
+	}
+
+	var headerSize = len(data) - reader.Buffered() - bytesReader.Len()
+	return header, data[headerSize:], nil
+}
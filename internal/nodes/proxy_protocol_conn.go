@@ -0,0 +1,92 @@
+// Copyright 2024 GoEdge CDN goedge.cdn@gmail.com. All rights reserved. Official site: https://goedge.cloud .
+
+package nodes
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"os"
+	"time"
+
+	"github.com/pires/go-proxyproto"
+)
+
+// ProxyProtocolConn 已经读取PROXY协议头的连接
+// RemoteAddr() 返回协议头中的客户端地址，LocalAddr() 仍然返回本机监听的地址
+type ProxyProtocolConn struct {
+	net.Conn
+
+	reader *bufio.Reader // 读取协议头时缓冲的数据
+	info   *ProxyProtocolInfo
+}
+
+// ReadProxyProtocolConn 从连接中读取PROXY协议头
+// 没有协议头并且策略不要求必须有协议头时，返回的连接作为普通连接使用
+func ReadProxyProtocolConn(conn net.Conn, policy *ProxyProtocolPolicy) (*ProxyProtocolConn, error) {
+	var reader = bufio.NewReader(conn)
+
+	_ = conn.SetReadDeadline(time.Now().Add(time.Duration(policy.HeaderTimeout) * time.Second))
+	header, err := proxyproto.Read(reader)
+	_ = conn.SetReadDeadline(time.Time{})
+
+	if err != nil {
+		// 没有协议头，或者等待超时并且没有收到任何数据（比如服务端先发送数据的协议）
+		var noHeader = errors.Is(err, proxyproto.ErrNoProxyProtocol) || (os.IsTimeout(err) && reader.Buffered() == 0)
+		if noHeader && !policy.Required {
+			return &ProxyProtocolConn{
+				Conn:   conn,
+				reader: reader,
+			}, nil
+		}
+		return nil, err
+	}
+
+	return &ProxyProtocolConn{
+		Conn:   conn,
+		reader: reader,
+		info:   newProxyProtocolInfo(header),
+	}, nil
+}
+
+func (this *ProxyProtocolConn) Read(b []byte) (n int, err error) {
+	if this.reader != nil {
+		if this.reader.Buffered() > 0 {
+			return this.reader.Read(b)
+		}
+		this.reader = nil
+	}
+	return this.Conn.Read(b)
+}
+
+// RemoteAddr 客户端地址
+func (this *ProxyProtocolConn) RemoteAddr() net.Addr {
+	if this.info != nil && !this.info.IsLocal && this.info.SourceAddr != nil {
+		return this.info.SourceAddr
+	}
+	return this.Conn.RemoteAddr()
+}
+
+// ProxyAddr 发送PROXY协议头的负载均衡器地址
+func (this *ProxyProtocolConn) ProxyAddr() net.Addr {
+	return this.Conn.RemoteAddr()
+}
+
+// Info 协议头中的信息，没有协议头时返回nil
+func (this *ProxyProtocolConn) Info() *ProxyProtocolInfo {
+	return this.info
+}
+
+// NetConn 包装前的连接
+func (this *ProxyProtocolConn) NetConn() net.Conn {
+	return this.Conn
+}
+
+// SetLinger 设置Linger
+func (this *ProxyProtocolConn) SetLinger(sec int) error {
+	lingerConn, ok := this.Conn.(LingerConn)
+	if ok {
+		return lingerConn.SetLinger(sec)
+	}
+	return nil
+}
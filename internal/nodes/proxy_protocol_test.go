@@ -0,0 +1,231 @@
+// Copyright 2024 GoEdge CDN goedge.cdn@gmail.com. All rights reserved. Official site: https://goedge.cloud .
+
+package nodes_test
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/TeaOSLab/EdgeNode/internal/nodes"
+	"github.com/iwind/TeaGo/assert"
+	"github.com/pires/go-proxyproto"
+	"github.com/pires/go-proxyproto/tlvparse"
+)
+
+func TestProxyProtocolPolicy_Init(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	a.IsNotNil((&nodes.ProxyProtocolPolicy{}).Init())
+	a.IsNotNil((&nodes.ProxyProtocolPolicy{TrustedIPs: []string{"a.b.c.d"}}).Init())
+	a.IsNotNil((&nodes.ProxyProtocolPolicy{TrustedIPs: []string{"10.0.0.0/33"}}).Init())
+
+	var policy = &nodes.ProxyProtocolPolicy{
+		Ports:      []int{80, 443},
+		TrustedIPs: []string{"10.0.0.0/8", "192.168.1.1", "::1"},
+	}
+	a.IsNil(policy.Init())
+	a.IsTrue(policy.HeaderTimeout == 5)
+	a.IsTrue(policy.MatchPort(443))
+	a.IsFalse(policy.MatchPort(8080))
+	a.IsTrue(policy.IsTrusted("10.1.2.3"))
+	a.IsTrue(policy.IsTrusted("192.168.1.1"))
+	a.IsFalse(policy.IsTrusted("192.168.1.2"))
+	a.IsTrue(policy.IsTrusted("::1"))
+	a.IsFalse(policy.IsTrusted(""))
+}
+
+func TestProxyProtocolManager_FindPolicy(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	var config = &nodes.ProxyProtocolConfig{
+		IsOn: true,
+		Policies: []*nodes.ProxyProtocolPolicy{
+			{Ports: []int{443}, TrustedIPs: []string{"10.0.0.0/8"}},
+		},
+	}
+	a.IsNil(config.Init())
+
+	var manager = nodes.NewProxyProtocolManager()
+	a.IsNil(manager.FindPolicy(443))
+	var version = manager.Version()
+	manager.UpdateConfig(config)
+	a.IsTrue(manager.Version() > version)
+	a.IsNotNil(manager.FindPolicy(443))
+	a.IsNil(manager.FindPolicy(80))
+
+	config.IsOn = false
+	a.IsNil(manager.FindPolicy(443))
+}
+
+func newTestProxyProtocolPolicy(t *testing.T, required bool) *nodes.ProxyProtocolPolicy {
+	var policy = &nodes.ProxyProtocolPolicy{
+		TrustedIPs:    []string{"127.0.0.1"},
+		HeaderTimeout: 1,
+		Required:      required,
+	}
+	err := policy.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return policy
+}
+
+func readTestProxyProtocolConn(t *testing.T, policy *nodes.ProxyProtocolPolicy, data []byte) (*nodes.ProxyProtocolConn, error) {
+	serverConn, clientConn := net.Pipe()
+	t.Cleanup(func() {
+		_ = serverConn.Close()
+		_ = clientConn.Close()
+	})
+	go func() {
+		if len(data) > 0 {
+			_, _ = clientConn.Write(data)
+		}
+	}()
+	return nodes.ReadProxyProtocolConn(serverConn, policy)
+}
+
+func TestReadProxyProtocolConn_V1(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	conn, err := readTestProxyProtocolConn(t, newTestProxyProtocolPolicy(t, true), []byte("PROXY TCP4 1.2.3.4 10.0.0.1 56324 443\r\nGET / HTTP/1.1\r\n\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.IsTrue(conn.RemoteAddr().String() == "1.2.3.4:56324")
+	a.IsTrue(conn.Info().Version == 1)
+	a.IsTrue(conn.Info().DestinationAddr.String() == "10.0.0.1:443")
+
+	var buf = make([]byte, 18)
+	_, err = io.ReadFull(conn, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.IsTrue(string(buf) == "GET / HTTP/1.1\r\n\r\n")
+}
+
+func TestReadProxyProtocolConn_V2(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	var header = &proxyproto.Header{
+		Version:           2,
+		Command:           proxyproto.PROXY,
+		TransportProtocol: proxyproto.TCPv6,
+		SourceAddr:        &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 1234},
+		DestinationAddr:   &net.TCPAddr{IP: net.ParseIP("2001:db8::2"), Port: 443},
+	}
+	sslTLV, err := tlvparse.PP2SSL{
+		Client: tlvparse.PP2_BITFIELD_CLIENT_SSL,
+		TLV: []proxyproto.TLV{
+			{Type: proxyproto.PP2_SUBTYPE_SSL_VERSION, Value: []byte("TLSv1.3")},
+			{Type: proxyproto.PP2_SUBTYPE_SSL_CN, Value: []byte("client.example.com")},
+		},
+	}.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = header.SetTLVs([]proxyproto.TLV{
+		{Type: proxyproto.PP2_TYPE_AUTHORITY, Value: []byte("example.com")},
+		{Type: proxyproto.PP2_TYPE_ALPN, Value: []byte("h2")},
+		sslTLV,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := header.Format()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := readTestProxyProtocolConn(t, newTestProxyProtocolPolicy(t, true), append(data, []byte("hello")...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.IsTrue(conn.RemoteAddr().String() == "[2001:db8::1]:1234")
+
+	var info = conn.Info()
+	a.IsTrue(info.Version == 2)
+	a.IsTrue(info.Authority == "example.com")
+	a.IsTrue(info.ALPN == "h2")
+	a.IsTrue(info.SSL)
+	a.IsFalse(info.SSLVerified)
+	a.IsTrue(info.SSLVersion == "TLSv1.3")
+	a.IsTrue(info.SSLCN == "client.example.com")
+
+	var buf = make([]byte, 5)
+	_, err = io.ReadFull(conn, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.IsTrue(string(buf) == "hello")
+}
+
+func TestReadProxyProtocolConn_NoHeader(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	// 不要求协议头
+	conn, err := readTestProxyProtocolConn(t, newTestProxyProtocolPolicy(t, false), []byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.IsNil(conn.Info())
+	a.IsTrue(conn.RemoteAddr().String() == conn.ProxyAddr().String())
+	var buf = make([]byte, 5)
+	_, err = io.ReadFull(conn, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.IsTrue(string(buf) == "hello")
+
+	// 没有收到数据
+	conn, err = readTestProxyProtocolConn(t, newTestProxyProtocolPolicy(t, false), nil)
+	a.IsNil(err)
+	a.IsNotNil(conn)
+
+	// 要求协议头
+	_, err = readTestProxyProtocolConn(t, newTestProxyProtocolPolicy(t, true), []byte("hello"))
+	a.IsNotNil(err)
+
+	// 错误的协议头
+	_, err = readTestProxyProtocolConn(t, newTestProxyProtocolPolicy(t, false), []byte("PROXY TCP4 a.b.c.d\r\n"))
+	a.IsNotNil(err)
+}
+
+func TestParseProxyProtocolPacket(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	var header = &proxyproto.Header{
+		Version:           2,
+		Command:           proxyproto.PROXY,
+		TransportProtocol: proxyproto.UDPv4,
+		SourceAddr:        &net.UDPAddr{IP: net.ParseIP("1.2.3.4"), Port: 5353},
+		DestinationAddr:   &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 53},
+	}
+	data, err := header.Format()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	{
+		parsedHeader, payload, err := nodes.ParseProxyProtocolPacket(append(data, []byte("query")...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		a.IsNotNil(parsedHeader)
+		a.IsTrue(parsedHeader.SourceAddr.String() == "1.2.3.4:5353")
+		a.IsTrue(string(payload) == "query")
+	}
+
+	{
+		parsedHeader, payload, err := nodes.ParseProxyProtocolPacket([]byte("query"))
+		a.IsNil(err)
+		a.IsNil(parsedHeader)
+		a.IsTrue(bytes.Equal(payload, []byte("query")))
+	}
+
+	{
+		_, _, err := nodes.ParseProxyProtocolPacket([]byte("PROXY UDP4 1.2.3.4 10.0.0.1 5353 53\r\nquery"))
+		a.IsNotNil(err)
+	}
+}
@@ -4,6 +4,7 @@ package nodes
 
 import (
 	"crypto/md5"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -241,11 +242,24 @@ func (this *UpgradeManager) unzip(zipPath string) error {
 
 // 重启
 func (this *UpgradeManager) restart() error {
+	// 优雅重启：新进程接管监听器后当前进程再退出，新进程无法启动时继续使用当前进程
+	if SharedGracefulRestartManager.IsOn() {
+		err := SharedGracefulRestartManager.Restart(filepath.Dir(this.exe) + "/" + teaconst.ProcessName)
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, ErrGracefulRestartNotSupported) {
+			this.rollback()
+			return errors.New("graceful restart failed, rollback to current version: " + err.Error())
+		}
+	}
+
 	// 关闭当前sock，防止无法重启
 	_ = gosock.NewTmpSock(teaconst.ProcessName).Close()
 
 	// 重新启动
-	if DaemonIsOn && DaemonPid == os.Getppid() {
+	// 通过优雅重启启动的进程不是守护进程的子进程，只要守护进程仍然存在，就由守护进程负责启动
+	if DaemonIsOn && (DaemonPid == os.Getppid() || isProcessAlive(DaemonPid)) {
 		utils.Exit() // TODO 试着更优雅重启
 	} else {
 		// quit
@@ -268,3 +282,17 @@ func (this *UpgradeManager) restart() error {
 	}
 	return nil
 }
+
+// 还原到升级之前的可执行文件
+func (this *UpgradeManager) rollback() {
+	var binDir = filepath.Dir(this.exe)
+	var backupFile = binDir + "/." + teaconst.ProcessName + ".dist"
+	_, err := os.Stat(backupFile)
+	if err != nil {
+		return
+	}
+	err = os.Rename(backupFile, binDir+"/"+teaconst.ProcessName)
+	if err != nil {
+		remotelogs.Error("UPGRADE_MANAGER", "rollback failed: "+err.Error())
+	}
+}
@@ -89,6 +89,10 @@ func (this *DAUManager) Init() error {
 	// clean expires items
 	goman.New(func() {
 		idles.RunTicker(this.cleanTicker, func() {
+			if kvstore.IsReleased() {
+				return
+			}
+
 			err := this.CleanStats()
 			if err != nil {
 				remotelogs.Error("DAU_MANAGER", "clean stats failed: "+err.Error())
@@ -13,6 +13,7 @@ import (
 	"github.com/TeaOSLab/EdgeNode/internal/remotelogs"
 	"github.com/TeaOSLab/EdgeNode/internal/rpc"
 	"github.com/TeaOSLab/EdgeNode/internal/utils/goman"
+	"github.com/TeaOSLab/EdgeNode/internal/utils/kvstore"
 	"github.com/iwind/TeaGo/Tea"
 )
 
@@ -82,6 +83,11 @@ func (this *Manager) Start() {
 	}
 	var ticker = time.NewTicker(duration)
 	for range ticker.C {
+		// 数据库已经交给其他进程
+		if kvstore.IsReleased() {
+			continue
+		}
+
 		err = this.LoopAll()
 		if err != nil {
 			remotelogs.Error("AGENT_MANAGER", "retrieve latest agent ip failed: "+err.Error())
@@ -5,6 +5,7 @@ package executils
 import (
 	"bytes"
 	"context"
+	"io"
 	"os"
 	"os/exec"
 	"strings"
@@ -12,10 +13,11 @@ import (
 )
 
 type Cmd struct {
-	name string
-	args []string
-	env  []string
-	dir  string
+	name  string
+	args  []string
+	env   []string
+	dir   string
+	stdin io.Reader
 
 	ctx        context.Context
 	timeout    time.Duration
@@ -74,6 +76,11 @@ func (this *Cmd) WithDir(dir string) *Cmd {
 	return this
 }
 
+func (this *Cmd) WithStdin(stdin io.Reader) *Cmd {
+	this.stdin = stdin
+	return this
+}
+
 func (this *Cmd) Start() error {
 	var cmd = this.compose()
 	return cmd.Start()
@@ -149,6 +156,10 @@ func (this *Cmd) compose() *exec.Cmd {
 		this.rawCmd.Dir = this.dir
 	}
 
+	if this.stdin != nil {
+		this.rawCmd.Stdin = this.stdin
+	}
+
 	if this.captureStdout {
 		this.stdout = &bytes.Buffer{}
 		this.rawCmd.Stdout = this.stdout
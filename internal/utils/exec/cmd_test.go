@@ -3,6 +3,7 @@
 package executils_test
 
 import (
+	"strings"
 	"testing"
 	"time"
 
@@ -49,6 +50,19 @@ func TestNewTimeoutCmd_Echo3(t *testing.T) {
 	t.Log("stderr:", cmd.Stderr())
 }
 
+func TestNewTimeoutCmd_Stdin(t *testing.T) {
+	var cmd = executils.NewTimeoutCmd(10*time.Second, "cat")
+	cmd.WithStdin(strings.NewReader("hello"))
+	cmd.WithStdout()
+	err := cmd.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cmd.Stdout() != "hello" {
+		t.Fatal("unexpected stdout: " + cmd.Stdout())
+	}
+}
+
 func TestCmd_Process(t *testing.T) {
 	var cmd = executils.NewCmd("echo", "-n", "hello")
 	err := cmd.Run()
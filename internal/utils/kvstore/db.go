@@ -86,6 +86,22 @@ func (this *DB) Truncate() error {
 	return this.store.rawDB.DeleteRange(start, append(start, 0xFF), DefaultWriteOptions)
 }
 
+// 重新打开所有的表
+func (this *DB) open() error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	var lastErr error
+	for _, table := range this.tableMap {
+		err := table.Open()
+		if err != nil {
+			lastErr = err
+		}
+	}
+
+	return lastErr
+}
+
 func (this *DB) Close() error {
 	this.mu.Lock()
 	defer this.mu.Unlock()
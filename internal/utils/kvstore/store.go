@@ -10,6 +10,7 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 
 	"github.com/TeaOSLab/EdgeNode/internal/events"
 	"github.com/TeaOSLab/EdgeNode/internal/remotelogs"
@@ -21,6 +22,11 @@ import (
 
 const StoreSuffix = ".store"
 
+var storesLocker = &sync.Mutex{}
+var openedStores = map[*Store]bool{} // store => true
+var openErrors []error
+var storesReleased = &atomic.Bool{} // 是否已经通过 ReleaseStores() 释放了数据库
+
 type Store struct {
 	name string
 
@@ -28,7 +34,8 @@ type Store struct {
 	rawDB  *pebble.DB
 	locker *fsutils.Locker
 
-	isClosed bool
+	isClosed   bool
+	isReleased bool
 
 	dbs []*DB
 
@@ -149,6 +156,28 @@ func (this *Store) Path() string {
 }
 
 func (this *Store) Open() error {
+	err := this.openRawDB()
+	if err != nil {
+		storesLocker.Lock()
+		openErrors = append(openErrors, fmt.Errorf("open store '%s' failed: %w", this.path, err))
+		storesLocker.Unlock()
+		return err
+	}
+
+	storesLocker.Lock()
+	openedStores[this] = true
+	storesLocker.Unlock()
+
+	// events
+	events.OnClose(func() {
+		_ = this.Close()
+	})
+
+	return nil
+}
+
+// 打开底层数据库，如果数据库正在被其他进程使用，则等待其释放
+func (this *Store) openRawDB() error {
 	err := this.locker.Lock()
 	if err != nil {
 		return err
@@ -172,18 +201,64 @@ func (this *Store) Open() error {
 
 	rawDB, err := pebble.Open(this.path, opt)
 	if err != nil {
+		_ = this.locker.Release()
 		return err
 	}
 	this.rawDB = rawDB
 
-	// events
-	events.OnClose(func() {
-		_ = this.Close()
-	})
-
 	return nil
 }
 
+// Release 临时释放底层数据库，以便其他进程打开，释放期间对表的操作都会返回错误
+func (this *Store) Release() error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	if this.isClosed || this.isReleased || this.rawDB == nil {
+		return nil
+	}
+
+	for _, db := range this.dbs {
+		_ = db.Close()
+	}
+
+	// 先关闭数据库再释放锁，防止其他进程获得锁后无法打开数据库
+	var err = this.rawDB.Close()
+	_ = this.locker.Release()
+	this.isReleased = true
+	return err
+}
+
+// Reopen 重新打开通过 Release() 释放的底层数据库
+func (this *Store) Reopen() error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	if this.isClosed || !this.isReleased {
+		return nil
+	}
+
+	err := this.openRawDB()
+	if err != nil {
+		return err
+	}
+	this.isReleased = false
+
+	var lastErr error
+	for _, db := range this.dbs {
+		err = db.open()
+		if err != nil {
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+// IsReleased 检查底层数据库是否已经被释放
+func (this *Store) IsReleased() bool {
+	return this.isReleased
+}
+
 func (this *Store) Set(keyBytes []byte, valueBytes []byte) error {
 	return this.rawDB.Set(keyBytes, valueBytes, DefaultWriteOptions)
 }
@@ -230,6 +305,15 @@ func (this *Store) Close() error {
 		return nil
 	}
 
+	storesLocker.Lock()
+	delete(openedStores, this)
+	storesLocker.Unlock()
+
+	if this.isReleased {
+		this.isClosed = true
+		return nil
+	}
+
 	_ = this.locker.Release()
 
 	this.mu.Lock()
@@ -257,3 +341,58 @@ func (this *Store) Close() error {
 func (this *Store) IsClosed() bool {
 	return this.isClosed
 }
+
+// ReleaseStores 临时释放所有已经打开的数据库，以便其他进程（比如优雅重启时启动的新进程）打开
+// 释放之前先设置标记，使用数据库的模块可以通过 IsReleased() 暂停读写
+func ReleaseStores() error {
+	storesReleased.Store(true)
+
+	var lastErr error
+	for _, store := range allOpenedStores() {
+		err := store.Release()
+		if err != nil {
+			lastErr = fmt.Errorf("release store '%s' failed: %w", store.path, err)
+		}
+	}
+	return lastErr
+}
+
+// ReopenStores 重新打开通过 ReleaseStores() 释放的数据库
+func ReopenStores() error {
+	var lastErr error
+	for _, store := range allOpenedStores() {
+		err := store.Reopen()
+		if err != nil {
+			lastErr = fmt.Errorf("reopen store '%s' failed: %w", store.path, err)
+		}
+	}
+	storesReleased.Store(false)
+	return lastErr
+}
+
+// IsReleased 数据库是否已经通过 ReleaseStores() 释放
+func IsReleased() bool {
+	return storesReleased.Load()
+}
+
+// OpenError 返回打开数据库时发生的第一个错误
+func OpenError() error {
+	storesLocker.Lock()
+	defer storesLocker.Unlock()
+
+	if len(openErrors) > 0 {
+		return openErrors[0]
+	}
+	return nil
+}
+
+func allOpenedStores() []*Store {
+	storesLocker.Lock()
+	defer storesLocker.Unlock()
+
+	var result = make([]*Store, 0, len(openedStores))
+	for store := range openedStores {
+		result = append(result, store)
+	}
+	return result
+}
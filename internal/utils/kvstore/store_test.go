@@ -126,6 +126,64 @@ func TestOpenStoreDir(t *testing.T) {
 	_ = store
 }
 
+func TestStore_ReleaseAndReopen(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	var dir = Tea.Root + "/data/stores"
+	store, err := kvstore.OpenStoreDir(dir, "test_release")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = store.Close()
+	}()
+
+	db, err := store.NewDB("db1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	table, err := kvstore.NewTable[string]("users", kvstore.NewStringValueEncoder[string]())
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.AddTable(table)
+
+	err = table.Set("a", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = kvstore.ReleaseStores()
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.IsTrue(store.IsReleased())
+	a.IsTrue(kvstore.IsReleased())
+	a.IsNotNil(table.Set("b", "2"))
+
+	// 释放后可以被其他使用者打开
+	otherStore, err := kvstore.OpenStoreDir(dir, "test_release")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = otherStore.Close()
+
+	err = kvstore.ReopenStores()
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.IsFalse(store.IsReleased())
+	a.IsFalse(kvstore.IsReleased())
+
+	value, err := table.Get("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.IsTrue(value == "1")
+	a.IsNil(table.Set("b", "2"))
+	a.IsNil(kvstore.OpenError())
+}
+
 func TestStore_CloseTwice(t *testing.T) {
 	store, err := kvstore.OpenStore("test")
 	if err != nil {
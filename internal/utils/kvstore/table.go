@@ -335,6 +335,11 @@ func (this *Table[T]) DecodeFieldKey(fieldName string, fieldKey []byte) (fieldVa
 	return
 }
 
+func (this *Table[T]) Open() error {
+	this.isClosed = false
+	return nil
+}
+
 func (this *Table[T]) Close() error {
 	this.isClosed = true
 	return nil
@@ -6,5 +6,6 @@ type TableInterface interface {
 	Name() string
 	SetNamespace(namespace []byte)
 	SetDB(db *DB)
+	Open() error
 	Close() error
 }
@@ -1,6 +1,9 @@
 package utils
 
 import (
+	"errors"
+	"net"
+
 	"github.com/TeaOSLab/EdgeCommon/pkg/configutils"
 	"github.com/miekg/dns"
 )
@@ -33,3 +36,78 @@ func LookupCNAME(host string) (string, error) {
 	}
 	return "", lastErr
 }
+
+// LookupIPs 获取域名对应的所有IPv4和IPv6地址
+func LookupIPs(host string) ([]string, error) {
+	if net.ParseIP(host) != nil {
+		return []string{host}, nil
+	}
+
+	var result = []string{}
+	var lastErr error
+	for _, qType := range []uint16{dns.TypeA, dns.TypeAAAA} {
+		r, err := lookupExchange(host, qType)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		for _, answer := range r.Answer {
+			switch record := answer.(type) {
+			case *dns.A:
+				result = append(result, record.A.String())
+			case *dns.AAAA:
+				result = append(result, record.AAAA.String())
+			}
+		}
+	}
+	if len(result) == 0 && lastErr != nil {
+		return nil, lastErr
+	}
+	return result, nil
+}
+
+// LookupSRV 获取SRV记录
+func LookupSRV(name string) ([]*dns.SRV, error) {
+	r, err := lookupExchange(name, dns.TypeSRV)
+	if err != nil {
+		return nil, err
+	}
+
+	var result = []*dns.SRV{}
+	for _, answer := range r.Answer {
+		record, ok := answer.(*dns.SRV)
+		if ok {
+			result = append(result, record)
+		}
+	}
+	return result, nil
+}
+
+// 依次向系统DNS服务器发送查询
+func lookupExchange(name string, qType uint16) (*dns.Msg, error) {
+	config, err := dns.ClientConfigFromFile("/etc/resolv.conf")
+	if err != nil {
+		return nil, err
+	}
+
+	c := new(dns.Client)
+	m := new(dns.Msg)
+
+	m.SetQuestion(dns.Fqdn(name), qType)
+	m.RecursionDesired = true
+
+	var lastErr error = errors.New("no dns servers available")
+	for _, serverAddr := range config.Servers {
+		r, _, err := c.Exchange(m, configutils.QuoteIP(serverAddr)+":"+config.Port)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if r.Rcode != dns.RcodeSuccess {
+			lastErr = errors.New("lookup '" + name + "' failed: " + dns.RcodeToString[r.Rcode])
+			continue
+		}
+		return r, nil
+	}
+	return nil, lastErr
+}
@@ -0,0 +1,188 @@
+// Copyright 2024 GoEdge CDN goedge.cdn@gmail.com. All rights reserved. Official site: https://goedge.cloud .
+
+package readers
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// DefaultReplaceMaxMatchSize 默认的单个匹配最大长度
+const DefaultReplaceMaxMatchSize = 1024
+
+const replaceGroupPrefix = "replace__"
+
+// ReplaceFunc 替换函数，返回的ok为false时表示保持原内容
+type ReplaceFunc = func(match []byte) (result []byte, ok bool)
+
+// ReplaceRule 替换规则
+type ReplaceRule struct {
+	Regexp  *regexp.Regexp
+	Replace ReplaceFunc
+}
+
+// ReplaceReaderCloser 对流式内容进行查找替换
+// 为了能匹配跨越多次读取的内容，会保留最后 maxMatchSize 长度的数据到下次读取时再处理，
+// 因此单个匹配的长度不能超过 maxMatchSize
+type ReplaceReaderCloser struct {
+	rawReader    io.Reader
+	rules        []*ReplaceRule
+	reg          *regexp.Regexp // 合并后的正则表达式
+	maxMatchSize int
+
+	inBuf  []byte // 尚未处理的数据
+	outBuf []byte // 已处理尚未读取的数据
+	rawEOF bool
+	err    error
+
+	readBuf []byte
+
+	countReplaced int
+}
+
+// NewReplaceReaderCloser 获取新对象
+func NewReplaceReaderCloser(rawReader io.Reader, rules []*ReplaceRule, maxMatchSize int) *ReplaceReaderCloser {
+	if maxMatchSize <= 0 {
+		maxMatchSize = DefaultReplaceMaxMatchSize
+	}
+
+	var patterns = []string{}
+	for index, rule := range rules {
+		patterns = append(patterns, "(?P<"+replaceGroupPrefix+strconv.Itoa(index)+">"+rule.Regexp.String()+")")
+	}
+
+	return &ReplaceReaderCloser{
+		rawReader:    rawReader,
+		rules:        rules,
+		reg:          regexp.MustCompile(strings.Join(patterns, "|")),
+		maxMatchSize: maxMatchSize,
+		readBuf:      make([]byte, 16<<10),
+	}
+}
+
+// CountReplaced 已经替换的数量
+func (this *ReplaceReaderCloser) CountReplaced() int {
+	return this.countReplaced
+}
+
+func (this *ReplaceReaderCloser) Read(p []byte) (n int, err error) {
+	for len(this.outBuf) == 0 {
+		if this.rawEOF {
+			if this.err != nil {
+				return 0, this.err
+			}
+			return 0, io.EOF
+		}
+
+		readN, readErr := this.rawReader.Read(this.readBuf)
+		if readN > 0 {
+			this.inBuf = append(this.inBuf, this.readBuf[:readN]...)
+		}
+		if readErr != nil {
+			this.rawEOF = true
+			if readErr != io.EOF {
+				this.err = readErr
+			}
+		}
+
+		// 数据不够时继续读取
+		if !this.rawEOF && len(this.inBuf) < this.maxMatchSize*2 {
+			continue
+		}
+
+		this.process(this.rawEOF)
+	}
+
+	n = copy(p, this.outBuf)
+	this.outBuf = this.outBuf[n:]
+	return
+}
+
+func (this *ReplaceReaderCloser) Close() error {
+	closer, ok := this.rawReader.(io.Closer)
+	if ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// 处理inBuf中的数据，atEOF为false时保留末尾的数据以便于匹配跨越边界的内容
+func (this *ReplaceReaderCloser) process(atEOF bool) {
+	var data = this.inBuf
+	var cut = len(data)
+	if !atEOF {
+		cut = len(data) - this.maxMatchSize
+		if cut <= 0 {
+			return
+		}
+	}
+
+	var out = &bytes.Buffer{}
+	var prev = 0
+	for _, loc := range this.reg.FindAllSubmatchIndex(data, -1) {
+		var start = loc[0]
+		var end = loc[1]
+		if start >= cut {
+			break
+		}
+
+		// 跨越保留区域的匹配留到下次处理，超出最大长度的匹配不再处理
+		if end > cut && !atEOF {
+			if cut-start < this.maxMatchSize {
+				cut = start
+			}
+			break
+		}
+
+		// 空匹配不做处理
+		if start == end {
+			continue
+		}
+
+		var rule = this.findRule(loc)
+		if rule == nil {
+			continue
+		}
+		result, ok := rule.Replace(data[start:end])
+		if !ok {
+			continue
+		}
+
+		out.Write(data[prev:start])
+		out.Write(result)
+		prev = end
+		this.countReplaced++
+	}
+	if cut > prev {
+		out.Write(data[prev:cut])
+		prev = cut
+	}
+
+	this.outBuf = out.Bytes()
+
+	// 保留剩余数据
+	var remaining = len(data) - prev
+	copy(this.inBuf, data[prev:])
+	this.inBuf = this.inBuf[:remaining]
+}
+
+// 根据子匹配的位置查找对应的规则
+func (this *ReplaceReaderCloser) findRule(loc []int) *ReplaceRule {
+	for index, name := range this.reg.SubexpNames() {
+		if index == 0 || !strings.HasPrefix(name, replaceGroupPrefix) {
+			continue
+		}
+		if loc[index*2] < 0 {
+			continue
+		}
+		ruleIndex, err := strconv.Atoi(name[len(replaceGroupPrefix):])
+		if err != nil || ruleIndex < 0 || ruleIndex >= len(this.rules) {
+			continue
+		}
+		return this.rules[ruleIndex]
+	}
+	return nil
+}
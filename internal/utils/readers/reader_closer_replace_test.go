@@ -0,0 +1,88 @@
+// Copyright 2024 GoEdge CDN goedge.cdn@gmail.com. All rights reserved. Official site: https://goedge.cloud .
+
+package readers_test
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/TeaOSLab/EdgeNode/internal/utils/readers"
+	"github.com/iwind/TeaGo/assert"
+)
+
+func TestReplaceReaderCloser_Read(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	var rules = []*readers.ReplaceRule{
+		{
+			Regexp: regexp.MustCompile(`http://origin\.internal`),
+			Replace: func(match []byte) (result []byte, ok bool) {
+				return []byte("https://www.example.com"), true
+			},
+		},
+		{
+			Regexp: regexp.MustCompile(`\d{4}`),
+			Replace: func(match []byte) (result []byte, ok bool) {
+				if string(match) == "2024" {
+					return nil, false
+				}
+				return []byte("****"), true
+			},
+		},
+	}
+
+	var source = strings.Repeat("<a href=\"http://origin.internal/page\">1234 2024</a>\n", 100)
+	var expected = strings.Repeat("<a href=\"https://www.example.com/page\">**** 2024</a>\n", 100)
+
+	// 每次只读取一个字节，测试跨越边界的情况
+	var reader = readers.NewReplaceReaderCloser(iotest.OneByteReader(bytes.NewBufferString(source)), rules, 32)
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.IsTrue(string(data) == expected)
+	a.IsTrue(reader.CountReplaced() == 200)
+
+	// 小缓冲区读取
+	reader = readers.NewReplaceReaderCloser(bytes.NewBufferString(source), rules, 0)
+	var result = &bytes.Buffer{}
+	var buf = make([]byte, 7)
+	for {
+		n, readErr := reader.Read(buf)
+		if n > 0 {
+			result.Write(buf[:n])
+		}
+		if readErr != nil {
+			a.IsTrue(readErr == io.EOF)
+			break
+		}
+	}
+	a.IsTrue(result.String() == expected)
+}
+
+func TestReplaceReaderCloser_LongMatch(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	var rules = []*readers.ReplaceRule{
+		{
+			Regexp: regexp.MustCompile(`a+`),
+			Replace: func(match []byte) (result []byte, ok bool) {
+				return []byte("b"), true
+			},
+		},
+	}
+
+	// 超出最大长度的匹配不会导致无限缓存
+	var source = strings.Repeat("a", 10000)
+	var reader = readers.NewReplaceReaderCloser(iotest.HalfReader(bytes.NewBufferString(source)), rules, 16)
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Log(len(data))
+	a.IsTrue(len(data) > 0 && len(data) < len(source))
+}
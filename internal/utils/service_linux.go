@@ -140,6 +140,7 @@ After=network-online.target
 Type=simple
 Restart=always
 RestartSec=1s
+NotifyAccess=all
 ExecStart=` + startCmd + `
 ExecStop=` + exePath + ` stop
 ExecReload=` + exePath + ` reload
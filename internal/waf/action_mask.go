@@ -0,0 +1,319 @@
+// Copyright 2024 GoEdge CDN goedge.cdn@gmail.com. All rights reserved. Official site: https://goedge.cloud .
+
+package waf
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/TeaOSLab/EdgeNode/internal/utils/readers"
+	"github.com/TeaOSLab/EdgeNode/internal/waf/requests"
+)
+
+type MaskRuleType = string
+
+const (
+	MaskRuleTypeCreditCard MaskRuleType = "creditCard" // 信用卡号，使用Luhn算法校验
+	MaskRuleTypeIdCard     MaskRuleType = "idCard"     // 中国大陆居民身份证号，使用校验码校验
+	MaskRuleTypeAPIKey     MaskRuleType = "apiKey"     // 常见的API Key
+	MaskRuleTypeStackTrace MaskRuleType = "stackTrace" // 程序异常堆栈
+	MaskRuleTypeRegexp     MaskRuleType = "regexp"     // 自定义正则表达式
+)
+
+var maskRuleTypePatterns = map[MaskRuleType]string{
+	MaskRuleTypeCreditCard: `\b\d(?:[ -]?\d){12,18}\b`,
+	MaskRuleTypeIdCard:     `\b\d{17}[\dXx]\b`,
+	MaskRuleTypeAPIKey:     `\b(?:AKIA[0-9A-Z]{16}|(?:sk|rk|pk)_(?:live|test)_[0-9A-Za-z]{16,}|gh[pousr]_[A-Za-z0-9]{36}|AIza[0-9A-Za-z_\-]{35}|xox[abprs]-[0-9A-Za-z\-]{10,48})\b`,
+	MaskRuleTypeStackTrace: `(?m)(?:^[ \t]*at [\w$.<>]+\([\w$.]*(?::\d+)?\)[ \t]*\r?\n?)+|(?:^[ \t]*File "[^"\n]+", line \d+, in [^\n]+\r?\n?)+|(?:^goroutine \d+ \[[^\]\n]+\]:[^\n]*\r?\n?)|(?:^[ \t]*[^\s]+\.go:\d+(?: \+0x[0-9a-f]+)?[ \t]*\r?\n?)+|(?:^(?:Traceback \(most recent call last\)|Stack trace):[ \t]*\r?\n?)|(?:^#\d+ [^\n]+\.php\(\d+\)[^\n]*\r?\n?)+`,
+}
+
+var defaultMaskContentTypes = []string{
+	"text/*",
+	"application/json",
+	"application/*+json",
+	"application/javascript",
+	"application/xml",
+	"application/*+xml",
+	"application/x-www-form-urlencoded",
+}
+
+// MaskRule 脱敏规则
+type MaskRule struct {
+	Type        MaskRuleType `yaml:"type" json:"type"`
+	Pattern     string       `yaml:"pattern" json:"pattern"`         // 自定义正则表达式，仅在type=regexp时有效
+	Replacement string       `yaml:"replacement" json:"replacement"` // 替换模板，支持 ${0}、${1}、${name} 和 ${masked}，为空时保持原长度使用掩码字符替换
+	KeepPrefix  int          `yaml:"keepPrefix" json:"keepPrefix"`   // 保留开头字符数
+	KeepSuffix  int          `yaml:"keepSuffix" json:"keepSuffix"`   // 保留结尾字符数
+	MaskChar    string       `yaml:"maskChar" json:"maskChar"`       // 掩码字符，默认为 *
+
+	reg      *regexp.Regexp
+	maskChar byte
+}
+
+// Init 初始化
+func (this *MaskRule) Init() error {
+	var pattern string
+	if this.Type == MaskRuleTypeRegexp {
+		pattern = this.Pattern
+	} else {
+		var ok bool
+		pattern, ok = maskRuleTypePatterns[this.Type]
+		if !ok {
+			return errors.New("invalid mask rule type '" + this.Type + "'")
+		}
+	}
+	if len(pattern) == 0 {
+		return errors.New("mask rule pattern should not be empty")
+	}
+
+	reg, err := regexp.Compile(pattern)
+	if err != nil {
+		return err
+	}
+	this.reg = reg
+
+	this.maskChar = '*'
+	if len(this.MaskChar) > 0 {
+		this.maskChar = this.MaskChar[0]
+	}
+
+	return nil
+}
+
+// IsLengthPreserving 替换后内容长度是否保持不变
+func (this *MaskRule) IsLengthPreserving() bool {
+	return len(this.Replacement) == 0
+}
+
+// Replace 替换匹配的内容
+func (this *MaskRule) Replace(match []byte) (result []byte, ok bool) {
+	// 校验
+	switch this.Type {
+	case MaskRuleTypeCreditCard:
+		if !maskCheckLuhn(match) {
+			return nil, false
+		}
+	case MaskRuleTypeIdCard:
+		if !maskCheckIdCard(match) {
+			return nil, false
+		}
+	}
+
+	var masked = this.mask(match)
+	if len(this.Replacement) == 0 {
+		return masked, true
+	}
+
+	var template = strings.ReplaceAll(this.Replacement, "${masked}", string(masked))
+	var submatches = this.reg.FindSubmatchIndex(match)
+	if submatches == nil {
+		return []byte(template), true
+	}
+	return this.reg.Expand(nil, []byte(template), match, submatches), true
+}
+
+// 使用掩码字符替换字母和数字，保留分隔符
+func (this *MaskRule) mask(match []byte) []byte {
+	var result = make([]byte, len(match))
+	copy(result, match)
+
+	var countAlphaNum = 0
+	for _, c := range match {
+		if maskIsAlphaNum(c) {
+			countAlphaNum++
+		}
+	}
+
+	var index = 0
+	for i, c := range result {
+		if !maskIsAlphaNum(c) {
+			continue
+		}
+		if index >= this.KeepPrefix && index < countAlphaNum-this.KeepSuffix {
+			result[i] = this.maskChar
+		}
+		index++
+	}
+	return result
+}
+
+// MaskAction 对响应内容中的敏感数据进行脱敏
+// 只对出站规则有效；策略中有脱敏动作时，相关的请求不再读写缓存，并且总是向源站请求完整的内容
+type MaskAction struct {
+	BaseAction
+
+	Rules        []*MaskRule `yaml:"rules" json:"rules"`
+	ContentTypes []string    `yaml:"contentTypes" json:"contentTypes"` // 需要处理的内容类型，为空时表示常见的文本类型
+	MaxMatchSize int         `yaml:"maxMatchSize" json:"maxMatchSize"` // 单个匹配的最大长度
+
+	rules []*MaskRule
+}
+
+func (this *MaskAction) Init(waf *WAF) error {
+	this.rules = []*MaskRule{}
+	for _, rule := range this.Rules {
+		if rule == nil {
+			continue
+		}
+		err := rule.Init()
+		if err != nil {
+			return err
+		}
+		this.rules = append(this.rules, rule)
+	}
+
+	if len(this.ContentTypes) == 0 {
+		this.ContentTypes = defaultMaskContentTypes
+	}
+
+	return nil
+}
+
+func (this *MaskAction) Code() string {
+	return ActionMask
+}
+
+func (this *MaskAction) IsAttack() bool {
+	return false
+}
+
+func (this *MaskAction) WillChange() bool {
+	return false
+}
+
+func (this *MaskAction) Perform(waf *WAF, group *RuleGroup, set *RuleSet, request requests.Request, writer http.ResponseWriter) PerformResult {
+	// 实际的替换操作在输出响应内容时执行，参考 WrapMaskReader()
+	return PerformResult{
+		ContinueRequest: true,
+	}
+}
+
+// HasRules 是否有可用的规则
+func (this *MaskAction) HasRules() bool {
+	return len(this.rules) > 0
+}
+
+// IsLengthPreserving 替换后内容长度是否保持不变
+func (this *MaskAction) IsLengthPreserving() bool {
+	for _, rule := range this.rules {
+		if !rule.IsLengthPreserving() {
+			return false
+		}
+	}
+	return true
+}
+
+// MatchContentType 检查内容类型
+func (this *MaskAction) MatchContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, pattern := range this.ContentTypes {
+		pattern = strings.ToLower(pattern)
+		if pattern == mediaType || pattern == "*/*" {
+			return true
+		}
+
+		// text/*, application/*+json
+		var starIndex = strings.Index(pattern, "*")
+		if starIndex >= 0 && strings.HasPrefix(mediaType, pattern[:starIndex]) && strings.HasSuffix(mediaType, pattern[starIndex+1:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// ReplaceRules 转换为替换规则
+func (this *MaskAction) ReplaceRules() []*readers.ReplaceRule {
+	var result = []*readers.ReplaceRule{}
+	for _, rule := range this.rules {
+		result = append(result, &readers.ReplaceRule{
+			Regexp:  rule.reg,
+			Replace: rule.Replace,
+		})
+	}
+	return result
+}
+
+// WrapMaskReader 包装响应内容读取器，支持一组脱敏动作
+func WrapMaskReader(reader io.ReadCloser, actions []*MaskAction) io.ReadCloser {
+	var rules = []*readers.ReplaceRule{}
+	var maxMatchSize = 0
+	for _, action := range actions {
+		rules = append(rules, action.ReplaceRules()...)
+		if action.MaxMatchSize > maxMatchSize {
+			maxMatchSize = action.MaxMatchSize
+		}
+	}
+	if len(rules) == 0 {
+		return reader
+	}
+	return readers.NewReplaceReaderCloser(reader, rules, maxMatchSize)
+}
+
+// MaskBytes 对一段内容进行脱敏
+func (this *MaskAction) MaskBytes(data []byte) []byte {
+	var reader = WrapMaskReader(io.NopCloser(bytes.NewReader(data)), []*MaskAction{this})
+	result, err := io.ReadAll(reader)
+	if err != nil {
+		return data
+	}
+	return result
+}
+
+// Luhn算法校验
+func maskCheckLuhn(match []byte) bool {
+	var sum = 0
+	var countDigits = 0
+	var double = false
+	for i := len(match) - 1; i >= 0; i-- {
+		var c = match[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		var d = int(c - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+		countDigits++
+	}
+	return countDigits >= 13 && countDigits <= 19 && sum%10 == 0
+}
+
+// 身份证校验码校验（GB 11643-1999）
+var maskIdCardWeights = []int{7, 9, 10, 5, 8, 4, 2, 1, 6, 3, 7, 9, 10, 5, 8, 4, 2}
+var maskIdCardCheckCodes = []byte("10X98765432")
+
+func maskCheckIdCard(match []byte) bool {
+	if len(match) != 18 {
+		return false
+	}
+	var sum = 0
+	for i := 0; i < 17; i++ {
+		var c = match[i]
+		if c < '0' || c > '9' {
+			return false
+		}
+		sum += int(c-'0') * maskIdCardWeights[i]
+	}
+	var checkCode = match[17]
+	if checkCode == 'x' {
+		checkCode = 'X'
+	}
+	return maskIdCardCheckCodes[sum%11] == checkCode
+}
+
+func maskIsAlphaNum(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
@@ -19,6 +19,7 @@ const (
 	ActionAllow            ActionString = "allow"     // allow
 	ActionGoGroup          ActionString = "go_group"  // go to next rule group
 	ActionGoSet            ActionString = "go_set"    // go to next rule set
+	ActionMask             ActionString = "mask"      // 响应内容脱敏
 )
 
 var AllActions = []*ActionDefinition{
@@ -106,4 +107,10 @@ var AllActions = []*ActionDefinition{
 		Instance: new(GoSetAction),
 		Type:     reflect.TypeOf(new(GoSetAction)).Elem(),
 	},
+	{
+		Name:     "响应内容脱敏",
+		Code:     ActionMask,
+		Instance: new(MaskAction),
+		Type:     reflect.TypeOf(new(MaskAction)).Elem(),
+	},
 }
@@ -373,6 +373,7 @@ func (this *CaptchaValidator) validateVerifyCodeForm(actionConfig *CaptchaAction
 
 			// 加入到白名单
 			SharedIPWhiteList.RecordIP(wafutils.ComposeIPType(setId, req), actionConfig.Scope, req.WAFServerId(), req.WAFRemoteIP(), time.Now().Unix()+int64(life), policyId, false, groupId, setId, "")
+			SharedRuleSetStatManager.IncreaseVerified(setId)
 
 			req.ProcessResponseHeaders(writer.Header(), http.StatusSeeOther)
 
@@ -530,6 +531,7 @@ func (this *CaptchaValidator) validateOneClickForm(actionConfig *CaptchaAction,
 
 				// 加入到白名单
 				SharedIPWhiteList.RecordIP(wafutils.ComposeIPType(setId, req), actionConfig.Scope, req.WAFServerId(), req.WAFRemoteIP(), time.Now().Unix()+int64(life), policyId, false, groupId, setId, "")
+				SharedRuleSetStatManager.IncreaseVerified(setId)
 
 				req.ProcessResponseHeaders(writer.Header(), http.StatusSeeOther)
 
@@ -733,6 +735,7 @@ func (this *CaptchaValidator) validateSlideForm(actionConfig *CaptchaAction, pol
 
 				// 加入到白名单
 				SharedIPWhiteList.RecordIP(wafutils.ComposeIPType(setId, req), actionConfig.Scope, req.WAFServerId(), req.WAFRemoteIP(), time.Now().Unix()+int64(life), policyId, false, groupId, setId, "")
+				SharedRuleSetStatManager.IncreaseVerified(setId)
 
 				req.ProcessResponseHeaders(writer.Header(), http.StatusSeeOther)
 
@@ -775,6 +778,7 @@ func (this *CaptchaValidator) validateGeeTestForm(actionConfig *CaptchaAction, p
 
 			// 加入到白名单
 			SharedIPWhiteList.RecordIP(wafutils.ComposeIPType(setId, req), actionConfig.Scope, req.WAFServerId(), req.WAFRemoteIP(), time.Now().Unix()+int64(life), policyId, false, groupId, setId, "")
+			SharedRuleSetStatManager.IncreaseVerified(setId)
 
 			// 记录到Cookie
 			this.setCookie(writer, setId, life)
@@ -0,0 +1,146 @@
+// Copyright 2024 GoEdge CDN goedge.cdn@gmail.com. All rights reserved. Official site: https://goedge.cloud .
+
+package checkpoints
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/TeaOSLab/EdgeNode/internal/waf/openapi"
+	"github.com/TeaOSLab/EdgeNode/internal/waf/requests"
+	"github.com/TeaOSLab/EdgeNode/internal/waf/utils"
+	"github.com/iwind/TeaGo/maps"
+	"github.com/iwind/TeaGo/types"
+)
+
+// OpenAPIAttrName 记录在日志中的违规原因属性名
+const OpenAPIAttrName = "waf.openAPI"
+
+// OpenAPIBodyAttrName 记录在日志中的请求体校验状态属性名，请求体超出读取限制而没有校验时为unchecked
+const OpenAPIBodyAttrName = "waf.openAPIBody"
+
+// RequestOpenAPICheckpoint 使用OpenAPI文档校验请求，不符合文档或者文档无法加载时值为true
+// 选项：
+//   - spec 文档内容
+//   - specFile 文档路径
+//   - specDir 文档目录，按服务ID读取 {serverId}.yaml、{serverId}.yml 或 {serverId}.json
+//   - maxArrayItems 文档中未设置maxItems的数组的最大长度
+//   - allowUncheckedBody 请求体超出读取限制而无法校验时是否放行，默认视为不符合
+type RequestOpenAPICheckpoint struct {
+	Checkpoint
+}
+
+func (this *RequestOpenAPICheckpoint) IsComposed() bool {
+	return true
+}
+
+func (this *RequestOpenAPICheckpoint) RequestValue(req requests.Request, param string, options maps.Map, ruleId int64) (value any, hasRequestBody bool, sysErr error, userErr error) {
+	value = false
+
+	validator, err := this.findValidator(req, options)
+	if err != nil {
+		// 文档无法加载时视为不符合，避免规则失效；错误已由 openapi.SharedManager 记录到日志
+		req.WAFSetAttr(OpenAPIAttrName, "load document failed: "+err.Error())
+		value = true
+		return
+	}
+	if validator == nil {
+		return
+	}
+
+	// 读取请求体
+	var bodyData []byte
+	if !this.RequestBodyIsEmpty(req) && req.WAFRaw().Body != nil {
+		bodyData = req.WAFGetCacheBody()
+		hasRequestBody = true
+		if len(bodyData) == 0 {
+			data, err := req.WAFReadBody(req.WAFMaxRequestSize()) // read body
+			if err != nil {
+				return false, hasRequestBody, err, nil
+			}
+
+			bodyData = data
+			req.WAFSetCacheBody(data)
+			req.WAFRestoreBody(data)
+		}
+	}
+
+	var violation *openapi.Violation
+	if this.isTruncatedBody(req, bodyData) {
+		// 请求体超出读取限制时无法完整解析，默认视为不符合，防止通过填充请求体绕过校验
+		req.WAFSetAttr(OpenAPIBodyAttrName, "unchecked")
+		if !options.GetBool("allowUncheckedBody") {
+			req.WAFSetAttr(OpenAPIAttrName, "request body exceeds the max request size and can not be validated")
+			value = true
+			return
+		}
+
+		// 明确允许时只校验请求体之外的部分
+		violation = validator.ValidateRequestWithoutBody(req.WAFRaw())
+	} else {
+		violation = validator.ValidateRequest(req.WAFRaw(), bodyData)
+	}
+	if violation != nil {
+		req.WAFSetAttr(OpenAPIAttrName, violation.String())
+		value = true
+	}
+
+	return
+}
+
+func (this *RequestOpenAPICheckpoint) ResponseValue(req requests.Request, resp *requests.Response, param string, options maps.Map, ruleId int64) (value any, hasRequestBody bool, sysErr error, userErr error) {
+	if this.IsRequest() {
+		return this.RequestValue(req, param, options, ruleId)
+	}
+	return
+}
+
+func (this *RequestOpenAPICheckpoint) CacheLife() utils.CacheLife {
+	return utils.CacheDisabled
+}
+
+// 检查请求体是否因为超出读取限制而没有完整读取
+func (this *RequestOpenAPICheckpoint) isTruncatedBody(req requests.Request, bodyData []byte) bool {
+	if len(bodyData) == 0 {
+		return false
+	}
+	var bodySize = int64(len(bodyData))
+	return bodySize >= req.WAFMaxRequestSize() || req.WAFRaw().ContentLength > bodySize
+}
+
+// 查找文档对应的校验器
+// 没有设置文档时返回nil；文档加载失败时返回错误
+func (this *RequestOpenAPICheckpoint) findValidator(req requests.Request, options maps.Map) (*openapi.Validator, error) {
+	if options == nil {
+		return nil, nil
+	}
+
+	var maxArrayItems = options.GetInt("maxArrayItems")
+
+	// 按服务读取
+	var specDir = options.GetString("specDir")
+	if len(specDir) > 0 {
+		var serverId = req.WAFServerId()
+		for _, ext := range []string{".yaml", ".yml", ".json"} {
+			validator, err := openapi.SharedManager.FindValidatorWithFile(filepath.Join(specDir, types.String(serverId)+ext), maxArrayItems)
+			if err == nil {
+				return validator, nil
+			}
+			if !os.IsNotExist(err) {
+				return nil, err
+			}
+		}
+	}
+
+	var specFile = options.GetString("specFile")
+	if len(specFile) > 0 {
+		return openapi.SharedManager.FindValidatorWithFile(specFile, maxArrayItems)
+	}
+
+	var spec = options.GetString("spec")
+	if len(spec) > 0 {
+		return openapi.SharedManager.FindValidatorWithData([]byte(spec), maxArrayItems)
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,183 @@
+// Copyright 2024 GoEdge CDN goedge.cdn@gmail.com. All rights reserved. Official site: https://goedge.cloud .
+
+package checkpoints
+
+import (
+	"bytes"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/TeaOSLab/EdgeNode/internal/waf/requests"
+	"github.com/iwind/TeaGo/assert"
+	"github.com/iwind/TeaGo/maps"
+)
+
+func TestRequestOpenAPICheckpoint_RequestValue(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	var options = maps.Map{
+		"spec": `{
+	"openapi": "3.0.0",
+	"paths": {
+		"/orders": {
+			"post": {
+				"requestBody": {
+					"required": true,
+					"content": {
+						"application/json": {
+							"schema": {
+								"type": "object",
+								"required": ["count"],
+								"properties": {
+									"count": {"type": "integer"}
+								}
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+}`,
+	}
+
+	var checkpoint = new(RequestOpenAPICheckpoint)
+
+	{
+		rawReq, err := http.NewRequest(http.MethodPost, "https://example.com/orders", bytes.NewReader([]byte(`{"count": 1}`)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rawReq.Header.Set("Content-Type", "application/json")
+		var req = requests.NewTestRequest(rawReq)
+		value, hasRequestBody, sysErr, _ := checkpoint.RequestValue(req, "", options, 1)
+		a.IsNil(sysErr)
+		a.IsTrue(hasRequestBody)
+		a.IsFalse(value.(bool))
+	}
+
+	{
+		rawReq, err := http.NewRequest(http.MethodPost, "https://example.com/orders", bytes.NewReader([]byte(`{"count": "1"}`)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rawReq.Header.Set("Content-Type", "application/json")
+		var req = requests.NewTestRequest(rawReq)
+		value, _, sysErr, _ := checkpoint.RequestValue(req, "", options, 1)
+		a.IsNil(sysErr)
+		a.IsTrue(value.(bool))
+		t.Log(req.Attrs[OpenAPIAttrName])
+	}
+
+	{
+		rawReq, err := http.NewRequest(http.MethodGet, "https://example.com/orders", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var req = requests.NewTestRequest(rawReq)
+		value, _, sysErr, _ := checkpoint.RequestValue(req, "", options, 1)
+		a.IsNil(sysErr)
+		a.IsTrue(value.(bool))
+		t.Log(req.Attrs[OpenAPIAttrName])
+	}
+}
+
+func TestRequestOpenAPICheckpoint_LargeBody(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	var options = maps.Map{
+		"spec": `{
+	"openapi": "3.0.0",
+	"paths": {
+		"/orders": {
+			"post": {
+				"requestBody": {
+					"content": {
+						"application/json": {
+							"schema": {"type": "object"}
+						}
+					}
+				}
+			}
+		}
+	}
+}`,
+	}
+
+	var checkpoint = new(RequestOpenAPICheckpoint)
+
+	// 超出读取限制的请求体无法完整校验，默认视为不符合，防止通过填充请求体绕过校验
+	var body = []byte(`{"data":"` + strings.Repeat("a", 1<<20) + `"}`)
+	rawReq, err := http.NewRequest(http.MethodPost, "https://example.com/orders", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rawReq.Header.Set("Content-Type", "application/json")
+	var req = requests.NewTestRequest(rawReq)
+	value, hasRequestBody, sysErr, _ := checkpoint.RequestValue(req, "", options, 1)
+	a.IsNil(sysErr)
+	a.IsTrue(hasRequestBody)
+	a.IsTrue(value.(bool))
+	a.IsTrue(req.Attrs[OpenAPIBodyAttrName] == "unchecked")
+
+	// 明确允许时不应该被当成无效的JSON
+	options["allowUncheckedBody"] = true
+	rawReq, err = http.NewRequest(http.MethodPost, "https://example.com/orders", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rawReq.Header.Set("Content-Type", "application/json")
+	req = requests.NewTestRequest(rawReq)
+	value, _, sysErr, _ = checkpoint.RequestValue(req, "", options, 1)
+	a.IsNil(sysErr)
+	a.IsFalse(value.(bool))
+	a.IsTrue(req.Attrs[OpenAPIBodyAttrName] == "unchecked")
+
+	// 请求体之外的部分仍然需要校验
+	rawReq, err = http.NewRequest(http.MethodPut, "https://example.com/orders", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req = requests.NewTestRequest(rawReq)
+	value, _, sysErr, _ = checkpoint.RequestValue(req, "", options, 1)
+	a.IsNil(sysErr)
+	a.IsTrue(value.(bool))
+}
+
+func TestRequestOpenAPICheckpoint_InvalidSpec(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	var checkpoint = new(RequestOpenAPICheckpoint)
+
+	rawReq, err := http.NewRequest(http.MethodGet, "https://example.com/orders", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 文档无法解析时不能放行
+	{
+		var req = requests.NewTestRequest(rawReq)
+		value, _, sysErr, _ := checkpoint.RequestValue(req, "", maps.Map{"spec": `{"swagger": "2.0"}`}, 1)
+		a.IsNil(sysErr)
+		a.IsTrue(value.(bool))
+		t.Log(req.Attrs[OpenAPIAttrName])
+	}
+
+	// 文档不存在
+	{
+		var req = requests.NewTestRequest(rawReq)
+		value, _, sysErr, _ := checkpoint.RequestValue(req, "", maps.Map{"specFile": filepath.Join(t.TempDir(), "not-found.yaml")}, 1)
+		a.IsNil(sysErr)
+		a.IsTrue(value.(bool))
+	}
+
+	// 没有设置文档
+	{
+		var req = requests.NewTestRequest(rawReq)
+		value, _, sysErr, _ := checkpoint.RequestValue(req, "", maps.Map{}, 1)
+		a.IsNil(sysErr)
+		a.IsFalse(value.(bool))
+	}
+}
@@ -314,6 +314,14 @@ var AllCheckpoints = []*CheckpointDefinition{
 		Instance:    new(RequestRefererBlockCheckpoint),
 		Priority:    20,
 	},
+	{
+		Name:        "OpenAPI文档校验",
+		Prefix:      "openAPI",
+		Description: "使用OpenAPI 3文档校验请求方法、路径、参数和JSON请求体，不符合文档时值为true",
+		HasParams:   true,
+		Instance:    new(RequestOpenAPICheckpoint),
+		Priority:    5,
+	},
 	{
 		Name:        "通用响应Header长度限制",
 		Prefix:      "responseGeneralHeaderLength",
@@ -23,6 +23,11 @@ import (
 
 // DetectSQLInjectionCache detect sql injection in string with cache
 func DetectSQLInjectionCache(input string, isStrict bool, cacheLife utils.CacheLife) bool {
+	return DetectSQLInjectionCacheStat(input, isStrict, cacheLife, nil)
+}
+
+// DetectSQLInjectionCacheStat detect with cache and record cache hits
+func DetectSQLInjectionCacheStat(input string, isStrict bool, cacheLife utils.CacheLife, stat utils.CacheStat) bool {
 	var l = len(input)
 
 	if l == 0 {
@@ -37,8 +42,14 @@ func DetectSQLInjectionCache(input string, isStrict bool, cacheLife utils.CacheL
 	var key = "WAF@SQLI@" + strconv.FormatUint(hash, 10)
 	var item = utils.SharedCache.Read(key)
 	if item != nil {
+		if stat != nil {
+			stat.IncreaseCacheHit()
+		}
 		return item.Value == 1
 	}
+	if stat != nil {
+		stat.IncreaseCacheMiss()
+	}
 
 	var result = DetectSQLInjection(input, isStrict)
 	if result {
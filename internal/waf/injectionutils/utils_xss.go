@@ -21,6 +21,11 @@ import (
 )
 
 func DetectXSSCache(input string, isStrict bool, cacheLife utils.CacheLife) bool {
+	return DetectXSSCacheStat(input, isStrict, cacheLife, nil)
+}
+
+// DetectXSSCacheStat detect with cache and record cache hits
+func DetectXSSCacheStat(input string, isStrict bool, cacheLife utils.CacheLife, stat utils.CacheStat) bool {
 	var l = len(input)
 
 	if l == 0 {
@@ -38,8 +43,14 @@ func DetectXSSCache(input string, isStrict bool, cacheLife utils.CacheLife) bool
 	}
 	var item = utils.SharedCache.Read(key)
 	if item != nil {
+		if stat != nil {
+			stat.IncreaseCacheHit()
+		}
 		return item.Value == 1
 	}
+	if stat != nil {
+		stat.IncreaseCacheMiss()
+	}
 
 	var result = DetectXSS(input, isStrict)
 	if result {
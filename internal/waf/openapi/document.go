@@ -0,0 +1,218 @@
+// Copyright 2024 GoEdge CDN goedge.cdn@gmail.com. All rights reserved. Official site: https://goedge.cloud .
+
+package openapi
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Document OpenAPI 3 文档
+// 只解析校验请求所需要的部分
+type Document struct {
+	OpenAPI    string               `yaml:"openapi" json:"openapi"`
+	Servers    []*Server            `yaml:"servers" json:"servers"`
+	Paths      map[string]*PathItem `yaml:"paths" json:"paths"`
+	Components *Components          `yaml:"components" json:"components"`
+}
+
+type Server struct {
+	URL string `yaml:"url" json:"url"`
+}
+
+type Components struct {
+	Schemas       map[string]*Schema      `yaml:"schemas" json:"schemas"`
+	Parameters    map[string]*Parameter   `yaml:"parameters" json:"parameters"`
+	RequestBodies map[string]*RequestBody `yaml:"requestBodies" json:"requestBodies"`
+}
+
+type PathItem struct {
+	Parameters []*Parameter `yaml:"parameters" json:"parameters"`
+	Get        *Operation   `yaml:"get" json:"get"`
+	Put        *Operation   `yaml:"put" json:"put"`
+	Post       *Operation   `yaml:"post" json:"post"`
+	Delete     *Operation   `yaml:"delete" json:"delete"`
+	Options    *Operation   `yaml:"options" json:"options"`
+	Head       *Operation   `yaml:"head" json:"head"`
+	Patch      *Operation   `yaml:"patch" json:"patch"`
+	Trace      *Operation   `yaml:"trace" json:"trace"`
+}
+
+// Operation 查找某个方法对应的操作
+func (this *PathItem) Operation(method string) *Operation {
+	switch strings.ToUpper(method) {
+	case "GET":
+		return this.Get
+	case "PUT":
+		return this.Put
+	case "POST":
+		return this.Post
+	case "DELETE":
+		return this.Delete
+	case "OPTIONS":
+		return this.Options
+	case "HEAD":
+		// 没有定义HEAD时和GET保持一致
+		if this.Head == nil {
+			return this.Get
+		}
+		return this.Head
+	case "PATCH":
+		return this.Patch
+	case "TRACE":
+		return this.Trace
+	}
+	return nil
+}
+
+type Operation struct {
+	OperationId string       `yaml:"operationId" json:"operationId"`
+	Parameters  []*Parameter `yaml:"parameters" json:"parameters"`
+	RequestBody *RequestBody `yaml:"requestBody" json:"requestBody"`
+}
+
+type ParameterIn = string
+
+const (
+	ParameterInPath   ParameterIn = "path"
+	ParameterInQuery  ParameterIn = "query"
+	ParameterInHeader ParameterIn = "header"
+	ParameterInCookie ParameterIn = "cookie"
+)
+
+type Parameter struct {
+	Ref      string      `yaml:"$ref" json:"$ref"`
+	Name     string      `yaml:"name" json:"name"`
+	In       ParameterIn `yaml:"in" json:"in"`
+	Required bool        `yaml:"required" json:"required"`
+	Schema   *Schema     `yaml:"schema" json:"schema"`
+	Style    string      `yaml:"style" json:"style"`
+	Explode  *bool       `yaml:"explode" json:"explode"`
+}
+
+type RequestBody struct {
+	Ref      string                `yaml:"$ref" json:"$ref"`
+	Required bool                  `yaml:"required" json:"required"`
+	Content  map[string]*MediaType `yaml:"content" json:"content"`
+}
+
+type MediaType struct {
+	Schema *Schema `yaml:"schema" json:"schema"`
+}
+
+type Schema struct {
+	Ref                  string             `yaml:"$ref" json:"$ref"`
+	Type                 string             `yaml:"type" json:"type"`
+	Format               string             `yaml:"format" json:"format"`
+	Nullable             bool               `yaml:"nullable" json:"nullable"`
+	Enum                 []any              `yaml:"enum" json:"enum"`
+	Required             []string           `yaml:"required" json:"required"`
+	Properties           map[string]*Schema `yaml:"properties" json:"properties"`
+	AdditionalProperties *bool              `yaml:"-" json:"-"`
+	Items                *Schema            `yaml:"items" json:"items"`
+	MinItems             *int               `yaml:"minItems" json:"minItems"`
+	MaxItems             *int               `yaml:"maxItems" json:"maxItems"`
+	MinLength            *int               `yaml:"minLength" json:"minLength"`
+	MaxLength            *int               `yaml:"maxLength" json:"maxLength"`
+	Minimum              *float64           `yaml:"minimum" json:"minimum"`
+	Maximum              *float64           `yaml:"maximum" json:"maximum"`
+	Pattern              string             `yaml:"pattern" json:"pattern"`
+	AllOf                []*Schema          `yaml:"allOf" json:"allOf"`
+	AnyOf                []*Schema          `yaml:"anyOf" json:"anyOf"`
+	OneOf                []*Schema          `yaml:"oneOf" json:"oneOf"`
+}
+
+// UnmarshalYAML 支持additionalProperties为布尔值的情况
+func (this *Schema) UnmarshalYAML(node *yaml.Node) error {
+	type rawSchema Schema
+	var raw = (*rawSchema)(this)
+	err := node.Decode(raw)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value != "additionalProperties" {
+			continue
+		}
+		var valueNode = node.Content[i+1]
+		if valueNode.Kind == yaml.ScalarNode {
+			var b bool
+			if valueNode.Decode(&b) == nil {
+				this.AdditionalProperties = &b
+			}
+		}
+	}
+	return nil
+}
+
+// ParseDocument 解析JSON或YAML格式的文档
+func ParseDocument(data []byte) (*Document, error) {
+	var doc = &Document{}
+	err := yaml.Unmarshal(data, doc)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(doc.OpenAPI, "3.") {
+		return nil, errors.New("unsupported openapi version '" + doc.OpenAPI + "', only 3.x is supported")
+	}
+	if len(doc.Paths) == 0 {
+		return nil, errors.New("no paths defined")
+	}
+	return doc, nil
+}
+
+// BasePath 从servers中读取基础路径
+func (this *Document) BasePath() string {
+	if len(this.Servers) == 0 {
+		return ""
+	}
+	u, err := url.Parse(this.Servers[0].URL)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimRight(u.Path, "/")
+}
+
+// ResolveSchema 解析Schema引用
+func (this *Document) ResolveSchema(schema *Schema) *Schema {
+	for depth := 0; schema != nil && len(schema.Ref) > 0 && depth < 32; depth++ {
+		if this.Components == nil {
+			return nil
+		}
+		schema = this.Components.Schemas[this.refName(schema.Ref, "#/components/schemas/")]
+	}
+	return schema
+}
+
+// ResolveParameter 解析参数引用
+func (this *Document) ResolveParameter(param *Parameter) *Parameter {
+	if param == nil || len(param.Ref) == 0 {
+		return param
+	}
+	if this.Components == nil {
+		return nil
+	}
+	return this.Components.Parameters[this.refName(param.Ref, "#/components/parameters/")]
+}
+
+// ResolveRequestBody 解析请求体引用
+func (this *Document) ResolveRequestBody(body *RequestBody) *RequestBody {
+	if body == nil || len(body.Ref) == 0 {
+		return body
+	}
+	if this.Components == nil {
+		return nil
+	}
+	return this.Components.RequestBodies[this.refName(body.Ref, "#/components/requestBodies/")]
+}
+
+func (this *Document) refName(ref string, prefix string) string {
+	if !strings.HasPrefix(ref, prefix) {
+		return ""
+	}
+	return ref[len(prefix):]
+}
@@ -0,0 +1,138 @@
+// Copyright 2024 GoEdge CDN goedge.cdn@gmail.com. All rights reserved. Official site: https://goedge.cloud .
+
+package openapi
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/TeaOSLab/EdgeNode/internal/remotelogs"
+	"github.com/cespare/xxhash/v2"
+)
+
+var SharedManager = NewManager()
+
+// 文件修改检查间隔
+const fileCheckInterval = 10 * time.Second
+
+type validatorItem struct {
+	validator *Validator
+	err       error
+
+	modifiedAt time.Time
+	checkedAt  time.Time
+}
+
+// Manager 文档管理器
+// 用来缓存解析后的文档，避免每个请求都重复解析
+type Manager struct {
+	itemMap map[string]*validatorItem // key => item
+	locker  sync.Mutex
+}
+
+// NewManager 获取新对象
+func NewManager() *Manager {
+	return &Manager{
+		itemMap: map[string]*validatorItem{},
+	}
+}
+
+// FindValidatorWithFile 从文件中加载文档
+// 如果文件不存在，则返回os.ErrNotExist
+func (this *Manager) FindValidatorWithFile(path string, maxArrayItems int) (*Validator, error) {
+	if len(path) == 0 {
+		return nil, errors.New("'path' should not be empty")
+	}
+
+	var key = "file:" + path + "@" + strconv.Itoa(maxArrayItems)
+	var now = time.Now()
+
+	this.locker.Lock()
+	defer this.locker.Unlock()
+
+	item, ok := this.itemMap[key]
+	if ok && now.Sub(item.checkedAt) < fileCheckInterval {
+		return item.validator, item.err
+	}
+
+	stat, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			delete(this.itemMap, key)
+			return nil, err
+		}
+
+		// 缓存错误，避免每个请求都记录日志
+		remotelogs.Error("WAF_OPENAPI", "load document '"+path+"' failed: "+err.Error())
+		this.itemMap[key] = &validatorItem{
+			err:       err,
+			checkedAt: now,
+		}
+		return nil, err
+	}
+	if ok && stat.ModTime().Equal(item.modifiedAt) {
+		item.checkedAt = now
+		return item.validator, item.err
+	}
+
+	item = &validatorItem{
+		modifiedAt: stat.ModTime(),
+		checkedAt:  now,
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		item.err = err
+	} else {
+		item.validator, item.err = this.parse(data, maxArrayItems)
+	}
+	if item.err != nil {
+		remotelogs.Error("WAF_OPENAPI", "load document '"+path+"' failed: "+item.err.Error())
+	}
+	this.itemMap[key] = item
+	return item.validator, item.err
+}
+
+// FindValidatorWithData 从文档内容中加载文档
+func (this *Manager) FindValidatorWithData(data []byte, maxArrayItems int) (*Validator, error) {
+	if len(data) == 0 {
+		return nil, errors.New("'data' should not be empty")
+	}
+
+	var key = "data:" + strconv.FormatUint(xxhash.Sum64(data), 10) + "@" + strconv.Itoa(maxArrayItems)
+
+	this.locker.Lock()
+	defer this.locker.Unlock()
+
+	item, ok := this.itemMap[key]
+	if ok {
+		return item.validator, item.err
+	}
+
+	item = &validatorItem{}
+	item.validator, item.err = this.parse(data, maxArrayItems)
+	if item.err != nil {
+		remotelogs.Error("WAF_OPENAPI", "load document failed: "+item.err.Error())
+	}
+	this.itemMap[key] = item
+	return item.validator, item.err
+}
+
+// Clean 清除所有缓存
+func (this *Manager) Clean() {
+	this.locker.Lock()
+	this.itemMap = map[string]*validatorItem{}
+	this.locker.Unlock()
+}
+
+func (this *Manager) parse(data []byte, maxArrayItems int) (*Validator, error) {
+	doc, err := ParseDocument(data)
+	if err != nil {
+		return nil, err
+	}
+	var validator = NewValidator(doc)
+	validator.SetMaxArrayItems(maxArrayItems)
+	return validator, nil
+}
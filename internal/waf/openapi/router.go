@@ -0,0 +1,122 @@
+// Copyright 2024 GoEdge CDN goedge.cdn@gmail.com. All rights reserved. Official site: https://goedge.cloud .
+
+package openapi
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+)
+
+type routeSegment struct {
+	value   string
+	isParam bool
+}
+
+type route struct {
+	template string
+	segments []routeSegment
+	item     *PathItem
+
+	countLiterals int
+}
+
+// 匹配请求路径，返回路径模板和路径参数
+func (this *route) match(pieces []string) (params map[string]string, ok bool) {
+	if len(pieces) != len(this.segments) {
+		return nil, false
+	}
+	for index, segment := range this.segments {
+		var piece = pieces[index]
+		if segment.isParam {
+			if len(piece) == 0 {
+				return nil, false
+			}
+			if params == nil {
+				params = map[string]string{}
+			}
+			unescapedPiece, err := url.PathUnescape(piece)
+			if err == nil {
+				piece = unescapedPiece
+			}
+			params[segment.value] = piece
+			continue
+		}
+		if segment.value != piece {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+type router struct {
+	basePath string
+	routes   []*route
+}
+
+func newRouter(doc *Document) *router {
+	var r = &router{
+		basePath: doc.BasePath(),
+	}
+	for template, item := range doc.Paths {
+		if item == nil {
+			continue
+		}
+		var rt = &route{
+			template: template,
+			item:     item,
+		}
+		for _, piece := range splitPath(template) {
+			if len(piece) > 2 && piece[0] == '{' && piece[len(piece)-1] == '}' {
+				rt.segments = append(rt.segments, routeSegment{
+					value:   piece[1 : len(piece)-1],
+					isParam: true,
+				})
+			} else {
+				rt.segments = append(rt.segments, routeSegment{
+					value: piece,
+				})
+				rt.countLiterals++
+			}
+		}
+		r.routes = append(r.routes, rt)
+	}
+
+	// 固定路径优先于模板路径，比如 /users/me 优先于 /users/{id}
+	sort.Slice(r.routes, func(i, j int) bool {
+		var route1 = r.routes[i]
+		var route2 = r.routes[j]
+		if route1.countLiterals != route2.countLiterals {
+			return route1.countLiterals > route2.countLiterals
+		}
+		return route1.template < route2.template
+	})
+
+	return r
+}
+
+func (this *router) find(path string) (rt *route, params map[string]string) {
+	if len(this.basePath) > 0 {
+		if path != this.basePath && !strings.HasPrefix(path, this.basePath+"/") {
+			return nil, nil
+		}
+		path = path[len(this.basePath):]
+	}
+
+	var pieces = splitPath(path)
+	for _, r := range this.routes {
+		params, ok := r.match(pieces)
+		if ok {
+			return r, params
+		}
+	}
+	return nil, nil
+}
+
+func splitPath(path string) []string {
+	path = strings.Trim(path, "/")
+	if len(path) == 0 {
+		return []string{}
+	}
+	return strings.Split(path, "/")
+}
@@ -0,0 +1,548 @@
+// Copyright 2024 GoEdge CDN goedge.cdn@gmail.com. All rights reserved. Official site: https://goedge.cloud .
+
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Violation 请求和文档不符合的原因
+type Violation struct {
+	Location string // path, method, query.xxx, header.xxx, body.xxx ...
+	Reason   string
+}
+
+func (this *Violation) String() string {
+	if len(this.Location) == 0 {
+		return this.Reason
+	}
+	return this.Location + ": " + this.Reason
+}
+
+// Validator 请求校验器
+type Validator struct {
+	doc    *Document
+	router *router
+
+	// 对于未设置maxItems的数组的最大长度限制，0表示不限制
+	maxArrayItems int
+
+	patternMap    map[string]*regexp.Regexp // pattern => *Regexp
+	patternLocker sync.RWMutex
+}
+
+// NewValidator 获取新对象
+func NewValidator(doc *Document) *Validator {
+	return &Validator{
+		doc:        doc,
+		router:     newRouter(doc),
+		patternMap: map[string]*regexp.Regexp{},
+	}
+}
+
+// SetMaxArrayItems 设置数组默认最大长度
+func (this *Validator) SetMaxArrayItems(maxItems int) {
+	this.maxArrayItems = maxItems
+}
+
+// Document 获取文档
+func (this *Validator) Document() *Document {
+	return this.doc
+}
+
+// ValidateRequest 校验请求，如果没有问题，则返回nil
+// body为已经读取的请求体内容
+func (this *Validator) ValidateRequest(req *http.Request, body []byte) *Violation {
+	return this.validateRequest(req, body, true)
+}
+
+// ValidateRequestWithoutBody 校验请求，但不校验请求体
+// 用于请求体没有完整读取的情况
+func (this *Validator) ValidateRequestWithoutBody(req *http.Request) *Violation {
+	return this.validateRequest(req, nil, false)
+}
+
+func (this *Validator) validateRequest(req *http.Request, body []byte, checkBody bool) *Violation {
+	var path = req.URL.EscapedPath()
+	rt, pathParams := this.router.find(path)
+	if rt == nil {
+		return &Violation{
+			Location: "path",
+			Reason:   "path '" + req.URL.Path + "' is not defined",
+		}
+	}
+
+	var operation = rt.item.Operation(req.Method)
+	if operation == nil {
+		return &Violation{
+			Location: "method",
+			Reason:   "method '" + req.Method + "' is not allowed for '" + rt.template + "'",
+		}
+	}
+
+	// parameters
+	// 操作中的参数会覆盖路径中的同名参数
+	var params = map[string]*Parameter{}
+	var paramKeys = []string{}
+	for _, param := range append(append([]*Parameter{}, rt.item.Parameters...), operation.Parameters...) {
+		param = this.doc.ResolveParameter(param)
+		if param == nil {
+			continue
+		}
+		var key = param.In + "." + param.Name
+		_, exists := params[key]
+		if !exists {
+			paramKeys = append(paramKeys, key)
+		}
+		params[key] = param
+	}
+
+	var query = req.URL.Query()
+	for _, location := range paramKeys {
+		var param = params[location]
+		var values []string
+		switch param.In {
+		case ParameterInPath:
+			value, ok := pathParams[param.Name]
+			if ok {
+				values = []string{value}
+			}
+		case ParameterInQuery:
+			values = query[param.Name]
+		case ParameterInHeader:
+			values = req.Header.Values(param.Name)
+		case ParameterInCookie:
+			cookie, err := req.Cookie(param.Name)
+			if err == nil {
+				values = []string{cookie.Value}
+			}
+		default:
+			continue
+		}
+
+		if len(values) == 0 {
+			if param.Required || param.In == ParameterInPath {
+				return &Violation{
+					Location: location,
+					Reason:   "required parameter is missing",
+				}
+			}
+			continue
+		}
+
+		var violation = this.validateParameter(location, param, values)
+		if violation != nil {
+			return violation
+		}
+	}
+
+	// request body
+	if !checkBody {
+		return nil
+	}
+	var requestBody = this.doc.ResolveRequestBody(operation.RequestBody)
+	if requestBody == nil {
+		return nil
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		if requestBody.Required {
+			return &Violation{
+				Location: "body",
+				Reason:   "request body is required",
+			}
+		}
+		return nil
+	}
+
+	if len(requestBody.Content) == 0 {
+		return nil
+	}
+
+	var contentType = req.Header.Get("Content-Type")
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = strings.ToLower(strings.TrimSpace(contentType))
+	}
+	var media = this.findMediaType(requestBody.Content, mediaType)
+	if media == nil {
+		return &Violation{
+			Location: "header.Content-Type",
+			Reason:   "content type '" + contentType + "' is not allowed",
+		}
+	}
+
+	// 目前只校验JSON请求体
+	if media.Schema == nil || !this.isJSONMediaType(mediaType) {
+		return nil
+	}
+
+	var decoder = json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var value any
+	err = decoder.Decode(&value)
+	if err != nil {
+		return &Violation{
+			Location: "body",
+			Reason:   "invalid json: " + err.Error(),
+		}
+	}
+	return this.validateValue("body", media.Schema, value)
+}
+
+func (this *Validator) findMediaType(content map[string]*MediaType, mediaType string) *MediaType {
+	media, ok := content[mediaType]
+	if ok {
+		if media == nil {
+			media = &MediaType{}
+		}
+		return media
+	}
+
+	// 通配符，比如 application/*、*/*
+	var slashIndex = strings.Index(mediaType, "/")
+	if slashIndex > 0 {
+		media, ok = content[mediaType[:slashIndex]+"/*"]
+		if ok {
+			if media == nil {
+				media = &MediaType{}
+			}
+			return media
+		}
+	}
+	media, ok = content["*/*"]
+	if ok {
+		if media == nil {
+			media = &MediaType{}
+		}
+		return media
+	}
+	return nil
+}
+
+func (this *Validator) isJSONMediaType(mediaType string) bool {
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// 校验字符串形式的参数
+func (this *Validator) validateParameter(location string, param *Parameter, values []string) *Violation {
+	var schema = this.doc.ResolveSchema(param.Schema)
+	if schema == nil {
+		return nil
+	}
+
+	if schema.Type == "array" {
+		// style=form, explode=false 或者 style=simple 时使用逗号分隔
+		var explode = param.Explode == nil || *param.Explode
+		if param.In != ParameterInQuery || (len(param.Style) > 0 && param.Style != "form") {
+			explode = false
+		}
+		var items = []any{}
+		for _, value := range values {
+			if explode {
+				items = append(items, value)
+				continue
+			}
+			for _, piece := range strings.Split(value, ",") {
+				items = append(items, piece)
+			}
+		}
+		return this.validateValue(location, schema, this.coerceArray(schema, items))
+	}
+
+	if len(values) > 1 {
+		return &Violation{
+			Location: location,
+			Reason:   "parameter should not be repeated",
+		}
+	}
+	return this.validateValue(location, schema, this.coerceString(schema, values[0]))
+}
+
+func (this *Validator) coerceArray(schema *Schema, items []any) []any {
+	var itemSchema = this.doc.ResolveSchema(schema.Items)
+	if itemSchema == nil {
+		return items
+	}
+	for index, item := range items {
+		s, ok := item.(string)
+		if ok {
+			items[index] = this.coerceString(itemSchema, s)
+		}
+	}
+	return items
+}
+
+// 将字符串转换为Schema中定义的类型，转换失败时保持原值
+func (this *Validator) coerceString(schema *Schema, s string) any {
+	switch schema.Type {
+	case "integer", "number":
+		_, err := strconv.ParseFloat(s, 64)
+		if err == nil {
+			return json.Number(s)
+		}
+	case "boolean":
+		switch s {
+		case "true":
+			return true
+		case "false":
+			return false
+		}
+	}
+	return s
+}
+
+func (this *Validator) validateValue(location string, schema *Schema, value any) *Violation {
+	schema = this.doc.ResolveSchema(schema)
+	if schema == nil {
+		return nil
+	}
+
+	if value == nil {
+		if schema.Nullable || len(schema.Type) == 0 {
+			return nil
+		}
+		return &Violation{
+			Location: location,
+			Reason:   "value should not be null",
+		}
+	}
+
+	// composition
+	for _, subSchema := range schema.AllOf {
+		var violation = this.validateValue(location, subSchema, value)
+		if violation != nil {
+			return violation
+		}
+	}
+	if len(schema.AnyOf) > 0 {
+		var matched bool
+		for _, subSchema := range schema.AnyOf {
+			if this.validateValue(location, subSchema, value) == nil {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return &Violation{
+				Location: location,
+				Reason:   "value does not match any of the allowed schemas",
+			}
+		}
+	}
+	if len(schema.OneOf) > 0 {
+		// 必须正好符合其中一个
+		var countMatched int
+		for _, subSchema := range schema.OneOf {
+			if this.validateValue(location, subSchema, value) == nil {
+				countMatched++
+				if countMatched > 1 {
+					break
+				}
+			}
+		}
+		switch countMatched {
+		case 0:
+			return &Violation{
+				Location: location,
+				Reason:   "value does not match any of the allowed schemas",
+			}
+		case 1:
+		default:
+			return &Violation{
+				Location: location,
+				Reason:   "value matches more than one of the exclusive schemas",
+			}
+		}
+	}
+
+	if len(schema.Enum) > 0 && !this.inEnum(schema.Enum, value) {
+		return &Violation{
+			Location: location,
+			Reason:   "value '" + this.stringify(value) + "' is not in enum",
+		}
+	}
+
+	switch schema.Type {
+	case "":
+		// 未定义类型的不做检查
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			return this.typeViolation(location, schema.Type, value)
+		}
+		var length = len([]rune(s))
+		if schema.MinLength != nil && length < *schema.MinLength {
+			return &Violation{
+				Location: location,
+				Reason:   fmt.Sprintf("length %d is less than minLength %d", length, *schema.MinLength),
+			}
+		}
+		if schema.MaxLength != nil && length > *schema.MaxLength {
+			return &Violation{
+				Location: location,
+				Reason:   fmt.Sprintf("length %d is greater than maxLength %d", length, *schema.MaxLength),
+			}
+		}
+		if len(schema.Pattern) > 0 {
+			var reg = this.compilePattern(schema.Pattern)
+			if reg != nil && !reg.MatchString(s) {
+				return &Violation{
+					Location: location,
+					Reason:   "value does not match pattern '" + schema.Pattern + "'",
+				}
+			}
+		}
+	case "integer", "number":
+		number, ok := value.(json.Number)
+		if !ok {
+			return this.typeViolation(location, schema.Type, value)
+		}
+		f, err := number.Float64()
+		if err != nil {
+			return this.typeViolation(location, schema.Type, value)
+		}
+		if schema.Type == "integer" {
+			_, err = number.Int64()
+			if err != nil {
+				return this.typeViolation(location, schema.Type, value)
+			}
+		}
+		if schema.Minimum != nil && f < *schema.Minimum {
+			return &Violation{
+				Location: location,
+				Reason:   "value " + number.String() + " is less than minimum " + strconv.FormatFloat(*schema.Minimum, 'f', -1, 64),
+			}
+		}
+		if schema.Maximum != nil && f > *schema.Maximum {
+			return &Violation{
+				Location: location,
+				Reason:   "value " + number.String() + " is greater than maximum " + strconv.FormatFloat(*schema.Maximum, 'f', -1, 64),
+			}
+		}
+	case "boolean":
+		_, ok := value.(bool)
+		if !ok {
+			return this.typeViolation(location, schema.Type, value)
+		}
+	case "array":
+		items, ok := value.([]any)
+		if !ok {
+			return this.typeViolation(location, schema.Type, value)
+		}
+		var maxItems = this.maxArrayItems
+		if schema.MaxItems != nil {
+			maxItems = *schema.MaxItems
+		}
+		if (schema.MaxItems != nil || maxItems > 0) && len(items) > maxItems {
+			return &Violation{
+				Location: location,
+				Reason:   fmt.Sprintf("array size %d is greater than maxItems %d", len(items), maxItems),
+			}
+		}
+		if schema.MinItems != nil && len(items) < *schema.MinItems {
+			return &Violation{
+				Location: location,
+				Reason:   fmt.Sprintf("array size %d is less than minItems %d", len(items), *schema.MinItems),
+			}
+		}
+		if schema.Items != nil {
+			for index, item := range items {
+				var violation = this.validateValue(location+"["+strconv.Itoa(index)+"]", schema.Items, item)
+				if violation != nil {
+					return violation
+				}
+			}
+		}
+	case "object":
+		m, ok := value.(map[string]any)
+		if !ok {
+			return this.typeViolation(location, schema.Type, value)
+		}
+		for _, field := range schema.Required {
+			_, ok = m[field]
+			if !ok {
+				return &Violation{
+					Location: location + "." + field,
+					Reason:   "required field is missing",
+				}
+			}
+		}
+		for key, fieldValue := range m {
+			propertySchema, ok := schema.Properties[key]
+			if !ok {
+				if schema.AdditionalProperties != nil && !*schema.AdditionalProperties {
+					return &Violation{
+						Location: location + "." + key,
+						Reason:   "unknown field",
+					}
+				}
+				continue
+			}
+			var violation = this.validateValue(location+"."+key, propertySchema, fieldValue)
+			if violation != nil {
+				return violation
+			}
+		}
+	}
+
+	return nil
+}
+
+func (this *Validator) typeViolation(location string, expectedType string, value any) *Violation {
+	return &Violation{
+		Location: location,
+		Reason:   "expected " + expectedType + ", got '" + this.stringify(value) + "'",
+	}
+}
+
+func (this *Validator) inEnum(enum []any, value any) bool {
+	var s = this.stringify(value)
+	for _, item := range enum {
+		if this.stringify(item) == s {
+			return true
+		}
+	}
+	return false
+}
+
+func (this *Validator) stringify(value any) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case []any:
+		return "[array]"
+	case map[string]any:
+		return "{object}"
+	}
+	return fmt.Sprintf("%v", value)
+}
+
+func (this *Validator) compilePattern(pattern string) *regexp.Regexp {
+	this.patternLocker.RLock()
+	reg, ok := this.patternMap[pattern]
+	this.patternLocker.RUnlock()
+	if ok {
+		return reg
+	}
+
+	reg, err := regexp.Compile(pattern)
+	if err != nil {
+		// 无法识别的正则表达式不做检查
+		reg = nil
+	}
+
+	this.patternLocker.Lock()
+	this.patternMap[pattern] = reg
+	this.patternLocker.Unlock()
+	return reg
+}
@@ -0,0 +1,203 @@
+// Copyright 2024 GoEdge CDN goedge.cdn@gmail.com. All rights reserved. Official site: https://goedge.cloud .
+
+package openapi_test
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+
+	"github.com/TeaOSLab/EdgeNode/internal/waf/openapi"
+	"github.com/iwind/TeaGo/assert"
+)
+
+var testSpec = []byte(`
+openapi: 3.0.3
+servers:
+  - url: https://api.example.com/v1
+paths:
+  /users:
+    get:
+      parameters:
+        - name: limit
+          in: query
+          schema:
+            type: integer
+            minimum: 1
+            maximum: 100
+        - name: ids
+          in: query
+          schema:
+            type: array
+            maxItems: 3
+            items:
+              type: integer
+    post:
+      requestBody:
+        required: true
+        content:
+          application/json:
+            schema:
+              $ref: "#/components/schemas/User"
+  /users/me:
+    get: {}
+  /users/{id}:
+    parameters:
+      - $ref: "#/components/parameters/UserId"
+    get:
+      parameters:
+        - name: X-Request-Id
+          in: header
+          required: true
+          schema:
+            type: string
+            maxLength: 8
+components:
+  parameters:
+    UserId:
+      name: id
+      in: path
+      required: true
+      schema:
+        type: integer
+  schemas:
+    User:
+      type: object
+      required: [name]
+      additionalProperties: false
+      properties:
+        name:
+          type: string
+          minLength: 1
+        role:
+          type: string
+          enum: [admin, user]
+        tags:
+          type: array
+          items:
+            type: string
+`)
+
+func testValidate(t *testing.T, validator *openapi.Validator, method string, url string, header http.Header, body string) *openapi.Violation {
+	req, err := http.NewRequest(method, url, bytes.NewReader([]byte(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	var violation = validator.ValidateRequest(req, []byte(body))
+	if violation != nil {
+		t.Log(method, url, "=>", violation.String())
+	} else {
+		t.Log(method, url, "=>", "ok")
+	}
+	return violation
+}
+
+func TestValidator_ValidateRequest(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	doc, err := openapi.ParseDocument(testSpec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var validator = openapi.NewValidator(doc)
+
+	// paths and methods
+	a.IsNil(testValidate(t, validator, http.MethodGet, "https://api.example.com/v1/users", nil, ""))
+	a.IsNotNil(testValidate(t, validator, http.MethodGet, "https://api.example.com/users", nil, ""))
+	a.IsNotNil(testValidate(t, validator, http.MethodGet, "https://api.example.com/v1/orders", nil, ""))
+	a.IsNotNil(testValidate(t, validator, http.MethodDelete, "https://api.example.com/v1/users", nil, ""))
+	a.IsNil(testValidate(t, validator, http.MethodHead, "https://api.example.com/v1/users", nil, ""))
+	a.IsNil(testValidate(t, validator, http.MethodGet, "https://api.example.com/v1/users/me", nil, ""))
+
+	// query
+	a.IsNil(testValidate(t, validator, http.MethodGet, "https://api.example.com/v1/users?limit=10", nil, ""))
+	a.IsNotNil(testValidate(t, validator, http.MethodGet, "https://api.example.com/v1/users?limit=abc", nil, ""))
+	a.IsNotNil(testValidate(t, validator, http.MethodGet, "https://api.example.com/v1/users?limit=1000", nil, ""))
+	a.IsNotNil(testValidate(t, validator, http.MethodGet, "https://api.example.com/v1/users?limit=1.5", nil, ""))
+	a.IsNil(testValidate(t, validator, http.MethodGet, "https://api.example.com/v1/users?ids=1&ids=2", nil, ""))
+	a.IsNotNil(testValidate(t, validator, http.MethodGet, "https://api.example.com/v1/users?ids=1&ids=2&ids=3&ids=4", nil, ""))
+
+	// path and header
+	a.IsNil(testValidate(t, validator, http.MethodGet, "https://api.example.com/v1/users/123", http.Header{"X-Request-Id": []string{"abc"}}, ""))
+	a.IsNotNil(testValidate(t, validator, http.MethodGet, "https://api.example.com/v1/users/abc", http.Header{"X-Request-Id": []string{"abc"}}, ""))
+	a.IsNotNil(testValidate(t, validator, http.MethodGet, "https://api.example.com/v1/users/123", nil, ""))
+	a.IsNotNil(testValidate(t, validator, http.MethodGet, "https://api.example.com/v1/users/123", http.Header{"X-Request-Id": []string{"123456789"}}, ""))
+
+	// body
+	var jsonHeader = http.Header{"Content-Type": []string{"application/json; charset=utf-8"}}
+	a.IsNil(testValidate(t, validator, http.MethodPost, "https://api.example.com/v1/users", jsonHeader, `{"name":"lily","role":"admin","tags":["a"]}`))
+	a.IsNotNil(testValidate(t, validator, http.MethodPost, "https://api.example.com/v1/users", jsonHeader, ``))
+	a.IsNotNil(testValidate(t, validator, http.MethodPost, "https://api.example.com/v1/users", jsonHeader, `{"role":"admin"}`))
+	a.IsNotNil(testValidate(t, validator, http.MethodPost, "https://api.example.com/v1/users", jsonHeader, `{"name":"lily","role":"root"}`))
+	a.IsNotNil(testValidate(t, validator, http.MethodPost, "https://api.example.com/v1/users", jsonHeader, `{"name":"lily","age":20}`))
+	a.IsNotNil(testValidate(t, validator, http.MethodPost, "https://api.example.com/v1/users", jsonHeader, `{"name":"lily","tags":[1]}`))
+	a.IsNotNil(testValidate(t, validator, http.MethodPost, "https://api.example.com/v1/users", jsonHeader, `{"name":`))
+	a.IsNotNil(testValidate(t, validator, http.MethodPost, "https://api.example.com/v1/users", http.Header{"Content-Type": []string{"text/plain"}}, `{"name":"lily"}`))
+}
+
+func TestValidator_MaxArrayItems(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	doc, err := openapi.ParseDocument(testSpec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var validator = openapi.NewValidator(doc)
+	validator.SetMaxArrayItems(2)
+
+	var jsonHeader = http.Header{"Content-Type": []string{"application/json"}}
+	a.IsNil(testValidate(t, validator, http.MethodPost, "https://api.example.com/v1/users", jsonHeader, `{"name":"lily","tags":["a","b"]}`))
+	a.IsNotNil(testValidate(t, validator, http.MethodPost, "https://api.example.com/v1/users", jsonHeader, `{"name":"lily","tags":["a","b","c"]}`))
+}
+
+func TestValidator_OneOf(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	doc, err := openapi.ParseDocument([]byte(`
+openapi: 3.0.3
+paths:
+  /one:
+    post:
+      requestBody:
+        content:
+          application/json:
+            schema:
+              oneOf:
+                - type: integer
+                - type: number
+  /any:
+    post:
+      requestBody:
+        content:
+          application/json:
+            schema:
+              anyOf:
+                - type: integer
+                - type: number
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var validator = openapi.NewValidator(doc)
+
+	var jsonHeader = http.Header{"Content-Type": []string{"application/json"}}
+	a.IsNil(testValidate(t, validator, http.MethodPost, "https://api.example.com/one", jsonHeader, `1.5`))
+	a.IsNotNil(testValidate(t, validator, http.MethodPost, "https://api.example.com/one", jsonHeader, `1`))
+	a.IsNotNil(testValidate(t, validator, http.MethodPost, "https://api.example.com/one", jsonHeader, `"a"`))
+	a.IsNil(testValidate(t, validator, http.MethodPost, "https://api.example.com/any", jsonHeader, `1`))
+	a.IsNotNil(testValidate(t, validator, http.MethodPost, "https://api.example.com/any", jsonHeader, `"a"`))
+}
+
+func TestParseDocument_Invalid(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	_, err := openapi.ParseDocument([]byte(`{"swagger": "2.0", "paths": {}}`))
+	a.IsNotNil(err)
+	t.Log(err)
+
+	_, err = openapi.ParseDocument([]byte(`{"openapi": "3.1.0", "paths": {"/hello": {"get": {}}}}`))
+	a.IsNil(err)
+}
@@ -0,0 +1,206 @@
+// Copyright 2024 GoEdge CDN goedge.cdn@gmail.com. All rights reserved. Official site: https://goedge.cloud .
+
+package replay
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// DefaultRemoteAddr 默认的客户端地址
+// 使用文档保留地址，避免被 ignoreLocal 规则忽略
+const DefaultRemoteAddr = "203.0.113.10:40000"
+
+type Verdict = string
+
+const (
+	VerdictPass  Verdict = "pass"  // 没有匹配任何规则集
+	VerdictMatch Verdict = "match" // 匹配了规则集，但请求仍然继续，比如 log、tag 等动作
+	VerdictAllow Verdict = "allow" // 匹配了规则集并被允许通过
+	VerdictBlock Verdict = "block" // 匹配了规则集并被拦截
+)
+
+// Expectation 期望结果
+// 格式为：VERDICT [group=ID|CODE|NAME] [set=ID|CODE|NAME] [action=CODE]，比如：
+//
+//	block group=sqlInjection set=7 action=block
+type Expectation struct {
+	Verdict Verdict // 为空或者 match 时表示匹配任意规则集
+	Group   string
+	Set     string
+	Action  string
+}
+
+// ParseExpectation 分析期望结果
+func ParseExpectation(s string) (*Expectation, error) {
+	var expectation = &Expectation{}
+	for _, piece := range strings.Fields(s) {
+		var key, value, found = strings.Cut(piece, "=")
+		if !found {
+			switch piece {
+			case VerdictPass, VerdictMatch, VerdictAllow, VerdictBlock:
+				expectation.Verdict = piece
+			default:
+				return nil, errors.New("invalid verdict '" + piece + "'")
+			}
+			continue
+		}
+		switch key {
+		case "group":
+			expectation.Group = value
+		case "set":
+			expectation.Set = value
+		case "action":
+			expectation.Action = value
+		default:
+			return nil, errors.New("invalid expectation key '" + key + "'")
+		}
+	}
+	if expectation.Verdict == VerdictPass && (len(expectation.Group) > 0 || len(expectation.Set) > 0 || len(expectation.Action) > 0) {
+		return nil, errors.New("'pass' can not be used with group, set or action")
+	}
+	return expectation, nil
+}
+
+func (this *Expectation) String() string {
+	var pieces = []string{}
+	if len(this.Verdict) > 0 {
+		pieces = append(pieces, this.Verdict)
+	} else {
+		pieces = append(pieces, VerdictMatch)
+	}
+	if len(this.Group) > 0 {
+		pieces = append(pieces, "group="+this.Group)
+	}
+	if len(this.Set) > 0 {
+		pieces = append(pieces, "set="+this.Set)
+	}
+	if len(this.Action) > 0 {
+		pieces = append(pieces, "action="+this.Action)
+	}
+	return strings.Join(pieces, " ")
+}
+
+// Case 一个回放用例，包含一个请求和一个可选的响应
+type Case struct {
+	Name        string
+	Expectation *Expectation
+	RemoteAddr  string
+
+	rawRequest   *http.Request
+	requestBody  []byte
+	rawResponse  *http.Response
+	responseBody []byte
+}
+
+// NewRequest 构造新的请求对象，每次调用都会得到可以重新读取Body的请求
+func (this *Case) NewRequest() *http.Request {
+	var req = this.rawRequest.Clone(this.rawRequest.Context())
+	req.Body = io.NopCloser(bytes.NewReader(this.requestBody))
+	req.ContentLength = int64(len(this.requestBody))
+	req.TransferEncoding = nil
+	req.Header.Del("Transfer-Encoding")
+	if len(this.RemoteAddr) > 0 {
+		req.RemoteAddr = this.RemoteAddr
+	} else {
+		req.RemoteAddr = DefaultRemoteAddr
+	}
+	if len(req.Host) == 0 {
+		req.Host = req.URL.Host
+	}
+	if req.URL.Scheme == "https" && req.TLS == nil {
+		req.TLS = &tls.ConnectionState{
+			ServerName: req.URL.Hostname(),
+		}
+	}
+	req.RequestURI = req.URL.RequestURI()
+	return req
+}
+
+// HasResponse 是否有响应
+func (this *Case) HasResponse() bool {
+	return this.rawResponse != nil
+}
+
+// NewResponse 构造新的响应对象
+func (this *Case) NewResponse(req *http.Request) *http.Response {
+	if this.rawResponse == nil {
+		return nil
+	}
+	var resp = *this.rawResponse
+	resp.Header = this.rawResponse.Header.Clone()
+	resp.Body = io.NopCloser(bytes.NewReader(this.responseBody))
+	resp.ContentLength = int64(len(this.responseBody))
+	resp.TransferEncoding = nil
+	resp.Header.Del("Transfer-Encoding")
+	resp.Request = req
+	return &resp
+}
+
+// 设置请求
+func (this *Case) setRequest(req *http.Request, body []byte) {
+	if req.Header == nil {
+		req.Header = http.Header{}
+	}
+	this.rawRequest = req
+	this.requestBody = body
+}
+
+// 设置响应
+func (this *Case) setResponse(resp *http.Response, body []byte) {
+	if resp.Header == nil {
+		resp.Header = http.Header{}
+	}
+
+	// 回放的内容都是解压后的内容
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+
+	this.rawResponse = resp
+	this.responseBody = body
+}
+
+// 从注释中分析用例属性，不能识别的注释会被忽略
+// 支持的格式为：name: NAME、expect: EXPECTATION、remoteAddr: IP[:PORT]
+func (this *Case) parseComment(comment string) error {
+	var key, value, found = strings.Cut(strings.TrimSpace(comment), ":")
+	if !found {
+		return nil
+	}
+	value = strings.TrimSpace(value)
+	switch strings.TrimSpace(key) {
+	case "name":
+		this.Name = value
+	case "expect":
+		expectation, err := ParseExpectation(value)
+		if err != nil {
+			return err
+		}
+		this.Expectation = expectation
+	case "remoteAddr":
+		_, _, err := net.SplitHostPort(value)
+		if err != nil {
+			value = net.JoinHostPort(value, "40000")
+		}
+		this.RemoteAddr = value
+	}
+	return nil
+}
+
+// LoadFile 从文件中加载用例
+// 以 .har 为扩展名或者内容以 { 开头的文件被认为是HAR文件，其他的被认为是原始的HTTP请求文件
+func LoadFile(filename string, data []byte) ([]*Case, error) {
+	var trimmedData = strings.TrimSpace(string(data))
+	if len(trimmedData) == 0 {
+		return nil, errors.New("'" + filename + "' is empty")
+	}
+	if strings.HasSuffix(strings.ToLower(filename), ".har") || trimmedData[0] == '{' {
+		return ParseHAR(data, filename)
+	}
+	return ParseRaw(data, filename)
+}
@@ -0,0 +1,191 @@
+// Copyright 2024 GoEdge CDN goedge.cdn@gmail.com. All rights reserved. Official site: https://goedge.cloud .
+
+package replay
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// HAR 1.2 中回放需要用到的字段
+type harFile struct {
+	Log struct {
+		Entries []*harEntry `json:"entries"`
+	} `json:"log"`
+}
+
+type harEntry struct {
+	Comment  string       `json:"comment"`
+	Request  *harRequest  `json:"request"`
+	Response *harResponse `json:"response"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harRequest struct {
+	Method      string          `json:"method"`
+	URL         string          `json:"url"`
+	HTTPVersion string          `json:"httpVersion"`
+	Headers     []*harNameValue `json:"headers"`
+	PostData    *struct {
+		MimeType string          `json:"mimeType"`
+		Text     string          `json:"text"`
+		Params   []*harNameValue `json:"params"`
+	} `json:"postData"`
+}
+
+type harResponse struct {
+	Status      int             `json:"status"`
+	StatusText  string          `json:"statusText"`
+	HTTPVersion string          `json:"httpVersion"`
+	Headers     []*harNameValue `json:"headers"`
+	Content     *struct {
+		MimeType string `json:"mimeType"`
+		Text     string `json:"text"`
+		Encoding string `json:"encoding"`
+	} `json:"content"`
+}
+
+// ParseHAR 分析HAR文件
+// 可以在条目的 comment 中设置用例属性，比如：
+//
+//	"comment": "name: login\nexpect: block set=7"
+func ParseHAR(data []byte, filename string) (result []*Case, err error) {
+	var file = &harFile{}
+	err = json.Unmarshal(data, file)
+	if err != nil {
+		return nil, errors.New("decode '" + filename + "' failed: " + err.Error())
+	}
+
+	for index, entry := range file.Log.Entries {
+		if entry == nil || entry.Request == nil {
+			continue
+		}
+
+		var c = &Case{
+			Name: filename + "#" + strconv.Itoa(index+1),
+		}
+		for _, line := range strings.Split(entry.Comment, "\n") {
+			err = c.parseComment(line)
+			if err != nil {
+				return nil, errors.New("parse '" + c.Name + "' failed: " + err.Error())
+			}
+		}
+
+		err = parseHAREntry(c, entry)
+		if err != nil {
+			return nil, errors.New("parse '" + c.Name + "' failed: " + err.Error())
+		}
+		result = append(result, c)
+	}
+	if len(result) == 0 {
+		return nil, errors.New("no requests found in '" + filename + "'")
+	}
+	return
+}
+
+func parseHAREntry(c *Case, entry *harEntry) error {
+	var harReq = entry.Request
+	u, err := url.Parse(harReq.URL)
+	if err != nil {
+		return err
+	}
+
+	var req = &http.Request{
+		Method:     strings.ToUpper(harReq.Method),
+		URL:        u,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header{},
+		Host:       u.Host,
+	}
+	if len(req.Method) == 0 {
+		req.Method = http.MethodGet
+	}
+	if strings.HasPrefix(strings.ToLower(harReq.HTTPVersion), "http/2") || strings.ToLower(harReq.HTTPVersion) == "h2" {
+		req.Proto = "HTTP/2.0"
+		req.ProtoMajor = 2
+		req.ProtoMinor = 0
+	}
+	for _, header := range harReq.Headers {
+		if header == nil || len(header.Name) == 0 {
+			continue
+		}
+
+		// HTTP/2伪头部
+		if header.Name[0] == ':' {
+			if header.Name == ":authority" {
+				req.Host = header.Value
+			}
+			continue
+		}
+		if strings.EqualFold(header.Name, "Host") {
+			req.Host = header.Value
+			continue
+		}
+		req.Header.Add(header.Name, header.Value)
+	}
+
+	var body []byte
+	if harReq.PostData != nil {
+		if len(harReq.PostData.Text) > 0 {
+			body = []byte(harReq.PostData.Text)
+		} else if len(harReq.PostData.Params) > 0 {
+			var form = url.Values{}
+			for _, param := range harReq.PostData.Params {
+				if param != nil {
+					form.Add(param.Name, param.Value)
+				}
+			}
+			body = []byte(form.Encode())
+		}
+		if len(harReq.PostData.MimeType) > 0 && len(req.Header.Get("Content-Type")) == 0 {
+			req.Header.Set("Content-Type", harReq.PostData.MimeType)
+		}
+	}
+	c.setRequest(req, body)
+
+	// 响应，status为0表示请求未完成
+	var harResp = entry.Response
+	if harResp == nil || harResp.Status <= 0 {
+		return nil
+	}
+	var resp = &http.Response{
+		Status:     strconv.Itoa(harResp.Status) + " " + harResp.StatusText,
+		StatusCode: harResp.Status,
+		Proto:      req.Proto,
+		ProtoMajor: req.ProtoMajor,
+		ProtoMinor: req.ProtoMinor,
+		Header:     http.Header{},
+	}
+	for _, header := range harResp.Headers {
+		if header == nil || len(header.Name) == 0 || header.Name[0] == ':' {
+			continue
+		}
+		resp.Header.Add(header.Name, header.Value)
+	}
+
+	var respBody []byte
+	if harResp.Content != nil && len(harResp.Content.Text) > 0 {
+		if harResp.Content.Encoding == "base64" {
+			respBody, err = base64.StdEncoding.DecodeString(harResp.Content.Text)
+			if err != nil {
+				return errors.New("decode response content failed: " + err.Error())
+			}
+		} else {
+			respBody = []byte(harResp.Content.Text)
+		}
+	}
+	c.setResponse(resp, respBody)
+
+	return nil
+}
@@ -0,0 +1,119 @@
+// Copyright 2024 GoEdge CDN goedge.cdn@gmail.com. All rights reserved. Official site: https://goedge.cloud .
+
+package replay_test
+
+import (
+	"io"
+	"testing"
+
+	"github.com/TeaOSLab/EdgeNode/internal/waf/replay"
+	"github.com/iwind/TeaGo/assert"
+)
+
+func TestParseHAR(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	cases, err := replay.ParseHAR([]byte(`{
+  "log": {
+    "version": "1.2",
+    "entries": [
+      {
+        "comment": "name: xss\nexpect: block set=xss",
+        "request": {
+          "method": "GET",
+          "url": "https://example.com/search?q=%3Cscript%3E",
+          "httpVersion": "http/2.0",
+          "headers": [
+            {"name": ":authority", "value": "www.example.com"},
+            {"name": "user-agent", "value": "Mozilla/5.0"}
+          ]
+        },
+        "response": {
+          "status": 0
+        }
+      },
+      {
+        "request": {
+          "method": "post",
+          "url": "http://example.com/login",
+          "httpVersion": "HTTP/1.1",
+          "headers": [],
+          "postData": {
+            "mimeType": "application/x-www-form-urlencoded",
+            "params": [{"name": "user", "value": "lily"}]
+          }
+        },
+        "response": {
+          "status": 200,
+          "statusText": "OK",
+          "headers": [{"name": "Content-Type", "value": "application/json"}],
+          "content": {"mimeType": "application/json", "text": "eyJvayI6dHJ1ZX0=", "encoding": "base64"}
+        }
+      }
+    ]
+  }
+}`), "test.har")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.IsTrue(len(cases) == 2)
+
+	{
+		var c = cases[0]
+		a.IsTrue(c.Name == "xss")
+		a.IsTrue(c.Expectation != nil && c.Expectation.Set == "xss")
+		a.IsFalse(c.HasResponse())
+
+		var req = c.NewRequest()
+		a.IsTrue(req.Host == "www.example.com")
+		a.IsTrue(req.ProtoMajor == 2)
+		a.IsTrue(req.TLS != nil)
+		a.IsTrue(req.URL.Query().Get("q") == "<script>")
+		a.IsTrue(req.UserAgent() == "Mozilla/5.0")
+	}
+
+	{
+		var c = cases[1]
+		a.IsTrue(c.Name == "test.har#2")
+
+		var req = c.NewRequest()
+		a.IsTrue(req.Method == "POST")
+		a.IsTrue(req.Header.Get("Content-Type") == "application/x-www-form-urlencoded")
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		a.IsTrue(string(body) == "user=lily")
+
+		a.IsTrue(c.HasResponse())
+		var resp = c.NewResponse(req)
+		respBody, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		a.IsTrue(string(respBody) == `{"ok":true}`)
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	{
+		cases, err := replay.LoadFile("a.json", []byte(`{"log":{"entries":[{"request":{"method":"GET","url":"http://example.com/"}}]}}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		a.IsTrue(len(cases) == 1)
+	}
+	{
+		cases, err := replay.LoadFile("a.txt", []byte("GET / HTTP/1.1\nHost: example.com\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		a.IsTrue(len(cases) == 1)
+	}
+	{
+		_, err := replay.LoadFile("a.txt", []byte("  \n"))
+		a.IsNotNil(err)
+	}
+}
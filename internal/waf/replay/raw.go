@@ -0,0 +1,152 @@
+// Copyright 2024 GoEdge CDN goedge.cdn@gmail.com. All rights reserved. Official site: https://goedge.cloud .
+
+package replay
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var rawSeparatorRegexp = regexp.MustCompile(`(?m)^###[^\n]*\n?`)
+var rawStatusLineRegexp = regexp.MustCompile(`(?m)^HTTP/\d(?:\.\d)? \d{3}`)
+
+// ParseRaw 分析原始的HTTP请求文件
+// 一个文件中可以有多个用例，用例之间使用 ### 开头的行分隔；
+// 每个用例以 # 开头的注释开始（可选），然后是请求，请求后面可以跟随一个以 HTTP/ 开头的响应：
+//
+//	# name: sql injection in query
+//	# expect: block group=sqlInjection
+//	GET /?id=1%20union%20select HTTP/1.1
+//	Host: example.com
+//
+//	###
+//	POST /login HTTP/1.1
+//	...
+func ParseRaw(data []byte, filename string) (result []*Case, err error) {
+	var index = 0
+	for _, block := range rawSeparatorRegexp.Split(string(data), -1) {
+		if len(strings.TrimSpace(block)) == 0 {
+			continue
+		}
+		index++
+
+		var c = &Case{
+			Name: filename + "#" + strconv.Itoa(index),
+		}
+		err = parseRawBlock(c, []byte(block))
+		if err != nil {
+			return nil, errors.New("parse '" + c.Name + "' failed: " + err.Error())
+		}
+		result = append(result, c)
+	}
+	if len(result) == 0 {
+		return nil, errors.New("no requests found in '" + filename + "'")
+	}
+	return
+}
+
+func parseRawBlock(c *Case, block []byte) error {
+	// 注释和前导空行
+	for len(block) > 0 {
+		var line = block
+		var lineEnd = bytes.IndexByte(block, '\n')
+		if lineEnd >= 0 {
+			line = block[:lineEnd]
+		}
+		var trimmedLine = bytes.TrimSpace(line)
+		if len(trimmedLine) > 0 && trimmedLine[0] != '#' {
+			break
+		}
+		if len(trimmedLine) > 0 {
+			err := c.parseComment(string(trimmedLine[1:]))
+			if err != nil {
+				return err
+			}
+		}
+		if lineEnd < 0 {
+			block = nil
+		} else {
+			block = block[lineEnd+1:]
+		}
+	}
+	if len(block) == 0 {
+		return errors.New("request not found")
+	}
+
+	// 请求
+	head, rest := splitRawHead(block)
+	req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(head)))
+	if err != nil {
+		return err
+	}
+
+	// 响应
+	var respData []byte
+	var loc = rawStatusLineRegexp.FindIndex(rest)
+	if loc != nil {
+		respData = rest[loc[0]:]
+		rest = rest[:loc[0]]
+	}
+
+	body, err := readRawBody(req.Header, req.TransferEncoding, rest)
+	if err != nil {
+		return err
+	}
+	c.setRequest(req, body)
+
+	if len(respData) > 0 {
+		respHead, respRest := splitRawHead(respData)
+		resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(respHead)), req)
+		if err != nil {
+			return err
+		}
+		respBody, err := readRawBody(resp.Header, resp.TransferEncoding, respRest)
+		if err != nil {
+			return err
+		}
+		c.setResponse(resp, respBody)
+	}
+
+	return nil
+}
+
+// 分割头部和剩余部分，返回的头部以空行结尾
+func splitRawHead(data []byte) (head []byte, rest []byte) {
+	for _, sep := range [][]byte{[]byte("\r\n\r\n"), []byte("\n\n")} {
+		var index = bytes.Index(data, sep)
+		if index >= 0 {
+			return data[:index+len(sep)], data[index+len(sep):]
+		}
+	}
+	return append(bytes.TrimRight(data, "\r\n"), "\r\n\r\n"...), nil
+}
+
+// 读取内容
+// 原始文件中的内容长度往往和Content-Length不一致，所以这里以实际内容为准
+func readRawBody(header http.Header, transferEncoding []string, data []byte) ([]byte, error) {
+	if len(transferEncoding) > 0 && transferEncoding[0] == "chunked" {
+		// 手工编辑的文件中常常只使用 \n 换行
+		if !bytes.Contains(data, []byte("\r\n")) {
+			data = bytes.ReplaceAll(data, []byte("\n"), []byte("\r\n"))
+		}
+		return io.ReadAll(httputil.NewChunkedReader(bytes.NewReader(data)))
+	}
+
+	var contentLength = header.Get("Content-Length")
+	if len(contentLength) > 0 {
+		length, err := strconv.Atoi(contentLength)
+		if err == nil && length >= 0 && length <= len(data) {
+			return data[:length], nil
+		}
+	}
+
+	// 去除用例之间的空行
+	return bytes.TrimRight(data, "\r\n"), nil
+}
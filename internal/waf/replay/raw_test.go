@@ -0,0 +1,136 @@
+// Copyright 2024 GoEdge CDN goedge.cdn@gmail.com. All rights reserved. Official site: https://goedge.cloud .
+
+package replay_test
+
+import (
+	"io"
+	"testing"
+
+	"github.com/TeaOSLab/EdgeNode/internal/waf/replay"
+	"github.com/iwind/TeaGo/assert"
+)
+
+func TestParseRaw(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	cases, err := replay.ParseRaw([]byte(`# name: sql injection
+# expect: block group=sqlInjection
+# remoteAddr: 1.2.3.4
+GET /?id=1%20union%20select HTTP/1.1
+Host: example.com
+User-Agent: curl/8.0
+
+###
+POST http://example.com/login HTTP/1.1
+Content-Type: application/x-www-form-urlencoded
+Content-Length: 100
+
+user=lily&password=123456
+
+HTTP/1.1 200 OK
+Content-Type: text/html
+Content-Encoding: gzip
+
+<html>hello</html>
+### chunked
+POST /upload HTTP/1.1
+Host: example.com
+Transfer-Encoding: chunked
+
+5
+hello
+0
+
+`), "test.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.IsTrue(len(cases) == 3)
+
+	{
+		var c = cases[0]
+		a.IsTrue(c.Name == "sql injection")
+		a.IsTrue(c.Expectation != nil && c.Expectation.Verdict == replay.VerdictBlock && c.Expectation.Group == "sqlInjection")
+		a.IsFalse(c.HasResponse())
+
+		var req = c.NewRequest()
+		a.IsTrue(req.Method == "GET")
+		a.IsTrue(req.Host == "example.com")
+		a.IsTrue(req.URL.Query().Get("id") == "1 union select")
+		a.IsTrue(req.RemoteAddr == "1.2.3.4:40000")
+		a.IsTrue(req.UserAgent() == "curl/8.0")
+	}
+
+	{
+		var c = cases[1]
+		a.IsTrue(c.Name == "test.txt#2")
+		a.IsTrue(c.Expectation == nil)
+		a.IsTrue(c.HasResponse())
+
+		var req = c.NewRequest()
+		a.IsTrue(req.Host == "example.com")
+		a.IsTrue(req.RemoteAddr == replay.DefaultRemoteAddr)
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		a.IsTrue(string(body) == "user=lily&password=123456")
+		a.IsTrue(req.ContentLength == int64(len(body)))
+
+		// 可以重复读取
+		body, _ = io.ReadAll(c.NewRequest().Body)
+		a.IsTrue(string(body) == "user=lily&password=123456")
+
+		var resp = c.NewResponse(req)
+		a.IsTrue(resp.StatusCode == 200)
+		a.IsTrue(resp.Header.Get("Content-Type") == "text/html")
+		a.IsTrue(len(resp.Header.Get("Content-Encoding")) == 0)
+		respBody, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		a.IsTrue(string(respBody) == "<html>hello</html>")
+	}
+
+	{
+		var c = cases[2]
+		body, err := io.ReadAll(c.NewRequest().Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		a.IsTrue(string(body) == "hello")
+	}
+}
+
+func TestParseRaw_Invalid(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	{
+		_, err := replay.ParseRaw([]byte("# only comments\n"), "test.txt")
+		a.IsNotNil(err)
+	}
+	{
+		_, err := replay.ParseRaw([]byte("# expect: blocked\nGET / HTTP/1.1\nHost: example.com\n"), "test.txt")
+		a.IsNotNil(err)
+	}
+}
+
+func TestParseExpectation(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	expectation, err := replay.ParseExpectation("block group=1 set=sqlInjection action=block")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.IsTrue(expectation.Verdict == replay.VerdictBlock)
+	a.IsTrue(expectation.Group == "1")
+	a.IsTrue(expectation.Set == "sqlInjection")
+	a.IsTrue(expectation.Action == "block")
+	a.IsTrue(expectation.String() == "block group=1 set=sqlInjection action=block")
+
+	_, err = replay.ParseExpectation("pass set=1")
+	a.IsNotNil(err)
+
+	_, err = replay.ParseExpectation("block rule=1")
+	a.IsNotNil(err)
+}
@@ -0,0 +1,138 @@
+// Copyright 2024 GoEdge CDN goedge.cdn@gmail.com. All rights reserved. Official site: https://goedge.cloud .
+
+package replay
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/iwind/TeaGo/types"
+)
+
+// 打印值时的最大长度
+const maxPrintValueLength = 256
+
+// Print 打印回放结果
+func (this *Result) Print(writer io.Writer) {
+	var status = "OK"
+	if !this.IsOk() {
+		status = "FAIL"
+	}
+
+	var req = this.Case.NewRequest()
+	_, _ = fmt.Fprintf(writer, "[%s] %s: %s %s\n", status, this.Case.Name, req.Method, req.URL.String())
+
+	for _, phaseResult := range []*PhaseResult{this.Request, this.Response} {
+		if phaseResult != nil {
+			phaseResult.print(writer)
+		}
+	}
+
+	var expectation = this.Case.Expectation
+	if expectation != nil {
+		_, _ = fmt.Fprintln(writer, "  expect: "+expectation.String())
+	}
+	for _, failure := range this.Failures {
+		_, _ = fmt.Fprintln(writer, "  [FAIL] "+failure)
+	}
+}
+
+func (this *PhaseResult) print(writer io.Writer) {
+	_, _ = fmt.Fprintf(writer, "  %s: %s, cost: %s\n", this.Phase, this.Verdict, formatCost(this.Cost))
+	if this.Err != nil {
+		_, _ = fmt.Fprintln(writer, "    error: "+this.Err.Error())
+	}
+	if this.Group != nil {
+		_, _ = fmt.Fprintln(writer, "    group: "+groupString(this.Group))
+	}
+	if this.Set != nil {
+		_, _ = fmt.Fprintln(writer, "    set: "+setString(this.Set)+", actions: "+strings.Join(this.Actions, ", "))
+	}
+	if len(this.SkippedActions) > 0 {
+		_, _ = fmt.Fprintln(writer, "    skipped actions: "+strings.Join(this.SkippedActions, ", "))
+	}
+	for _, ruleResult := range this.Rules {
+		var rule = ruleResult.Rule
+		var matchedString = "not matched"
+		if ruleResult.IsMatched {
+			matchedString = "matched"
+		}
+		_, _ = fmt.Fprintf(writer, "    rule: %d %s %s %s => %s, cost: %s\n", rule.Id, rule.Param, rule.Operator, formatValue(rule.Value), matchedString, formatCost(ruleResult.Cost))
+		if ruleResult.Err != nil {
+			_, _ = fmt.Fprintln(writer, "      error: "+ruleResult.Err.Error())
+		}
+
+		var varNames = []string{}
+		for varName := range ruleResult.Values {
+			varNames = append(varNames, varName)
+		}
+		sort.Strings(varNames)
+		for _, varName := range varNames {
+			_, _ = fmt.Fprintln(writer, "      "+varName+": "+formatValue(ruleResult.Values[varName]))
+		}
+	}
+}
+
+// Stat 回放统计
+type Stat struct {
+	Count       int
+	CountFailed int
+	Cost        time.Duration
+	verdicts    map[Verdict]int
+}
+
+// NewStat 获取新对象
+func NewStat() *Stat {
+	return &Stat{
+		verdicts: map[Verdict]int{},
+	}
+}
+
+// Add 添加结果
+func (this *Stat) Add(result *Result) {
+	this.Count++
+	if !result.IsOk() {
+		this.CountFailed++
+	}
+	for _, phaseResult := range []*PhaseResult{result.Request, result.Response} {
+		if phaseResult != nil {
+			this.Cost += phaseResult.Cost
+		}
+	}
+	this.verdicts[result.Final().Verdict]++
+}
+
+// Print 打印统计
+func (this *Stat) Print(writer io.Writer) {
+	_, _ = fmt.Fprintf(writer, "%d cases, %d failed, pass: %d, match: %d, allow: %d, block: %d, cost: %s\n",
+		this.Count,
+		this.CountFailed,
+		this.verdicts[VerdictPass],
+		this.verdicts[VerdictMatch],
+		this.verdicts[VerdictAllow],
+		this.verdicts[VerdictBlock],
+		formatCost(this.Cost))
+}
+
+func formatCost(cost time.Duration) string {
+	return fmt.Sprintf("%.4fms", cost.Seconds()*1000)
+}
+
+func formatValue(value any) string {
+	var s string
+	switch v := value.(type) {
+	case []byte:
+		s = string(v)
+	case string:
+		s = v
+	default:
+		return types.String(value)
+	}
+	if len(s) > maxPrintValueLength {
+		return fmt.Sprintf("%q... (%d bytes)", s[:maxPrintValueLength], len(s))
+	}
+	return fmt.Sprintf("%q", s)
+}
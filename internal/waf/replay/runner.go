@@ -0,0 +1,313 @@
+// Copyright 2024 GoEdge CDN goedge.cdn@gmail.com. All rights reserved. Official site: https://goedge.cloud .
+
+package replay
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/TeaOSLab/EdgeCommon/pkg/serverconfigs/firewallconfigs"
+	"github.com/TeaOSLab/EdgeNode/internal/waf"
+	"github.com/TeaOSLab/EdgeNode/internal/waf/requests"
+	"github.com/iwind/TeaGo/types"
+)
+
+var ruleParamVarRegexp = regexp.MustCompile(`\${([\w.-]+)}`)
+
+type Phase = string
+
+const (
+	PhaseRequest  Phase = "request"
+	PhaseResponse Phase = "response"
+)
+
+// RuleResult 单个规则的检查结果
+type RuleResult struct {
+	Rule      *waf.Rule
+	IsMatched bool
+	Values    map[string]any // 参数 => 检查点取得的值
+	Cost      time.Duration
+	Err       error
+}
+
+// PhaseResult 请求或响应阶段的检查结果
+type PhaseResult struct {
+	Phase          Phase
+	Verdict        Verdict
+	Group          *waf.RuleGroup
+	Set            *waf.RuleSet
+	Actions        []string // 匹配的规则集中的动作
+	SkippedActions []string // 回放时没有执行的动作
+	Rules          []*RuleResult
+	Cost           time.Duration
+	Err            error
+}
+
+// Result 用例回放结果
+type Result struct {
+	Case     *Case
+	Request  *PhaseResult
+	Response *PhaseResult // 请求阶段被拦截或者没有响应时为nil
+	Failures []string
+}
+
+// Final 最终起作用的阶段结果
+func (this *Result) Final() *PhaseResult {
+	if this.Response != nil && this.Response.Verdict != VerdictPass {
+		return this.Response
+	}
+	return this.Request
+}
+
+// IsOk 是否符合期望
+func (this *Result) IsOk() bool {
+	return len(this.Failures) == 0
+}
+
+// Runner 使用WAF策略回放用例
+// 回放时总是使用防御模式，以便于检查规则实际的效果；
+// 不会修改本地防火墙，记录IP（record_ip）动作也不会被执行，而是直接结束当前请求；
+// 为了取得匹配的规则和检查点的值，匹配的规则集中的规则会被再次执行，所以CC等有计数的检查点会重复计数
+type Runner struct {
+	waf                *waf.WAF
+	DefaultExpectation *Expectation // 用例中没有设置期望结果时使用的期望结果
+}
+
+// NewRunner 获取新对象
+func NewRunner(w *waf.WAF) *Runner {
+	w.Mode = firewallconfigs.FirewallModeDefend
+	w.UseLocalFirewall = false
+	return &Runner{
+		waf: w,
+	}
+}
+
+// NewRunnerWithFile 从WAF策略文件中构造对象
+func NewRunnerWithFile(policyFile string) (*Runner, error) {
+	w, err := waf.NewWAFFromFile(policyFile)
+	if err != nil {
+		return nil, err
+	}
+	var runner = NewRunner(w)
+	errs := w.Init()
+	if len(errs) > 0 {
+		return nil, errs[0]
+	}
+	return runner, nil
+}
+
+// Run 回放单个用例
+func (this *Runner) Run(c *Case) *Result {
+	var result = &Result{
+		Case: c,
+	}
+
+	// 请求
+	var rawReq = c.NewRequest()
+	var req = newReplayRequest(rawReq)
+	var writer = httptest.NewRecorder()
+	var before = time.Now()
+	matchResult, err := this.waf.MatchRequest(req, writer, firewallconfigs.ServerCaptchaTypeNone)
+	result.Request = this.tracePhase(PhaseRequest, matchResult, time.Since(before), err, req, nil)
+
+	// 响应
+	if err == nil && matchResult.GoNext && !matchResult.IsAllowed && c.HasResponse() {
+		req.skippedActions = nil
+		var rawResp = c.NewResponse(rawReq)
+		before = time.Now()
+		matchResult, err = this.waf.MatchResponse(req, rawResp, writer)
+		result.Response = this.tracePhase(PhaseResponse, matchResult, time.Since(before), err, req, rawResp)
+	}
+
+	result.Failures = this.check(c, result)
+	return result
+}
+
+// 记录阶段结果，并重新执行匹配的规则集中的规则
+func (this *Runner) tracePhase(phase Phase, matchResult waf.MatchResult, cost time.Duration, err error, req *replayRequest, rawResp *http.Response) *PhaseResult {
+	var phaseResult = &PhaseResult{
+		Phase:          phase,
+		Verdict:        VerdictPass,
+		Group:          matchResult.Group,
+		Set:            matchResult.Set,
+		SkippedActions: req.skippedActions,
+		Cost:           cost,
+		Err:            err,
+	}
+	if err != nil || matchResult.Set == nil {
+		return phaseResult
+	}
+
+	switch {
+	case matchResult.IsAllowed:
+		phaseResult.Verdict = VerdictAllow
+	case !matchResult.GoNext:
+		phaseResult.Verdict = VerdictBlock
+	default:
+		phaseResult.Verdict = VerdictMatch
+	}
+	phaseResult.Actions = matchResult.Set.ActionCodes()
+
+	for _, rule := range matchResult.Set.Rules {
+		var ruleResult = &RuleResult{
+			Rule: rule,
+		}
+		var ruleBefore = time.Now()
+		if phase == PhaseResponse {
+			ruleResult.IsMatched, _, ruleResult.Err = rule.MatchResponse(req, requests.NewResponse(rawResp))
+		} else {
+			ruleResult.IsMatched, _, ruleResult.Err = rule.MatchRequest(req)
+		}
+		ruleResult.Cost = time.Since(ruleBefore)
+		ruleResult.Values = this.ruleValues(rule, ruleResult.IsMatched, req, rawResp)
+		phaseResult.Rules = append(phaseResult.Rules, ruleResult)
+	}
+
+	return phaseResult
+}
+
+// 读取规则中检查点的值
+func (this *Runner) ruleValues(rule *waf.Rule, isMatched bool, req requests.Request, rawResp *http.Response) map[string]any {
+	var result = map[string]any{}
+	for _, match := range ruleParamVarRegexp.FindAllStringSubmatch(rule.Param, -1) {
+		var varName = match[1]
+		var prefix, param, _ = strings.Cut(varName, ".")
+		var checkpoint = this.waf.FindCheckpointInstance(prefix)
+		if checkpoint == nil {
+			continue
+		}
+
+		// 组合检查点的值即为匹配结果，不再重复执行
+		if checkpoint.IsComposed() {
+			result["${"+varName+"}"] = isMatched
+			continue
+		}
+
+		var value any
+		var sysErr error
+		if checkpoint.IsRequest() {
+			value, _, sysErr, _ = checkpoint.RequestValue(req, param, rule.CheckpointOptions, rule.Id)
+		} else if rawResp != nil {
+			value, _, sysErr, _ = checkpoint.ResponseValue(req, requests.NewResponse(rawResp), param, rule.CheckpointOptions, rule.Id)
+		} else {
+			continue
+		}
+		if sysErr != nil {
+			value = "error: " + sysErr.Error()
+		}
+		result["${"+varName+"}"] = value
+	}
+	return result
+}
+
+// 检查是否符合期望
+func (this *Runner) check(c *Case, result *Result) (failures []string) {
+	for _, phaseResult := range []*PhaseResult{result.Request, result.Response} {
+		if phaseResult != nil && phaseResult.Err != nil {
+			failures = append(failures, phaseResult.Phase+" error: "+phaseResult.Err.Error())
+		}
+	}
+
+	var expectation = c.Expectation
+	if expectation == nil {
+		expectation = this.DefaultExpectation
+	}
+	if expectation == nil {
+		return
+	}
+
+	var final = result.Final()
+	switch expectation.Verdict {
+	case "", VerdictMatch:
+		if final.Verdict == VerdictPass {
+			failures = append(failures, "expect '"+VerdictMatch+"', but got '"+final.Verdict+"'")
+		}
+	default:
+		if final.Verdict != expectation.Verdict {
+			failures = append(failures, "expect '"+expectation.Verdict+"', but got '"+final.Verdict+"'")
+		}
+	}
+
+	if len(expectation.Group) > 0 {
+		if final.Group == nil || !matchIdentity(expectation.Group, final.Group.Id, final.Group.Code, final.Group.Name) {
+			failures = append(failures, "expect group '"+expectation.Group+"', but got '"+groupString(final.Group)+"'")
+		}
+	}
+	if len(expectation.Set) > 0 {
+		if final.Set == nil || !matchIdentity(expectation.Set, final.Set.Id, final.Set.Code, final.Set.Name) {
+			failures = append(failures, "expect set '"+expectation.Set+"', but got '"+setString(final.Set)+"'")
+		}
+	}
+	if len(expectation.Action) > 0 {
+		var found = false
+		for _, action := range final.Actions {
+			if action == expectation.Action {
+				found = true
+				break
+			}
+		}
+		if !found {
+			failures = append(failures, "expect action '"+expectation.Action+"', but got '"+strings.Join(final.Actions, ", ")+"'")
+		}
+	}
+	return
+}
+
+func matchIdentity(s string, id int64, code string, name string) bool {
+	return s == types.String(id) || (len(code) > 0 && s == code) || (len(name) > 0 && s == name)
+}
+
+func groupString(group *waf.RuleGroup) string {
+	if group == nil {
+		return ""
+	}
+	return identityString(group.Id, group.Code, group.Name)
+}
+
+func setString(set *waf.RuleSet) string {
+	if set == nil {
+		return ""
+	}
+	return identityString(set.Id, set.Code, set.Name)
+}
+
+func identityString(id int64, code string, name string) string {
+	var s = types.String(id)
+	if len(code) > 0 {
+		s += " " + code
+	}
+	if len(name) > 0 {
+		s += " \"" + name + "\""
+	}
+	return s
+}
+
+// 回放使用的请求
+type replayRequest struct {
+	*requests.TestRequest
+
+	skippedActions []string
+}
+
+func newReplayRequest(rawReq *http.Request) *replayRequest {
+	return &replayRequest{
+		TestRequest: requests.NewTestRequest(rawReq),
+	}
+}
+
+func (this *replayRequest) WAFOnAction(action any) (goNext bool) {
+	instance, ok := action.(waf.ActionInterface)
+	if !ok {
+		return true
+	}
+
+	// 会改变IP名单的动作不执行
+	if instance.Code() == waf.ActionRecordIP {
+		this.skippedActions = append(this.skippedActions, instance.Code())
+		return false
+	}
+	return true
+}
@@ -0,0 +1,116 @@
+// Copyright 2024 GoEdge CDN goedge.cdn@gmail.com. All rights reserved. Official site: https://goedge.cloud .
+
+package replay_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/TeaOSLab/EdgeNode/internal/waf"
+	"github.com/TeaOSLab/EdgeNode/internal/waf/replay"
+	"github.com/iwind/TeaGo/assert"
+)
+
+func TestRunner_Run(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	var wafInstance = waf.NewWAF()
+	{
+		var set = waf.NewRuleSet()
+		set.Id = 7
+		set.Code = "adminPath"
+		set.Name = "Admin Path"
+		set.Connector = waf.RuleConnectorAnd
+		set.Rules = []*waf.Rule{
+			{
+				Id:       1,
+				Param:    "${requestPath}",
+				Operator: waf.RuleOperatorPrefix,
+				Value:    "/admin",
+			},
+			{
+				Id:       2,
+				Param:    "${arg.debug}",
+				Operator: waf.RuleOperatorEqString,
+				Value:    "1",
+			},
+		}
+		set.AddAction(waf.ActionBlock, nil)
+
+		var group = waf.NewRuleGroup()
+		group.Id = 1
+		group.Code = "custom"
+		group.IsInbound = true
+		group.AddRuleSet(set)
+		wafInstance.AddRuleGroup(group)
+	}
+	{
+		var set = waf.NewRuleSet()
+		set.Id = 8
+		set.Connector = waf.RuleConnectorAnd
+		set.Rules = []*waf.Rule{
+			{
+				Id:       3,
+				Param:    "${responseBody}",
+				Operator: waf.RuleOperatorContains,
+				Value:    "SQLSTATE",
+			},
+		}
+		set.AddAction(waf.ActionBlock, nil)
+
+		var group = waf.NewRuleGroup()
+		group.Id = 2
+		group.Code = "leak"
+		group.IsInbound = false
+		group.AddRuleSet(set)
+		wafInstance.AddRuleGroup(group)
+	}
+	errs := wafInstance.Init()
+	if len(errs) > 0 {
+		t.Fatal(errs[0])
+	}
+
+	cases, err := replay.ParseRaw([]byte(`# expect: block group=custom set=adminPath action=block
+GET /admin/users?debug=1 HTTP/1.1
+Host: example.com
+
+###
+# expect: block
+GET /admin/users HTTP/1.1
+Host: example.com
+
+###
+# expect: block group=leak
+GET /users HTTP/1.1
+Host: example.com
+
+HTTP/1.1 500 Internal Server Error
+Content-Type: text/plain
+
+SQLSTATE[42000]: Syntax error
+`), "test.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var runner = replay.NewRunner(wafInstance)
+	var results = []*replay.Result{}
+	for _, c := range cases {
+		var result = runner.Run(c)
+		var buf = &bytes.Buffer{}
+		result.Print(buf)
+		t.Log(buf.String())
+		results = append(results, result)
+	}
+
+	a.IsTrue(results[0].IsOk())
+	a.IsTrue(results[0].Request.Verdict == replay.VerdictBlock)
+	a.IsTrue(len(results[0].Request.Rules) == 2)
+	a.IsTrue(results[0].Request.Rules[1].Values["${arg.debug}"] == "1")
+
+	a.IsFalse(results[1].IsOk())
+	a.IsTrue(results[1].Request.Verdict == replay.VerdictPass)
+
+	a.IsTrue(results[2].IsOk())
+	a.IsTrue(results[2].Response != nil && results[2].Response.Verdict == replay.VerdictBlock)
+}
@@ -49,4 +49,13 @@ type Request interface {
 
 	// DisableStat 在当前请求中停用统计
 	DisableStat()
+
+	// WAFSetAttr 设置日志属性
+	WAFSetAttr(name string, value string)
+
+	// WAFGetCacheValue 读取当前请求中缓存的数据
+	WAFGetCacheValue(key string) (value any, ok bool)
+
+	// WAFSetCacheValue 在当前请求中缓存数据
+	WAFSetCacheValue(key string, value any)
 }
@@ -12,11 +12,15 @@ import (
 type TestRequest struct {
 	req      *http.Request
 	BodyData []byte
+	Attrs    map[string]string
+
+	cacheValues map[string]any
 }
 
 func NewTestRequest(raw *http.Request) *TestRequest {
 	return &TestRequest{
-		req: raw,
+		req:   raw,
+		Attrs: map[string]string{},
 	}
 }
 
@@ -96,3 +100,19 @@ func (this *TestRequest) ProcessResponseHeaders(headers http.Header, status int)
 func (this *TestRequest) WAFMaxRequestSize() int64 {
 	return 1 << 20
 }
+
+func (this *TestRequest) WAFSetAttr(name string, value string) {
+	this.Attrs[name] = value
+}
+
+func (this *TestRequest) WAFGetCacheValue(key string) (value any, ok bool) {
+	value, ok = this.cacheValues[key]
+	return
+}
+
+func (this *TestRequest) WAFSetCacheValue(key string, value any) {
+	if this.cacheValues == nil {
+		this.cacheValues = map[string]any{}
+	}
+	this.cacheValues[key] = value
+}
@@ -19,6 +19,7 @@ import (
 	"github.com/TeaOSLab/EdgeNode/internal/waf/checkpoints"
 	"github.com/TeaOSLab/EdgeNode/internal/waf/injectionutils"
 	"github.com/TeaOSLab/EdgeNode/internal/waf/requests"
+	"github.com/TeaOSLab/EdgeNode/internal/waf/transforms"
 	"github.com/TeaOSLab/EdgeNode/internal/waf/utils"
 	"github.com/TeaOSLab/EdgeNode/internal/waf/values"
 	"github.com/iwind/TeaGo/lists"
@@ -41,6 +42,7 @@ type Rule struct {
 	IsCaseInsensitive bool           `yaml:"isCaseInsensitive" json:"isCaseInsensitive"`
 	CheckpointOptions map[string]any `yaml:"checkpointOptions" json:"checkpointOptions"`
 	Priority          int            `yaml:"priority" json:"priority"`
+	Transforms        []string       `yaml:"transforms" json:"transforms"` // 对参数值执行的转换，比如 urlDecode、htmlEntityDecode 等，按顺序执行
 
 	checkpointFinder func(prefix string) checkpoints.CheckpointInterface
 
@@ -61,6 +63,11 @@ type Rule struct {
 
 	reg       *re.Regexp
 	cacheLife utils.CacheLife
+	cacheStat utils.CacheStat // 缓存命中统计
+	stat      *RuleSetStat    // 所属规则集的统计
+
+	transformChain     *transforms.Chain
+	transformCacheKeys []string // 每一步转换结果在当前请求中的缓存Key
 }
 
 func NewRule() *Rule {
@@ -68,6 +75,24 @@ func NewRule() *Rule {
 }
 
 func (this *Rule) Init() error {
+	// transforms
+	this.transformChain = nil
+	this.transformCacheKeys = nil
+	if len(this.Transforms) > 0 {
+		chain, err := transforms.NewChain(this.Transforms)
+		if err != nil {
+			return err
+		}
+		this.transformChain = chain
+
+		// 有参数过滤器或者选项时参数值可能和其他规则不同，所以不能共享转换结果
+		if len(this.ParamFilters) == 0 && len(this.CheckpointOptions) == 0 {
+			for i := 1; i <= chain.Len(); i++ {
+				this.transformCacheKeys = append(this.transformCacheKeys, "WAF_TRANSFORM@"+this.Param+"@"+chain.Key(i))
+			}
+		}
+	}
+
 	// operator
 	switch this.Operator {
 	case RuleOperatorGt:
@@ -249,6 +274,11 @@ func (this *Rule) MatchRequest(req requests.Request) (b bool, hasRequestBody boo
 			return types.Bool(value), hasRequestBody, nil
 		}
 
+		// execute transforms
+		if this.transformChain != nil {
+			value = this.transformValue(req, value)
+		}
+
 		return this.Test(value), hasRequestBody, nil
 	}
 
@@ -285,6 +315,11 @@ func (this *Rule) MatchRequest(req requests.Request) (b bool, hasRequestBody boo
 		return false, hasRequestBody, err
 	}
 
+	// execute transforms
+	if this.transformChain != nil {
+		return this.Test(this.transformValue(req, value)), hasRequestBody, nil
+	}
+
 	return this.Test(value), hasRequestBody, nil
 }
 
@@ -305,6 +340,11 @@ func (this *Rule) MatchResponse(req requests.Request, resp *requests.Response) (
 				value = this.execFilter(value)
 			}
 
+			// execute transforms
+			if this.transformChain != nil {
+				value = this.transformValue(req, value)
+			}
+
 			return this.Test(value), hasRequestBody, nil
 		}
 
@@ -322,6 +362,11 @@ func (this *Rule) MatchResponse(req requests.Request, resp *requests.Response) (
 			return types.Bool(value), hasRequestBody, nil
 		}
 
+		// execute transforms
+		if this.transformChain != nil {
+			value = this.transformValue(req, value)
+		}
+
 		return this.Test(value), hasRequestBody, nil
 	}
 
@@ -380,6 +425,11 @@ func (this *Rule) MatchResponse(req requests.Request, resp *requests.Response) (
 		return false, hasRequestBody, err
 	}
 
+	// execute transforms
+	if this.transformChain != nil {
+		return this.Test(this.transformValue(req, value)), hasRequestBody, nil
+	}
+
 	return this.Test(value), hasRequestBody, nil
 }
 
@@ -419,7 +469,7 @@ func (this *Rule) Test(value any) bool {
 		stringList, ok := value.([]string)
 		if ok {
 			for _, s := range stringList {
-				if utils.MatchStringCache(this.reg, s, this.cacheLife) {
+				if utils.MatchStringCacheStat(this.reg, s, this.cacheLife, this.cacheStat) {
 					return true
 				}
 			}
@@ -430,7 +480,7 @@ func (this *Rule) Test(value any) bool {
 		byteSlices, ok := value.([][]byte)
 		if ok {
 			for _, byteSlice := range byteSlices {
-				if utils.MatchBytesCache(this.reg, byteSlice, this.cacheLife) {
+				if utils.MatchBytesCacheStat(this.reg, byteSlice, this.cacheLife, this.cacheStat) {
 					return true
 				}
 			}
@@ -440,11 +490,11 @@ func (this *Rule) Test(value any) bool {
 		// bytes
 		byteSlice, ok := value.([]byte)
 		if ok {
-			return utils.MatchBytesCache(this.reg, byteSlice, this.cacheLife)
+			return utils.MatchBytesCacheStat(this.reg, byteSlice, this.cacheLife, this.cacheStat)
 		}
 
 		// string
-		return utils.MatchStringCache(this.reg, this.stringifyValue(value), this.cacheLife)
+		return utils.MatchStringCacheStat(this.reg, this.stringifyValue(value), this.cacheLife, this.cacheStat)
 	case RuleOperatorNotMatch, RuleOperatorWildcardNotMatch:
 		if value == nil {
 			value = ""
@@ -452,7 +502,7 @@ func (this *Rule) Test(value any) bool {
 		stringList, ok := value.([]string)
 		if ok {
 			for _, s := range stringList {
-				if utils.MatchStringCache(this.reg, s, this.cacheLife) {
+				if utils.MatchStringCacheStat(this.reg, s, this.cacheLife, this.cacheStat) {
 					return false
 				}
 			}
@@ -463,7 +513,7 @@ func (this *Rule) Test(value any) bool {
 		byteSlices, ok := value.([][]byte)
 		if ok {
 			for _, byteSlice := range byteSlices {
-				if utils.MatchBytesCache(this.reg, byteSlice, this.cacheLife) {
+				if utils.MatchBytesCacheStat(this.reg, byteSlice, this.cacheLife, this.cacheStat) {
 					return false
 				}
 			}
@@ -473,10 +523,10 @@ func (this *Rule) Test(value any) bool {
 		// bytes
 		byteSlice, ok := value.([]byte)
 		if ok {
-			return !utils.MatchBytesCache(this.reg, byteSlice, this.cacheLife)
+			return !utils.MatchBytesCacheStat(this.reg, byteSlice, this.cacheLife, this.cacheStat)
 		}
 
-		return !utils.MatchStringCache(this.reg, this.stringifyValue(value), this.cacheLife)
+		return !utils.MatchStringCacheStat(this.reg, this.stringifyValue(value), this.cacheLife, this.cacheStat)
 	case RuleOperatorContains:
 		if types.IsSlice(value) {
 			_, isBytes := value.([]byte)
@@ -587,20 +637,20 @@ func (this *Rule) Test(value any) bool {
 		switch xValue := value.(type) {
 		case []string:
 			for _, v := range xValue {
-				if injectionutils.DetectSQLInjectionCache(v, isStrict, this.cacheLife) {
+				if injectionutils.DetectSQLInjectionCacheStat(v, isStrict, this.cacheLife, this.cacheStat) {
 					return true
 				}
 			}
 			return false
 		case [][]byte:
 			for _, v := range xValue {
-				if injectionutils.DetectSQLInjectionCache(string(v), isStrict, this.cacheLife) {
+				if injectionutils.DetectSQLInjectionCacheStat(string(v), isStrict, this.cacheLife, this.cacheStat) {
 					return true
 				}
 			}
 			return false
 		default:
-			return injectionutils.DetectSQLInjectionCache(this.stringifyValue(value), isStrict, this.cacheLife)
+			return injectionutils.DetectSQLInjectionCacheStat(this.stringifyValue(value), isStrict, this.cacheLife, this.cacheStat)
 		}
 	case RuleOperatorContainsXSS, RuleOperatorContainsXSSStrictly:
 		if value == nil {
@@ -610,20 +660,20 @@ func (this *Rule) Test(value any) bool {
 		switch xValue := value.(type) {
 		case []string:
 			for _, v := range xValue {
-				if injectionutils.DetectXSSCache(v, isStrict, this.cacheLife) {
+				if injectionutils.DetectXSSCacheStat(v, isStrict, this.cacheLife, this.cacheStat) {
 					return true
 				}
 			}
 			return false
 		case [][]byte:
 			for _, v := range xValue {
-				if injectionutils.DetectXSSCache(string(v), isStrict, this.cacheLife) {
+				if injectionutils.DetectXSSCacheStat(string(v), isStrict, this.cacheLife, this.cacheStat) {
 					return true
 				}
 			}
 			return false
 		default:
-			return injectionutils.DetectXSSCache(this.stringifyValue(value), isStrict, this.cacheLife)
+			return injectionutils.DetectXSSCacheStat(this.stringifyValue(value), isStrict, this.cacheLife, this.cacheStat)
 		}
 	case RuleOperatorContainsBinary:
 		data, _ := base64.StdEncoding.DecodeString(this.stringifyValue(this.Value))
@@ -839,6 +889,53 @@ func (this *Rule) execFilter(value any) any {
 	return value
 }
 
+// 对参数值执行转换
+// 同一个请求中相同参数的转换结果会被缓存，后续的规则可以直接使用已经转换过的最长前缀结果
+func (this *Rule) transformValue(req requests.Request, value any) any {
+	if len(this.transformCacheKeys) == 0 {
+		return this.transformChain.ApplyValue(value)
+	}
+
+	var s string
+	var isBytes bool
+	switch v := value.(type) {
+	case string:
+		s = v
+	case []byte:
+		s = string(v)
+		isBytes = true
+	default:
+		return this.transformChain.ApplyValue(value)
+	}
+
+	var from = 0
+	for i := len(this.transformCacheKeys) - 1; i >= 0; i-- {
+		cachedValue, ok := req.WAFGetCacheValue(this.transformCacheKeys[i])
+		if ok {
+			s = cachedValue.(string)
+			from = i + 1
+			break
+		}
+	}
+	if this.stat != nil {
+		if from > 0 {
+			this.stat.IncreaseValueCacheHit()
+		} else {
+			this.stat.IncreaseValueCacheMiss()
+		}
+	}
+
+	for i := from; i < this.transformChain.Len(); i++ {
+		s = this.transformChain.ApplyStep(s, i)
+		req.WAFSetCacheValue(this.transformCacheKeys[i], s)
+	}
+
+	if isBytes {
+		return []byte(s)
+	}
+	return s
+}
+
 func (this *Rule) stringifyValue(value any) string {
 	if value == nil {
 		return ""
@@ -34,6 +34,7 @@ func (this *RuleGroup) Init(waf *WAF) error {
 			if err != nil {
 				return fmt.Errorf("init set '%d' failed: %w", set.Id, err)
 			}
+			set.initStat(this)
 		}
 	}
 	return nil
@@ -83,7 +84,9 @@ func (this *RuleGroup) MatchRequest(req requests.Request) (b bool, hasRequestBod
 		if !set.IsOn {
 			continue
 		}
+		var before = set.beginStat()
 		b, hasRequestBody, err = set.MatchRequest(req)
+		set.endStat(b, before)
 		if err != nil {
 			return false, hasRequestBody, nil, err
 		}
@@ -102,7 +105,9 @@ func (this *RuleGroup) MatchResponse(req requests.Request, resp *requests.Respon
 		if !set.IsOn {
 			continue
 		}
+		var before = set.beginStat()
 		b, hasRequestBody, err = set.MatchResponse(req, resp)
+		set.endStat(b, before)
 		if err != nil {
 			return false, hasRequestBody, nil, err
 		}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"net/http"
 	"sort"
+	"time"
 
 	"github.com/TeaOSLab/EdgeCommon/pkg/serverconfigs/firewallconfigs"
 	"github.com/TeaOSLab/EdgeNode/internal/remotelogs"
@@ -41,6 +42,8 @@ type RuleSet struct {
 	allowScope      string
 
 	hasRules bool
+
+	stat *RuleSetStat
 }
 
 func NewRuleSet() *RuleSet {
@@ -114,6 +117,37 @@ func (this *RuleSet) Init(waf *WAF) error {
 	return nil
 }
 
+// 初始化统计
+func (this *RuleSet) initStat(group *RuleGroup) {
+	this.stat = SharedRuleSetStatManager.FindStat(group, this)
+	if this.stat == nil {
+		return
+	}
+	for _, rule := range this.Rules {
+		rule.stat = this.stat
+		rule.cacheStat = this.stat
+	}
+}
+
+// 开始统计，只在抽样的检查中记录开始时间
+func (this *RuleSet) beginStat() (before time.Time) {
+	if this.stat != nil && this.stat.ShouldSampleCost() {
+		before = time.Now()
+	}
+	return
+}
+
+// 记录统计
+func (this *RuleSet) endStat(isMatched bool, before time.Time) {
+	if this.stat == nil {
+		return
+	}
+	this.stat.Add(isMatched)
+	if !before.IsZero() {
+		this.stat.AddCost(time.Since(before))
+	}
+}
+
 func (this *RuleSet) AddRule(rule ...*Rule) {
 	this.Rules = append(this.Rules, rule...)
 }
@@ -0,0 +1,439 @@
+// Copyright 2024 GoEdge CDN goedge.cdn@gmail.com. All rights reserved. Official site: https://goedge.cloud .
+
+package waf
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	teaconst "github.com/TeaOSLab/EdgeNode/internal/const"
+	"github.com/TeaOSLab/EdgeNode/internal/events"
+	"github.com/TeaOSLab/EdgeNode/internal/monitor"
+	"github.com/TeaOSLab/EdgeNode/internal/utils"
+	"github.com/TeaOSLab/EdgeNode/internal/utils/goman"
+	"github.com/iwind/TeaGo/maps"
+)
+
+const (
+	ruleSetStatWindowSeconds  = 60  // 每个统计窗口的时长
+	ruleSetStatMaxWindows     = 60  // 本地保留的窗口数量
+	ruleSetStatMaxUploads     = 100 // 每次最多上传的规则集数量
+	ruleSetStatCostSampleRate = 16  // 每多少次检查统计一次耗时，避免每次检查都调用 time.Now()
+)
+
+// RuleSetStatCostBuckets 耗时直方图的区间上限（微秒），最后一个区间为无上限
+var RuleSetStatCostBuckets = []int64{10, 50, 100, 500, 1_000, 5_000, 10_000}
+
+var SharedRuleSetStatManager = NewRuleSetStatManager()
+
+func init() {
+	if !teaconst.IsMain {
+		return
+	}
+
+	events.On(events.EventLoaded, func() {
+		goman.New(func() {
+			SharedRuleSetStatManager.Start()
+		})
+	})
+	events.OnClose(func() {
+		SharedRuleSetStatManager.Stop()
+	})
+}
+
+// RuleSetStat 单个规则集的统计
+type RuleSetStat struct {
+	setId     int64
+	setName   string
+	groupId   int64
+	groupName string
+
+	countRequests         uint64
+	countHits             uint64
+	countVerified         uint64 // 命中后又通过了人机验证的数量，可以用来估算误报
+	countValueCacheHits   uint64 // 参数值缓存（同一个请求中已经转换过的参数值）命中数量
+	countValueCacheMisses uint64
+	countMatchCacheHits   uint64 // 匹配结果缓存（正则表达式、SQL注入和XSS检测结果）命中数量
+	countMatchCacheMisses uint64
+	countCostChecks       uint64 // 用来决定是否抽样统计耗时
+	countCostSamples      uint64 // 统计了耗时的检查次数
+	costNanos             uint64
+	maxCostNanos          uint64
+	costBuckets           []uint64
+}
+
+func newRuleSetStat() *RuleSetStat {
+	return &RuleSetStat{
+		costBuckets: make([]uint64, len(RuleSetStatCostBuckets)+1),
+	}
+}
+
+// Add 添加一次检查结果
+func (this *RuleSetStat) Add(isMatched bool) {
+	atomic.AddUint64(&this.countRequests, 1)
+	if isMatched {
+		atomic.AddUint64(&this.countHits, 1)
+	}
+}
+
+// ShouldSampleCost 本次检查是否需要统计耗时
+func (this *RuleSetStat) ShouldSampleCost() bool {
+	return atomic.AddUint64(&this.countCostChecks, 1)%ruleSetStatCostSampleRate == 1
+}
+
+// AddCost 添加一次抽样的耗时
+func (this *RuleSetStat) AddCost(cost time.Duration) {
+	atomic.AddUint64(&this.countCostSamples, 1)
+
+	var costNanos = uint64(cost.Nanoseconds())
+	atomic.AddUint64(&this.costNanos, costNanos)
+	for {
+		var maxCost = atomic.LoadUint64(&this.maxCostNanos)
+		if costNanos <= maxCost || atomic.CompareAndSwapUint64(&this.maxCostNanos, maxCost, costNanos) {
+			break
+		}
+	}
+
+	var costMicros = cost.Microseconds()
+	var bucketIndex = len(RuleSetStatCostBuckets)
+	for index, bucket := range RuleSetStatCostBuckets {
+		if costMicros < bucket {
+			bucketIndex = index
+			break
+		}
+	}
+	atomic.AddUint64(&this.costBuckets[bucketIndex], 1)
+}
+
+// IncreaseVerified 增加通过验证的数量
+func (this *RuleSetStat) IncreaseVerified() {
+	atomic.AddUint64(&this.countVerified, 1)
+}
+
+// IncreaseValueCacheHit 增加参数值缓存命中数量
+func (this *RuleSetStat) IncreaseValueCacheHit() {
+	atomic.AddUint64(&this.countValueCacheHits, 1)
+}
+
+// IncreaseValueCacheMiss 增加参数值缓存未命中数量
+func (this *RuleSetStat) IncreaseValueCacheMiss() {
+	atomic.AddUint64(&this.countValueCacheMisses, 1)
+}
+
+// IncreaseCacheHit 增加匹配结果缓存命中数量
+func (this *RuleSetStat) IncreaseCacheHit() {
+	atomic.AddUint64(&this.countMatchCacheHits, 1)
+}
+
+// IncreaseCacheMiss 增加匹配结果缓存未命中数量
+func (this *RuleSetStat) IncreaseCacheMiss() {
+	atomic.AddUint64(&this.countMatchCacheMisses, 1)
+}
+
+// 读取当前数据，如果 reset 为 true 则同时清零
+func (this *RuleSetStat) snapshot(reset bool) *RuleSetStatItem {
+	var load = func(p *uint64) uint64 {
+		if reset {
+			return atomic.SwapUint64(p, 0)
+		}
+		return atomic.LoadUint64(p)
+	}
+
+	var item = &RuleSetStatItem{
+		SetId:                 this.setId,
+		SetName:               this.setName,
+		GroupId:               this.groupId,
+		GroupName:             this.groupName,
+		CountRequests:         load(&this.countRequests),
+		CountHits:             load(&this.countHits),
+		CountVerified:         load(&this.countVerified),
+		CountValueCacheHits:   load(&this.countValueCacheHits),
+		CountValueCacheMisses: load(&this.countValueCacheMisses),
+		CountMatchCacheHits:   load(&this.countMatchCacheHits),
+		CountMatchCacheMisses: load(&this.countMatchCacheMisses),
+		CountCostSamples:      load(&this.countCostSamples),
+		CostNanos:             load(&this.costNanos),
+		MaxCostNanos:          load(&this.maxCostNanos),
+		CostBuckets:           make([]uint64, len(this.costBuckets)),
+	}
+	for index := range this.costBuckets {
+		item.CostBuckets[index] = load(&this.costBuckets[index])
+	}
+	return item
+}
+
+// RuleSetStatItem 规则集在一段时间内的统计数据
+type RuleSetStatItem struct {
+	SetId                 int64    `json:"setId"`
+	SetName               string   `json:"setName"`
+	GroupId               int64    `json:"groupId"`
+	GroupName             string   `json:"groupName"`
+	CountRequests         uint64   `json:"countRequests"`
+	CountHits             uint64   `json:"countHits"`
+	CountVerified         uint64   `json:"countVerified"`
+	CountValueCacheHits   uint64   `json:"countValueCacheHits"`
+	CountValueCacheMisses uint64   `json:"countValueCacheMisses"`
+	CountMatchCacheHits   uint64   `json:"countMatchCacheHits"`
+	CountMatchCacheMisses uint64   `json:"countMatchCacheMisses"`
+	CountCostSamples      uint64   `json:"countCostSamples"` // 耗时是抽样统计的，这里是抽样的次数
+	CostNanos             uint64   `json:"costNanos"`
+	MaxCostNanos          uint64   `json:"maxCostNanos"`
+	CostBuckets           []uint64 `json:"costBuckets"` // 和 RuleSetStatCostBuckets 对应，多出的最后一个为无上限区间
+}
+
+// Merge 合并另外一段时间的数据
+func (this *RuleSetStatItem) Merge(item *RuleSetStatItem) {
+	this.CountRequests += item.CountRequests
+	this.CountHits += item.CountHits
+	this.CountVerified += item.CountVerified
+	this.CountValueCacheHits += item.CountValueCacheHits
+	this.CountValueCacheMisses += item.CountValueCacheMisses
+	this.CountMatchCacheHits += item.CountMatchCacheHits
+	this.CountMatchCacheMisses += item.CountMatchCacheMisses
+	this.CountCostSamples += item.CountCostSamples
+	this.CostNanos += item.CostNanos
+	if item.MaxCostNanos > this.MaxCostNanos {
+		this.MaxCostNanos = item.MaxCostNanos
+	}
+	for index, count := range item.CostBuckets {
+		if index < len(this.CostBuckets) {
+			this.CostBuckets[index] += count
+		}
+	}
+}
+
+// AvgCostMicros 平均耗时（微秒）
+func (this *RuleSetStatItem) AvgCostMicros() float64 {
+	if this.CountCostSamples == 0 {
+		return 0
+	}
+	return float64(this.CostNanos) / float64(this.CountCostSamples) / 1000
+}
+
+// ValueCacheHitRate 参数值缓存命中率
+func (this *RuleSetStatItem) ValueCacheHitRate() float64 {
+	var total = this.CountValueCacheHits + this.CountValueCacheMisses
+	if total == 0 {
+		return 0
+	}
+	return float64(this.CountValueCacheHits) / float64(total)
+}
+
+// MatchCacheHitRate 匹配结果缓存命中率
+func (this *RuleSetStatItem) MatchCacheHitRate() float64 {
+	var total = this.CountMatchCacheHits + this.CountMatchCacheMisses
+	if total == 0 {
+		return 0
+	}
+	return float64(this.CountMatchCacheHits) / float64(total)
+}
+
+// AsMap 转换为Map
+func (this *RuleSetStatItem) AsMap() maps.Map {
+	return maps.Map{
+		"setId":                 this.SetId,
+		"setName":               this.SetName,
+		"groupId":               this.GroupId,
+		"groupName":             this.GroupName,
+		"countRequests":         this.CountRequests,
+		"countHits":             this.CountHits,
+		"countVerified":         this.CountVerified,
+		"countValueCacheHits":   this.CountValueCacheHits,
+		"countValueCacheMisses": this.CountValueCacheMisses,
+		"valueCacheHitRate":     this.ValueCacheHitRate(),
+		"countMatchCacheHits":   this.CountMatchCacheHits,
+		"countMatchCacheMisses": this.CountMatchCacheMisses,
+		"matchCacheHitRate":     this.MatchCacheHitRate(),
+		"countCostSamples":      this.CountCostSamples,
+		"avgCostMicros":         this.AvgCostMicros(),
+		"maxCostMicros":         float64(this.MaxCostNanos) / 1000,
+		"costBuckets":           this.CostBuckets,
+	}
+}
+
+type ruleSetStatWindow struct {
+	timestamp int64
+	items     []*RuleSetStatItem
+}
+
+// RuleSetStatManager 规则集统计管理器
+// 数据按照时间窗口汇总，每个窗口结束后上传，并在本地保留最近的一些窗口以便于查看
+type RuleSetStatManager struct {
+	statMap map[int64]*RuleSetStat // setId => *RuleSetStat
+	windows []*ruleSetStatWindow
+
+	ticker    *utils.Ticker
+	isStopped bool
+	locker    sync.RWMutex
+}
+
+// NewRuleSetStatManager 获取新对象
+func NewRuleSetStatManager() *RuleSetStatManager {
+	return &RuleSetStatManager{
+		statMap: map[int64]*RuleSetStat{},
+	}
+}
+
+// Start 启动
+// 会一直阻塞，直到调用 Stop()
+func (this *RuleSetStatManager) Start() {
+	this.locker.Lock()
+	if this.isStopped {
+		this.locker.Unlock()
+		return
+	}
+	var ticker = utils.NewTicker(ruleSetStatWindowSeconds * time.Second)
+	this.ticker = ticker
+	this.locker.Unlock()
+
+	for ticker.Next() {
+		var items = this.Rotate()
+		this.upload(items)
+	}
+}
+
+// Stop 停止
+func (this *RuleSetStatManager) Stop() {
+	this.locker.Lock()
+	this.isStopped = true
+	if this.ticker != nil {
+		this.ticker.Stop()
+	}
+	this.locker.Unlock()
+}
+
+// FindStat 查找或创建规则集统计对象
+// 使用规则集ID作为标识，所以重新加载策略后统计数据仍然可以延续
+func (this *RuleSetStatManager) FindStat(group *RuleGroup, set *RuleSet) *RuleSetStat {
+	if set == nil || set.Id <= 0 {
+		return nil
+	}
+
+	this.locker.Lock()
+	defer this.locker.Unlock()
+
+	stat, ok := this.statMap[set.Id]
+	if !ok {
+		stat = newRuleSetStat()
+		stat.setId = set.Id
+		this.statMap[set.Id] = stat
+	}
+
+	// 名称可能会变化
+	stat.setName = set.Name
+	if group != nil {
+		stat.groupId = group.Id
+		stat.groupName = group.Name
+	}
+
+	return stat
+}
+
+// IncreaseVerified 增加某个规则集通过验证的数量
+func (this *RuleSetStatManager) IncreaseVerified(setId int64) {
+	this.locker.RLock()
+	stat, ok := this.statMap[setId]
+	this.locker.RUnlock()
+	if ok {
+		stat.IncreaseVerified()
+	}
+}
+
+// Rotate 结束当前窗口，并返回当前窗口中的数据
+func (this *RuleSetStatManager) Rotate() []*RuleSetStatItem {
+	var items = []*RuleSetStatItem{}
+
+	this.locker.Lock()
+	defer this.locker.Unlock()
+
+	for _, stat := range this.statMap {
+		var item = stat.snapshot(true)
+		if item.CountRequests > 0 || item.CountVerified > 0 {
+			items = append(items, item)
+		}
+	}
+
+	this.windows = append(this.windows, &ruleSetStatWindow{
+		timestamp: time.Now().Unix(),
+		items:     items,
+	})
+	if len(this.windows) > ruleSetStatMaxWindows {
+		this.windows = this.windows[len(this.windows)-ruleSetStatMaxWindows:]
+	}
+
+	return items
+}
+
+// Stats 汇总最近若干分钟内的数据，包括当前尚未结束的窗口
+func (this *RuleSetStatManager) Stats(minutes int) []*RuleSetStatItem {
+	var resultMap = map[int64]*RuleSetStatItem{}
+	var merge = func(item *RuleSetStatItem) {
+		result, ok := resultMap[item.SetId]
+		if ok {
+			result.Merge(item)
+			return
+		}
+		var newItem = *item
+		newItem.CostBuckets = append([]uint64{}, item.CostBuckets...)
+		resultMap[item.SetId] = &newItem
+	}
+
+	this.locker.RLock()
+	for _, stat := range this.statMap {
+		var item = stat.snapshot(false)
+		if item.CountRequests > 0 || item.CountVerified > 0 {
+			merge(item)
+		}
+	}
+	var minTimestamp = time.Now().Unix() - int64(minutes)*60
+	for _, window := range this.windows {
+		if minutes > 0 && window.timestamp < minTimestamp {
+			continue
+		}
+		for _, item := range window.items {
+			merge(item)
+		}
+	}
+	this.locker.RUnlock()
+
+	var result = []*RuleSetStatItem{}
+	for _, item := range resultMap {
+		result = append(result, item)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CostNanos > result[j].CostNanos
+	})
+	return result
+}
+
+// 上传一个窗口的数据，只上传耗时最多的一部分规则集
+func (this *RuleSetStatManager) upload(items []*RuleSetStatItem) {
+	if len(items) == 0 {
+		return
+	}
+	monitor.SharedValueQueue.Add(monitor.NodeValueItemWAFRuleSets, ruleSetStatUploadValue(items))
+}
+
+// 构造上传的数据
+func ruleSetStatUploadValue(items []*RuleSetStatItem) maps.Map {
+	items = append([]*RuleSetStatItem{}, items...)
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].CostNanos > items[j].CostNanos
+	})
+	if len(items) > ruleSetStatMaxUploads {
+		items = items[:ruleSetStatMaxUploads]
+	}
+
+	var itemMaps = []maps.Map{}
+	for _, item := range items {
+		itemMaps = append(itemMaps, item.AsMap())
+	}
+	return maps.Map{
+		"windowSeconds":  ruleSetStatWindowSeconds,
+		"costSampleRate": ruleSetStatCostSampleRate,
+		"costBuckets":    RuleSetStatCostBuckets,
+		"items":          itemMaps,
+	}
+}
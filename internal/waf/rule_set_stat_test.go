@@ -0,0 +1,139 @@
+// Copyright 2024 GoEdge CDN goedge.cdn@gmail.com. All rights reserved. Official site: https://goedge.cloud .
+
+package waf_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/TeaOSLab/EdgeNode/internal/waf"
+	"github.com/iwind/TeaGo/assert"
+)
+
+func TestRuleSetStatManager(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	var manager = waf.NewRuleSetStatManager()
+
+	var group = waf.NewRuleGroup()
+	group.Id = 1
+	group.Name = "SQL注入"
+
+	var set = waf.NewRuleSet()
+	set.Id = 2
+	set.Name = "union select"
+
+	a.IsNil(manager.FindStat(group, waf.NewRuleSet()))
+
+	var stat = manager.FindStat(group, set)
+	a.IsNotNil(stat)
+	a.IsTrue(manager.FindStat(group, set) == stat)
+
+	stat.Add(true)
+	stat.Add(false)
+	stat.Add(false)
+	stat.AddCost(5 * time.Microsecond)
+	stat.AddCost(200 * time.Microsecond)
+	stat.AddCost(20 * time.Millisecond)
+	stat.IncreaseValueCacheHit()
+	stat.IncreaseValueCacheMiss()
+	stat.IncreaseCacheHit()
+	stat.IncreaseCacheMiss()
+	stat.IncreaseCacheMiss()
+	stat.IncreaseCacheMiss()
+	manager.IncreaseVerified(2)
+	manager.IncreaseVerified(3) // not exist
+
+	// 当前窗口
+	{
+		var items = manager.Stats(10)
+		a.IsTrue(len(items) == 1)
+		var item = items[0]
+		a.IsTrue(item.SetId == 2)
+		a.IsTrue(item.GroupName == "SQL注入")
+		a.IsTrue(item.CountRequests == 3)
+		a.IsTrue(item.CountHits == 1)
+		a.IsTrue(item.CountVerified == 1)
+		a.IsTrue(item.MatchCacheHitRate() == 0.25)
+		a.IsTrue(item.ValueCacheHitRate() == 0.5)
+		a.IsTrue(item.CountCostSamples == 3)
+		a.IsTrue(item.MaxCostNanos == uint64(20*time.Millisecond))
+		a.IsTrue(item.CostBuckets[0] == 1)
+		a.IsTrue(item.CostBuckets[3] == 1)
+		a.IsTrue(item.CostBuckets[len(item.CostBuckets)-1] == 1)
+		t.Logf("%+v, avg: %.2fus", item, item.AvgCostMicros())
+	}
+
+	// 结束窗口后数据仍然可以查看
+	{
+		var rotatedItems = manager.Rotate()
+		a.IsTrue(len(rotatedItems) == 1)
+
+		stat.Add(true)
+
+		var items = manager.Stats(10)
+		a.IsTrue(len(items) == 1)
+		a.IsTrue(items[0].CountRequests == 4)
+		a.IsTrue(items[0].CountHits == 2)
+	}
+
+	// 没有数据的规则集不会出现在窗口中
+	{
+		manager.Rotate()
+		var rotatedItems = manager.Rotate()
+		a.IsTrue(len(rotatedItems) == 0)
+	}
+}
+
+func TestRuleSetStat_ShouldSampleCost(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	var manager = waf.NewRuleSetStatManager()
+	var set = waf.NewRuleSet()
+	set.Id = 1
+	var stat = manager.FindStat(nil, set)
+
+	var countSamples = 0
+	for i := 0; i < 160; i++ {
+		if stat.ShouldSampleCost() {
+			countSamples++
+		}
+	}
+	a.IsTrue(countSamples == 10)
+}
+
+func TestRuleSetStatManager_Stop(t *testing.T) {
+	var manager = waf.NewRuleSetStatManager()
+	var done = make(chan bool)
+	go func() {
+		manager.Start()
+		done <- true
+	}()
+	time.Sleep(100 * time.Millisecond)
+	manager.Stop()
+
+	select {
+	case <-done:
+	case <-time.After(1 * time.Second):
+		t.Fatal("manager should quit after stopped")
+	}
+
+	// 停止后再启动时立即返回
+	manager.Start()
+}
+
+func BenchmarkRuleSetStat_Add(b *testing.B) {
+	var manager = waf.NewRuleSetStatManager()
+	var set = waf.NewRuleSet()
+	set.Id = 1
+	var stat = manager.FindStat(nil, set)
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			stat.Add(false)
+			if stat.ShouldSampleCost() {
+				stat.AddCost(100 * time.Microsecond)
+			}
+		}
+	})
+}
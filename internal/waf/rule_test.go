@@ -49,6 +49,70 @@ func TestRule_Init_Composite(t *testing.T) {
 	t.Log(rule.MatchRequest(req))
 }
 
+func TestRule_Transforms(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	{
+		var rule = NewRule()
+		rule.Param = "${arg.id}"
+		rule.Operator = RuleOperatorContains
+		rule.Value = "union select"
+		rule.Transforms = []string{"urlDecode", "abc"}
+		a.IsNotNil(rule.Init())
+	}
+
+	var rule1 = NewRule()
+	rule1.Param = "${arg.id}"
+	rule1.Operator = RuleOperatorContains
+	rule1.Value = "union select"
+	rule1.Transforms = []string{"urlDecode", "removeSQLComments", "compressWhitespace", "lowercase"}
+	err := rule1.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var rule2 = NewRule()
+	rule2.Param = "${arg.id}"
+	rule2.Operator = RuleOperatorContains
+	rule2.Value = "union"
+	rule2.Transforms = []string{"urlDecode", "removeSQLComments"}
+	err = rule2.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var stat = newRuleSetStat()
+	rule1.stat = stat
+	rule2.stat = stat
+
+	rawReq, err := http.NewRequest(http.MethodGet, "http://teaos.cn/hello?id=1%2520UNION/**/%2553ELECT", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var req = requests.NewTestRequest(rawReq)
+	b, _, err := rule1.MatchRequest(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.IsTrue(b)
+
+	// 使用上一个规则缓存的转换结果
+	cachedValue, ok := req.WAFGetCacheValue("WAF_TRANSFORM@${arg.id}@urlDecode,removeSQLComments")
+	a.IsTrue(ok)
+	a.IsTrue(cachedValue == "1 UNION SELECT")
+	b, _, err = rule2.MatchRequest(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.IsFalse(b)
+
+	// 参数值缓存命中统计
+	var item = stat.snapshot(false)
+	a.IsTrue(item.CountValueCacheMisses == 1)
+	a.IsTrue(item.CountValueCacheHits == 1)
+	a.IsTrue(item.ValueCacheHitRate() == 0.5)
+}
+
 func TestRule_Test(t *testing.T) {
 	var a = assert.NewAssertion(t)
 
@@ -0,0 +1,83 @@
+// Copyright 2024 GoEdge CDN goedge.cdn@gmail.com. All rights reserved. Official site: https://goedge.cloud .
+
+package transforms
+
+import (
+	"errors"
+	"strings"
+)
+
+// Chain 转换链
+type Chain struct {
+	codes       []Code
+	definitions []*Definition
+}
+
+// NewChain 获取新的转换链
+func NewChain(codes []Code) (*Chain, error) {
+	var chain = &Chain{}
+	for _, code := range codes {
+		var def = FindTransform(code)
+		if def == nil {
+			return nil, errors.New("invalid transform '" + code + "'")
+		}
+		chain.codes = append(chain.codes, code)
+		chain.definitions = append(chain.definitions, def)
+	}
+	return chain, nil
+}
+
+// Len 转换数量
+func (this *Chain) Len() int {
+	return len(this.definitions)
+}
+
+// Key 前N个转换组成的唯一标识，用来缓存中间结果
+func (this *Chain) Key(n int) string {
+	return strings.Join(this.codes[:n], ",")
+}
+
+// Apply 执行所有转换
+func (this *Chain) Apply(s string) string {
+	return this.ApplyFrom(s, 0)
+}
+
+// ApplyFrom 从第N个转换开始执行
+func (this *Chain) ApplyFrom(s string, from int) string {
+	for i := from; i < len(this.definitions); i++ {
+		s = this.definitions[i].Apply(s)
+	}
+	return s
+}
+
+// ApplyStep 执行第N个转换
+func (this *Chain) ApplyStep(s string, step int) string {
+	return this.definitions[step].Apply(s)
+}
+
+// ApplyValue 对参数值执行转换
+// 支持 string、[]byte、[]string、[][]byte，其他类型的值保持不变
+func (this *Chain) ApplyValue(value any) any {
+	if len(this.definitions) == 0 {
+		return value
+	}
+	switch v := value.(type) {
+	case string:
+		return this.Apply(v)
+	case []byte:
+		return []byte(this.Apply(string(v)))
+	case []string:
+		var result = make([]string, len(v))
+		for i, s := range v {
+			result[i] = this.Apply(s)
+		}
+		return result
+	case [][]byte:
+		var result = make([][]byte, len(v))
+		for i, b := range v {
+			result[i] = []byte(this.Apply(string(b)))
+		}
+		return result
+	}
+	return value
+}
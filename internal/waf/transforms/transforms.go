@@ -0,0 +1,398 @@
+// Copyright 2024 GoEdge CDN goedge.cdn@gmail.com. All rights reserved. Official site: https://goedge.cloud .
+
+package transforms
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"html"
+	"path"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+type Code = string
+
+const (
+	CodeURLDecode          Code = "urlDecode"          // 递归URL解码，支持 %uXXXX
+	CodeHTMLEntityDecode   Code = "htmlEntityDecode"   // HTML实体解码
+	CodeNFKC               Code = "nfkc"               // Unicode NFKC规范化，比如全角字符转为半角
+	CodeNormalizePath      Code = "normalizePath"      // 路径规范化，处理 /./、/../、// 和 \
+	CodeCompressWhitespace Code = "compressWhitespace" // 压缩空白字符
+	CodeRemoveSQLComments  Code = "removeSQLComments"  // 去除SQL注释
+	CodeRemoveJSComments   Code = "removeJSComments"   // 去除JavaScript和HTML注释
+	CodeHexDecode          Code = "hexDecode"          // 十六进制解码，支持 \xHH 和 0xHHHH
+	CodeBase64Decode       Code = "base64Decode"       // Base64解码
+	CodeLowercase          Code = "lowercase"          // 转换为小写
+)
+
+// 递归解码的最大次数
+const maxDecodeRounds = 5
+
+type Definition struct {
+	Name        string `json:"name"`
+	Code        Code   `json:"code"`
+	Description string `json:"description"`
+
+	fn func(s string) string
+}
+
+// AllTransforms 所有的转换
+var AllTransforms = []*Definition{
+	{
+		Name:        "URL解码",
+		Code:        CodeURLDecode,
+		Description: "递归进行URL解码，直到内容不再变化，支持%uXXXX格式",
+		fn:          URLDecode,
+	},
+	{
+		Name:        "HTML实体解码",
+		Code:        CodeHTMLEntityDecode,
+		Description: "解码&lt;、&#60;、&#x3c;等HTML实体",
+		fn:          HTMLEntityDecode,
+	},
+	{
+		Name:        "Unicode NFKC规范化",
+		Code:        CodeNFKC,
+		Description: "将全角字符等兼容字符转换为标准字符",
+		fn:          NFKC,
+	},
+	{
+		Name:        "路径规范化",
+		Code:        CodeNormalizePath,
+		Description: "处理/./、/../、//和反斜杠",
+		fn:          NormalizePath,
+	},
+	{
+		Name:        "压缩空白字符",
+		Code:        CodeCompressWhitespace,
+		Description: "将连续的空白字符替换为一个空格",
+		fn:          CompressWhitespace,
+	},
+	{
+		Name:        "去除SQL注释",
+		Code:        CodeRemoveSQLComments,
+		Description: "将/*...*/、--和#注释替换为空格，保留MySQL可执行注释/*!...*/中的内容",
+		fn:          RemoveSQLComments,
+	},
+	{
+		Name:        "去除JavaScript注释",
+		Code:        CodeRemoveJSComments,
+		Description: "将/*...*/、//和<!--...-->注释替换为空格",
+		fn:          RemoveJSComments,
+	},
+	{
+		Name:        "十六进制解码",
+		Code:        CodeHexDecode,
+		Description: "解码\\xHH和0xHHHH格式的内容",
+		fn:          HexDecode,
+	},
+	{
+		Name:        "Base64解码",
+		Code:        CodeBase64Decode,
+		Description: "如果整个内容为Base64编码的文本，则进行解码",
+		fn:          Base64Decode,
+	},
+	{
+		Name:        "转换为小写",
+		Code:        CodeLowercase,
+		Description: "将所有字母转换为小写",
+		fn:          strings.ToLower,
+	},
+}
+
+// FindTransform 查找转换
+func FindTransform(code Code) *Definition {
+	for _, def := range AllTransforms {
+		if def.Code == code {
+			return def
+		}
+	}
+	return nil
+}
+
+// Apply 执行转换
+func (this *Definition) Apply(s string) string {
+	if len(s) == 0 {
+		return s
+	}
+	return this.fn(s)
+}
+
+// URLDecode 递归URL解码
+func URLDecode(s string) string {
+	for i := 0; i < maxDecodeRounds; i++ {
+		if strings.IndexByte(s, '%') < 0 && strings.IndexByte(s, '+') < 0 {
+			return s
+		}
+		var decoded = urlDecodeOnce(s)
+		if decoded == s {
+			return s
+		}
+		s = decoded
+	}
+	return s
+}
+
+// 宽松的URL解码，无效的编码保持原样
+func urlDecodeOnce(s string) string {
+	var b = &strings.Builder{}
+	b.Grow(len(s))
+	for i := 0; i < len(s); i++ {
+		var c = s[i]
+		switch {
+		case c == '+':
+			b.WriteByte(' ')
+		case c == '%' && i+2 < len(s) && (s[i+1] == 'u' || s[i+1] == 'U') && i+5 < len(s) && isHex(s[i+2]) && isHex(s[i+3]) && isHex(s[i+4]) && isHex(s[i+5]):
+			var r = rune(unhex(s[i+2]))<<12 | rune(unhex(s[i+3]))<<8 | rune(unhex(s[i+4]))<<4 | rune(unhex(s[i+5]))
+			b.WriteRune(r)
+			i += 5
+		case c == '%' && i+2 < len(s) && isHex(s[i+1]) && isHex(s[i+2]):
+			b.WriteByte(unhex(s[i+1])<<4 | unhex(s[i+2]))
+			i += 2
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// HTMLEntityDecode 递归解码HTML实体
+func HTMLEntityDecode(s string) string {
+	for i := 0; i < maxDecodeRounds; i++ {
+		if strings.IndexByte(s, '&') < 0 {
+			return s
+		}
+		var decoded = html.UnescapeString(s)
+		if decoded == s {
+			return s
+		}
+		s = decoded
+	}
+	return s
+}
+
+// NFKC Unicode NFKC规范化
+func NFKC(s string) string {
+	// 纯ASCII字符不需要处理
+	var isASCII = true
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			isASCII = false
+			break
+		}
+	}
+	if isASCII {
+		return s
+	}
+	return norm.NFKC.String(s)
+}
+
+// NormalizePath 路径规范化
+func NormalizePath(s string) string {
+	var p = strings.ReplaceAll(s, "\\", "/")
+	var hasTrailingSlash = strings.HasSuffix(p, "/") || strings.HasSuffix(p, "/.") || strings.HasSuffix(p, "/..")
+	var isAbs = strings.HasPrefix(p, "/")
+	p = path.Clean(p)
+	if isAbs {
+		// 防止 /../ 跳出根目录
+		p = path.Clean("/" + p)
+	}
+	if hasTrailingSlash && !strings.HasSuffix(p, "/") {
+		p += "/"
+	}
+	return p
+}
+
+// CompressWhitespace 压缩空白字符
+func CompressWhitespace(s string) string {
+	var b = &strings.Builder{}
+	b.Grow(len(s))
+	var lastIsSpace = false
+	for _, r := range s {
+		if unicode.IsSpace(r) || r == 0 {
+			if !lastIsSpace {
+				b.WriteByte(' ')
+				lastIsSpace = true
+			}
+			continue
+		}
+		lastIsSpace = false
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+var sqlExecutableCommentRegexp = regexp.MustCompile(`/\*!\d*`)
+
+// RemoveSQLComments 去除SQL注释
+func RemoveSQLComments(s string) string {
+	if !strings.Contains(s, "/*") && !strings.Contains(s, "--") && strings.IndexByte(s, '#') < 0 {
+		return s
+	}
+
+	var b = &strings.Builder{}
+	b.Grow(len(s))
+	var quote byte = 0
+	for i := 0; i < len(s); i++ {
+		var c = s[i]
+
+		// 字符串中的内容不处理
+		if quote != 0 {
+			b.WriteByte(c)
+			if c == quote {
+				quote = 0
+			} else if c == '\\' && i+1 < len(s) {
+				i++
+				b.WriteByte(s[i])
+			}
+			continue
+		}
+
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+			b.WriteByte(c)
+		case c == '/' && i+1 < len(s) && s[i+1] == '*':
+			// MySQL可执行注释 /*!50000 select */ 中的内容会被执行，所以需要保留
+			var loc = sqlExecutableCommentRegexp.FindStringIndex(s[i:])
+			if loc != nil && loc[0] == 0 {
+				b.WriteByte(' ')
+				i += loc[1] - 1
+				continue
+			}
+			var end = strings.Index(s[i+2:], "*/")
+			b.WriteByte(' ')
+			if end < 0 {
+				i = len(s)
+			} else {
+				i += 2 + end + 1
+			}
+		case c == '*' && i+1 < len(s) && s[i+1] == '/':
+			// 可执行注释的结尾
+			b.WriteByte(' ')
+			i++
+		case c == '-' && i+1 < len(s) && s[i+1] == '-', c == '#':
+			var end = strings.IndexByte(s[i:], '\n')
+			b.WriteByte(' ')
+			if end < 0 {
+				i = len(s)
+			} else {
+				i += end - 1
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// RemoveJSComments 去除JavaScript和HTML注释
+func RemoveJSComments(s string) string {
+	if !strings.Contains(s, "/*") && !strings.Contains(s, "//") && !strings.Contains(s, "<!--") {
+		return s
+	}
+
+	var b = &strings.Builder{}
+	b.Grow(len(s))
+	for i := 0; i < len(s); i++ {
+		var c = s[i]
+		switch {
+		case c == '/' && i+1 < len(s) && s[i+1] == '*':
+			var end = strings.Index(s[i+2:], "*/")
+			b.WriteByte(' ')
+			if end < 0 {
+				i = len(s)
+			} else {
+				i += 2 + end + 1
+			}
+		case c == '/' && i+1 < len(s) && s[i+1] == '/' && (i == 0 || s[i-1] != ':'): // 不处理 http:// 之类的URL
+			var end = strings.IndexByte(s[i:], '\n')
+			b.WriteByte(' ')
+			if end < 0 {
+				i = len(s)
+			} else {
+				i += end - 1
+			}
+		case c == '<' && strings.HasPrefix(s[i:], "<!--"):
+			var end = strings.Index(s[i+4:], "-->")
+			b.WriteByte(' ')
+			if end < 0 {
+				i = len(s)
+			} else {
+				i += 4 + end + 2
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+var hexEscapeRegexp = regexp.MustCompile(`\\x([0-9a-fA-F]{2})|\b0x((?:[0-9a-fA-F]{2})+)\b`)
+
+// HexDecode 十六进制解码
+func HexDecode(s string) string {
+	if !strings.Contains(s, "\\x") && !strings.Contains(s, "0x") {
+		return s
+	}
+	return hexEscapeRegexp.ReplaceAllStringFunc(s, func(match string) string {
+		data, err := hex.DecodeString(match[2:])
+		if err != nil {
+			return match
+		}
+		return string(data)
+	})
+}
+
+// Base64Decode Base64解码
+// 只有整个内容都是Base64编码，并且解码后为文本时才会解码
+func Base64Decode(s string) string {
+	var trimmed = strings.TrimSpace(s)
+	if len(trimmed) < 4 {
+		return s
+	}
+	for _, encoding := range []*base64.Encoding{base64.StdEncoding, base64.URLEncoding, base64.RawStdEncoding, base64.RawURLEncoding} {
+		data, err := encoding.DecodeString(trimmed)
+		if err != nil {
+			continue
+		}
+		if !isText(data) {
+			return s
+		}
+		return string(data)
+	}
+	return s
+}
+
+func isText(data []byte) bool {
+	if !utf8.Valid(data) {
+		return false
+	}
+	for _, r := range string(data) {
+		if r != '\n' && r != '\r' && r != '\t' && !unicode.IsPrint(r) {
+			return false
+		}
+	}
+	return true
+}
+
+func isHex(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+func unhex(c byte) byte {
+	switch {
+	case c >= '0' && c <= '9':
+		return c - '0'
+	case c >= 'a' && c <= 'f':
+		return c - 'a' + 10
+	case c >= 'A' && c <= 'F':
+		return c - 'A' + 10
+	}
+	return 0
+}
@@ -0,0 +1,141 @@
+// Copyright 2024 GoEdge CDN goedge.cdn@gmail.com. All rights reserved. Official site: https://goedge.cloud .
+
+package transforms_test
+
+import (
+	"testing"
+
+	"github.com/TeaOSLab/EdgeNode/internal/waf/transforms"
+	"github.com/iwind/TeaGo/assert"
+)
+
+func TestURLDecode(t *testing.T) {
+	var a = assert.NewAssertion(t)
+	a.IsTrue(transforms.URLDecode("abc") == "abc")
+	a.IsTrue(transforms.URLDecode("a+b%20c") == "a b c")
+	a.IsTrue(transforms.URLDecode("%253Cscript%253E") == "<script>")
+	a.IsTrue(transforms.URLDecode("%u003Cscript%u003E") == "<script>")
+	a.IsTrue(transforms.URLDecode("%u4E2D%u6587") == "中文")
+	a.IsTrue(transforms.URLDecode("100%") == "100%")
+	a.IsTrue(transforms.URLDecode("%zz%2") == "%zz%2")
+}
+
+func TestHTMLEntityDecode(t *testing.T) {
+	var a = assert.NewAssertion(t)
+	a.IsTrue(transforms.HTMLEntityDecode("&lt;script&#62;&#x3c;") == "<script><")
+	a.IsTrue(transforms.HTMLEntityDecode("&amp;lt;") == "<")
+	a.IsTrue(transforms.HTMLEntityDecode("a & b") == "a & b")
+}
+
+func TestNFKC(t *testing.T) {
+	var a = assert.NewAssertion(t)
+	a.IsTrue(transforms.NFKC("ｓｅｌｅｃｔ") == "select")
+	a.IsTrue(transforms.NFKC("＜script＞") == "<script>")
+	a.IsTrue(transforms.NFKC("中文") == "中文")
+}
+
+func TestNormalizePath(t *testing.T) {
+	var a = assert.NewAssertion(t)
+	for _, item := range [][2]string{
+		{"/a/b/../c", "/a/c"},
+		{"/a/./b//c/", "/a/b/c/"},
+		{"/../../etc/passwd", "/etc/passwd"},
+		{"\\a\\..\\..\\etc\\passwd", "/etc/passwd"},
+		{"/a/b/..", "/a/"},
+		{"a/../../b", "../b"},
+		{"/", "/"},
+	} {
+		var result = transforms.NormalizePath(item[0])
+		t.Log(item[0], "=>", result)
+		a.IsTrue(result == item[1])
+	}
+}
+
+func TestCompressWhitespace(t *testing.T) {
+	var a = assert.NewAssertion(t)
+	a.IsTrue(transforms.CompressWhitespace("union \t\r\n  select") == "union select")
+	a.IsTrue(transforms.CompressWhitespace("union 　select") == "union select")
+}
+
+func TestRemoveSQLComments(t *testing.T) {
+	var a = assert.NewAssertion(t)
+	for _, item := range [][2]string{
+		{"union/**/select", "union select"},
+		{"union/*abc*/select/*", "union select "},
+		{"1 or 1=1 -- abc", "1 or 1=1  "},
+		{"1 or 1=1 #abc\nunion", "1 or 1=1  \nunion"},
+		{"/*!50000union*/ select", " union  select"},
+		{"'a--b' or 1", "'a--b' or 1"},
+		{"'a\\'#b' or 1", "'a\\'#b' or 1"},
+	} {
+		var result = transforms.RemoveSQLComments(item[0])
+		t.Logf("%q => %q", item[0], result)
+		a.IsTrue(result == item[1])
+	}
+}
+
+func TestRemoveJSComments(t *testing.T) {
+	var a = assert.NewAssertion(t)
+	a.IsTrue(transforms.RemoveJSComments("alert/**/(1)") == "alert (1)")
+	a.IsTrue(transforms.RemoveJSComments("alert(1)//abc\nb") == "alert(1) \nb")
+	a.IsTrue(transforms.RemoveJSComments("<!--abc-->alert(1)") == " alert(1)")
+	a.IsTrue(transforms.RemoveJSComments("location='http://example.com'") == "location='http://example.com'")
+}
+
+func TestHexDecode(t *testing.T) {
+	var a = assert.NewAssertion(t)
+	a.IsTrue(transforms.HexDecode("\\x3cscript\\x3e") == "<script>")
+	a.IsTrue(transforms.HexDecode("select 0x61646d696e") == "select admin")
+	a.IsTrue(transforms.HexDecode("0x1 0xZZ") == "0x1 0xZZ")
+}
+
+func TestBase64Decode(t *testing.T) {
+	var a = assert.NewAssertion(t)
+	a.IsTrue(transforms.Base64Decode("PHNjcmlwdD4=") == "<script>")
+	a.IsTrue(transforms.Base64Decode("PHNjcmlwdD4") == "<script>")
+	a.IsTrue(transforms.Base64Decode("hello world") == "hello world")
+	a.IsTrue(transforms.Base64Decode("AAEC") == "AAEC") // binary
+}
+
+func TestChain(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	{
+		_, err := transforms.NewChain([]string{"urlDecode", "abc"})
+		a.IsNotNil(err)
+	}
+
+	chain, err := transforms.NewChain([]string{transforms.CodeURLDecode, transforms.CodeHTMLEntityDecode, transforms.CodeNFKC, transforms.CodeRemoveSQLComments, transforms.CodeCompressWhitespace, transforms.CodeLowercase})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.IsTrue(chain.Len() == 6)
+	a.IsTrue(chain.Key(2) == "urlDecode,htmlEntityDecode")
+
+	var result = chain.Apply("1%20%55NION/**/%26%2365%3B%EF%BC%AC%EF%BC%AClect")
+	t.Log(result)
+	a.IsTrue(result == "1 union alllect")
+
+	// 分步执行结果一致
+	var s = "%2526lt%3Bscript%2526gt%3B"
+	var step = s
+	for i := 0; i < chain.Len(); i++ {
+		step = chain.ApplyStep(step, i)
+	}
+	a.IsTrue(step == chain.Apply(s))
+	a.IsTrue(chain.ApplyFrom(chain.ApplyStep(s, 0), 1) == chain.Apply(s))
+
+	a.IsTrue(string(chain.ApplyValue([]byte("A%20B")).([]byte)) == "a b")
+	a.IsTrue(chain.ApplyValue([]string{"A", "%42"}).([]string)[1] == "b")
+	a.IsTrue(chain.ApplyValue(123).(int) == 123)
+}
+
+func BenchmarkChain_Apply(b *testing.B) {
+	chain, err := transforms.NewChain([]string{transforms.CodeURLDecode, transforms.CodeHTMLEntityDecode, transforms.CodeNFKC, transforms.CodeRemoveSQLComments, transforms.CodeCompressWhitespace})
+	if err != nil {
+		b.Fatal(err)
+	}
+	for i := 0; i < b.N; i++ {
+		_ = chain.Apply("id=1%20union/**/select%20*%20from%20users&name=%E4%B8%AD%E6%96%87")
+	}
+}
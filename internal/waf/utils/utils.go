@@ -39,8 +39,19 @@ const (
 	CacheLongLife   CacheLife = 7200
 )
 
+// CacheStat 缓存命中统计
+type CacheStat interface {
+	IncreaseCacheHit()
+	IncreaseCacheMiss()
+}
+
 // MatchStringCache 正则表达式匹配字符串，并缓存结果
 func MatchStringCache(regex *re.Regexp, s string, cacheLife CacheLife) bool {
+	return MatchStringCacheStat(regex, s, cacheLife, nil)
+}
+
+// MatchStringCacheStat 正则表达式匹配字符串，并缓存结果，同时记录缓存命中情况
+func MatchStringCacheStat(regex *re.Regexp, s string, cacheLife CacheLife, stat CacheStat) bool {
 	if regex == nil {
 		return false
 	}
@@ -57,6 +68,9 @@ func MatchStringCache(regex *re.Regexp, s string, cacheLife CacheLife) bool {
 	var item = SharedCache.Read(key)
 	if item != nil {
 		cacheHits.IncreaseHit(regIdString)
+		if stat != nil {
+			stat.IncreaseCacheHit()
+		}
 		return item.Value == 1
 	}
 	var b = regex.MatchString(s)
@@ -66,11 +80,19 @@ func MatchStringCache(regex *re.Regexp, s string, cacheLife CacheLife) bool {
 		SharedCache.Write(key, 0, fasttime.Now().Unix()+cacheLife)
 	}
 	cacheHits.IncreaseCached(regIdString)
+	if stat != nil {
+		stat.IncreaseCacheMiss()
+	}
 	return b
 }
 
 // MatchBytesCache 正则表达式匹配字节slice，并缓存结果
 func MatchBytesCache(regex *re.Regexp, byteSlice []byte, cacheLife CacheLife) bool {
+	return MatchBytesCacheStat(regex, byteSlice, cacheLife, nil)
+}
+
+// MatchBytesCacheStat 正则表达式匹配字节slice，并缓存结果，同时记录缓存命中情况
+func MatchBytesCacheStat(regex *re.Regexp, byteSlice []byte, cacheLife CacheLife, stat CacheStat) bool {
 	if regex == nil {
 		return false
 	}
@@ -87,6 +109,9 @@ func MatchBytesCache(regex *re.Regexp, byteSlice []byte, cacheLife CacheLife) bo
 	var item = SharedCache.Read(key)
 	if item != nil {
 		cacheHits.IncreaseHit(regIdString)
+		if stat != nil {
+			stat.IncreaseCacheHit()
+		}
 		return item.Value == 1
 	}
 	var b = regex.Match(byteSlice)
@@ -96,6 +121,9 @@ func MatchBytesCache(regex *re.Regexp, byteSlice []byte, cacheLife CacheLife) bo
 		SharedCache.Write(key, 0, fasttime.Now().Unix()+cacheLife)
 	}
 	cacheHits.IncreaseCached(regIdString)
+	if stat != nil {
+		stat.IncreaseCacheMiss()
+	}
 	return b
 }
 
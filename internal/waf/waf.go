@@ -42,6 +42,7 @@ type WAF struct {
 
 	hasInboundRules  bool
 	hasOutboundRules bool
+	hasMaskActions   bool
 
 	checkpointsMap map[string]checkpoints.CheckpointInterface // prefix => checkpoint
 	actionMap      map[int64]ActionInterface                  // actionId => ActionInterface
@@ -130,9 +131,40 @@ func (this *WAF) Init() (resultErrors []error) {
 		}
 	}
 
+	this.hasMaskActions = this.containsMaskActions()
+
 	return nil
 }
 
+// HasMaskActions 是否有启用的规则集使用了响应内容脱敏动作
+func (this *WAF) HasMaskActions() bool {
+	return this.hasMaskActions
+}
+
+func (this *WAF) containsMaskActions() bool {
+	if len(this.Mode) != 0 && this.Mode != firewallconfigs.FirewallModeDefend {
+		return false
+	}
+	for _, groups := range [][]*RuleGroup{this.Inbound, this.Outbound} {
+		for _, group := range groups {
+			if group == nil || !group.IsOn {
+				continue
+			}
+			for _, set := range group.RuleSets {
+				if set == nil || !set.IsOn {
+					continue
+				}
+				for _, action := range set.Actions {
+					if action != nil && action.Code == ActionMask {
+						return true
+					}
+				}
+			}
+		}
+	}
+	return false
+}
+
 func (this *WAF) AddRuleGroup(ruleGroup *RuleGroup) {
 	if ruleGroup.IsInbound {
 		this.Inbound = append(this.Inbound, ruleGroup)
@@ -211,3 +211,46 @@ func TestWAF_MatchRequest_Allow2(t *testing.T) {
 	a.IsTrue(result.IsAllowed)
 	a.IsTrue(result.AllowScope == "global")
 }
+
+func TestWAF_HasMaskActions(t *testing.T) {
+	var a = assert.NewAssertion(t)
+
+	var newWAF = func(mode firewallconfigs.FirewallMode, setIsOn bool) *waf.WAF {
+		var set = waf.NewRuleSet()
+		set.IsOn = setIsOn
+		set.Rules = []*waf.Rule{
+			{
+				Param:    "${status}",
+				Operator: waf.RuleOperatorEq,
+				Value:    "206",
+			},
+		}
+		set.AddAction(waf.ActionMask, maps.Map{
+			"rules": []maps.Map{
+				{
+					"type": waf.MaskRuleTypeCreditCard,
+				},
+			},
+		})
+
+		var group = waf.NewRuleGroup()
+		group.AddRuleSet(set)
+		group.IsInbound = false
+
+		var wafInstance = waf.NewWAF()
+		wafInstance.Mode = mode
+		wafInstance.AddRuleGroup(group)
+		errs := wafInstance.Init()
+		if len(errs) > 0 {
+			t.Fatal(errs[0])
+		}
+		return wafInstance
+	}
+
+	// 出站规则中的脱敏动作在源站响应之后才会执行，所以需要在请求之前就能知道
+	a.IsTrue(newWAF(firewallconfigs.FirewallModeDefend, true).HasMaskActions())
+	a.IsTrue(newWAF("", true).HasMaskActions())
+	a.IsFalse(newWAF(firewallconfigs.FirewallModeBypass, true).HasMaskActions())
+	a.IsFalse(newWAF(firewallconfigs.FirewallModeDefend, false).HasMaskActions())
+	a.IsFalse(waf.NewWAF().HasMaskActions())
+}